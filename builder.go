@@ -0,0 +1,361 @@
+package dag
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// DefaultMaxCachedNodes is the number of nodes a ManifestBuilder will hold in
+// it's cache unless configured otherwise
+const DefaultMaxCachedNodes = 10000
+
+// DefaultBreakerWindow is the number of recent node fetches a ManifestBuilder
+// circuit breaker measures error rates over unless configured otherwise
+const DefaultBreakerWindow = 20
+
+// ErrStoreUnhealthy is returned by ManifestBuilder builds while the builder's
+// circuit breaker is tripped
+var ErrStoreUnhealthy = fmt.Errorf("store unhealthy")
+
+// ManifestBuilderConfig encapsulates options for a ManifestBuilder
+type ManifestBuilderConfig struct {
+	// MaxCachedNodes caps the number of nodes held in the cache. zero or less
+	// disables the limit
+	MaxCachedNodes int
+	// MaxCachedBytes caps the sum of raw node data held in the cache. zero or
+	// less disables the limit
+	MaxCachedBytes int
+	// BreakerErrorRate trips the builder's circuit breaker when this fraction
+	// of recent node fetches fail, between 0 and 1. While the breaker is
+	// tripped new builds fail with ErrStoreUnhealthy, and in-flight builds
+	// fail at their next uncached fetch, with blocked fetches cancelled.
+	// Not-found errors & fetches abandoned by their caller don't count as
+	// failures. Zero disables the breaker
+	BreakerErrorRate float64
+	// BreakerWindow is the number of recent fetches the error rate is measured
+	// over. The breaker can't trip until this many fetches have been made.
+	// Defaults to DefaultBreakerWindow
+	BreakerWindow int
+	// BreakerCooldown is how long a tripped breaker stays tripped before
+	// builds are allowed to try the store again. Zero means the breaker stays
+	// tripped until ResetBreaker is called
+	BreakerCooldown time.Duration
+}
+
+// ManifestBuilder creates manifests from a node cache that is shared across
+// calls, so manifests of DAGs with overlapping subtrees only fetch shared
+// nodes once. When cache limits are reached the least recently used nodes are
+// evicted first. Builders can be configured with a circuit breaker, so builds
+// sharing an unhealthy store fail fast. ManifestBuilder is safe for
+// concurrent use
+type ManifestBuilder struct {
+	ng      ipld.NodeGetter
+	cache   *nodeCache
+	breaker *breaker // nil when the circuit breaker is disabled
+}
+
+// assert at compile time that ManifestBuilder is a NodeGetter
+var _ ipld.NodeGetter = (*ManifestBuilder)(nil)
+
+// NewManifestBuilder creates a ManifestBuilder that fetches uncached nodes
+// from ng
+func NewManifestBuilder(ng ipld.NodeGetter, opts ...func(cfg *ManifestBuilderConfig)) *ManifestBuilder {
+	cfg := &ManifestBuilderConfig{
+		MaxCachedNodes: DefaultMaxCachedNodes,
+		BreakerWindow:  DefaultBreakerWindow,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	b := &ManifestBuilder{
+		ng:    ng,
+		cache: newNodeCache(cfg.MaxCachedNodes, cfg.MaxCachedBytes),
+	}
+	if cfg.BreakerErrorRate > 0 {
+		b.breaker = newBreaker(cfg.BreakerErrorRate, cfg.BreakerWindow, cfg.BreakerCooldown)
+	}
+	return b
+}
+
+// Manifest generates a manifest for the DAG rooted at id, reading nodes
+// through the builder's cache
+func (b *ManifestBuilder) Manifest(ctx context.Context, id cid.Cid) (*Manifest, error) {
+	if !b.Healthy() {
+		return nil, ErrStoreUnhealthy
+	}
+	return NewManifest(ctx, b, id)
+}
+
+// Info generates an Info for the DAG rooted at id, reading nodes through the
+// builder's cache
+func (b *ManifestBuilder) Info(ctx context.Context, id cid.Cid) (*Info, error) {
+	if !b.Healthy() {
+		return nil, ErrStoreUnhealthy
+	}
+	return NewInfo(ctx, b, id)
+}
+
+// Healthy reports if the builder's circuit breaker allows fetches from the
+// store. Builders without a breaker are always healthy
+func (b *ManifestBuilder) Healthy() bool {
+	return b.breaker == nil || !b.breaker.isTripped()
+}
+
+// ResetBreaker closes a tripped circuit breaker, clearing recorded fetches
+func (b *ManifestBuilder) ResetBreaker() {
+	if b.breaker != nil {
+		b.breaker.reset()
+	}
+}
+
+// Get implements the ipld.NodeGetter interface, returning a cached node if one
+// exists and fetching & caching the node otherwise
+func (b *ManifestBuilder) Get(ctx context.Context, id cid.Cid) (ipld.Node, error) {
+	if nd, ok := b.cache.get(id); ok {
+		return nd, nil
+	}
+
+	nd, err := b.fetch(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	b.cache.add(nd)
+	return nd, nil
+}
+
+// fetch gets a node from the underlying node getter, through the circuit
+// breaker if the builder has one
+func (b *ManifestBuilder) fetch(ctx context.Context, id cid.Cid) (ipld.Node, error) {
+	if b.breaker == nil {
+		return b.ng.Get(ctx, id)
+	}
+
+	tripped, ok := b.breaker.allow()
+	if !ok {
+		return nil, ErrStoreUnhealthy
+	}
+
+	// cancel the fetch if the breaker trips while it's in progress
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-tripped:
+			cancel()
+		case <-fetchCtx.Done():
+		}
+	}()
+
+	nd, err := b.ng.Get(fetchCtx, id)
+	select {
+	case <-tripped:
+		return nil, ErrStoreUnhealthy
+	default:
+	}
+	if ctx.Err() == nil {
+		b.breaker.record(err != nil && !isNotFound(err))
+	}
+	return nd, err
+}
+
+// GetMany implements the ipld.NodeGetter interface
+func (b *ManifestBuilder) GetMany(ctx context.Context, ids []cid.Cid) <-chan *ipld.NodeOption {
+	ch := make(chan *ipld.NodeOption, len(ids))
+	go func() {
+		defer close(ch)
+		for _, id := range ids {
+			nd, err := b.Get(ctx, id)
+			select {
+			case ch <- &ipld.NodeOption{Node: nd, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// CachedNodes returns the number of nodes currently held in the cache
+func (b *ManifestBuilder) CachedNodes() int {
+	return b.cache.len()
+}
+
+// nodeCache holds nodes keyed by CID, evicting the least recently used nodes
+// first once it holds more than maxNodes nodes, or more than maxBytes of raw
+// node data. Limits of zero or less are disabled. nodeCache is safe for
+// concurrent use
+type nodeCache struct {
+	maxNodes int
+	maxBytes int
+
+	lock  sync.Mutex
+	bytes int
+	order *list.List // least recently used entries are at the back
+	nodes map[string]*list.Element
+}
+
+func newNodeCache(maxNodes, maxBytes int) *nodeCache {
+	return &nodeCache{
+		maxNodes: maxNodes,
+		maxBytes: maxBytes,
+		order:    list.New(),
+		nodes:    map[string]*list.Element{},
+	}
+}
+
+func (c *nodeCache) len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.order.Len()
+}
+
+func (c *nodeCache) get(id cid.Cid) (ipld.Node, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	el, ok := c.nodes[id.KeyString()]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(ipld.Node), true
+}
+
+func (c *nodeCache) add(nd ipld.Node) {
+	size := len(nd.RawData())
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := nd.Cid().KeyString()
+	if el, ok := c.nodes[key]; ok {
+		// added by a concurrent fetch
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.nodes[key] = c.order.PushFront(nd)
+	c.bytes += size
+
+	for (c.maxNodes > 0 && c.order.Len() > c.maxNodes) || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		el := c.order.Back()
+		evict := el.Value.(ipld.Node)
+		c.order.Remove(el)
+		delete(c.nodes, evict.Cid().KeyString())
+		c.bytes -= len(evict.RawData())
+	}
+}
+
+// breaker is a circuit breaker that trips when the error rate over a window
+// of recent results passes a threshold
+type breaker struct {
+	rate     float64
+	cooldown time.Duration
+
+	lock      sync.Mutex
+	results   []bool // ring buffer of recent results, true for failures
+	next      int
+	filled    bool
+	trippedAt time.Time
+	tripped   chan struct{} // closed when the breaker trips
+}
+
+func newBreaker(rate float64, window int, cooldown time.Duration) *breaker {
+	if window < 1 {
+		window = DefaultBreakerWindow
+	}
+	return &breaker{
+		rate:     rate,
+		cooldown: cooldown,
+		results:  make([]bool, window),
+		tripped:  make(chan struct{}),
+	}
+}
+
+// allow reports if the breaker is closed, returning a channel that's closed
+// if the breaker trips
+func (br *breaker) allow() (<-chan struct{}, bool) {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	if br.isTrippedLocked() {
+		return nil, false
+	}
+	return br.tripped, true
+}
+
+func (br *breaker) isTripped() bool {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	return br.isTrippedLocked()
+}
+
+// isTrippedLocked reports if the breaker is tripped, closing it once the
+// cooldown has passed. br.lock must be held
+func (br *breaker) isTrippedLocked() bool {
+	if br.trippedAt.IsZero() {
+		return false
+	}
+	if br.cooldown > 0 && time.Since(br.trippedAt) >= br.cooldown {
+		br.resetLocked()
+		return false
+	}
+	return true
+}
+
+// record adds a result, tripping the breaker if the error rate over a full
+// window reaches the threshold
+func (br *breaker) record(failed bool) {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	if !br.trippedAt.IsZero() {
+		return
+	}
+
+	br.results[br.next] = failed
+	br.next = (br.next + 1) % len(br.results)
+	if br.next == 0 {
+		br.filled = true
+	}
+	if !br.filled {
+		return
+	}
+
+	failures := 0
+	for _, f := range br.results {
+		if f {
+			failures++
+		}
+	}
+	if float64(failures)/float64(len(br.results)) >= br.rate {
+		br.trippedAt = time.Now()
+		close(br.tripped)
+	}
+}
+
+func (br *breaker) reset() {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	br.resetLocked()
+}
+
+// resetLocked closes the breaker, clearing recorded results. br.lock must be
+// held
+func (br *breaker) resetLocked() {
+	if !br.trippedAt.IsZero() {
+		br.trippedAt = time.Time{}
+		br.tripped = make(chan struct{})
+	}
+	for i := range br.results {
+		br.results[i] = false
+	}
+	br.next = 0
+	br.filled = false
+}
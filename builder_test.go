@@ -0,0 +1,232 @@
+package dag
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+func TestManifestBuilder(t *testing.T) {
+	content = 0
+
+	// two roots that share the subtree under c
+	//	a     e
+	//	 \   /
+	//	  c-+
+	//	  |
+	//	  d
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	d := newNode(40)
+	e := newNode(50)
+	a.links = []*node{b, c}
+	c.links = []*node{d}
+	e.links = []*node{c}
+
+	ng := &idCountingGetter{ng: TestingNodeGetter{[]ipld.Node{a, b, c, d, e}}, gets: map[string]int{}}
+	bld := NewManifestBuilder(ng)
+
+	ctx := context.Background()
+	ma, err := bld.Manifest(ctx, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	me, err := bld.Manifest(ctx, e.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect, err := NewManifest(ctx, TestingNodeGetter{[]ipld.Node{a, b, c, d, e}}, e.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyManifest(t, expect, me)
+	if len(ma.Nodes) != 4 {
+		t.Errorf("expected first manifest to have 4 nodes. got: %d", len(ma.Nodes))
+	}
+
+	for id, count := range ng.gets {
+		if count != 1 {
+			t.Errorf("expected node %s to be fetched once. got: %d", id, count)
+		}
+	}
+	if len(ng.gets) != 5 {
+		t.Errorf("expected 5 distinct node fetches. got: %d", len(ng.gets))
+	}
+
+	limited := NewManifestBuilder(ng, func(cfg *ManifestBuilderConfig) {
+		cfg.MaxCachedNodes = 2
+	})
+	if _, err := limited.Manifest(ctx, a.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if limited.CachedNodes() != 2 {
+		t.Errorf("expected cache to be capped at 2 nodes. got: %d", limited.CachedNodes())
+	}
+}
+
+// idCountingGetter counts Get calls per node id, and is safe for concurrent use
+type idCountingGetter struct {
+	ng   ipld.NodeGetter
+	lock sync.Mutex
+	gets map[string]int
+}
+
+func (cg *idCountingGetter) Get(ctx context.Context, id cid.Cid) (ipld.Node, error) {
+	cg.lock.Lock()
+	cg.gets[id.String()]++
+	cg.lock.Unlock()
+	return cg.ng.Get(ctx, id)
+}
+
+func (cg *idCountingGetter) GetMany(ctx context.Context, ids []cid.Cid) <-chan *ipld.NodeOption {
+	return cg.ng.GetMany(ctx, ids)
+}
+
+// flakyGetter fails fetches while failing is set, and blocks fetches of the
+// hang id until they're cancelled
+type flakyGetter struct {
+	ng      ipld.NodeGetter
+	hang    cid.Cid
+	hanging chan struct{}
+
+	lock    sync.Mutex
+	failing bool
+	gets    int
+}
+
+func (fg *flakyGetter) setFailing(failing bool) {
+	fg.lock.Lock()
+	defer fg.lock.Unlock()
+	fg.failing = failing
+}
+
+func (fg *flakyGetter) Get(ctx context.Context, id cid.Cid) (ipld.Node, error) {
+	fg.lock.Lock()
+	fg.gets++
+	failing := fg.failing
+	fg.lock.Unlock()
+
+	if id.Equals(fg.hang) {
+		close(fg.hanging)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if failing {
+		return nil, errors.New("disk on fire")
+	}
+	return fg.ng.Get(ctx, id)
+}
+
+func (fg *flakyGetter) GetMany(ctx context.Context, ids []cid.Cid) <-chan *ipld.NodeOption {
+	return fg.ng.GetMany(ctx, ids)
+}
+
+func TestManifestBuilderCircuitBreaker(t *testing.T) {
+	content = 0
+
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	a.links = []*node{b, c}
+	hung := newNode(40)
+	hang := newNode(50)
+	hung.links = []*node{hang}
+	other := newNode(60)
+
+	ctx := context.Background()
+	ng := &flakyGetter{
+		ng:      TestingNodeGetter{[]ipld.Node{a, b, c, hung, hang, other}},
+		hang:    hang.Cid(),
+		hanging: make(chan struct{}),
+	}
+	bld := NewManifestBuilder(ng, func(cfg *ManifestBuilderConfig) {
+		cfg.BreakerErrorRate = 0.5
+		cfg.BreakerWindow = 4
+	})
+
+	// healthy builds fill the window with successes
+	if _, err := bld.Manifest(ctx, a.Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	inflight := make(chan error)
+	go func() {
+		_, err := bld.Manifest(ctx, hung.Cid())
+		inflight <- err
+	}()
+	<-ng.hanging
+
+	// two failures in a window of four trips the breaker
+	ng.setFailing(true)
+	for i := 0; i < 2; i++ {
+		if !bld.Healthy() {
+			t.Fatalf("expected breaker not to trip after %d failures", i)
+		}
+		if _, err := bld.Manifest(ctx, other.Cid()); err == nil || errors.Is(err, ErrStoreUnhealthy) {
+			t.Fatalf("expected a store error. got: %v", err)
+		}
+	}
+	if bld.Healthy() {
+		t.Fatal("expected breaker to trip")
+	}
+
+	select {
+	case err := <-inflight:
+		if !errors.Is(err, ErrStoreUnhealthy) {
+			t.Errorf("expected in-flight build to fail with ErrStoreUnhealthy. got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected in-flight build to be aborted when the breaker trips")
+	}
+
+	ng.setFailing(false)
+	before := ng.gets
+	if _, err := bld.Manifest(ctx, other.Cid()); !errors.Is(err, ErrStoreUnhealthy) {
+		t.Errorf("expected new build to fail fast with ErrStoreUnhealthy. got: %v", err)
+	}
+	if ng.gets != before {
+		t.Errorf("expected tripped breaker to skip the store. got %d fetches", ng.gets-before)
+	}
+
+	bld.ResetBreaker()
+	if _, err := bld.Manifest(ctx, other.Cid()); err != nil {
+		t.Errorf("expected build to succeed after reset. got: %v", err)
+	}
+
+	// not-found errors aren't store failures
+	missing := NewManifestBuilder(TestingNodeGetter{}, func(cfg *ManifestBuilderConfig) {
+		cfg.BreakerErrorRate = 0.5
+		cfg.BreakerWindow = 2
+	})
+	for i := 0; i < 4; i++ {
+		if _, err := missing.Manifest(ctx, a.Cid()); err == nil {
+			t.Fatal("expected an error building a manifest of missing nodes")
+		}
+	}
+	if !missing.Healthy() {
+		t.Error("expected not-found errors not to trip the breaker")
+	}
+
+	// tripped breakers close once the cooldown passes
+	ng.setFailing(true)
+	cooled := NewManifestBuilder(ng, func(cfg *ManifestBuilderConfig) {
+		cfg.BreakerErrorRate = 1
+		cfg.BreakerWindow = 1
+		cfg.BreakerCooldown = 10 * time.Millisecond
+	})
+	cooled.Manifest(ctx, other.Cid())
+	if cooled.Healthy() {
+		t.Fatal("expected breaker to trip")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if !cooled.Healthy() {
+		t.Error("expected breaker to close after the cooldown")
+	}
+}
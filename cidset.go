@@ -0,0 +1,42 @@
+package dag
+
+import "sort"
+
+// SortedCIDs returns the unique CID strings in ids in ascending order, leaving
+// ids unmodified
+func SortedCIDs(ids []string) []string {
+	return newCIDSet(ids...).Sorted()
+}
+
+// cidSet is a set of CID strings. Operations that produce lists of CIDs from
+// a cidSet return them sorted, keeping set-derived output deterministic
+type cidSet map[string]struct{}
+
+func newCIDSet(ids ...string) cidSet {
+	s := make(cidSet, len(ids))
+	s.Add(ids...)
+	return s
+}
+
+// Add places ids in the set
+func (s cidSet) Add(ids ...string) {
+	for _, id := range ids {
+		s[id] = struct{}{}
+	}
+}
+
+// Has reports if id is in the set
+func (s cidSet) Has(id string) bool {
+	_, ok := s[id]
+	return ok
+}
+
+// Sorted returns the ids in the set in ascending order
+func (s cidSet) Sorted() []string {
+	ids := make([]string, 0, len(s))
+	for id := range s {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
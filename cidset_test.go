@@ -0,0 +1,34 @@
+package dag
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCIDSet(t *testing.T) {
+	a := newCIDSet("c", "a", "b", "a")
+
+	if !a.Has("a") || a.Has("d") {
+		t.Errorf("membership mismatch for set: %v", a.Sorted())
+	}
+	if expect := []string{"a", "b", "c"}; fmt.Sprintf("%v", expect) != fmt.Sprintf("%v", a.Sorted()) {
+		t.Errorf("sorted mismatch. expected: %v, got: %v", expect, a.Sorted())
+	}
+
+	a.Add("e")
+	if !a.Has("e") {
+		t.Error("expected added id to be in set")
+	}
+}
+
+func TestSortedCIDs(t *testing.T) {
+	ids := []string{"QmC", "QmA", "QmB", "QmA"}
+	got := SortedCIDs(ids)
+	expect := []string{"QmA", "QmB", "QmC"}
+	if fmt.Sprintf("%v", expect) != fmt.Sprintf("%v", got) {
+		t.Errorf("sorted ids mismatch. expected: %v, got: %v", expect, got)
+	}
+	if ids[0] != "QmC" {
+		t.Error("expected input to be left unmodified")
+	}
+}
@@ -3,6 +3,7 @@ package dag
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/ipfs/go-cid"
@@ -13,14 +14,14 @@ import (
 func Missing(ctx context.Context, ng ipld.NodeGetter, m *Manifest) (missing *Manifest, err error) {
 	var nodes []string
 
-	for _, idstr := range m.Nodes {
-		id, err := cid.Parse(idstr)
+	for i := range m.Nodes {
+		id, err := m.NodeCID(i)
 		if err != nil {
 			return nil, err
 		}
 
 		_, err = ng.Get(ctx, id)
-		if errors.Is(err, ipld.ErrNotFound) || (err != nil && strings.Contains(err.Error(), "not found")) {
+		if isNotFound(err) {
 			nodes = append(nodes, id.String())
 		} else if err != nil {
 			return nil, err
@@ -28,3 +29,33 @@ func Missing(ctx context.Context, ng ipld.NodeGetter, m *Manifest) (missing *Man
 	}
 	return &Manifest{Nodes: nodes}, nil
 }
+
+// isNotFound checks for not found errors, some NodeGetter implementations
+// don't return ipld.ErrNotFound, so we also check the error message
+func isNotFound(err error) bool {
+	return errors.Is(err, ipld.ErrNotFound) || (err != nil && strings.Contains(err.Error(), "not found"))
+}
+
+// CompletionFromStore builds a completion of the manifest from the blocks a
+// store has, without transferring anything. has reports if the store holds a
+// block. Blocks the store has are 100 complete, all others are 0
+func CompletionFromStore(ctx context.Context, has func(cid.Cid) (bool, error), m *Manifest) (Completion, error) {
+	prog := make(Completion, len(m.Nodes))
+	for i := range m.Nodes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		id, err := m.NodeCID(i)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := has(id)
+		if err != nil {
+			return nil, fmt.Errorf("checking for block %s: %w", id, err)
+		}
+		if ok {
+			prog[i] = 100
+		}
+	}
+	return prog, nil
+}
@@ -12,13 +12,24 @@
 package dag
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"path"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/multiformats/go-multihash"
 	"github.com/ugorji/go/codec"
 )
 
@@ -39,19 +50,69 @@ var (
 
 	// ErrIDNotFound indicates the id given is not found in the Manifest
 	ErrIDNotFound = fmt.Errorf("id not found in Manifest")
+
+	// ErrInvalidNodeOrder indicates the order of nodes in a Manifest doesn't
+	// match the order that would be computed from the graph it describes
+	ErrInvalidNodeOrder = fmt.Errorf("invalid manifest node order")
+
+	// ErrLinkOutOfRange indicates a Manifest link references an index outside
+	// the node list
+	ErrLinkOutOfRange = fmt.Errorf("manifest link out of range")
+
+	// ErrDuplicateNode indicates a Manifest lists the same node more than once
+	ErrDuplicateNode = fmt.Errorf("duplicate manifest node")
+
+	// ErrCycleDetected indicates a graph contains a cycle, and isn't a DAG
+	ErrCycleDetected = fmt.Errorf("cycle detected")
 )
 
-// NewManifest generates a manifest from an ipld node
-func NewManifest(ctx context.Context, ng ipld.NodeGetter, id cid.Cid) (*Manifest, error) {
-	ms := &mstate{
-		ctx:     ctx,
-		ng:      ng,
-		weights: map[string]int{},
-		links:   [][2]string{},
-		sizes:   map[string]uint64{},
-		m:       &Manifest{},
-	}
+// ManifestConfig encapsulates options for generating manifests & infos
+type ManifestConfig struct {
+	// FetchConcurrency is the number of linked nodes fetched from the
+	// NodeGetter at once. Links of each node are fetched together before the
+	// node's children are walked, which cuts build time for NodeGetters with
+	// high latency. Nodes are always walked in the same order, so manifests
+	// don't depend on the order fetches complete. Values below 2 fetch nodes
+	// one at a time
+	FetchConcurrency int
+	// BatchFetch fetches the links of each node with a single call to the
+	// NodeGetter's GetMany method instead of a Get call per link. Combined with
+	// FetchConcurrency links are split into up to FetchConcurrency batches
+	// fetched at once. Nodes a batch doesn't return are fetched with Get when
+	// they're walked. Only enable for NodeGetters that implement GetMany,
+	// some NodeGetters return errors or block forever
+	BatchFetch bool
+	// Paths makes NewInfo label nodes with their path from the root through
+	// named links, like the entries of a unixfs directory. The root is
+	// labelled "/", and a file "foo.csv" in a directory "data" linked from the
+	// root is labelled "/data/foo.csv". Unnamed links aren't followed. Nodes
+	// reachable by more than one path are labelled with the first path found
+	// breadth-first, following links in order of child id. Has no effect on
+	// NewManifest
+	Paths bool
+}
+
+// WithFetchConcurrency configures manifest generation to fetch up to n nodes
+// at once
+func WithFetchConcurrency(n int) func(cfg *ManifestConfig) {
+	return func(cfg *ManifestConfig) { cfg.FetchConcurrency = n }
+}
+
+// WithBatchFetch configures manifest generation to fetch linked nodes with
+// GetMany
+func WithBatchFetch(enabled bool) func(cfg *ManifestConfig) {
+	return func(cfg *ManifestConfig) { cfg.BatchFetch = enabled }
+}
+
+// WithPaths configures NewInfo to label nodes with their paths through
+// named links
+func WithPaths(enabled bool) func(cfg *ManifestConfig) {
+	return func(cfg *ManifestConfig) { cfg.Paths = enabled }
+}
 
+// NewManifest generates a manifest from an ipld node
+func NewManifest(ctx context.Context, ng ipld.NodeGetter, id cid.Cid, opts ...func(cfg *ManifestConfig)) (*Manifest, error) {
+	ms := newMState(ctx, ng, opts)
 	err := ms.makeManifest(id)
 	return ms.m, err
 }
@@ -90,13 +151,36 @@ func (m *Manifest) RootCID() cid.Cid {
 	if len(m.Nodes) == 0 {
 		return cid.Undef
 	}
-	id, err := cid.Parse(m.Nodes[0])
+	id, err := m.NodeCID(0)
 	if err != nil {
 		return cid.Undef
 	}
 	return id
 }
 
+// NodeCID parses the id of the node at index i as a CID. NodeCID returns an
+// error wrapping ErrIndexOutOfRange if i isn't a node index
+func (m *Manifest) NodeCID(i int) (cid.Cid, error) {
+	if i < 0 || i >= len(m.Nodes) {
+		return cid.Undef, fmt.Errorf("%w: node %d of %d", ErrIndexOutOfRange, i, len(m.Nodes))
+	}
+	id, err := cid.Parse(m.Nodes[i])
+	if err != nil {
+		return cid.Undef, fmt.Errorf("parsing node %d id %q: %w", i, m.Nodes[i], err)
+	}
+	return id, nil
+}
+
+// MustNodeCID is NodeCID that panics on error. Use it only where the
+// manifest is known to be valid, like tests
+func (m *Manifest) MustNodeCID(i int) cid.Cid {
+	id, err := m.NodeCID(i)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
 // IDIndex returns the node index of the id
 func (m *Manifest) IDIndex(id string) int {
 	for i, node := range m.Nodes {
@@ -107,6 +191,164 @@ func (m *Manifest) IDIndex(id string) int {
 	return -1
 }
 
+// Validate checks a manifest upholds the structural invariants of manifests,
+// returning an error wrapping the sentinel error of the first invariant
+// violated, checked in this order:
+//   - every link references indexes in Nodes: ErrLinkOutOfRange
+//   - no node is listed more than once: ErrDuplicateNode
+//   - links don't form a cycle: ErrCycleDetected
+//   - nodes are in the order NewManifest produces: ErrInvalidNodeOrder
+//
+// Validate manifests decoded from untrusted sources before using them
+func (m *Manifest) Validate() error {
+	if err := m.checkLinks(); err != nil {
+		return err
+	}
+
+	seen := make(map[string]int, len(m.Nodes))
+	for i, id := range m.Nodes {
+		if first, ok := seen[id]; ok {
+			return fmt.Errorf("%w: %s at indexes %d and %d", ErrDuplicateNode, id, first, i)
+		}
+		seen[id] = i
+	}
+
+	if idx := m.cycleIndex(); idx != -1 {
+		return fmt.Errorf("%w: through node %d %s", ErrCycleDetected, idx, m.Nodes[idx])
+	}
+
+	return m.VerifyNodeOrder()
+}
+
+// cycleIndex returns the index of a node on a cycle formed by manifest links,
+// or -1 if links are acyclic. Links must be in range
+func (m *Manifest) cycleIndex() int {
+	children := make([][]int, len(m.Nodes))
+	for _, l := range m.Links {
+		children[l[0]] = append(children[l[0]], l[1])
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(m.Nodes))
+	for start := range m.Nodes {
+		if state[start] != unvisited {
+			continue
+		}
+		// iterative depth-first search, stack entries are node & next child
+		stack := [][2]int{{start, 0}}
+		state[start] = visiting
+		for len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			idx := top[0]
+			if top[1] == len(children[idx]) {
+				state[idx] = done
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			ch := children[idx][top[1]]
+			top[1]++
+			switch state[ch] {
+			case visiting:
+				return ch
+			case unvisited:
+				state[ch] = visiting
+				stack = append(stack, [2]int{ch, 0})
+			}
+		}
+	}
+	return -1
+}
+
+// VerifyNodeOrder recomputes the order of manifest nodes from the node set and
+// link structure, returning an error wrapping ErrInvalidNodeOrder if the
+// order of Nodes doesn't match. A manifest with tampered ordering will
+// corrupt any completion that relies on node indexes.
+//
+// Node weights are recomputed by walking links from the root, visiting the
+// children of each node in order of child id, the same walk order NewManifest
+// uses. Links out of range error with ErrLinkOutOfRange
+func (m *Manifest) VerifyNodeOrder() error {
+	if len(m.Nodes) == 0 {
+		return nil
+	}
+	if err := m.checkLinks(); err != nil {
+		return err
+	}
+
+	children := make([][]int, len(m.Nodes))
+	for _, l := range m.Links {
+		children[l[0]] = append(children[l[0]], l[1])
+	}
+
+	weights := walkWeights(m.Nodes, children, []int{0})
+	if len(weights) != len(m.Nodes) {
+		return fmt.Errorf("%w: %d nodes are not reachable from the root", ErrInvalidNodeOrder, len(m.Nodes)-len(weights))
+	}
+
+	expect := make([]string, len(m.Nodes))
+	copy(expect, m.Nodes)
+	sortNodes(expect, weights)
+	for i, id := range expect {
+		if m.Nodes[i] != id {
+			return fmt.Errorf("%w: expected node %d to be %s, got %s", ErrInvalidNodeOrder, i, id, m.Nodes[i])
+		}
+	}
+	return nil
+}
+
+// walkWeights computes node weights the way mstate.addNode does, walking links
+// depth-first from each root in turn & visiting the children of each node in
+// order of child id. Nodes reached by an earlier walk aren't counted again, so
+// the weight of a shared node depends on walk order. Nodes that aren't
+// reachable from a root have no weight. children is sorted in place
+func walkWeights(nodes []string, children [][]int, roots []int) map[string]int {
+	for _, ch := range children {
+		sort.SliceStable(ch, func(i, j int) bool { return nodes[ch[i]] < nodes[ch[j]] })
+	}
+
+	weights := map[string]int{}
+	visited := make([]bool, len(nodes))
+	// walkFrame tracks a node being walked: the next child to visit and the
+	// weight accumulated from children visited so far
+	type walkFrame struct {
+		idx    int
+		next   int
+		weight int
+	}
+	var stack []walkFrame
+	for _, root := range roots {
+		if visited[root] {
+			continue
+		}
+		visited[root] = true
+		stack = append(stack, walkFrame{idx: root})
+		for len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			if top.next == len(children[top.idx]) {
+				weights[nodes[top.idx]] = top.weight
+				weight := top.weight
+				stack = stack[:len(stack)-1]
+				if len(stack) > 0 {
+					stack[len(stack)-1].weight += weight
+				}
+				continue
+			}
+			ch := children[top.idx][top.next]
+			top.next++
+			top.weight++
+			if !visited[ch] {
+				visited[ch] = true
+				stack = append(stack, walkFrame{idx: ch})
+			}
+		}
+	}
+	return weights
+}
+
 // // SubDAGIndex lists all hashes that are a descendant of manifest node index
 // func (m *Manifest) SubDAGIndex(idx int, nodes *[]string) {
 // 	// for i, l := range m.Links {
@@ -124,18 +366,584 @@ func (m *Manifest) MarshalCBOR() (data []byte, err error) {
 	return
 }
 
+// MarshalJSON encodes this manifest as JSON with a stable field order, links
+// first, then nodes
+func (m *Manifest) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := EncodeJSONManifest(buf, m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Hash returns a base58-encoded sha2-256 multihash of the manifest's CBOR
+// encoding. Manifests are deterministic, so two manifests of the same DAG will
+// always have the same hash
+func (m *Manifest) Hash() (string, error) {
+	data, err := m.MarshalCBOR()
+	if err != nil {
+		return "", err
+	}
+	h, err := multihash.Sum(data, multihash.SHA2_256, -1)
+	if err != nil {
+		return "", err
+	}
+	return h.B58String(), nil
+}
+
+// Checksum returns a fast, non-cryptographic 64-bit checksum of the manifest
+// nodes & links, for comparing & deduplicating manifests in memory. Use Hash
+// for a checksum that's safe to share
+func (m *Manifest) Checksum() uint64 {
+	h := fnv.New64a()
+	buf := make([]byte, 8)
+	for _, l := range m.Links {
+		binary.BigEndian.PutUint32(buf, uint32(l[0]))
+		binary.BigEndian.PutUint32(buf[4:], uint32(l[1]))
+		h.Write(buf)
+	}
+	for _, id := range m.Nodes {
+		binary.BigEndian.PutUint32(buf, uint32(len(id)))
+		h.Write(buf[:4])
+		h.Write([]byte(id))
+	}
+	return h.Sum64()
+}
+
+// Equal reports if two manifests have the same nodes & links in the same
+// order. Manifests are deterministic, so manifests of the same DAG are equal
+// regardless of how they were built. Nil & empty slices are equal. A nil
+// manifest only equals nil
+func (m *Manifest) Equal(b *Manifest) bool {
+	if m == b {
+		return true
+	}
+	if m == nil || b == nil {
+		return false
+	}
+	if len(m.Nodes) != len(b.Nodes) || len(m.Links) != len(b.Links) {
+		return false
+	}
+	for i, id := range m.Nodes {
+		if b.Nodes[i] != id {
+			return false
+		}
+	}
+	for i, l := range m.Links {
+		if b.Links[i] != l {
+			return false
+		}
+	}
+	return true
+}
+
+// shortLabelLen is the number of leading & minimum number of trailing id
+// characters in a short label
+const shortLabelLen = 4
+
+// ShortLabels returns a short, human-friendly label for each node in the
+// manifest, keyed by node id. Labels are the first & last four characters of
+// an id, joined by "..". The leading characters of CIDs describe their version
+// & codec, the trailing characters come from the hash. When labels collide
+// they're lengthened with more trailing characters until they're unique
+// within the manifest. Ids too short to abbreviate are their own label
+func (m *Manifest) ShortLabels() map[string]string {
+	labels := make(map[string]string, len(m.Nodes))
+	pending := make([]string, 0, len(m.Nodes))
+	for _, id := range m.Nodes {
+		if _, ok := labels[id]; !ok {
+			labels[id] = ""
+			pending = append(pending, id)
+		}
+	}
+
+	for n := shortLabelLen; len(pending) > 0; n++ {
+		ids := map[string][]string{}
+		for _, id := range pending {
+			l := shortLabel(id, n)
+			ids[l] = append(ids[l], id)
+		}
+		pending = pending[:0]
+		for l, group := range ids {
+			if len(group) == 1 {
+				labels[group[0]] = l
+			} else {
+				pending = append(pending, group...)
+			}
+		}
+	}
+	return labels
+}
+
+// shortLabel abbreviates id to it's leading characters & n trailing
+// characters
+func shortLabel(id string, n int) string {
+	if len(id) <= shortLabelLen+n+2 {
+		return id
+	}
+	return id[:shortLabelLen] + ".." + id[len(id)-n:]
+}
+
+// ReachableFrom returns the set of node indexes reachable from any of the
+// given indexes by following links, including the given indexes themselves.
+// Out of range indexes & links are ignored
+func (m *Manifest) ReachableFrom(indices []int) map[int]bool {
+	children := make([][]int, len(m.Nodes))
+	for _, l := range m.Links {
+		if m.linkInRange(l) {
+			children[l[0]] = append(children[l[0]], l[1])
+		}
+	}
+
+	reached := map[int]bool{}
+	stack := make([]int, 0, len(indices))
+	for _, idx := range indices {
+		if idx >= 0 && idx < len(m.Nodes) && !reached[idx] {
+			reached[idx] = true
+			stack = append(stack, idx)
+		}
+	}
+	for len(stack) > 0 {
+		idx := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, ch := range children[idx] {
+			if !reached[ch] {
+				reached[ch] = true
+				stack = append(stack, ch)
+			}
+		}
+	}
+	return reached
+}
+
+// Roots returns the indexes of nodes no link points to, in index order. A
+// single-root DAG returns [0], manifests that describe a forest of DAGs
+// return the index of each tree's root. Out of range links are ignored
+func (m *Manifest) Roots() []int {
+	linked := make([]bool, len(m.Nodes))
+	for _, l := range m.Links {
+		if l[1] >= 0 && l[1] < len(m.Nodes) {
+			linked[l[1]] = true
+		}
+	}
+
+	roots := []int{}
+	for i, hasParent := range linked {
+		if !hasParent {
+			roots = append(roots, i)
+		}
+	}
+	return roots
+}
+
+// Walk calls visit for each node in Nodes order, passing the node's index,
+// id, and the indexes of it's children in Links order. Manifests list the
+// root first, followed by nodes with more descendants before nodes with
+// fewer, so Walk visits the DAG from the root towards the leaves. Nodes are
+// visited once by index, nodes shared by several parents aren't revisited.
+// Walk stops at the first error visit returns, returning it as-is. Links out
+// of range error with ErrLinkOutOfRange before any node is visited
+func (m *Manifest) Walk(visit func(index int, cid string, children []int) error) error {
+	if err := m.checkLinks(); err != nil {
+		return err
+	}
+
+	children := make([][]int, len(m.Nodes))
+	for _, l := range m.Links {
+		children[l[0]] = append(children[l[0]], l[1])
+	}
+
+	for i, id := range m.Nodes {
+		if err := visit(i, id, children[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReverseTopologicalOrder returns manifest node indexes ordered so every node
+// comes after all of it's children, leaves first and the root last. Leaves
+// are listed in index order, followed by each parent once it's last child is
+// listed, so the result is deterministic. Links out of range error with
+// ErrLinkOutOfRange, cyclic links with ErrCycleDetected
+func (m *Manifest) ReverseTopologicalOrder() ([]int, error) {
+	if err := m.checkLinks(); err != nil {
+		return nil, err
+	}
+
+	parents := make([][]int, len(m.Nodes))
+	pending := make([]int, len(m.Nodes))
+	for _, l := range m.Links {
+		parents[l[1]] = append(parents[l[1]], l[0])
+		pending[l[0]]++
+	}
+
+	order := make([]int, 0, len(m.Nodes))
+	for i, n := range pending {
+		if n == 0 {
+			order = append(order, i)
+		}
+	}
+	for next := 0; next < len(order); next++ {
+		for _, p := range parents[order[next]] {
+			if pending[p]--; pending[p] == 0 {
+				order = append(order, p)
+			}
+		}
+	}
+
+	if len(order) != len(m.Nodes) {
+		return nil, fmt.Errorf("%w: %d nodes are on or above a cycle", ErrCycleDetected, len(m.Nodes)-len(order))
+	}
+	return order, nil
+}
+
+// TopoOrder returns manifest node indexes in topological order, so every node
+// comes before all of it's children, the root first and leaves last. Nodes
+// sorted by descendant count aren't strictly topological, a node shared by
+// several parents can be listed before some of them. Nodes without parents
+// are listed in index order, followed by each child once it's last parent is
+// listed, so the result is deterministic. Reverse it, or use
+// ReverseTopologicalOrder, to schedule leaves before their parents. Links out
+// of range error with ErrLinkOutOfRange, cyclic links with ErrCycleDetected
+func (m *Manifest) TopoOrder() ([]int, error) {
+	if err := m.checkLinks(); err != nil {
+		return nil, err
+	}
+
+	children := make([][]int, len(m.Nodes))
+	pending := make([]int, len(m.Nodes))
+	for _, l := range m.Links {
+		children[l[0]] = append(children[l[0]], l[1])
+		pending[l[1]]++
+	}
+
+	order := make([]int, 0, len(m.Nodes))
+	for i, n := range pending {
+		if n == 0 {
+			order = append(order, i)
+		}
+	}
+	for next := 0; next < len(order); next++ {
+		for _, ch := range children[order[next]] {
+			if pending[ch]--; pending[ch] == 0 {
+				order = append(order, ch)
+			}
+		}
+	}
+
+	if len(order) != len(m.Nodes) {
+		return nil, fmt.Errorf("%w: %d nodes are on or below a cycle", ErrCycleDetected, len(m.Nodes)-len(order))
+	}
+	return order, nil
+}
+
+// BFSOrder returns the indexes of nodes reachable from the root in
+// breadth-first order, following links in manifest order. Each node is
+// listed once, at it's shallowest depth. Nodes that aren't reachable from the
+// root are left out, as are links out of range
+func (m *Manifest) BFSOrder() []int {
+	if len(m.Nodes) == 0 {
+		return nil
+	}
+
+	children := make([][]int, len(m.Nodes))
+	for _, l := range m.Links {
+		if !m.linkInRange(l) {
+			continue
+		}
+		children[l[0]] = append(children[l[0]], l[1])
+	}
+
+	seen := make([]bool, len(m.Nodes))
+	seen[0] = true
+	order := []int{0}
+	for next := 0; next < len(order); next++ {
+		for _, ch := range children[order[next]] {
+			if !seen[ch] {
+				seen[ch] = true
+				order = append(order, ch)
+			}
+		}
+	}
+	return order
+}
+
+// Truncate returns a manifest of at most n nodes for previewing a large DAG.
+// Nodes are chosen breadth-first from the root, following links in manifest
+// order, so every kept node is reachable from the root. Kept nodes retain
+// their relative order, and links are renumbered to match. The result
+// describes a partial DAG: links to nodes that weren't kept are dropped, so
+// it isn't the manifest of any complete DAG. Out of range links are ignored
+func (m *Manifest) Truncate(n int) *Manifest {
+	if n <= 0 || len(m.Nodes) == 0 {
+		return &Manifest{}
+	}
+
+	children := make([][]int, len(m.Nodes))
+	for _, l := range m.Links {
+		if m.linkInRange(l) {
+			children[l[0]] = append(children[l[0]], l[1])
+		}
+	}
+
+	kept := make([]bool, len(m.Nodes))
+	kept[0] = true
+	count := 1
+	queue := []int{0}
+	for len(queue) > 0 && count < n {
+		idx := queue[0]
+		queue = queue[1:]
+		for _, ch := range children[idx] {
+			if count == n {
+				break
+			}
+			if !kept[ch] {
+				kept[ch] = true
+				count++
+				queue = append(queue, ch)
+			}
+		}
+	}
+
+	return m.subset(kept)
+}
+
+// Diff returns a manifest of the nodes in m that aren't in other, in m's
+// order. Links between nodes in the result are kept, renumbered to index into
+// the result, links to or from nodes in other are dropped. Diff only compares
+// node ids, other needn't describe the same DAG. Out of range links are
+// dropped too
+func (m *Manifest) Diff(other *Manifest) *Manifest {
+	have := newCIDSet(other.Nodes...)
+	kept := make([]bool, len(m.Nodes))
+	for i, id := range m.Nodes {
+		kept[i] = !have.Has(id)
+	}
+	return m.subset(kept)
+}
+
+// Union merges manifests into a single manifest of all their nodes, linked by
+// all of their links, for accounting for the blocks of several DAGs that
+// share nodes. The union of DAGs with different roots has several roots, so
+// isn't the manifest of a single DAG.
+//
+// Nodes are ordered by the manifest sort rules, with weights accounted by
+// walking from each root in order of root id, so the union of the same
+// manifests is identical regardless of argument order, and the union of a
+// single manifest is the manifest itself. Links out of range error with
+// ErrLinkOutOfRange, manifests that link into a cycle error with
+// ErrCycleDetected
+func Union(manifests ...*Manifest) (*Manifest, error) {
+	index := map[string]int{}
+	var nodes []string
+	for _, m := range manifests {
+		if m == nil {
+			continue
+		}
+		for _, id := range m.Nodes {
+			if _, ok := index[id]; !ok {
+				index[id] = len(nodes)
+				nodes = append(nodes, id)
+			}
+		}
+	}
+
+	merged := &Manifest{Nodes: nodes}
+	linked := map[[2]int]bool{}
+	for _, m := range manifests {
+		if m == nil {
+			continue
+		}
+		if err := m.checkLinks(); err != nil {
+			return nil, err
+		}
+		for _, l := range m.Links {
+			link := [2]int{index[m.Nodes[l[0]]], index[m.Nodes[l[1]]]}
+			if !linked[link] {
+				linked[link] = true
+				merged.Links = append(merged.Links, link)
+			}
+		}
+	}
+	if _, err := merged.TopoOrder(); err != nil {
+		return nil, err
+	}
+
+	children := make([][]int, len(nodes))
+	hasParent := make([]bool, len(nodes))
+	for _, l := range merged.Links {
+		children[l[0]] = append(children[l[0]], l[1])
+		hasParent[l[1]] = true
+	}
+	var roots []int
+	for i := range nodes {
+		if !hasParent[i] {
+			roots = append(roots, i)
+		}
+	}
+	sort.Slice(roots, func(i, j int) bool { return nodes[roots[i]] < nodes[roots[j]] })
+	weights := walkWeights(nodes, children, roots)
+
+	u := &Manifest{Nodes: make([]string, len(nodes))}
+	copy(u.Nodes, nodes)
+	sortNodes(u.Nodes, weights)
+	newIdx := make(map[string]int, len(u.Nodes))
+	for i, id := range u.Nodes {
+		newIdx[id] = i
+	}
+	var sl sortableLinks
+	for _, l := range merged.Links {
+		sl = append(sl, [2]int{newIdx[nodes[l[0]]], newIdx[nodes[l[1]]]})
+	}
+	sort.Sort(sl)
+	u.Links = ([][2]int)(sl)
+	return u, nil
+}
+
+// subset returns a manifest of the nodes of m where kept is true, keeping
+// m's order and the links between kept nodes
+func (m *Manifest) subset(kept []bool) *Manifest {
+	t := &Manifest{}
+	newIdx := make([]int, len(m.Nodes))
+	for i, id := range m.Nodes {
+		if kept[i] {
+			newIdx[i] = len(t.Nodes)
+			t.Nodes = append(t.Nodes, id)
+		}
+	}
+	for _, l := range m.Links {
+		if m.linkInRange(l) && kept[l[0]] && kept[l[1]] {
+			t.Links = append(t.Links, [2]int{newIdx[l[0]], newIdx[l[1]]})
+		}
+	}
+	return t
+}
+
+// linkInRange reports if both ends of a link index into the node list
+func (m *Manifest) linkInRange(l [2]int) bool {
+	return l[0] >= 0 && l[0] < len(m.Nodes) && l[1] >= 0 && l[1] < len(m.Nodes)
+}
+
+// checkLinks returns an error wrapping ErrLinkOutOfRange for the first link
+// that doesn't index into the node list
+func (m *Manifest) checkLinks() error {
+	for i, l := range m.Links {
+		if !m.linkInRange(l) {
+			return fmt.Errorf("%w: link %d %v, manifest has %d nodes", ErrLinkOutOfRange, i, l, len(m.Nodes))
+		}
+	}
+	return nil
+}
+
+// DecodeConfig configures decoding manifests
+type DecodeConfig struct {
+	// Strict makes decoding error on fields manifests don't define, like
+	// fields added by a newer version of this package. The default ignores
+	// unknown fields. Consumers that can't risk misreading a manifest they
+	// don't fully understand should decode strictly
+	Strict bool
+}
+
+// OptStrictDecode configures decoding to error on unknown manifest fields
+func OptStrictDecode(cfg *DecodeConfig) {
+	cfg.Strict = true
+}
+
 // UnmarshalCBORManifest decodes a manifest from a byte slice
-func UnmarshalCBORManifest(data []byte) (m *Manifest, err error) {
+func UnmarshalCBORManifest(data []byte, opts ...func(cfg *DecodeConfig)) (m *Manifest, err error) {
+	cfg := &DecodeConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	h := &codec.CborHandle{}
+	h.ErrorIfNoField = cfg.Strict
 	m = &Manifest{}
-	err = codec.NewDecoder(bytes.NewReader(data), &codec.CborHandle{}).Decode(m)
+	err = codec.NewDecoder(bytes.NewReader(data), h).Decode(m)
 	return
 }
 
+// UnmarshalJSONManifest decodes a manifest from JSON data, checking the
+// decoded manifest with Validate. Manifests that fail validation, like a
+// hand-edited manifest with bad link indexes, return an error. Partial
+// manifests that aren't the manifest of a complete DAG, (diffs, for example)
+// don't validate, and should be decoded with json.Unmarshal
+func UnmarshalJSONManifest(data []byte, opts ...func(cfg *DecodeConfig)) (*Manifest, error) {
+	cfg := &DecodeConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if cfg.Strict {
+		dec.DisallowUnknownFields()
+	}
+	m := &Manifest{}
+	if err := dec.Decode(m); err != nil {
+		return nil, err
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// EncodeJSONManifest writes the JSON encoding of a manifest to w, producing
+// the same bytes as json.Marshal. Nodes & links are written as they're
+// encoded, so peak memory doesn't grow with the size of the manifest
+func EncodeJSONManifest(w io.Writer, m *Manifest) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(`{"links":`)
+	if m.Links == nil {
+		bw.WriteString("null")
+	} else {
+		bw.WriteByte('[')
+		for i, l := range m.Links {
+			if i > 0 {
+				bw.WriteByte(',')
+			}
+			bw.WriteByte('[')
+			bw.WriteString(strconv.Itoa(l[0]))
+			bw.WriteByte(',')
+			bw.WriteString(strconv.Itoa(l[1]))
+			bw.WriteByte(']')
+		}
+		bw.WriteByte(']')
+	}
+
+	bw.WriteString(`,"nodes":`)
+	if m.Nodes == nil {
+		bw.WriteString("null")
+	} else {
+		bw.WriteByte('[')
+		for i, id := range m.Nodes {
+			if i > 0 {
+				bw.WriteByte(',')
+			}
+			data, err := json.Marshal(id)
+			if err != nil {
+				return err
+			}
+			if _, err := bw.Write(data); err != nil {
+				return err
+			}
+		}
+		bw.WriteByte(']')
+	}
+	bw.WriteByte('}')
+	return bw.Flush()
+}
+
+// sortableLinks orders links by from index, then by to index
 type sortableLinks [][2]int
 
 func (sl sortableLinks) Len() int { return len(sl) }
 func (sl sortableLinks) Less(i, j int) bool {
-	return (1000*(sl[i][0]+1) + (sl[i][1])) < (1000*(sl[j][0]+1) + (sl[j][1]))
+	if sl[i][0] != sl[j][0] {
+		return sl[i][0] < sl[j][0]
+	}
+	return sl[i][1] < sl[j][1]
 }
 func (sl sortableLinks) Swap(i, j int) { sl[i], sl[j] = sl[j], sl[i] }
 
@@ -147,23 +955,55 @@ type mstate struct {
 	links   [][2]string
 	sizes   map[string]uint64
 	m       *Manifest
+	// adding holds ids of nodes on the current recursion stack. A link to a
+	// node that's still being added is a cycle
+	adding map[string]bool
+	// fetchConcurrency is the number of linked nodes fetched at once
+	fetchConcurrency int
+	// batchFetch fetches linked nodes with GetMany
+	batchFetch bool
+	// linkNames holds the name of each link in links when recording paths,
+	// nil otherwise
+	linkNames []string
+	// profiler records build timings, nil when not profiling
+	profiler *buildProfiler
+}
+
+func newMState(ctx context.Context, ng ipld.NodeGetter, opts []func(cfg *ManifestConfig)) *mstate {
+	cfg := &ManifestConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	ms := &mstate{
+		ctx:     ctx,
+		ng:      ng,
+		weights: map[string]int{},
+		links:   [][2]string{},
+		sizes:   map[string]uint64{},
+		m:       &Manifest{},
+		adding:  map[string]bool{},
+
+		fetchConcurrency: cfg.FetchConcurrency,
+		batchFetch:       cfg.BatchFetch,
+	}
+	if cfg.Paths {
+		ms.linkNames = []string{}
+	}
+	return ms
 }
 
 func (ms *mstate) makeManifest(id cid.Cid) error {
-	node, err := ms.ng.Get(ms.ctx, id)
+	node, err := ms.get(id)
 	if err != nil {
 		return err
 	}
 
-	weight := 0
-	if err := ms.addNode(node, &weight); err != nil {
+	if err := ms.addNode(node); err != nil {
 		return err
 	}
 
-	// alpha sort keys
-	sort.StringSlice(ms.m.Nodes).Sort()
-	// then sort by weight
-	sort.Sort(ms)
+	sortStart := time.Now()
+	sortNodes(ms.m.Nodes, ms.weights)
 
 	// at this point indexes are set, re-use weights map to hold indicies
 	for i, id := range ms.m.Nodes {
@@ -177,64 +1017,312 @@ func (ms *mstate) makeManifest(id cid.Cid) error {
 	}
 	sort.Sort(sl)
 	ms.m.Links = ([][2]int)(sl)
+	ms.profiler.addSort(time.Since(sortStart))
 
 	return nil
 }
 
-// mstate implements the sort interface to sort Manifest nodes by weights
-func (ms *mstate) Len() int           { return len(ms.sizes) }
-func (ms *mstate) Less(a, b int) bool { return ms.weights[ms.m.Nodes[a]] > ms.weights[ms.m.Nodes[b]] }
-func (ms *mstate) Swap(i, j int)      { ms.m.Nodes[j], ms.m.Nodes[i] = ms.m.Nodes[i], ms.m.Nodes[j] }
+// sortNodes puts a list of node ids in manifest order. Any code that needs to
+// reproduce manifest order must use sortNodes, the weight sort isn't stable,
+// so the same sequence of sorts is needed to get identical results
+func sortNodes(nodes []string, weights map[string]int) {
+	// alpha sort keys
+	sort.StringSlice(nodes).Sort()
+	// then sort by weight
+	sort.Sort(byWeight{nodes: nodes, weights: weights})
+}
+
+// byWeight implements the sort interface to sort Manifest nodes by weights
+type byWeight struct {
+	nodes   []string
+	weights map[string]int
+}
 
-// addNode places a node in the manifest & state machine, recursively adding linked nodes
-// addNode returns early if this node is already added to the manifest
-// note (b5): this is one of my fav techniques. I ship hard for pointer outparams + recursion
-func (ms *mstate) addNode(node Node, weight *int) (err error) {
+func (bw byWeight) Len() int           { return len(bw.nodes) }
+func (bw byWeight) Less(a, b int) bool { return bw.weights[bw.nodes[a]] > bw.weights[bw.nodes[b]] }
+func (bw byWeight) Swap(i, j int)      { bw.nodes[j], bw.nodes[i] = bw.nodes[i], bw.nodes[j] }
+
+// addNode places a node in the manifest & state machine, adding all nodes
+// linked from it. Nodes already added to the manifest are skipped, a link to
+// a node that's still being added errors with ErrCycleDetected.
+// links are visited in order of child id, not the order node.Links() returns
+// them. The weight of a node reachable by more than one path depends on the
+// order links are visited, so a fixed order keeps manifests deterministic for
+// node implementations that don't return links in a stable order.
+// The walk is depth-first, using an explicit stack instead of recursion so
+// deep graphs like long chains don't grow the goroutine stack with depth
+func (ms *mstate) addNode(node Node) error {
 	id := node.Cid().String()
 	if _, ok := ms.sizes[id]; ok {
 		return nil
 	}
 
-	ms.m.Nodes = append(ms.m.Nodes, id)
-	lWeight := 0
+	// addFrame tracks a node being added: it's sorted links, any prefetched
+	// linked nodes, the next link to visit, and the weight accumulated from
+	// links visited so far
+	type addFrame struct {
+		id      string
+		links   []*ipld.Link
+		fetched []fetchedNode
+		next    int
+		weight  int
+	}
+	var stack []addFrame
+	enter := func(node Node) error {
+		id := node.Cid().String()
+		ms.adding[id] = true
+		ms.m.Nodes = append(ms.m.Nodes, id)
 
-	ms.sizes[id], err = node.Size()
-	if err != nil {
-		return
+		sizeStart := time.Now()
+		size, err := node.Size()
+		ms.profiler.addSize(time.Since(sizeStart))
+		if err != nil {
+			return err
+		}
+		ms.sizes[id] = size
+
+		// copy before sorting, nodes may return their internal link slice
+		links := append([]*ipld.Link{}, node.Links()...)
+		sortStart := time.Now()
+		sort.SliceStable(links, func(i, j int) bool { return links[i].Cid.String() < links[j].Cid.String() })
+		ms.profiler.addSort(time.Since(sortStart))
+		stack = append(stack, addFrame{id: id, links: links, fetched: ms.prefetch(links)})
+		return nil
 	}
 
-	for _, link := range node.Links() {
-		*weight++
+	if err := enter(node); err != nil {
+		return err
+	}
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.next == len(top.links) {
+			ms.weights[top.id] = top.weight
+			delete(ms.adding, top.id)
+			added := top.weight
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 {
+				stack[len(stack)-1].weight += added
+			}
+			continue
+		}
+
+		link := top.links[top.next]
+		var fetched fetchedNode
+		if top.fetched != nil {
+			fetched = top.fetched[top.next]
+		}
+		top.next++
+		top.weight++
 
-		linkNode, err := link.GetNode(ms.ctx, ms.ng)
+		linkNode, err := fetched.node, fetched.err
+		if linkNode == nil && err == nil {
+			linkNode, err = ms.get(link.Cid)
+		}
 		if err != nil {
 			return err
 		}
-		ms.links = append(ms.links, [2]string{id, linkNode.Cid().String()})
+		linkID := linkNode.Cid().String()
+		ms.links = append(ms.links, [2]string{top.id, linkID})
+		if ms.linkNames != nil {
+			ms.linkNames = append(ms.linkNames, link.Name)
+		}
 
-		lWeight = 0
-		if err = ms.addNode(linkNode, &lWeight); err != nil {
+		if ms.adding[linkID] {
+			return fmt.Errorf("%w: link to node %s forms a cycle", ErrCycleDetected, linkID)
+		}
+		if _, ok := ms.sizes[linkID]; ok {
+			continue
+		}
+		if err := enter(linkNode); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		*weight += lWeight
+// paths labels manifest nodes with their path from the root through named
+// links, visiting each node once breadth-first. Links with names that aren't
+// a single path element are skipped. makeManifest must be called
+// first, so the weights map holds node indexes
+func (ms *mstate) paths() map[string]int {
+	type namedLink struct {
+		name string
+		to   int
+	}
+	children := map[int][]namedLink{}
+	for i, l := range ms.links {
+		if name := ms.linkNames[i]; name != "" && name != "." && name != ".." && !strings.Contains(name, "/") {
+			from := ms.weights[l[0]]
+			children[from] = append(children[from], namedLink{name: name, to: ms.weights[l[1]]})
+		}
 	}
 
-	ms.weights[id] = *weight
-	return nil
+	type entry struct {
+		idx  int
+		path string
+	}
+	paths := map[string]int{"/": 0}
+	visited := map[int]bool{0: true}
+	queue := []entry{{0, "/"}}
+	for len(queue) > 0 {
+		e := queue[0]
+		queue = queue[1:]
+		for _, ch := range children[e.idx] {
+			if visited[ch.to] {
+				continue
+			}
+			visited[ch.to] = true
+			p := path.Join(e.path, ch.name)
+			paths[p] = ch.to
+			queue = append(queue, entry{ch.to, p})
+		}
+	}
+	return paths
 }
 
-// NewInfo creates an info with an underlying manifest
-func NewInfo(ctx context.Context, ng ipld.NodeGetter, id cid.Cid) (*Info, error) {
-	ms := &mstate{
-		ctx:     ctx,
-		ng:      ng,
-		weights: map[string]int{},
-		links:   [][2]string{},
-		sizes:   map[string]uint64{},
-		m:       &Manifest{},
+// get fetches a node from the state's NodeGetter
+func (ms *mstate) get(id cid.Cid) (ipld.Node, error) {
+	if ms.profiler == nil {
+		return ms.ng.Get(ms.ctx, id)
+	}
+	start := time.Now()
+	nd, err := ms.ng.Get(ms.ctx, id)
+	ms.profiler.addFetch(id, time.Since(start))
+	return nd, err
+}
+
+// fetchedNode is the result of prefetching a linked node
+type fetchedNode struct {
+	node ipld.Node
+	err  error
+}
+
+// prefetch fetches the nodes of links concurrently or in batches when the
+// state is configured to, skipping nodes already added to the manifest. Links
+// that weren't prefetched have a zero result, and are fetched when they're
+// visited
+func (ms *mstate) prefetch(links []*ipld.Link) []fetchedNode {
+	if (ms.fetchConcurrency < 2 && !ms.batchFetch) || len(links) < 2 {
+		return nil
+	}
+
+	var idxs []int
+	for i, link := range links {
+		if _, ok := ms.sizes[link.Cid.String()]; !ok {
+			idxs = append(idxs, i)
+		}
+	}
+
+	fetched := make([]fetchedNode, len(links))
+	if ms.batchFetch {
+		ms.prefetchBatches(links, idxs, fetched)
+		return fetched
+	}
+
+	work := make(chan int)
+	workers := ms.fetchConcurrency
+	if workers > len(idxs) {
+		workers = len(idxs)
+	}
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				nd, err := ms.get(links[i].Cid)
+				fetched[i] = fetchedNode{node: nd, err: err}
+			}
+		}()
+	}
+	for _, i := range idxs {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	return fetched
+}
+
+// prefetchBatches fetches the nodes of links at idxs with GetMany, splitting
+// them into up to fetchConcurrency batches fetched at once
+func (ms *mstate) prefetchBatches(links []*ipld.Link, idxs []int, fetched []fetchedNode) {
+	batches := 1
+	if ms.fetchConcurrency > 1 {
+		batches = ms.fetchConcurrency
+	}
+	if batches > len(idxs) {
+		batches = len(idxs)
+	}
+	if batches == 0 {
+		return
+	}
+	size := (len(idxs) + batches - 1) / batches
+
+	wg := sync.WaitGroup{}
+	for start := 0; start < len(idxs); start += size {
+		end := start + size
+		if end > len(idxs) {
+			end = len(idxs)
+		}
+		wg.Add(1)
+		go func(batch []int) {
+			defer wg.Done()
+			ms.getMany(links, batch, fetched)
+		}(idxs[start:end])
+	}
+	wg.Wait()
+}
+
+// getMany fetches the nodes of links at idxs with a single GetMany call,
+// matching results to links by CID. GetMany results can't be attributed to a
+// CID when they error, so an error ends the batch, leaving the rest to be
+// fetched with Get, which reports the node that failed
+func (ms *mstate) getMany(links []*ipld.Link, idxs []int, fetched []fetchedNode) {
+	ctx, cancel := context.WithCancel(ms.ctx)
+	defer cancel()
+
+	want := map[string][]int{}
+	ids := make([]cid.Cid, 0, len(idxs))
+	for _, i := range idxs {
+		key := links[i].Cid.KeyString()
+		if _, ok := want[key]; !ok {
+			ids = append(ids, links[i].Cid)
+		}
+		want[key] = append(want[key], i)
 	}
 
+	start := time.Now()
+	res := ms.ng.GetMany(ctx, ids)
+	// NodeGetters needn't close the channel GetMany returns, read only as many
+	// results as were requested
+	for range ids {
+		var opt *ipld.NodeOption
+		select {
+		case o, ok := <-res:
+			if !ok {
+				return
+			}
+			opt = o
+		case <-ctx.Done():
+			return
+		}
+		if opt.Err != nil {
+			return
+		}
+		ms.profiler.addFetch(opt.Node.Cid(), time.Since(start))
+		for _, i := range want[opt.Node.Cid().KeyString()] {
+			fetched[i] = fetchedNode{node: opt.Node}
+		}
+	}
+}
+
+// NewInfo creates an info with an underlying manifest
+func NewInfo(ctx context.Context, ng ipld.NodeGetter, id cid.Cid, opts ...func(cfg *ManifestConfig)) (*Info, error) {
+	return newMState(ctx, ng, opts).info(id)
+}
+
+// info builds an Info for the DAG rooted at id
+func (ms *mstate) info(id cid.Cid) (*Info, error) {
 	err := ms.makeManifest(id)
 	if err != nil {
 		return nil, err
@@ -249,6 +1337,9 @@ func NewInfo(ctx context.Context, ng ipld.NodeGetter, id cid.Cid) (*Info, error)
 		Manifest: ms.m,
 		Sizes:    sizes,
 	}
+	if ms.linkNames != nil {
+		di.Labels = ms.paths()
+	}
 
 	return di, nil
 }
@@ -298,6 +1389,47 @@ func (i *Info) RootCID() cid.Cid {
 	return i.Manifest.RootCID()
 }
 
+// TotalSize returns the size of the whole DAG in bytes, the sum of all node
+// sizes. Each node is counted once, no matter how many nodes link to it.
+// Infos without sizes have a total size of zero
+func (i *Info) TotalSize() uint64 {
+	var total uint64
+	for _, size := range i.Sizes {
+		total += size
+	}
+	return total
+}
+
+// EstimateTransferTime estimates how long sending the whole DAG takes at a
+// rate of bytesPerSec, using node sizes. Infos without sizes, and rates that
+// aren't positive estimate zero
+func (i *Info) EstimateTransferTime(bytesPerSec float64) time.Duration {
+	return transferTime(float64(i.TotalSize()), bytesPerSec)
+}
+
+// EstimateRemainingTime estimates how long sending the blocks that aren't
+// complete in c takes at a rate of bytesPerSec. Partially complete blocks
+// count the part left to send. c must describe this info's manifest
+func (i *Info) EstimateRemainingTime(c Completion, bytesPerSec float64) (time.Duration, error) {
+	if len(c) != len(i.Sizes) {
+		return 0, fmt.Errorf("completion length %d doesn't match %d node sizes", len(c), len(i.Sizes))
+	}
+	var remaining float64
+	for idx, size := range i.Sizes {
+		if c[idx] < 100 {
+			remaining += float64(size) * float64(100-c[idx]) / 100
+		}
+	}
+	return transferTime(remaining, bytesPerSec), nil
+}
+
+func transferTime(size, bytesPerSec float64) time.Duration {
+	if bytesPerSec <= 0 {
+		return 0
+	}
+	return time.Duration(size / bytesPerSec * float64(time.Second))
+}
+
 // MarshalCBOR encodes a dag.Info as CBOR data
 func (i *Info) MarshalCBOR() (data []byte, err error) {
 	buf := &bytes.Buffer{}
@@ -348,7 +1480,11 @@ func NewCompletion(mfst, missing *Manifest) Completion {
 }
 
 // Percentage expressess the completion as a floating point number betwen 0.0 and 1.0
+// An empty completion has nothing left to transfer, and is 1.0, matching Complete
 func (p Completion) Percentage() (pct float32) {
+	if len(p) == 0 {
+		return 1
+	}
 	for _, bl := range p {
 		pct += float32(bl) / float32(100)
 	}
@@ -365,7 +1501,7 @@ func (p Completion) CompletedBlocks() (count int) {
 	return count
 }
 
-// Complete returns weather progress is finished
+// Complete returns weather progress is finished. Empty completions are complete
 func (p Completion) Complete() bool {
 	for _, bl := range p {
 		if bl != 100 {
@@ -374,3 +1510,89 @@ func (p Completion) Complete() bool {
 	}
 	return true
 }
+
+// Diff returns the indexes of blocks that are complete in p but were
+// incomplete in a previous snapshot of the same completion, in index order.
+// Diff errors if the completions are of different lengths
+func (p Completion) Diff(prev Completion) (newlyComplete []int, err error) {
+	if len(p) != len(prev) {
+		return nil, fmt.Errorf("completion length mismatch. previous: %d, current: %d", len(prev), len(p))
+	}
+	for i, bl := range p {
+		if bl == 100 && prev[i] != 100 {
+			newlyComplete = append(newlyComplete, i)
+		}
+	}
+	return newlyComplete, nil
+}
+
+const (
+	// BlockStatusComplete is the StatusMap status of a fully transferred block
+	BlockStatusComplete = "complete"
+	// BlockStatusPartial is the StatusMap status of a block that's been partly
+	// transferred
+	BlockStatusPartial = "partial"
+	// BlockStatusPending is the StatusMap status of a block that hasn't been
+	// transferred at all
+	BlockStatusPending = "pending"
+)
+
+// StatusMap describes the completion of each node in a manifest by CID, with
+// one of BlockStatusComplete, BlockStatusPartial or BlockStatusPending.
+// Completions that don't match the manifest are read as far as they go, nodes
+// past the end of p are pending, values past the end of the manifest are
+// ignored. A nil manifest produces an empty map
+func (p Completion) StatusMap(m *Manifest) map[string]string {
+	statuses := map[string]string{}
+	if m == nil {
+		return statuses
+	}
+	for i, id := range m.Nodes {
+		switch {
+		case i >= len(p) || p[i] == 0:
+			statuses[id] = BlockStatusPending
+		case p[i] >= 100:
+			statuses[id] = BlockStatusComplete
+		default:
+			statuses[id] = BlockStatusPartial
+		}
+	}
+	return statuses
+}
+
+// MarshalBinary encodes a completion compactly as a uvarint count of blocks
+// followed by one byte per block. Values above 100 are clamped to 100
+func (p Completion) MarshalBinary() ([]byte, error) {
+	data := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(p))
+	n := binary.PutUvarint(data, uint64(len(p)))
+	data = data[:n]
+	for _, bl := range p {
+		if bl > 100 {
+			bl = 100
+		}
+		data = append(data, byte(bl))
+	}
+	return data, nil
+}
+
+// UnmarshalCompletion decodes a completion encoded with
+// Completion.MarshalBinary, erroring if the block count doesn't match the
+// data or any value is above 100
+func UnmarshalCompletion(data []byte) (Completion, error) {
+	count, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid completion: reading block count")
+	}
+	data = data[n:]
+	if count != uint64(len(data)) {
+		return nil, fmt.Errorf("invalid completion: expected %d blocks, got %d", count, len(data))
+	}
+	p := make(Completion, len(data))
+	for i, bl := range data {
+		if bl > 100 {
+			return nil, fmt.Errorf("invalid completion: block %d is %d%% complete", i, bl)
+		}
+		p[i] = uint16(bl)
+	}
+	return p, nil
+}
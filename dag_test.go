@@ -3,15 +3,24 @@ package dag
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/multiformats/go-multihash"
 	"github.com/ugorji/go/codec"
 
 	"github.com/ipfs/go-cid"
 	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	unixfs "github.com/ipfs/go-unixfs"
 )
 
 func TestGraphManifestSizeRato(t *testing.T) {
@@ -88,6 +97,43 @@ func TestNewManifest(t *testing.T) {
 	verifyManifest(t, exp, mf)
 }
 
+func TestNewManifestLinkOrder(t *testing.T) {
+	content = 0
+
+	// e is reachable from both b & c, so it's weight is counted toward which
+	// ever of the two is visited first
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	d := newNode(40)
+	e := newNode(50)
+	f := newNode(60)
+	a.links = []*node{b, c, d}
+	b.links = []*node{e}
+	c.links = []*node{e, f}
+	e.links = []*node{f}
+
+	ctx := context.Background()
+	ng := TestingNodeGetter{[]ipld.Node{a, b, c, d, e, f}}
+	expect, err := NewManifest(ctx, ng, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		// shuffle the links every node returns
+		for _, n := range []*node{a, b, c, d, e, f} {
+			rnd.Shuffle(len(n.links), func(i, j int) { n.links[i], n.links[j] = n.links[j], n.links[i] })
+		}
+		got, err := NewManifest(ctx, ng, a.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyManifest(t, expect, got)
+	}
+}
+
 func TestIDIndex(t *testing.T) {
 	content = 0
 
@@ -312,6 +358,7 @@ type layer struct {
 
 type node struct {
 	cid   *cid.Cid
+	data  []byte
 	size  uint64
 	links []*node
 }
@@ -332,7 +379,7 @@ func (n node) Links() (links []*ipld.Link) {
 // Not needed for manifest test:
 func (n node) Loggable() map[string]interface{}                        { return nil }
 func (n node) Copy() ipld.Node                                         { return nil }
-func (n node) RawData() []byte                                         { return nil }
+func (n node) RawData() []byte                                         { return n.data }
 func (n node) Resolve(path []string) (interface{}, []string, error)    { return nil, nil, nil }
 func (n node) ResolveLink(path []string) (*ipld.Link, []string, error) { return nil, nil, nil }
 func (n node) Stat() (*ipld.NodeStat, error)                           { return nil, nil }
@@ -369,7 +416,8 @@ func newNode(size uint64) *node {
 	}
 
 	// And then feed it some data
-	c, err := pref.Sum([]byte(strconv.Itoa(content)))
+	data := []byte(strconv.Itoa(content))
+	c, err := pref.Sum(data)
 	if err != nil {
 		panic(err)
 	}
@@ -377,6 +425,7 @@ func newNode(size uint64) *node {
 	content++
 	return &node{
 		cid:  &c,
+		data: data,
 		size: size,
 	}
 }
@@ -455,6 +504,155 @@ func TestCompletion(t *testing.T) {
 	}
 }
 
+func TestCompletionPercentage(t *testing.T) {
+	cases := []struct {
+		description string
+		comp        Completion
+		pct         float32
+		complete    bool
+	}{
+		{"empty", Completion{}, 1, true},
+		{"nil", nil, 1, true},
+		{"all zero", Completion{0, 0, 0, 0}, 0, false},
+		{"all complete", Completion{100, 100, 100, 100}, 1, true},
+		{"mixed", Completion{100, 0, 50, 50}, 0.5, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			if got := c.comp.Percentage(); got != c.pct {
+				t.Errorf("percentage mismatch. expected: %f, got: %f", c.pct, got)
+			}
+			if got := c.comp.Complete(); got != c.complete {
+				t.Errorf("complete mismatch. expected: %t, got: %t", c.complete, got)
+			}
+		})
+	}
+}
+
+func TestCompletionStatusMap(t *testing.T) {
+	m := &Manifest{Nodes: []string{"a", "b", "c", "d"}}
+
+	cases := []struct {
+		description string
+		comp        Completion
+		m           *Manifest
+		expect      map[string]string
+	}{
+		{"mixed", Completion{100, 0, 50, 100}, m, map[string]string{
+			"a": BlockStatusComplete,
+			"b": BlockStatusPending,
+			"c": BlockStatusPartial,
+			"d": BlockStatusComplete,
+		}},
+		{"short completion", Completion{100, 1}, m, map[string]string{
+			"a": BlockStatusComplete,
+			"b": BlockStatusPartial,
+			"c": BlockStatusPending,
+			"d": BlockStatusPending,
+		}},
+		{"long completion", Completion{100, 100, 100, 100, 0}, m, map[string]string{
+			"a": BlockStatusComplete,
+			"b": BlockStatusComplete,
+			"c": BlockStatusComplete,
+			"d": BlockStatusComplete,
+		}},
+		{"empty manifest", Completion{100}, &Manifest{}, map[string]string{}},
+		{"nil manifest", Completion{100}, nil, map[string]string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			got := c.comp.StatusMap(c.m)
+			if !reflect.DeepEqual(c.expect, got) {
+				t.Errorf("result mismatch.\nexpected: %v\ngot:      %v", c.expect, got)
+			}
+		})
+	}
+}
+
+func TestCompletionBinary(t *testing.T) {
+	large := make(Completion, 1000)
+	for i := range large {
+		large[i] = uint16(i % 101)
+	}
+
+	cases := []struct {
+		description string
+		comp        Completion
+	}{
+		{"empty", Completion{}},
+		{"mixed", Completion{0, 100, 50, 1, 99}},
+		{"large", large},
+	}
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			data, err := c.comp.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := UnmarshalCompletion(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c.comp, got) {
+				t.Errorf("round trip mismatch.\nexpected: %v\ngot:      %v", c.comp, got)
+			}
+		})
+	}
+
+	data, err := Completion{100, 250}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := UnmarshalCompletion(data); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(Completion{100, 100}, got) {
+		t.Errorf("expected out of range values to clamp to 100. got: %v", got)
+	}
+
+	bad := []struct {
+		description string
+		data        []byte
+	}{
+		{"empty data", nil},
+		{"out of range value", []byte{2, 100, 101}},
+		{"truncated", []byte{3, 100, 50}},
+		{"trailing data", []byte{1, 100, 50}},
+	}
+	for _, c := range bad {
+		t.Run(c.description, func(t *testing.T) {
+			if _, err := UnmarshalCompletion(c.data); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestCompletionDiff(t *testing.T) {
+	prev := Completion{0, 100, 50, 0, 100}
+	cur := Completion{100, 100, 100, 20, 100}
+	got, err := prev.Diff(prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("expected no newly completed blocks diffing a snapshot with itself. got: %v", got)
+	}
+
+	got, err = cur.Diff(prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []int{0, 2}
+	if fmt.Sprintf("%v", expect) != fmt.Sprintf("%v", got) {
+		t.Errorf("newly complete mismatch. expected: %v, got: %v", expect, got)
+	}
+
+	if _, err := cur.Diff(Completion{100}); err == nil {
+		t.Error("expected diffing completions of different lengths to error")
+	}
+}
+
 func TestNewCompletion(t *testing.T) {
 	mfst := &Manifest{
 		Nodes: []string{"a", "b", "c", "d"},
@@ -467,3 +665,1581 @@ func TestNewCompletion(t *testing.T) {
 		t.Errorf("expected completion percentage to equal 0.5. got: %f", comp.Percentage())
 	}
 }
+
+func TestCompletionFromStore(t *testing.T) {
+	content = 0
+
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	d := newNode(40)
+	a.links = []*node{b, c, d}
+
+	ctx := context.Background()
+	m, err := NewManifest(ctx, TestingNodeGetter{[]ipld.Node{a, b, c, d}}, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// store holds half the DAG
+	store := map[string]bool{a.Cid().String(): true, c.Cid().String(): true}
+	has := func(id cid.Cid) (bool, error) { return store[id.String()], nil }
+
+	comp, err := CompletionFromStore(ctx, has, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comp.Percentage() != 0.5 {
+		t.Errorf("expected completion percentage to equal 0.5. got: %f", comp.Percentage())
+	}
+	for i, id := range m.Nodes {
+		if expect := store[id]; (comp[i] == 100) != expect {
+			t.Errorf("node %d: expected complete: %t, got: %d", i, expect, comp[i])
+		}
+	}
+
+	errHas := func(cid.Cid) (bool, error) { return false, errors.New("store unavailable") }
+	if _, err := CompletionFromStore(ctx, errHas, m); err == nil {
+		t.Error("expected has error to be returned")
+	}
+}
+
+func TestVerifyNodeOrder(t *testing.T) {
+	content = 0
+
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	d := newNode(40)
+	e := newNode(50)
+	f := newNode(60)
+	a.links = []*node{b, c}
+	c.links = []*node{d, e}
+	d.links = []*node{f}
+
+	ctx := context.Background()
+	ng := TestingNodeGetter{[]ipld.Node{a, b, c, d, e, f}}
+	mf, err := NewManifest(ctx, ng, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mf.VerifyNodeOrder(); err != nil {
+		t.Errorf("expected generated manifest to verify. got: %s", err)
+	}
+
+	g := newGraph([]layer{{5, 4 * kb}, {5, 4 * kb}, {3, 4 * kb}})
+	large, err := NewManifest(ctx, TestingNodeGetter{g}, g[0].Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := large.VerifyNodeOrder(); err != nil {
+		t.Errorf("expected generated manifest with %d nodes to verify. got: %s", len(large.Nodes), err)
+	}
+
+	// swap c & d, remapping links so the graph itself is unchanged
+	swap := map[int]int{1: 2, 2: 1}
+	misordered := &Manifest{Nodes: append([]string{}, mf.Nodes...)}
+	misordered.Nodes[1], misordered.Nodes[2] = misordered.Nodes[2], misordered.Nodes[1]
+	for _, l := range mf.Links {
+		for i, idx := range l {
+			if to, ok := swap[idx]; ok {
+				l[i] = to
+			}
+		}
+		misordered.Links = append(misordered.Links, l)
+	}
+
+	if err := misordered.VerifyNodeOrder(); !errors.Is(err, ErrInvalidNodeOrder) {
+		t.Errorf("expected misordered manifest to fail with ErrInvalidNodeOrder. got: %v", err)
+	}
+}
+
+func TestManifestShortLabels(t *testing.T) {
+	m := &Manifest{Nodes: []string{
+		"bafkreic75tvwn76in44nsutynrwws3dzyln4eoo5j2i3izzj245cp62x5e",
+		"bafkreidlq2zhh7zu7tqz224aj37vup2xi6w2j2vcf4outqa6klo3pb23jm",
+		// share the last four characters
+		"bafkreiguonpdujs6c3xoap2zogfzwxidagoapwfwyupzbwr2mzxoye5abcd",
+		"bafkreicoa5aikyv63ofwbtqfyhpm7y5nc23semewpxqb6zalpzdstnexabcd",
+		// share the last four characters, and the fifth to last
+		"bafkreiclej3xpvg5d7dby34ij5egihicwtisdu75gkglbc2vgh6kzwv7zyxwvu",
+		"bafkreihpfujh3y33sqv2vudbixsuwddbtipsemt3f2547pwhr5kwjl7aaxwvu",
+		// too short to abbreviate
+		"short",
+	}}
+
+	expect := map[string]string{
+		m.Nodes[0]: "bafk..2x5e",
+		m.Nodes[1]: "bafk..23jm",
+		m.Nodes[2]: "bafk..5abcd",
+		m.Nodes[3]: "bafk..xabcd",
+		m.Nodes[4]: "bafk..yxwvu",
+		m.Nodes[5]: "bafk..axwvu",
+		m.Nodes[6]: "short",
+	}
+
+	labels := m.ShortLabels()
+	if fmt.Sprintf("%v", expect) != fmt.Sprintf("%v", labels) {
+		t.Errorf("labels mismatch.\nexpected: %v\ngot:      %v", expect, labels)
+	}
+
+	seen := map[string]bool{}
+	for _, l := range labels {
+		if seen[l] {
+			t.Errorf("duplicate label %q", l)
+		}
+		seen[l] = true
+	}
+
+	// labels don't depend on node order
+	reversed := &Manifest{}
+	for i := len(m.Nodes) - 1; i >= 0; i-- {
+		reversed.Nodes = append(reversed.Nodes, m.Nodes[i])
+	}
+	if fmt.Sprintf("%v", labels) != fmt.Sprintf("%v", reversed.ShortLabels()) {
+		t.Errorf("expected labels to be independent of node order")
+	}
+}
+
+func TestManifestHash(t *testing.T) {
+	content = 0
+
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	a.links = []*node{b, c}
+
+	ctx := context.Background()
+	ng := TestingNodeGetter{[]ipld.Node{a, b, c}}
+	m1, err := NewManifest(ctx, ng, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2, err := NewManifest(ctx, ng, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h1, err := m1.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := m2.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h1 != h2 {
+		t.Errorf("expected manifests of the same DAG to have equal hashes. got: %s != %s", h1, h2)
+	}
+
+	sub, err := NewManifest(ctx, ng, c.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h3, err := sub.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h1 == h3 {
+		t.Errorf("expected manifests of different DAGs to have different hashes")
+	}
+}
+
+func TestManifestEncodingEquivalence(t *testing.T) {
+	content = 0
+
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	d := newNode(40)
+	a.links = []*node{b, c}
+	b.links = []*node{d}
+	c.links = []*node{d}
+
+	ctx := context.Background()
+	diamond, err := NewManifest(ctx, TestingNodeGetter{[]ipld.Node{a, b, c, d}}, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	single, err := NewManifest(ctx, TestingNodeGetter{[]ipld.Node{d}}, d.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		description string
+		m           *Manifest
+	}{
+		{"empty", &Manifest{}},
+		{"empty slices", &Manifest{Links: [][2]int{}, Nodes: []string{}}},
+		{"single node", single},
+		{"diamond", diamond},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			cborData, err := c.m.MarshalCBOR()
+			if err != nil {
+				t.Fatal(err)
+			}
+			fromCBOR, err := UnmarshalCBORManifest(cborData)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			jsonData, err := json.Marshal(c.m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fromJSON, err := UnmarshalJSONManifest(jsonData)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(fromCBOR, fromJSON) {
+				t.Errorf("CBOR & JSON round trips disagree.\ncbor: %#v\njson: %#v", fromCBOR, fromJSON)
+			}
+			if !reflect.DeepEqual(c.m, fromCBOR) {
+				t.Errorf("CBOR round trip mismatch.\nexpected: %#v\ngot:      %#v", c.m, fromCBOR)
+			}
+		})
+	}
+}
+
+func TestUnmarshalJSONManifestValidates(t *testing.T) {
+	cases := []struct {
+		description string
+		data        string
+		err         error
+	}{
+		{"link out of range", `{"links":[[0,2]],"nodes":["a","b"]}`, ErrLinkOutOfRange},
+		{"duplicate node", `{"links":[[0,1]],"nodes":["a","a"]}`, ErrDuplicateNode},
+		{"misordered nodes", `{"links":[[1,0]],"nodes":["a","b"]}`, ErrInvalidNodeOrder},
+	}
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			if _, err := UnmarshalJSONManifest([]byte(c.data)); !errors.Is(err, c.err) {
+				t.Errorf("expected error to wrap %q. got: %v", c.err, err)
+			}
+		})
+	}
+}
+
+func TestUnmarshalManifestUnknownFields(t *testing.T) {
+	// a manifest from a future version, with a field this version doesn't know
+	future := map[string]interface{}{
+		"links":   [][2]int{{0, 1}},
+		"nodes":   []string{"a", "b"},
+		"version": 2,
+	}
+	cborBuf := &bytes.Buffer{}
+	if err := codec.NewEncoder(cborBuf, &codec.CborHandle{}).Encode(future); err != nil {
+		t.Fatal(err)
+	}
+	jsonData, err := json.Marshal(future)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := &Manifest{Links: [][2]int{{0, 1}}, Nodes: []string{"a", "b"}}
+	decoders := []struct {
+		description string
+		decode      func(opts ...func(cfg *DecodeConfig)) (*Manifest, error)
+	}{
+		{"cbor", func(opts ...func(cfg *DecodeConfig)) (*Manifest, error) {
+			return UnmarshalCBORManifest(cborBuf.Bytes(), opts...)
+		}},
+		{"json", func(opts ...func(cfg *DecodeConfig)) (*Manifest, error) {
+			return UnmarshalJSONManifest(jsonData, opts...)
+		}},
+	}
+
+	for _, d := range decoders {
+		t.Run(d.description, func(t *testing.T) {
+			got, err := d.decode()
+			if err != nil {
+				t.Fatalf("expected lenient decode to ignore unknown fields. got: %s", err)
+			}
+			if !reflect.DeepEqual(expect, got) {
+				t.Errorf("result mismatch.\nexpected: %#v\ngot:      %#v", expect, got)
+			}
+
+			if _, err := d.decode(OptStrictDecode); err == nil {
+				t.Error("expected strict decode to error on unknown fields")
+			}
+		})
+	}
+}
+
+func TestInfoEstimateTransferTime(t *testing.T) {
+	info := &Info{
+		Manifest: &Manifest{Nodes: []string{"a", "b", "c"}},
+		Sizes:    []uint64{3 * mb, 1 * mb, 1 * mb},
+	}
+
+	if got := info.EstimateTransferTime(kb * 100); got != time.Second*50 {
+		t.Errorf("expected 5mb at 100kb/s to take 50s. got: %s", got)
+	}
+	if got := info.EstimateTransferTime(0); got != 0 {
+		t.Errorf("expected a zero rate to estimate zero. got: %s", got)
+	}
+
+	// first block done, second halfway
+	got, err := info.EstimateRemainingTime(Completion{100, 50, 0}, kb*100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != time.Second*15 {
+		t.Errorf("expected 1.5mb at 100kb/s to take 15s. got: %s", got)
+	}
+
+	if _, err := info.EstimateRemainingTime(Completion{100}, kb*100); err == nil {
+		t.Error("expected completion of the wrong length to error")
+	}
+}
+
+func TestManifestTruncate(t *testing.T) {
+	content = 0
+
+	// a
+	// ├── b
+	// │   ├── d
+	// │   └── e
+	// └── c
+	//     └── f
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	d := newNode(40)
+	e := newNode(50)
+	f := newNode(60)
+	a.links = []*node{b, c}
+	b.links = []*node{d, e}
+	c.links = []*node{f}
+
+	ctx := context.Background()
+	m, err := NewManifest(ctx, TestingNodeGetter{[]ipld.Node{a, b, c, d, e, f}}, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n := 0; n <= len(m.Nodes)+1; n++ {
+		tr := m.Truncate(n)
+		expect := n
+		if expect > len(m.Nodes) {
+			expect = len(m.Nodes)
+		}
+		if len(tr.Nodes) != expect {
+			t.Errorf("truncate %d: expected %d nodes. got: %d", n, expect, len(tr.Nodes))
+			continue
+		}
+		if n == 0 {
+			continue
+		}
+		if tr.Nodes[0] != m.Nodes[0] {
+			t.Errorf("truncate %d: expected root to be first", n)
+		}
+
+		// every kept node is reachable from the root over kept links
+		reached := map[int]bool{0: true}
+		for _, l := range tr.Links {
+			if l[0] >= len(tr.Nodes) || l[1] >= len(tr.Nodes) {
+				t.Fatalf("truncate %d: link %v out of range", n, l)
+			}
+			if !reached[l[0]] {
+				t.Errorf("truncate %d: link %v from unreached node", n, l)
+			}
+			reached[l[1]] = true
+			if m.IDIndex(tr.Nodes[l[0]]) == -1 || m.IDIndex(tr.Nodes[l[1]]) == -1 {
+				t.Errorf("truncate %d: unknown node in link %v", n, l)
+			}
+		}
+		if len(reached) != len(tr.Nodes) {
+			t.Errorf("truncate %d: expected all %d nodes to be reachable. got: %d", n, len(tr.Nodes), len(reached))
+		}
+	}
+
+	if full := m.Truncate(len(m.Nodes)); fmt.Sprintf("%v", full) != fmt.Sprintf("%v", m) {
+		t.Errorf("expected truncating to the manifest length to keep the whole manifest.\nexpected: %v\ngot:      %v", m, full)
+	}
+
+	// malformed manifests with out of range links don't panic
+	bad := &Manifest{
+		Nodes: []string{"a", "b", "c"},
+		Links: [][2]int{{0, 1}, {0, 7}, {-1, 2}, {1, 2}},
+	}
+	expect := &Manifest{Nodes: []string{"a", "b", "c"}, Links: [][2]int{{0, 1}, {1, 2}}}
+	if got := bad.Truncate(3); !reflect.DeepEqual(expect, got) {
+		t.Errorf("expected out of range links to be dropped.\nexpected: %v\ngot:      %v", expect, got)
+	}
+}
+
+func TestEncodeJSONManifest(t *testing.T) {
+	content = 0
+
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	d := newNode(40)
+	a.links = []*node{b, c}
+	b.links = []*node{d}
+	c.links = []*node{d}
+	diamond, err := NewManifest(context.Background(), TestingNodeGetter{[]ipld.Node{a, b, c, d}}, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := &Manifest{}
+	for i := 0; i < 5000; i++ {
+		large.Nodes = append(large.Nodes, fmt.Sprintf("node-%d", i))
+		if i > 0 {
+			large.Links = append(large.Links, [2]int{i / 2, i})
+		}
+	}
+
+	cases := []struct {
+		description string
+		m           *Manifest
+	}{
+		{"empty", &Manifest{}},
+		{"empty slices", &Manifest{Links: [][2]int{}, Nodes: []string{}}},
+		{"escaped ids", &Manifest{Nodes: []string{`"quoted"`, "<tag>&", "tab\t"}}},
+		{"diamond", diamond},
+		{"large", large},
+	}
+
+	// plainManifest encodes with encoding/json's default struct encoding,
+	// without Manifest.MarshalJSON
+	type plainManifest Manifest
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			expect, err := json.Marshal((*plainManifest)(c.m))
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := &bytes.Buffer{}
+			if err := EncodeJSONManifest(buf, c.m); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(expect, buf.Bytes()) {
+				t.Errorf("streamed encoding mismatch.\nexpected: %s\ngot:      %s", expect, buf.Bytes())
+			}
+
+			marshalled, err := json.Marshal(c.m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(expect, marshalled) {
+				t.Errorf("MarshalJSON mismatch.\nexpected: %s\ngot:      %s", expect, marshalled)
+			}
+		})
+	}
+}
+
+func TestManifestChecksum(t *testing.T) {
+	content = 0
+
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	d := newNode(40)
+	a.links = []*node{b, c}
+	b.links = []*node{d}
+	c.links = []*node{d}
+	diamond, err := NewManifest(context.Background(), TestingNodeGetter{[]ipld.Node{a, b, c, d}}, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copyOf := func(m *Manifest) *Manifest {
+		return &Manifest{
+			Nodes: append([]string{}, m.Nodes...),
+			Links: append([][2]int{}, m.Links...),
+		}
+	}
+	changedNode := copyOf(diamond)
+	changedNode.Nodes[3] = "foo"
+	changedLink := copyOf(diamond)
+	changedLink.Links[0] = [2]int{0, 3}
+	// ids that concatenate to the same string must not collide
+	splitA := &Manifest{Nodes: []string{"ab", "c"}}
+	splitB := &Manifest{Nodes: []string{"a", "bc"}}
+
+	manifests := []*Manifest{
+		{},
+		diamond,
+		copyOf(diamond),
+		changedNode,
+		changedLink,
+		splitA,
+		splitB,
+	}
+
+	for i, m := range manifests {
+		sum := m.Checksum()
+		if again := m.Checksum(); again != sum {
+			t.Errorf("manifest %d: checksum changed between calls. %d != %d", i, sum, again)
+		}
+	}
+
+	for i, m := range manifests {
+		for j, o := range manifests {
+			expect := reflect.DeepEqual(m.Nodes, o.Nodes) && reflect.DeepEqual(m.Links, o.Links)
+			if got := m.Equal(o); got != expect {
+				t.Errorf("manifests %d & %d: expected Equal to be %t", i, j, expect)
+			}
+		}
+	}
+
+	var nilManifest *Manifest
+	if !nilManifest.Equal(nil) {
+		t.Error("expected nil manifests to be equal")
+	}
+	if diamond.Equal(nil) {
+		t.Error("expected a manifest not to equal nil")
+	}
+
+	// checksums are computed from contents, so they follow modifications
+	modified := copyOf(diamond)
+	before := modified.Checksum()
+	modified.Nodes[3] = changedNode.Nodes[3]
+	if sum := modified.Checksum(); sum == before || sum != changedNode.Checksum() {
+		t.Errorf("expected checksum of a modified manifest to change. got: %d", sum)
+	}
+}
+
+func TestManifestEqual(t *testing.T) {
+	content = 0
+	g := newGraph([]layer{
+		{3, kb},
+		{2, kb},
+	})
+	ctx := context.Background()
+	built, err := NewManifest(ctx, TestingNodeGetter{g}, g[0].Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same DAG from a different getter, with nodes fetched concurrently
+	rebuilt, err := NewManifest(ctx, newMapNodeGetter(g), g[0].Cid(), WithFetchConcurrency(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(built)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := UnmarshalJSONManifest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var nilManifest *Manifest
+	cases := []struct {
+		description string
+		a, b        *Manifest
+		expect      bool
+	}{
+		{"rebuilt", built, rebuilt, true},
+		{"decoded", built, decoded, true},
+		{"nil & empty slices", &Manifest{}, &Manifest{Links: [][2]int{}, Nodes: []string{}}, true},
+		{"truncated", built, built.Truncate(3), false},
+		{"nil receiver", nilManifest, built, false},
+		{"nil argument", built, nil, false},
+		{"nil receiver & argument", nilManifest, nil, true},
+		{"nil & empty", nilManifest, &Manifest{}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			if got := c.a.Equal(c.b); got != c.expect {
+				t.Errorf("expected Equal to be %t", c.expect)
+			}
+		})
+	}
+}
+
+func TestManifestNodeCID(t *testing.T) {
+	content = 0
+	a := newNode(10)
+	m := &Manifest{Nodes: []string{a.Cid().String(), "not_a_cid"}}
+
+	id, err := m.NodeCID(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !id.Equals(a.Cid()) {
+		t.Errorf("expected %s. got: %s", a.Cid(), id)
+	}
+	if !m.MustNodeCID(0).Equals(a.Cid()) {
+		t.Errorf("expected MustNodeCID to return %s", a.Cid())
+	}
+
+	for _, i := range []int{-1, 2} {
+		if _, err := m.NodeCID(i); !errors.Is(err, ErrIndexOutOfRange) {
+			t.Errorf("index %d: expected ErrIndexOutOfRange. got: %v", i, err)
+		}
+	}
+
+	if _, err := m.NodeCID(1); err == nil || errors.Is(err, ErrIndexOutOfRange) {
+		t.Errorf("expected a parse error for a malformed CID. got: %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected MustNodeCID to panic on a malformed CID")
+		}
+	}()
+	m.MustNodeCID(1)
+}
+
+func TestManifestLinkOrderLargeDAG(t *testing.T) {
+	content = 0
+	// more than 1000 nodes, with nodes that link to indexes past 1000
+	nodes := newGraph([]layer{{40, kb}, {55, kb}})
+	if len(nodes) <= 2000 {
+		t.Fatalf("expected more than 2000 nodes. got: %d", len(nodes))
+	}
+	ng := TestingNodeGetter{nodes}
+	ctx := context.Background()
+
+	m, err := NewManifest(ctx, ng, nodes[0].Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(m.Links); i++ {
+		prev, l := m.Links[i-1], m.Links[i]
+		if prev[0] > l[0] || (prev[0] == l[0] && prev[1] >= l[1]) {
+			t.Fatalf("links out of order at index %d: %v, %v", i, prev, l)
+		}
+	}
+
+	expect, err := m.MarshalCBOR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		again, err := NewManifest(ctx, ng, nodes[0].Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := again.MarshalCBOR()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expect, got) {
+			t.Fatalf("run %d: expected manifest encoding to be identical across runs", i)
+		}
+	}
+}
+
+func TestManifestDiff(t *testing.T) {
+	content = 0
+
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	d := newNode(40)
+	a.links = []*node{b, c}
+	b.links = []*node{d}
+	c.links = []*node{d}
+	m, err := NewManifest(context.Background(), TestingNodeGetter{[]ipld.Node{a, b, c, d}}, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := m.Diff(m); len(got.Nodes) != 0 || len(got.Links) != 0 {
+		t.Errorf("expected diff of identical manifests to be empty. got: %v", got)
+	}
+
+	disjoint := &Manifest{Nodes: []string{newNode(50).Cid().String()}}
+	if got := m.Diff(disjoint); !reflect.DeepEqual(got.Nodes, m.Nodes) || !reflect.DeepEqual(got.Links, m.Links) {
+		t.Errorf("expected diff of disjoint manifests to copy m.\nexpected: %v\ngot:      %v", m, got)
+	}
+
+	got := m.Diff(&Manifest{Nodes: []string{b.Cid().String()}})
+	expectNodes := []string{}
+	for _, id := range m.Nodes {
+		if id != b.Cid().String() {
+			expectNodes = append(expectNodes, id)
+		}
+	}
+	if !reflect.DeepEqual(expectNodes, got.Nodes) {
+		t.Errorf("nodes mismatch.\nexpected: %v\ngot:      %v", expectNodes, got.Nodes)
+	}
+	// a->c & c->d survive, a->b & b->d don't
+	expectLinks := [][2]int{
+		{got.IDIndex(a.Cid().String()), got.IDIndex(c.Cid().String())},
+		{got.IDIndex(c.Cid().String()), got.IDIndex(d.Cid().String())},
+	}
+	if !reflect.DeepEqual(expectLinks, got.Links) {
+		t.Errorf("links mismatch.\nexpected: %v\ngot:      %v", expectLinks, got.Links)
+	}
+
+	// malformed manifests with out of range links don't panic
+	bad := &Manifest{
+		Nodes: []string{"a", "b", "c"},
+		Links: [][2]int{{0, 1}, {1, 3}, {-1, 2}, {0, 2}},
+	}
+	got = bad.Diff(&Manifest{Nodes: []string{"b"}})
+	expect := &Manifest{Nodes: []string{"a", "c"}, Links: [][2]int{{0, 1}}}
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("expected out of range links to be dropped.\nexpected: %v\ngot:      %v", expect, got)
+	}
+}
+
+func TestUnion(t *testing.T) {
+	content = 0
+	ctx := context.Background()
+
+	// two datasets sharing a subtree:
+	// a -> b -> d -> e
+	// a -> c
+	// f -> d
+	// f -> g -> e
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	d := newNode(40)
+	e := newNode(50)
+	f := newNode(60)
+	g := newNode(70)
+	a.links = []*node{b, c}
+	b.links = []*node{d}
+	d.links = []*node{e}
+	f.links = []*node{d, g}
+	g.links = []*node{e}
+	ng := TestingNodeGetter{[]ipld.Node{a, b, c, d, e, f, g}}
+	mA, err := NewManifest(ctx, ng, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mF, err := NewManifest(ctx, ng, f.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mD, err := NewManifest(ctx, ng, d.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := Union(mA, mF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Nodes) != 7 {
+		t.Errorf("expected union of 7 distinct nodes. got: %d", len(u.Nodes))
+	}
+	if len(u.Links) != 7 {
+		t.Errorf("expected union of 7 distinct links. got: %d", len(u.Links))
+	}
+	for _, m := range []*Manifest{mA, mF} {
+		for _, l := range m.Links {
+			link := [2]int{u.IDIndex(m.Nodes[l[0]]), u.IDIndex(m.Nodes[l[1]])}
+			found := false
+			for _, ul := range u.Links {
+				found = found || ul == link
+			}
+			if !found {
+				t.Errorf("expected union to link %s -> %s", m.Nodes[l[0]], m.Nodes[l[1]])
+			}
+		}
+	}
+
+	expect, err := u.MarshalCBOR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, args := range [][]*Manifest{
+		{mF, mA},
+		{mD, mA, mF},
+		{mF, mD, mA, mF},
+	} {
+		got, err := Union(args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := got.MarshalCBOR()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expect, data) {
+			t.Errorf("case %d: expected union to be identical regardless of argument order", i)
+		}
+	}
+
+	single, err := Union(mA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !single.Equal(mA) {
+		t.Errorf("expected union of one manifest to be the manifest.\nexpected: %v\ngot:      %v", mA, single)
+	}
+
+	cyclic := &Manifest{Nodes: []string{"x", "y"}, Links: [][2]int{{0, 1}}}
+	back := &Manifest{Nodes: []string{"y", "x"}, Links: [][2]int{{0, 1}}}
+	if _, err := Union(cyclic, back); !errors.Is(err, ErrCycleDetected) {
+		t.Errorf("expected manifests linking into a cycle to error with ErrCycleDetected. got: %v", err)
+	}
+}
+
+func TestNewManifestCycle(t *testing.T) {
+	content = 0
+
+	// a -> b -> c -> b, d is reachable by two paths but isn't a cycle
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	d := newNode(40)
+	a.links = []*node{b, d}
+	b.links = []*node{c, d}
+	c.links = []*node{b}
+	ng := TestingNodeGetter{[]ipld.Node{a, b, c, d}}
+
+	if _, err := NewManifest(context.Background(), ng, a.Cid()); !errors.Is(err, ErrCycleDetected) {
+		t.Errorf("expected NewManifest error to wrap ErrCycleDetected. got: %v", err)
+	} else if !strings.Contains(err.Error(), b.Cid().String()) {
+		t.Errorf("expected error to name the node that closes the cycle. got: %s", err)
+	}
+	if _, err := NewInfo(context.Background(), ng, a.Cid()); !errors.Is(err, ErrCycleDetected) {
+		t.Errorf("expected NewInfo error to wrap ErrCycleDetected. got: %v", err)
+	}
+
+	c.links = nil
+	if _, err := NewManifest(context.Background(), ng, a.Cid()); err != nil {
+		t.Errorf("expected acyclic graph to produce a manifest. got: %s", err)
+	}
+}
+
+// mapNodeGetter looks up nodes by id in constant time, for graphs too large
+// for TestingNodeGetter
+type mapNodeGetter map[string]ipld.Node
+
+func (ng mapNodeGetter) Get(_ context.Context, id cid.Cid) (ipld.Node, error) {
+	if nd, ok := ng[id.KeyString()]; ok {
+		return nd, nil
+	}
+	return nil, fmt.Errorf("cid not found: %s", id.String())
+}
+
+func (ng mapNodeGetter) GetMany(ctx context.Context, ids []cid.Cid) <-chan *ipld.NodeOption {
+	ch := make(chan *ipld.NodeOption, len(ids))
+	for _, id := range ids {
+		nd, err := ng.Get(ctx, id)
+		ch <- &ipld.NodeOption{Node: nd, Err: err}
+	}
+	close(ch)
+	return ch
+}
+
+// newChain creates a linear DAG of n nodes, each linking to the next
+func newChain(n int) (root *node, ng mapNodeGetter) {
+	ng = mapNodeGetter{}
+	var prev *node
+	for i := 0; i < n; i++ {
+		nd := newNode(kb)
+		if prev != nil {
+			nd.links = []*node{prev}
+		}
+		ng[nd.Cid().KeyString()] = nd
+		prev = nd
+	}
+	return prev, ng
+}
+
+func TestNewManifestDeepChain(t *testing.T) {
+	content = 0
+	root, ng := newChain(10000)
+
+	m, err := NewManifest(context.Background(), ng, root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Nodes) != 10000 || len(m.Links) != 9999 {
+		t.Fatalf("expected 10000 nodes & 9999 links. got: %d nodes, %d links", len(m.Nodes), len(m.Links))
+	}
+	// nodes are ordered by descendant count, which follows the chain
+	for i, l := range m.Links {
+		if l != [2]int{i, i + 1} {
+			t.Fatalf("expected link %d to be [%d %d]. got: %v", i, i, i+1, l)
+		}
+	}
+	if err := m.VerifyNodeOrder(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestManifestValidateDeepChain(t *testing.T) {
+	// a chain deep enough to overflow the stack of a recursive walk
+	const n = 1000000
+	m := &Manifest{Nodes: make([]string, n), Links: make([][2]int, n-1)}
+	for i := range m.Nodes {
+		m.Nodes[i] = fmt.Sprintf("node_%07d", i)
+		if i > 0 {
+			m.Links[i-1] = [2]int{i - 1, i}
+		}
+	}
+
+	if err := m.VerifyNodeOrder(); err != nil {
+		t.Errorf("expected chain to verify. got: %s", err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Errorf("expected chain to validate. got: %s", err)
+	}
+
+	// relink the tail so the last node comes before its parent
+	m.Links[n-3] = [2]int{n - 3, n - 1}
+	m.Links[n-2] = [2]int{n - 1, n - 2}
+	if err := m.Validate(); !errors.Is(err, ErrInvalidNodeOrder) {
+		t.Errorf("expected misordered chain to fail with ErrInvalidNodeOrder. got: %v", err)
+	}
+}
+
+func BenchmarkNewManifestLinearDAG(b *testing.B) {
+	content = 0
+	root, ng := newChain(100000)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewManifest(ctx, ng, root.Cid()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// latentGetter delays every Get, like a NodeGetter backed by a network store
+type latentGetter struct {
+	ng      ipld.NodeGetter
+	latency func() time.Duration
+}
+
+func (lg latentGetter) Get(ctx context.Context, id cid.Cid) (ipld.Node, error) {
+	time.Sleep(lg.latency())
+	return lg.ng.Get(ctx, id)
+}
+
+func (lg latentGetter) GetMany(ctx context.Context, ids []cid.Cid) <-chan *ipld.NodeOption {
+	return lg.ng.GetMany(ctx, ids)
+}
+
+func newMapNodeGetter(nodes []ipld.Node) mapNodeGetter {
+	ng := mapNodeGetter{}
+	for _, nd := range nodes {
+		ng[nd.Cid().KeyString()] = nd
+	}
+	return ng
+}
+
+func TestNewManifestFetchConcurrency(t *testing.T) {
+	content = 0
+	g := newGraph([]layer{
+		{8, 4 * kb},
+		{4, 2 * kb},
+		{2, kb},
+	})
+	// nodes reachable by more than one path make weights depend on walk order
+	shared := newNode(kb)
+	g = append(g, shared)
+	for _, nd := range g[1:] {
+		if len(nd.(*node).links) > 0 {
+			nd.(*node).links = append(nd.(*node).links, shared)
+		}
+	}
+	ng := newMapNodeGetter(g)
+	ctx := context.Background()
+
+	expect, err := NewInfo(ctx, ng, g[0].Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// random latency completes fetches out of order
+	rnd := rand.New(rand.NewSource(0))
+	lock := sync.Mutex{}
+	jittery := latentGetter{ng: ng, latency: func() time.Duration {
+		lock.Lock()
+		defer lock.Unlock()
+		return time.Duration(rnd.Intn(200)) * time.Microsecond
+	}}
+	for _, n := range []int{2, 4, 16} {
+		got, err := NewInfo(ctx, jittery, g[0].Cid(), WithFetchConcurrency(n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expect, got) {
+			t.Errorf("concurrency %d: info mismatch with serial build", n)
+		}
+	}
+
+	// missing nodes error the same way they do serially
+	missing := newMapNodeGetter(g)
+	delete(missing, g[3].Cid().KeyString())
+	_, serialErr := NewManifest(ctx, missing, g[0].Cid())
+	_, concurrentErr := NewManifest(ctx, missing, g[0].Cid(), WithFetchConcurrency(4))
+	if serialErr == nil || concurrentErr == nil || serialErr.Error() != concurrentErr.Error() {
+		t.Errorf("expected serial & concurrent builds to error alike.\nserial:     %v\nconcurrent: %v", serialErr, concurrentErr)
+	}
+}
+
+func TestNewManifestBatchFetch(t *testing.T) {
+	content = 0
+	g := newGraph([]layer{
+		{8, 4 * kb},
+		{4, 2 * kb},
+		{2, kb},
+	})
+	ng := newMapNodeGetter(g)
+	ctx := context.Background()
+
+	expect, err := NewInfo(ctx, ng, g[0].Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []int{0, 4} {
+		counter := &callCountingGetter{ng: ng}
+		got, err := NewInfo(ctx, counter, g[0].Cid(), WithBatchFetch(true), WithFetchConcurrency(n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expect, got) {
+			t.Errorf("concurrency %d: info mismatch with serial build", n)
+		}
+		// only the root is fetched with Get, each node's children are batched
+		if counter.gets != 1 {
+			t.Errorf("concurrency %d: expected 1 Get call. got: %d", n, counter.gets)
+		}
+		if counter.getManys == 0 {
+			t.Errorf("concurrency %d: expected GetMany calls", n)
+		}
+	}
+
+	// getters that don't support GetMany fall back to Get
+	counter := &callCountingGetter{ng: ng, getManyErr: fmt.Errorf("doesn't support GetMany")}
+	got, err := NewInfo(ctx, counter, g[0].Cid(), WithBatchFetch(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("info mismatch with serial build after GetMany errors")
+	}
+	if counter.gets != len(g) {
+		t.Errorf("expected %d Get calls. got: %d", len(g), counter.gets)
+	}
+
+	// missing nodes error the same way they do serially
+	missing := newMapNodeGetter(g)
+	delete(missing, g[3].Cid().KeyString())
+	_, serialErr := NewManifest(ctx, missing, g[0].Cid())
+	_, batchErr := NewManifest(ctx, missing, g[0].Cid(), WithBatchFetch(true))
+	if serialErr == nil || batchErr == nil || serialErr.Error() != batchErr.Error() {
+		t.Errorf("expected serial & batched builds to error alike.\nserial:  %v\nbatched: %v", serialErr, batchErr)
+	}
+}
+
+// callCountingGetter counts calls to Get & GetMany. GetMany errors with
+// getManyErr when it's set
+type callCountingGetter struct {
+	ng         ipld.NodeGetter
+	getManyErr error
+	lock       sync.Mutex
+	gets       int
+	getManys   int
+}
+
+func (cg *callCountingGetter) Get(ctx context.Context, id cid.Cid) (ipld.Node, error) {
+	cg.lock.Lock()
+	cg.gets++
+	cg.lock.Unlock()
+	return cg.ng.Get(ctx, id)
+}
+
+func (cg *callCountingGetter) GetMany(ctx context.Context, ids []cid.Cid) <-chan *ipld.NodeOption {
+	cg.lock.Lock()
+	cg.getManys++
+	cg.lock.Unlock()
+	if cg.getManyErr != nil {
+		ch := make(chan *ipld.NodeOption, 1)
+		ch <- &ipld.NodeOption{Err: cg.getManyErr}
+		return ch
+	}
+	return cg.ng.GetMany(ctx, ids)
+}
+
+func BenchmarkNewManifestFetchConcurrency(b *testing.B) {
+	content = 0
+	g := newGraph([]layer{
+		{64, kb},
+		{4, kb},
+	})
+	ng := latentGetter{ng: newMapNodeGetter(g), latency: func() time.Duration { return 100 * time.Microsecond }}
+	ctx := context.Background()
+
+	for _, n := range []int{1, 8, 32} {
+		b.Run(fmt.Sprintf("concurrency_%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := NewManifest(ctx, ng, g[0].Cid(), WithFetchConcurrency(n)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewInfoWithPaths(t *testing.T) {
+	// root/
+	//   a.txt
+	//   data/
+	//     foo.csv (two chunks)
+	//     copy.txt -> same node as a.txt
+	var nodes []ipld.Node
+	file := func(data string) *merkledag.ProtoNode {
+		nd := merkledag.NodeWithData(unixfs.FilePBData([]byte(data), uint64(len(data))))
+		nodes = append(nodes, nd)
+		return nd
+	}
+	dir := func(links map[string]ipld.Node) *merkledag.ProtoNode {
+		nd := merkledag.NodeWithData(unixfs.FolderPBData())
+		for name, ch := range links {
+			if err := nd.AddNodeLink(name, ch); err != nil {
+				t.Fatal(err)
+			}
+		}
+		nodes = append(nodes, nd)
+		return nd
+	}
+
+	apples := file("apples")
+	csv := merkledag.NodeWithData(unixfs.FilePBData(nil, 8))
+	for _, chunk := range []string{"a,b\n", "1,2\n"} {
+		if err := csv.AddNodeLink("", file(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nodes = append(nodes, csv)
+	data := dir(map[string]ipld.Node{"foo.csv": csv, "copy.txt": apples})
+	root := dir(map[string]ipld.Node{"a.txt": apples, "data": data})
+
+	ctx := context.Background()
+	ng := newMapNodeGetter(nodes)
+	info, err := NewInfo(ctx, ng, root.Cid(), WithPaths(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx := func(nd ipld.Node) int { return info.Manifest.IDIndex(nd.Cid().String()) }
+	expect := map[string]int{
+		"/":             0,
+		"/a.txt":        idx(apples),
+		"/data":         idx(data),
+		"/data/foo.csv": idx(csv),
+	}
+	if !reflect.DeepEqual(expect, info.Labels) {
+		t.Errorf("paths mismatch.\nexpected: %v\ngot:      %v", expect, info.Labels)
+	}
+
+	sub, err := info.InfoAtLabel("/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.RootCID() != data.Cid() {
+		t.Errorf("expected path label to resolve to the data directory. got: %s", sub.RootCID())
+	}
+
+	plain, err := NewInfo(ctx, ng, root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain.Labels != nil {
+		t.Errorf("expected info without paths to have no labels. got: %v", plain.Labels)
+	}
+	if !reflect.DeepEqual(plain.Manifest, info.Manifest) {
+		t.Error("expected paths not to change the manifest")
+	}
+}
+
+func TestManifestReachableFrom(t *testing.T) {
+	content = 0
+
+	// a -> b -> d -> e
+	// a -> c -> d
+	// c -> f
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	d := newNode(40)
+	e := newNode(50)
+	f := newNode(60)
+	a.links = []*node{b, c}
+	b.links = []*node{d}
+	c.links = []*node{d, f}
+	d.links = []*node{e}
+	m, err := NewManifest(context.Background(), TestingNodeGetter{[]ipld.Node{a, b, c, d, e, f}}, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx := func(nds ...*node) map[int]bool {
+		set := map[int]bool{}
+		for _, nd := range nds {
+			set[m.IDIndex(nd.Cid().String())] = true
+		}
+		return set
+	}
+	indices := func(nds ...*node) []int {
+		var is []int
+		for i := range idx(nds...) {
+			is = append(is, i)
+		}
+		return is
+	}
+
+	cases := []struct {
+		description string
+		from        []int
+		expect      map[int]bool
+	}{
+		{"none", nil, map[int]bool{}},
+		{"root", []int{0}, idx(a, b, c, d, e, f)},
+		{"leaf", indices(e), idx(e)},
+		{"overlapping", indices(b, c), idx(b, c, d, e, f)},
+		{"contained", indices(c, d), idx(c, d, e, f)},
+		{"out of range", []int{-1, len(m.Nodes)}, map[int]bool{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			got := m.ReachableFrom(c.from)
+			if !reflect.DeepEqual(c.expect, got) {
+				t.Errorf("result mismatch.\nexpected: %v\ngot:      %v", c.expect, got)
+			}
+		})
+	}
+
+	// malformed manifests with out of range links don't panic
+	bad := &Manifest{
+		Nodes: []string{"a", "b", "c"},
+		Links: [][2]int{{0, 1}, {1, 5}, {7, 2}, {0, -1}},
+	}
+	if got := bad.ReachableFrom([]int{0}); !reflect.DeepEqual(map[int]bool{0: true, 1: true}, got) {
+		t.Errorf("expected out of range links to be ignored. got: %v", got)
+	}
+}
+
+func TestManifestRoots(t *testing.T) {
+	cases := []struct {
+		description string
+		m           *Manifest
+		expect      []int
+	}{
+		{"empty", &Manifest{}, []int{}},
+		{"single node", &Manifest{Nodes: []string{"a"}}, []int{0}},
+		{"single root",
+			// a -> b -> d
+			// a -> c -> d
+			&Manifest{
+				Nodes: []string{"a", "b", "c", "d"},
+				Links: [][2]int{{0, 1}, {0, 2}, {1, 3}, {2, 3}},
+			},
+			[]int{0},
+		},
+		{"two roots sharing a leaf",
+			// a -> c -> e
+			// b -> d -> e
+			&Manifest{
+				Nodes: []string{"a", "b", "c", "d", "e"},
+				Links: [][2]int{{0, 2}, {1, 3}, {2, 4}, {3, 4}},
+			},
+			[]int{0, 1},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			if got := c.m.Roots(); !reflect.DeepEqual(c.expect, got) {
+				t.Errorf("roots mismatch. expected: %v, got: %v", c.expect, got)
+			}
+		})
+	}
+
+	content = 0
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	a.links = []*node{b, c}
+	m, err := NewManifest(context.Background(), TestingNodeGetter{[]ipld.Node{a, b, c}}, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Roots(); !reflect.DeepEqual([]int{0}, got) {
+		t.Errorf("expected manifest of a DAG to have root [0]. got: %v", got)
+	}
+}
+
+func TestManifestWalk(t *testing.T) {
+	m := &Manifest{
+		Nodes: []string{"a", "b", "c", "d"},
+		// a -> b -> d
+		// a -> c -> d
+		Links: [][2]int{{0, 1}, {0, 2}, {1, 3}, {2, 3}},
+	}
+
+	var (
+		visited  []string
+		children [][]int
+	)
+	err := m.Walk(func(index int, id string, ch []int) error {
+		if m.Nodes[index] != id {
+			t.Errorf("index %d: expected id %q, got %q", index, m.Nodes[index], id)
+		}
+		visited = append(visited, id)
+		children = append(children, ch)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"a", "b", "c", "d"}; !reflect.DeepEqual(expect, visited) {
+		t.Errorf("expected shared nodes to be visited once in Nodes order. expected: %v, got: %v", expect, visited)
+	}
+	if expect := [][]int{{1, 2}, {3}, {3}, nil}; !reflect.DeepEqual(expect, children) {
+		t.Errorf("children mismatch. expected: %v, got: %v", expect, children)
+	}
+
+	stop := errors.New("stop")
+	visited = nil
+	err = m.Walk(func(index int, id string, ch []int) error {
+		visited = append(visited, id)
+		if id == "b" {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("expected Walk to return the visit error. got: %v", err)
+	}
+	if len(visited) != 2 {
+		t.Errorf("expected Walk to stop at the first error. visited: %v", visited)
+	}
+
+	bad := &Manifest{Nodes: []string{"a"}, Links: [][2]int{{0, 1}}}
+	if err := bad.Walk(func(int, string, []int) error { return nil }); !errors.Is(err, ErrLinkOutOfRange) {
+		t.Errorf("expected ErrLinkOutOfRange, got: %v", err)
+	}
+}
+
+func TestManifestReverseTopologicalOrder(t *testing.T) {
+	g := newGraph([]layer{
+		{3, 4 * kb},
+		{3, 2 * kb},
+		{2, kb},
+	})
+	// add a node reachable by several paths
+	shared := newNode(kb)
+	g = append(g, shared)
+	for _, nd := range g[1:4] {
+		nd.(*node).links = append(nd.(*node).links, shared)
+	}
+	m, err := NewManifest(context.Background(), TestingNodeGetter{g}, g[0].Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order, err := m.ReverseTopologicalOrder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != len(m.Nodes) {
+		t.Fatalf("expected order to list all %d nodes. got: %d", len(m.Nodes), len(order))
+	}
+	pos := make([]int, len(m.Nodes))
+	for i, idx := range order {
+		pos[idx] = i
+	}
+	for _, l := range m.Links {
+		if pos[l[1]] > pos[l[0]] {
+			t.Errorf("child %d comes after parent %d", l[1], l[0])
+		}
+	}
+	if order[len(order)-1] != 0 {
+		t.Errorf("expected root to come last. got: %d", order[len(order)-1])
+	}
+
+	cyclic := &Manifest{Nodes: m.Nodes[:3], Links: [][2]int{{0, 1}, {1, 2}, {2, 1}}}
+	if _, err := cyclic.ReverseTopologicalOrder(); !errors.Is(err, ErrCycleDetected) {
+		t.Errorf("expected cyclic manifest to error with ErrCycleDetected. got: %v", err)
+	}
+	outOfRange := &Manifest{Nodes: m.Nodes[:2], Links: [][2]int{{0, 2}}}
+	if _, err := outOfRange.ReverseTopologicalOrder(); !errors.Is(err, ErrLinkOutOfRange) {
+		t.Errorf("expected out of range link to error with ErrLinkOutOfRange. got: %v", err)
+	}
+}
+
+func TestManifestTopoOrder(t *testing.T) {
+	g := newGraph([]layer{
+		{3, 4 * kb},
+		{3, 2 * kb},
+		{2, kb},
+	})
+	// add a node reachable by several paths
+	shared := newNode(kb)
+	g = append(g, shared)
+	for _, nd := range g[1:4] {
+		nd.(*node).links = append(nd.(*node).links, shared)
+	}
+	m, err := NewManifest(context.Background(), TestingNodeGetter{g}, g[0].Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order, err := m.TopoOrder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != len(m.Nodes) {
+		t.Fatalf("expected order to list all %d nodes. got: %d", len(m.Nodes), len(order))
+	}
+	pos := make([]int, len(m.Nodes))
+	for i, idx := range order {
+		pos[idx] = i
+	}
+	for _, l := range m.Links {
+		if pos[l[0]] > pos[l[1]] {
+			t.Errorf("parent %d comes after child %d", l[0], l[1])
+		}
+	}
+	if order[0] != 0 {
+		t.Errorf("expected root to come first. got: %d", order[0])
+	}
+
+	cyclic := &Manifest{Nodes: m.Nodes[:3], Links: [][2]int{{0, 1}, {1, 2}, {2, 1}}}
+	if _, err := cyclic.TopoOrder(); !errors.Is(err, ErrCycleDetected) {
+		t.Errorf("expected cyclic manifest to error with ErrCycleDetected. got: %v", err)
+	}
+	outOfRange := &Manifest{Nodes: m.Nodes[:2], Links: [][2]int{{0, 2}}}
+	if _, err := outOfRange.TopoOrder(); !errors.Is(err, ErrLinkOutOfRange) {
+		t.Errorf("expected out of range link to error with ErrLinkOutOfRange. got: %v", err)
+	}
+}
+
+func TestManifestBFSOrder(t *testing.T) {
+	// a -> b -> d -> e
+	// a -> c -> d
+	// c -> f
+	m := &Manifest{
+		Nodes: []string{"a", "b", "c", "d", "e", "f", "unreachable"},
+		Links: [][2]int{{0, 1}, {0, 2}, {1, 3}, {2, 3}, {2, 5}, {3, 4}},
+	}
+	expect := []int{0, 1, 2, 3, 5, 4}
+	if got := m.BFSOrder(); !reflect.DeepEqual(expect, got) {
+		t.Errorf("order mismatch. expected: %v, got: %v", expect, got)
+	}
+	if got := (&Manifest{}).BFSOrder(); got != nil {
+		t.Errorf("expected empty manifest to have no order. got: %v", got)
+	}
+}
+
+func TestManifestValidate(t *testing.T) {
+	content = 0
+
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	d := newNode(40)
+	a.links = []*node{b, c}
+	b.links = []*node{d}
+	c.links = []*node{d}
+	m, err := NewManifest(context.Background(), TestingNodeGetter{[]ipld.Node{a, b, c, d}}, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Errorf("expected manifest from NewManifest to be valid. got: %s", err)
+	}
+	if err := (&Manifest{}).Validate(); err != nil {
+		t.Errorf("expected empty manifest to be valid. got: %s", err)
+	}
+
+	modified := func(fn func(m *Manifest)) *Manifest {
+		cp := &Manifest{
+			Nodes: append([]string{}, m.Nodes...),
+			Links: append([][2]int{}, m.Links...),
+		}
+		fn(cp)
+		return cp
+	}
+
+	cases := []struct {
+		description string
+		m           *Manifest
+		expect      error
+	}{
+		{"link out of range", modified(func(m *Manifest) { m.Links = append(m.Links, [2]int{0, 4}) }), ErrLinkOutOfRange},
+		{"negative link", modified(func(m *Manifest) { m.Links[0] = [2]int{-1, 1} }), ErrLinkOutOfRange},
+		{"duplicate node", modified(func(m *Manifest) { m.Nodes[3] = m.Nodes[1] }), ErrDuplicateNode},
+		{"cycle", modified(func(m *Manifest) { m.Links = append(m.Links, [2]int{3, 0}) }), ErrCycleDetected},
+		{"self link", modified(func(m *Manifest) { m.Links = append(m.Links, [2]int{2, 2}) }), ErrCycleDetected},
+		{"out of order", modified(func(m *Manifest) { m.Nodes[1], m.Nodes[2] = m.Nodes[2], m.Nodes[1] }), ErrInvalidNodeOrder},
+		// the first violated invariant is reported
+		{"out of range & cycle", modified(func(m *Manifest) {
+			m.Links = append(m.Links, [2]int{3, 0}, [2]int{0, 9})
+		}), ErrLinkOutOfRange},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			if err := c.m.Validate(); !errors.Is(err, c.expect) {
+				t.Errorf("expected error wrapping %q. got: %v", c.expect, err)
+			}
+		})
+	}
+}
+
+func TestMissing(t *testing.T) {
+	content = 0
+	g := newGraph([]layer{
+		{3, kb},
+		{3, kb},
+	})
+	ctx := context.Background()
+	m, err := NewManifest(ctx, newMapNodeGetter(g), g[0].Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every other node of the manifest is local
+	var have []ipld.Node
+	var expect []string
+	for i, id := range m.Nodes {
+		if i%2 == 0 {
+			expect = append(expect, id)
+			continue
+		}
+		for _, nd := range g {
+			if nd.Cid().String() == id {
+				have = append(have, nd)
+			}
+		}
+	}
+
+	missing, err := Missing(ctx, newMapNodeGetter(have), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expect, missing.Nodes) {
+		t.Errorf("missing nodes mismatch. expected: %v, got: %v", expect, missing.Nodes)
+	}
+
+	if missing, err = Missing(ctx, newMapNodeGetter(g), m); err != nil {
+		t.Fatal(err)
+	}
+	if len(missing.Nodes) != 0 {
+		t.Errorf("expected no missing nodes when all are local. got: %v", missing.Nodes)
+	}
+
+	// errors other than not found aren't mistaken for missing nodes
+	failing := &flakyGetter{ng: newMapNodeGetter(have), failing: true}
+	if _, err := Missing(ctx, failing, m); err == nil {
+		t.Error("expected getter error to fail Missing")
+	}
+}
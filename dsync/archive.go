@@ -0,0 +1,324 @@
+package dsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	protocol "github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/qri-io/dag"
+)
+
+// BlockSink is a store of raw block data keyed by CID. Sinks don't need to
+// decode blocks or understand links, making object stores & key-value
+// databases suitable block sinks
+type BlockSink interface {
+	// PutBlock stores block data under id. data has been checked to hash to id
+	PutBlock(ctx context.Context, id cid.Cid, data []byte) error
+	// GetBlock returns the data stored under id
+	GetBlock(ctx context.Context, id cid.Cid) ([]byte, error)
+	// HasBlock reports if the sink has data stored under id
+	HasBlock(ctx context.Context, id cid.Cid) (bool, error)
+}
+
+// ArchiveRemote is a write-only remote that archives pushed DAGs into a
+// BlockSink. Archives accept the manifest & blocks of a push, storing each
+// block once it's been checked to hash to it's CID, without decoding blocks
+// or checking received DAGs can be reconstructed by following links. The
+// manifest of each completed push is stored, and can be fetched along with
+// archived blocks, so archived DAGs can be pulled. Archives don't support pins
+// or removes
+type ArchiveRemote struct {
+	sink     BlockSink
+	infos    dag.InfoStore
+	preCheck Hook
+
+	lock     sync.Mutex
+	sessions map[string]*archiveSession
+}
+
+var (
+	// compile-time assertions that ArchiveRemote satisfies remote interfaces
+	_ DagSyncable   = (*ArchiveRemote)(nil)
+	_ DagStreamable = (*ArchiveRemote)(nil)
+)
+
+// archiveSession tracks the blocks of a push an archive hasn't received
+type archiveSession struct {
+	info    *dag.Info
+	missing map[string]bool
+}
+
+// NewArchiveRemote creates a remote that archives pushes to sink. Manifests of
+// completed pushes are kept in infos, which defaults to an in-memory store
+// when nil. preCheck is called before accepting a push, defaulting to
+// DefaultDagPrecheck when nil
+func NewArchiveRemote(sink BlockSink, infos dag.InfoStore, preCheck Hook) *ArchiveRemote {
+	if infos == nil {
+		infos = dag.NewMemInfoStore()
+	}
+	if preCheck == nil {
+		preCheck = DefaultDagPrecheck
+	}
+	return &ArchiveRemote{
+		sink:     sink,
+		infos:    infos,
+		preCheck: preCheck,
+		sessions: map[string]*archiveSession{},
+	}
+}
+
+// NewReceiveSession starts a push to the archive, returning a manifest of the
+// blocks the sink doesn't have. Archives never pin, pinOnComplete is ignored
+func (a *ArchiveRemote) NewReceiveSession(info *dag.Info, pinOnComplete bool, meta map[string]string) (sid string, diff *dag.Manifest, err error) {
+	ctx := context.Background()
+	if info == nil || info.Manifest == nil || len(info.Manifest.Nodes) == 0 {
+		return "", nil, fmt.Errorf("dag info must have a manifest with at least one node")
+	}
+	if err := a.preCheck(ctx, *info, meta); err != nil {
+		return "", nil, err
+	}
+
+	sess := &archiveSession{info: info, missing: map[string]bool{}}
+	diff = &dag.Manifest{}
+	for _, idStr := range info.Manifest.Nodes {
+		id, err := cid.Parse(idStr)
+		if err != nil {
+			return "", nil, err
+		}
+		has, err := a.sink.HasBlock(ctx, id)
+		if err != nil {
+			return "", nil, err
+		}
+		if !has {
+			sess.missing[idStr] = true
+			diff.Nodes = append(diff.Nodes, idStr)
+		}
+	}
+
+	sid = randStringBytesMask(10)
+	if len(sess.missing) == 0 {
+		return sid, diff, a.infos.PutDAGInfo(ctx, info.RootCID().String(), info)
+	}
+
+	a.lock.Lock()
+	a.sessions[sid] = sess
+	a.lock.Unlock()
+	return sid, diff, nil
+}
+
+// ProtocolVersion reports the version of dsync the archive speaks
+func (a *ArchiveRemote) ProtocolVersion() (protocol.ID, error) {
+	return DsyncProtocolID, nil
+}
+
+// ReceiveBlock stores a block of a push in the sink
+func (a *ArchiveRemote) ReceiveBlock(sid, hash string, data []byte) ReceiveResponse {
+	if err := a.receive(context.Background(), sid, hash, data); err != nil {
+		return ReceiveResponse{Hash: hash, Status: StatusErrored, Err: err}
+	}
+	return ReceiveResponse{Hash: hash, Status: StatusOk}
+}
+
+// ReceiveBlocks stores the blocks of a CAR stream in the sink. The stream must
+// complete the push
+func (a *ArchiveRemote) ReceiveBlocks(ctx context.Context, sid string, r io.Reader) error {
+	rdr, err := car.NewCarReader(r)
+	if err != nil {
+		return err
+	}
+	for {
+		blk, err := rdr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if err := a.receive(ctx, sid, blk.Cid().String(), blk.RawData()); err != nil {
+			return err
+		}
+	}
+
+	a.lock.Lock()
+	sess, ok := a.sessions[sid]
+	a.lock.Unlock()
+	if ok {
+		return fmt.Errorf("stream ended with %d blocks missing", len(sess.missing))
+	}
+	return nil
+}
+
+// receive checks data hashes to the CID of a block the session is missing,
+// storing it in the sink. The session is finalized when its last missing block
+// arrives
+func (a *ArchiveRemote) receive(ctx context.Context, sid, hash string, data []byte) error {
+	a.lock.Lock()
+	sess, ok := a.sessions[sid]
+	a.lock.Unlock()
+	if !ok {
+		return fmt.Errorf("sid %q not found", sid)
+	}
+
+	if err := verifyBlock(hash, data); err != nil {
+		return err
+	}
+	id, err := cid.Parse(hash)
+	if err != nil {
+		return err
+	}
+	if err := a.sink.PutBlock(ctx, id, data); err != nil {
+		return err
+	}
+
+	a.lock.Lock()
+	delete(sess.missing, hash)
+	complete := len(sess.missing) == 0
+	if complete {
+		delete(a.sessions, sid)
+	}
+	a.lock.Unlock()
+
+	if complete {
+		return a.infos.PutDAGInfo(ctx, sess.info.RootCID().String(), sess.info)
+	}
+	return nil
+}
+
+// GetDagInfo returns the info of an archived DAG
+func (a *ArchiveRemote) GetDagInfo(ctx context.Context, cidStr string, meta map[string]string) (*dag.Info, error) {
+	return a.infos.DAGInfo(ctx, cidStr)
+}
+
+// GetBlock returns the data of an archived block
+func (a *ArchiveRemote) GetBlock(ctx context.Context, hash string) ([]byte, error) {
+	id, err := cid.Parse(hash)
+	if err != nil {
+		return nil, err
+	}
+	return a.sink.GetBlock(ctx, id)
+}
+
+// OpenBlockStream creates a block stream of the archived blocks in info
+func (a *ArchiveRemote) OpenBlockStream(ctx context.Context, info *dag.Info, meta map[string]string) (io.ReadCloser, error) {
+	return newGetterCARStream(ctx, info.Manifest, a.sink.GetBlock)
+}
+
+// RemoveCID isn't supported by archives
+func (a *ArchiveRemote) RemoveCID(ctx context.Context, cidStr string, meta map[string]string) error {
+	return ErrRemoveNotSupported
+}
+
+// HTTPArchiveHandler exposes an archive over HTTP, speaking the same protocol
+// as HTTPRemoteHandler, so HTTPClient can push to & pull from archives.
+// Requests for features archives don't support, like probes, deltas & block
+// parts, are rejected
+func HTTPArchiveHandler(a *ArchiveRemote) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(httpDsyncProtocolIDHeader, string(DsyncProtocolID))
+
+		switch r.Method {
+		case http.MethodPost:
+			meta := map[string]string{}
+			for key := range r.URL.Query() {
+				if key != "pin" {
+					meta[key] = r.URL.Query().Get(key)
+				}
+			}
+			info, err := decodeDAGInfoBody(r)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			sid, diff, err := a.NewReceiveSession(info, false, meta)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			w.Header().Set(sidHeader, sid)
+			w.Header().Set("Content-Type", jsonMIMEType)
+			json.NewEncoder(w).Encode(diff)
+		case http.MethodPut:
+			if r.Header.Get("Content-Type") == carMIMEType {
+				if err := a.ReceiveBlocks(r.Context(), r.FormValue("sid"), r.Body); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte(err.Error()))
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			if r.FormValue("base") != "" || r.FormValue("offset") != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("archives only accept whole blocks"))
+				return
+			}
+
+			data, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			if res := a.ReceiveBlock(r.FormValue("sid"), r.FormValue("hash"), data); res.Status != StatusOk {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(res.Err.Error()))
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		case http.MethodGet:
+			var (
+				data []byte
+				err  error
+			)
+			if mfstID := r.FormValue("manifest"); mfstID != "" {
+				var info *dag.Info
+				if info, err = a.GetDagInfo(r.Context(), mfstID, nil); err == nil {
+					data, err = json.Marshal(info)
+				}
+				w.Header().Set("Content-Type", jsonMIMEType)
+			} else if blockID := r.FormValue("block"); blockID != "" {
+				data, err = a.GetBlock(r.Context(), blockID)
+				w.Header().Set("Content-Type", binaryMIMEType)
+			} else {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("either manifest or block query params are required"))
+				return
+			}
+			if err != nil {
+				w.Header().Del("Content-Type")
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			w.Write(data)
+		case http.MethodPatch:
+			info, err := decodeDAGInfoBody(r)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			rdr, err := a.OpenBlockStream(r.Context(), info, nil)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			defer rdr.Close()
+			w.Header().Set("Content-Type", carMIMEType)
+			w.WriteHeader(http.StatusOK)
+			io.Copy(w, rdr)
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(ErrRemoveNotSupported.Error()))
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package dsync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/qri-io/dag"
+)
+
+// memSink is an in-memory BlockSink
+type memSink struct {
+	lock   sync.Mutex
+	blocks map[string][]byte
+}
+
+func newMemSink() *memSink {
+	return &memSink{blocks: map[string][]byte{}}
+}
+
+func (s *memSink) PutBlock(_ context.Context, id cid.Cid, data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.blocks[id.KeyString()] = data
+	return nil
+}
+
+func (s *memSink) GetBlock(_ context.Context, id cid.Cid) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	data, ok := s.blocks[id.KeyString()]
+	if !ok {
+		return nil, fmt.Errorf("block %s not found", id)
+	}
+	return data, nil
+}
+
+func (s *memSink) HasBlock(_ context.Context, id cid.Cid) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, ok := s.blocks[id.KeyString()]
+	return ok, nil
+}
+
+func TestArchiveRemote(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, root, info := newTestDAG(t, "archive", 3, 2)
+
+	sink := newMemSink()
+	archive := NewArchiveRemote(sink, nil, func(context.Context, dag.Info, map[string]string) error { return nil })
+	s := httptest.NewServer(HTTPArchiveHandler(archive))
+	defer s.Close()
+
+	ds, err := New(local.nodeGetter(), local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	push, err := ds.NewPushInfo(info, s.URL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := push.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.blocks) != len(info.Manifest.Nodes) {
+		t.Errorf("expected sink to have %d blocks. got: %d", len(info.Manifest.Nodes), len(sink.blocks))
+	}
+	for _, idStr := range info.Manifest.Nodes {
+		id, _ := cid.Parse(idStr)
+		data, err := sink.GetBlock(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expect, _ := local.rawData(id); !bytes.Equal(data, expect) {
+			t.Errorf("block %s data mismatch", id)
+		}
+	}
+
+	// archived DAGs can be pulled back out
+	restored := newMemStore()
+	pull, err := NewPull(root.Cid().String(), restored.nodeGetter(), restored, &HTTPClient{URL: s.URL}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pull.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dag.NewManifest(ctx, restored.nodeGetter(), root.Cid()); err != nil {
+		t.Errorf("expected pulled DAG to be complete. error: %s", err)
+	}
+
+	// pushing again sends nothing
+	sid, diff, err := archive.NewReceiveSession(info, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Nodes) != 0 {
+		t.Errorf("expected archive to request no blocks for an archived DAG. got: %d", len(diff.Nodes))
+	}
+	if res := archive.ReceiveBlock(sid, root.Cid().String(), root.RawData()); res.Status != StatusErrored {
+		t.Errorf("expected block for a completed session to error. got status: %s", res.Status)
+	}
+
+	// blocks must match their CIDs
+	other := addTestDAG(t, local, "corrupt", 1, 1)
+	otherInfo, err := dag.NewInfo(ctx, local.nodeGetter(), other.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sid, _, err = archive.NewReceiveSession(otherInfo, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := archive.ReceiveBlock(sid, other.Cid().String(), []byte("not the block")); res.Status != StatusErrored {
+		t.Errorf("expected corrupt block to error. got status: %s", res.Status)
+	}
+}
@@ -0,0 +1,108 @@
+package dsync
+
+import (
+	"context"
+	"io"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+)
+
+// BlockPutManyer is an optional interface for block APIs that can place many
+// blocks in one call, amortizing the cost of writes
+type BlockPutManyer interface {
+	// PutMany places a batch of blocks in the store. Blocks are stored under
+	// the CIDs they carry
+	PutMany(ctx context.Context, blks []blocks.Block) error
+}
+
+// batchWriter buffers blocks, placing them in a block store with PutMany each
+// time the buffer fills, and when flushed
+type batchWriter struct {
+	ctx    context.Context
+	putter BlockPutManyer
+	size   int
+	buf    []blocks.Block
+	progCh chan cid.Cid
+	added  int
+}
+
+func newBatchWriter(ctx context.Context, putter BlockPutManyer, size int, progCh chan cid.Cid) *batchWriter {
+	return &batchWriter{
+		ctx:    ctx,
+		putter: putter,
+		size:   size,
+		buf:    make([]blocks.Block, 0, size),
+		progCh: progCh,
+	}
+}
+
+// add buffers a block, writing the buffer if it's full. PutMany trusts the
+// CIDs blocks carry, so blocks are checked to hash to their CID first
+func (w *batchWriter) add(blk blocks.Block) error {
+	id, err := blk.Cid().Prefix().Sum(blk.RawData())
+	if err != nil {
+		return err
+	}
+	if !id.Equals(blk.Cid()) {
+		return &HashMismatchError{Expected: blk.Cid().String(), Got: id.String()}
+	}
+
+	w.buf = append(w.buf, blk)
+	if len(w.buf) >= w.size {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush writes all buffered blocks
+func (w *batchWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	if err := w.putter.PutMany(w.ctx, w.buf); err != nil {
+		return err
+	}
+	log.Debugf("wrote batch of %d blocks", len(w.buf))
+
+	w.added += len(w.buf)
+	if w.progCh != nil {
+		for _, blk := range w.buf {
+			go func(id cid.Cid) { w.progCh <- id }(blk.Cid())
+		}
+	}
+	w.buf = make([]blocks.Block, 0, w.size)
+	return nil
+}
+
+// addAllFromCARReaderBatched consumes a CAR reader stream like
+// AddAllFromCARReader, placing blocks in batches of up to size blocks. Blocks
+// are only reported on progCh once the batch they're in has been written
+func addAllFromCARReaderBatched(ctx context.Context, putter BlockPutManyer, size int, r io.Reader, progCh chan cid.Cid) (int, error) {
+	rdr, err := car.NewCarReader(r)
+	if err != nil {
+		return 0, err
+	}
+
+	w := newBatchWriter(ctx, putter, size, progCh)
+	for {
+		if err := ctx.Err(); err != nil {
+			return w.added, err
+		}
+
+		blk, err := rdr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return w.added, err
+		}
+
+		if err := w.add(blk); err != nil {
+			return w.added, err
+		}
+	}
+
+	err = w.flush()
+	return w.added, err
+}
@@ -0,0 +1,77 @@
+package dsync
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/qri-io/dag"
+)
+
+func TestPullBatchSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	remote := newMemStore()
+	root := addTestDAG(t, remote, "batched", 4, 2)
+	rem, err := New(remote.nodeGetter(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := dag.NewInfo(ctx, remote.nodeGetter(), root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local := newMemStore()
+	pull, err := NewPull(root.Cid().String(), local.nodeGetter(), local, rem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pull.batchSize = 8
+	if err := pull.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dag.NewManifest(ctx, local.nodeGetter(), root.Cid()); err != nil {
+		t.Errorf("expected DAG to be complete locally after pull. error: %s", err)
+	}
+	if !pull.completion().Complete() {
+		t.Errorf("expected pull progress to be complete. got: %v", pull.completion())
+	}
+
+	// 21 blocks in batches of 8
+	expect := (len(info.Manifest.Nodes) + 7) / 8
+	if local.putManys != expect {
+		t.Errorf("expected %d batched writes. got: %d", expect, local.putManys)
+	}
+	if len(local.Puts()) != len(info.Manifest.Nodes) {
+		t.Errorf("expected %d blocks written. got: %d", len(info.Manifest.Nodes), len(local.Puts()))
+	}
+}
+
+func BenchmarkPullBatchSize(b *testing.B) {
+	ctx := context.Background()
+	remote := newMemStore()
+	root := addTestDAG(b, remote, "bench", 32, 2)
+	rem, err := New(remote.nodeGetter(), remote)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, size := range []int{0, 16, 128} {
+		b.Run(fmt.Sprintf("batch_%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				local := newMemStore()
+				pull, err := NewPull(root.Cid().String(), local.nodeGetter(), local, rem, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				pull.batchSize = size
+				if err := pull.Do(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
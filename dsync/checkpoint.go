@@ -0,0 +1,256 @@
+package dsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	ipld "github.com/ipfs/go-ipld-format"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/qri-io/dag"
+)
+
+// PullCheckpoint records the progress of a pull, keyed by the hash of the
+// manifest being pulled, so an interrupted pull can be resumed after a
+// process restart
+type PullCheckpoint struct {
+	ManifestHash string         `json:"manifestHash"`
+	Completion   dag.Completion `json:"completion"`
+}
+
+// pullCheckpointPath is the location of the checkpoint for a manifest hash
+func pullCheckpointPath(dir, manifestHash string) string {
+	return filepath.Join(dir, manifestHash+".pull.json")
+}
+
+// SavePullCheckpoint writes a checkpoint to dir, replacing any previous
+// checkpoint for the same manifest. The checkpoint is written to a temp file
+// & renamed into place, so a crash mid-write never leaves a corrupt
+// checkpoint behind
+func SavePullCheckpoint(dir string, cp *PullCheckpoint) error {
+	if cp.ManifestHash == "" {
+		return fmt.Errorf("checkpoint manifest hash is required")
+	}
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dir, pullCheckpointPath(dir, cp.ManifestHash), data)
+}
+
+// writeCheckpoint writes data to a temp file in dir & renames it to path
+func writeFileAtomic(dir, path string, data []byte) error {
+	f, err := ioutil.TempFile(dir, "checkpoint")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// LoadPullCheckpoint reads the checkpoint for a manifest hash from dir.
+// Errors satisfy os.IsNotExist when no checkpoint exists
+func LoadPullCheckpoint(dir, manifestHash string) (*PullCheckpoint, error) {
+	data, err := ioutil.ReadFile(pullCheckpointPath(dir, manifestHash))
+	if err != nil {
+		return nil, err
+	}
+	cp := &PullCheckpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("decoding checkpoint: %w", err)
+	}
+	if cp.ManifestHash != manifestHash {
+		return nil, fmt.Errorf("checkpoint manifest hash mismatch. expected %s, got: %s", manifestHash, cp.ManifestHash)
+	}
+	return cp, nil
+}
+
+// Checkpoint returns a snapshot of the pull's progress. Checkpoints are only
+// available once a pull has worked out which blocks are missing
+func (f *Pull) Checkpoint() (*PullCheckpoint, error) {
+	prog := f.completion()
+	if f.info == nil || prog == nil {
+		return nil, fmt.Errorf("pull hasn't started")
+	}
+	hash, err := f.info.Manifest.Hash()
+	if err != nil {
+		return nil, err
+	}
+	return &PullCheckpoint{ManifestHash: hash, Completion: prog}, nil
+}
+
+// SaveCheckpoint writes a checkpoint of the pull's progress to dir, for
+// resuming the pull with ResumePull
+func (f *Pull) SaveCheckpoint(dir string) error {
+	cp, err := f.Checkpoint()
+	if err != nil {
+		return err
+	}
+	return SavePullCheckpoint(dir, cp)
+}
+
+// ResumePull sets up a pull of a DAG from a remote that continues from a
+// checkpoint saved to dir. The manifest is fetched from the remote, and its
+// hash locates the checkpoint. Blocks the checkpoint records as complete are
+// re-checked against the local store in case they've been removed since, and
+// blocks it doesn't are pulled without probing the local store. Without a
+// checkpoint the resulting pull behaves like NewPullWithInfo
+func ResumePull(ctx context.Context, dir, cidStr string, lng ipld.NodeGetter, bapi coreiface.BlockAPI, rem DagSyncable, meta map[string]string) (*Pull, error) {
+	info, err := rem.GetDagInfo(ctx, cidStr, meta)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := info.Manifest.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := NewPullWithInfo(info, lng, bapi, rem, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	cp, err := LoadPullCheckpoint(dir, hash)
+	if os.IsNotExist(err) {
+		return f, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(cp.Completion) != len(info.Manifest.Nodes) {
+		return nil, fmt.Errorf("checkpoint has %d entries, manifest has %d nodes", len(cp.Completion), len(info.Manifest.Nodes))
+	}
+	f.resume = cp.Completion
+	return f, nil
+}
+
+// reconcileCheckpoint returns a manifest of the nodes in m that are missing
+// locally, given checkpointed progress. Only nodes marked complete are checked
+// against the local store
+func reconcileCheckpoint(ctx context.Context, lng ipld.NodeGetter, m *dag.Manifest, prog dag.Completion) (*dag.Manifest, error) {
+	done := &dag.Manifest{}
+	for i, id := range m.Nodes {
+		if prog[i] == 100 {
+			done.Nodes = append(done.Nodes, id)
+		}
+	}
+	gone, err := dag.Missing(ctx, lng, done)
+	if err != nil {
+		return nil, err
+	}
+	removed := make(map[string]bool, len(gone.Nodes))
+	for _, id := range gone.Nodes {
+		removed[id] = true
+	}
+	if len(removed) > 0 {
+		log.Debugf("%d checkpointed blocks are missing locally", len(removed))
+	}
+
+	missing := &dag.Manifest{}
+	for i, id := range m.Nodes {
+		if prog[i] != 100 || removed[id] {
+			missing.Nodes = append(missing.Nodes, id)
+		}
+	}
+	return missing, nil
+}
+
+// SessionCheckpoint records the progress of a receive session, so a push
+// interrupted by a remote restart can be resumed with ResumeSession
+type SessionCheckpoint struct {
+	SID        string            `json:"sid"`
+	Info       *dag.Info         `json:"info"`
+	Completion dag.Completion    `json:"completion"`
+	Pin        bool              `json:"pin,omitempty"`
+	Meta       map[string]string `json:"meta,omitempty"`
+}
+
+// SessionCheckpointPath is the location of the checkpoint for a receive
+// session id
+func SessionCheckpointPath(dir, sid string) string {
+	return filepath.Join(dir, sid+".session.json")
+}
+
+// LoadSessionCheckpoint reads a receive session checkpoint from path
+func LoadSessionCheckpoint(path string) (*SessionCheckpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cp := &SessionCheckpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("decoding checkpoint: %w", err)
+	}
+	if cp.SID == "" {
+		return nil, fmt.Errorf("checkpoint session id is required")
+	}
+	if cp.Info == nil || cp.Info.Manifest == nil || len(cp.Info.Manifest.Nodes) == 0 {
+		return nil, fmt.Errorf("checkpoint manifest is required")
+	}
+	if len(cp.Completion) != len(cp.Info.Manifest.Nodes) {
+		return nil, fmt.Errorf("checkpoint has %d entries, manifest has %d nodes", len(cp.Completion), len(cp.Info.Manifest.Nodes))
+	}
+	return cp, nil
+}
+
+// saveCheckpoint writes a snapshot of the session's progress to it's
+// checkpoint directory, replacing any previous checkpoint
+func (s *session) saveCheckpoint() error {
+	s.checkpointLock.Lock()
+	defer s.checkpointLock.Unlock()
+
+	prog := make(dag.Completion, len(s.prog))
+	copy(prog, s.prog)
+	data, err := json.Marshal(&SessionCheckpoint{
+		SID:        s.id,
+		Info:       s.info,
+		Completion: prog,
+		Pin:        s.pin,
+		Meta:       s.meta,
+	})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.checkpointDir, SessionCheckpointPath(s.checkpointDir, s.id), data)
+}
+
+// removeCheckpoint drops the session's checkpoint, if it has one
+func (s *session) removeCheckpoint() {
+	if s.checkpointDir == "" {
+		return
+	}
+	s.checkpointLock.Lock()
+	defer s.checkpointLock.Unlock()
+	if err := os.Remove(SessionCheckpointPath(s.checkpointDir, s.id)); err != nil && !os.IsNotExist(err) {
+		log.Debugf("removing session checkpoint. sid=%q err=%q", s.id, err)
+	}
+}
+
+// resume narrows the session to nodes of missing, marking the rest of it's
+// diff complete
+func (s *session) resume(missing *dag.Manifest) {
+	need := make(map[string]bool, len(missing.Nodes))
+	for _, id := range missing.Nodes {
+		need[id] = true
+	}
+	diff := &dag.Manifest{}
+	for _, id := range s.diff.Nodes {
+		if need[id] {
+			diff.Nodes = append(diff.Nodes, id)
+		}
+	}
+	s.diff = diff
+	s.prog = dag.NewCompletion(s.info.Manifest, diff)
+	s.expectedBytes = s.incompleteBytes()
+	s.completionChanged()
+}
@@ -0,0 +1,229 @@
+package dsync
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/qri-io/dag"
+)
+
+func TestResumePull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "dsync_pull_checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	remote := newMemStore()
+	root := addTestDAG(t, remote, "resume", 3, 2)
+	remDs, err := New(remote.nodeGetter(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := remDs.GetDagInfo(ctx, root.Cid().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// interrupt a pull partway through, checkpointing progress
+	local := newMemStore()
+	stalling := &stallingStreamRemote{Dsync: remDs, stalled: make(chan struct{})}
+	p, err := NewPull(root.Cid().String(), local.nodeGetter(), local, stalling, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pullCtx, cancelPull := context.WithCancel(ctx)
+	go func() {
+		<-stalling.stalled
+		cancelPull()
+	}()
+	if err := p.Do(pullCtx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected interrupted pull to return context.Canceled. got: %v", err)
+	}
+	if err := p.SaveCheckpoint(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	cp, err := p.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := cp.Completion.CompletedBlocks()
+	if done == 0 || cp.Completion.Complete() {
+		t.Fatalf("expected a partial checkpoint. got %d of %d blocks", done, len(cp.Completion))
+	}
+
+	// remove a checkpointed block, which must be pulled again
+	var removed string
+	for i, id := range info.Manifest.Nodes {
+		if cp.Completion[i] == 100 {
+			removed = id
+			break
+		}
+	}
+	removedID, err := cid.Decode(removed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := local.Rm(ctx, path.IpldPath(removedID)); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]bool{removed: true}
+	for i, id := range info.Manifest.Nodes {
+		if cp.Completion[i] != 100 {
+			expect[id] = true
+		}
+	}
+
+	// "restart" with a fresh pull that only knows the checkpoint directory
+	rem := &scopedStreamRemote{Dsync: remDs}
+	resumed, err := ResumePull(ctx, dir, root.Cid().String(), local.nodeGetter(), local, rem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resumed.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rem.streams) != 1 {
+		t.Fatalf("expected one stream. got: %d", len(rem.streams))
+	}
+	requested := rem.streams[0].Nodes
+	if len(requested) != len(expect) {
+		t.Errorf("expected resumed pull to request %d blocks. got: %d", len(expect), len(requested))
+	}
+	for _, id := range requested {
+		if !expect[id] {
+			t.Errorf("resumed pull requested block %s the checkpoint recorded as complete", id)
+		}
+	}
+	if _, err := dag.NewManifest(ctx, local.nodeGetter(), root.Cid()); err != nil {
+		t.Errorf("expected DAG to be complete locally after resuming. error: %s", err)
+	}
+
+	// a missing checkpoint resumes by probing the local store
+	if _, err := LoadPullCheckpoint(dir, "not_a_hash"); !os.IsNotExist(err) {
+		t.Errorf("expected loading a missing checkpoint to be a not-exist error. got: %v", err)
+	}
+	empty, err := ioutil.TempDir("", "dsync_pull_checkpoint_empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(empty)
+	rem.streams = nil
+	fresh, err := ResumePull(ctx, empty, root.Cid().String(), local.nodeGetter(), local, rem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fresh.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(rem.streams) != 0 {
+		t.Errorf("expected pulling a complete DAG without a checkpoint to open no streams. got: %d", len(rem.streams))
+	}
+}
+
+func TestResumeSession(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "dsync_session_checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, _, info := newTestDAG(t, "resume_session", 3, 2)
+
+	dst := newMemStore()
+	opts := func(cfg *Config) {
+		cfg.CheckpointDir = dir
+		cfg.CheckpointInterval = 2
+	}
+	remote, err := New(dst.nodeGetter(), dst, acceptPushes, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sid, diff, err := remote.NewReceiveSession(info, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpPath := SessionCheckpointPath(dir, sid)
+
+	send := func(ds *Dsync, id string) {
+		c, err := cid.Decode(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := src.rawData(c)
+		if res := ds.ReceiveBlock(sid, id, data); res.Status != StatusOk {
+			t.Fatalf("receiving block %s: %v", id, res.Err)
+		}
+	}
+
+	// interrupt the push after two checkpoints
+	sent := map[string]bool{}
+	for _, id := range diff.Nodes[:4] {
+		send(remote, id)
+		sent[id] = true
+	}
+	remote.removeSession(sid)
+
+	cp, err := LoadSessionCheckpoint(cpPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.SID != sid {
+		t.Errorf("checkpoint sid mismatch. expected %q, got: %q", sid, cp.SID)
+	}
+	if got := cp.Completion.CompletedBlocks(); got != 4 {
+		t.Errorf("expected checkpoint to record 4 complete blocks. got: %d", got)
+	}
+
+	// a restarted remote picks up where the session left off
+	restarted, err := New(dst.nodeGetter(), dst, acceptPushes, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumedSid, resumed, err := restarted.ResumeSession(ctx, cpPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumedSid != sid {
+		t.Errorf("resumed sid mismatch. expected %q, got: %q", sid, resumedSid)
+	}
+	if len(resumed.Nodes) != len(diff.Nodes)-4 {
+		t.Errorf("expected resumed diff to have %d nodes. got: %d", len(diff.Nodes)-4, len(resumed.Nodes))
+	}
+	for _, id := range resumed.Nodes {
+		if sent[id] {
+			t.Errorf("resumed diff re-requests complete block %s", id)
+		}
+	}
+	if _, _, err := restarted.ResumeSession(ctx, cpPath); err == nil {
+		t.Error("expected resuming an open session to error")
+	}
+
+	for _, id := range resumed.Nodes {
+		send(restarted, id)
+	}
+	missing, err := dag.Missing(ctx, dst.nodeGetter(), info.Manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing.Nodes) != 0 {
+		t.Errorf("expected resumed push to complete the DAG. missing %d blocks", len(missing.Nodes))
+	}
+	if _, err := os.Stat(cpPath); !os.IsNotExist(err) {
+		t.Errorf("expected completed session to remove it's checkpoint. got: %v", err)
+	}
+}
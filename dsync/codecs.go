@@ -0,0 +1,69 @@
+package dsync
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/qri-io/dag"
+)
+
+// CodecsMetaKey is the push metadata key a sender uses to limit a transfer to
+// blocks with CID codecs in a comma-separated list, like "protobuf,cbor".
+// Codecs are named as in cid.CodecToStr, or given as decimal multicodec codes
+//
+// Pushes with a codec list are partial transfers. The remote only asks for
+// blocks with a listed codec, and the session completes once those blocks
+// arrive, leaving the received DAG with dangling links to any blocks that
+// weren't sent, (raw leaves of a "structure-only" push, for example).
+// Partial DAGs can't be walked to their leaves, so remotes never pin them.
+// Remotes instead record the root as partial, reported by Dsync.PartialRoots.
+// Pushing the same DAG again without a codec list completes it
+const CodecsMetaKey = "dsync-codecs"
+
+// encodeCodecs formats a list of codecs as a CodecsMetaKey value
+func encodeCodecs(codecs []uint64) string {
+	strs := make([]string, len(codecs))
+	for i, c := range codecs {
+		if name, ok := cid.CodecToStr[c]; ok {
+			strs[i] = name
+		} else {
+			strs[i] = strconv.FormatUint(c, 10)
+		}
+	}
+	return strings.Join(strs, ",")
+}
+
+// parseCodecs reads a CodecsMetaKey value into a set of codecs
+func parseCodecs(str string) (map[uint64]bool, error) {
+	codecs := map[uint64]bool{}
+	for _, name := range strings.Split(str, ",") {
+		name = strings.TrimSpace(name)
+		if c, ok := cid.Codecs[name]; ok {
+			codecs[c] = true
+			continue
+		}
+		c, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unrecognized codec %q", name)
+		}
+		codecs[c] = true
+	}
+	return codecs, nil
+}
+
+// filterCodecs drops nodes with CID codecs not in codecs from a manifest
+func filterCodecs(mfst *dag.Manifest, codecs map[uint64]bool) (*dag.Manifest, error) {
+	filtered := &dag.Manifest{}
+	for _, idStr := range mfst.Nodes {
+		id, err := cid.Parse(idStr)
+		if err != nil {
+			return nil, err
+		}
+		if codecs[id.Type()] {
+			filtered.Nodes = append(filtered.Nodes, idStr)
+		}
+	}
+	return filtered, nil
+}
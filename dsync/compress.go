@@ -0,0 +1,104 @@
+package dsync
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// BlockCompressionMetaKey is the push metadata key a sender uses to signal
+// it's block stream is made of compression frames. The only supported value
+// is "deflate"
+//
+// Each block in a framed stream is a CAR section whose data is a flag byte
+// followed by the block payload. The flag says if the payload is the block
+// data as-is, or the block data compressed with DEFLATE. Receivers
+// decompress each block before checking it hashes to it's CID. Compressing
+// blocks one by one survives proxies that break compression of the whole
+// stream. Only streamed blocks are framed, blocks sent one-by-one are not
+const BlockCompressionMetaKey = "dsync-block-compression"
+
+const (
+	// blockCompressionDeflate is the BlockCompressionMetaKey value for
+	// streams of DEFLATE-compressed frames
+	blockCompressionDeflate = "deflate"
+
+	// DefaultPerBlockCompressionThreshold is the default size in bytes a block
+	// must exceed for a push to compress it
+	DefaultPerBlockCompressionThreshold = 1024
+
+	// frameRaw flags a frame payload as uncompressed block data
+	frameRaw byte = 0
+	// frameDeflate flags a frame payload as DEFLATE-compressed block data
+	frameDeflate byte = 1
+
+	// maxFrameBlockSize caps the size of a decompressed block, so a small
+	// frame can't expand without bound
+	maxFrameBlockSize = 4 << 20
+)
+
+// encodeFrame builds the frame for a block, compressing blocks larger than
+// threshold. Blocks that don't shrink when compressed are sent as-is
+func encodeFrame(data []byte, threshold int) ([]byte, error) {
+	if len(data) > threshold {
+		buf := &bytes.Buffer{}
+		buf.WriteByte(frameDeflate)
+		w, err := flate.NewWriter(buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		if buf.Len() < len(data)+1 {
+			return buf.Bytes(), nil
+		}
+	}
+
+	frame := make([]byte, len(data)+1)
+	frame[0] = frameRaw
+	copy(frame[1:], data)
+	return frame, nil
+}
+
+// decodeFrame returns the block data of a frame created with encodeFrame
+func decodeFrame(frame []byte) ([]byte, error) {
+	if len(frame) == 0 {
+		return nil, fmt.Errorf("invalid frame: missing compression flag")
+	}
+	switch frame[0] {
+	case frameRaw:
+		return frame[1:], nil
+	case frameDeflate:
+		r := flate.NewReader(bytes.NewReader(frame[1:]))
+		defer r.Close()
+		data, err := ioutil.ReadAll(io.LimitReader(r, maxFrameBlockSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("invalid frame: %w", err)
+		}
+		if len(data) > maxFrameBlockSize {
+			return nil, fmt.Errorf("invalid frame: block exceeds %d bytes", maxFrameBlockSize)
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("invalid frame: unknown compression flag %d", frame[0])
+	}
+}
+
+// parseBlockCompression reads a BlockCompressionMetaKey value, reporting if
+// a session's block stream is framed
+func parseBlockCompression(meta map[string]string) (bool, error) {
+	str, ok := meta[BlockCompressionMetaKey]
+	if !ok {
+		return false, nil
+	}
+	if str != blockCompressionDeflate {
+		return false, fmt.Errorf("unsupported block compression %q", str)
+	}
+	return true, nil
+}
@@ -0,0 +1,109 @@
+package dsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/qri-io/dag"
+)
+
+func TestPerBlockCompression(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a compressible block, an incompressible block & a block too small to
+	// compress, each above or below the threshold
+	noise := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(noise)
+	compressible := merkledag.NodeWithData(bytes.Repeat([]byte("compress me "), 500))
+	incompressible := merkledag.NodeWithData(noise)
+	root := merkledag.NodeWithData([]byte("root"))
+	if err := root.AddNodeLink("0", compressible); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.AddNodeLink("1", incompressible); err != nil {
+		t.Fatal(err)
+	}
+
+	local := newMemStore()
+	local.putNode(root)
+	local.putNode(compressible)
+	local.putNode(incompressible)
+	info, err := dag.NewInfo(ctx, local.nodeGetter(), root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := newManifestCARReader(ctx, local.nodeGetter(), info.Manifest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.framed = true
+	r.compressAbove = DefaultPerBlockCompressionThreshold
+
+	br := bufio.NewReader(r)
+	if _, err := car.ReadHeader(br); err != nil {
+		t.Fatal(err)
+	}
+	flags := map[string]byte{}
+	for {
+		id, frame, err := carutil.ReadNode(br)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		flags[id.String()] = frame[0]
+		data, err := decodeFrame(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verifyBlock(id.String(), data); err != nil {
+			t.Errorf("decoded frame doesn't match block %s: %s", id, err)
+		}
+	}
+	expect := map[string]byte{
+		root.Cid().String():           frameRaw,
+		compressible.Cid().String():   frameDeflate,
+		incompressible.Cid().String(): frameRaw,
+	}
+	for id, flag := range expect {
+		if flags[id] != flag {
+			t.Errorf("block %s frame flag mismatch. expected: %d, got: %d", id, flag, flags[id])
+		}
+	}
+
+	rem, remote := newTestRemote(t)
+	push, err := NewPush(local.nodeGetter(), info, rem, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	push.compress = true
+	push.compressAbove = DefaultPerBlockCompressionThreshold
+	if err := push.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dag.NewManifest(ctx, remote.nodeGetter(), root.Cid()); err != nil {
+		t.Errorf("expected pushed DAG to be complete. error: %s", err)
+	}
+
+	// remotes reject compression they don't understand
+	if _, _, err := rem.NewReceiveSession(info, false, map[string]string{BlockCompressionMetaKey: "zstd"}); err == nil {
+		t.Error("expected unsupported block compression to error")
+	}
+
+	// corrupt frames fail the stream
+	if _, err := decodeFrame([]byte{frameDeflate, 0xff, 0xff}); err == nil {
+		t.Error("expected corrupt frame to error")
+	}
+	if _, err := decodeFrame([]byte{7}); err == nil {
+		t.Error("expected unknown frame flag to error")
+	}
+}
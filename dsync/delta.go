@@ -0,0 +1,197 @@
+package dsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/qri-io/dag"
+)
+
+const (
+	// maxDeltaCandidates is the number of base blocks closest in size to a
+	// block that are tried when delta-encoding
+	maxDeltaCandidates = 8
+)
+
+// BlockDeltaReceiver is an optional interface for remotes that can accept a
+// block encoded as a delta against a base block the remote already has
+type BlockDeltaReceiver interface {
+	// ReceiveBlockDelta reconstructs a block from a base block & delta, placing
+	// the result on the remote
+	ReceiveBlockDelta(sid, hash, baseHash string, delta []byte) ReceiveResponse
+}
+
+// ContextBlockDeltaReceiver is the ContextBlockReceiver counterpart of
+// BlockDeltaReceiver. Pushes that send deltas use it when the remote supports
+// it, so cancelling a push stops deltas that are in flight
+type ContextBlockDeltaReceiver interface {
+	// ReceiveBlockDeltaContext places a block sent as a delta on the remote,
+	// returning early with a non-ok status if ctx is cancelled
+	ReceiveBlockDeltaContext(ctx context.Context, sid, hash, baseHash string, delta []byte) ReceiveResponse
+}
+
+// encodeDelta describes target as the bytes it shares with the start & end of
+// base, plus the bytes that differ. This is cheap to compute & apply, and
+// compact for blocks that differ by a localized edit. Encoded deltas are:
+//
+//	uvarint(prefix length) | uvarint(suffix length) | replacement bytes
+func encodeDelta(base, target []byte) []byte {
+	prefix := 0
+	for prefix < len(base) && prefix < len(target) && base[prefix] == target[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(base)-prefix && suffix < len(target)-prefix && base[len(base)-1-suffix] == target[len(target)-1-suffix] {
+		suffix++
+	}
+
+	buf := make([]byte, binary.MaxVarintLen64*2, binary.MaxVarintLen64*2+len(target)-prefix-suffix)
+	n := binary.PutUvarint(buf, uint64(prefix))
+	n += binary.PutUvarint(buf[n:], uint64(suffix))
+	return append(buf[:n], target[prefix:len(target)-suffix]...)
+}
+
+// applyDelta reconstructs a block from a base block & delta created with
+// encodeDelta
+func applyDelta(base, delta []byte) ([]byte, error) {
+	prefix, n := binary.Uvarint(delta)
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid delta: reading prefix length")
+	}
+	delta = delta[n:]
+	suffix, n := binary.Uvarint(delta)
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid delta: reading suffix length")
+	}
+	delta = delta[n:]
+	if prefix+suffix > uint64(len(base)) {
+		return nil, fmt.Errorf("invalid delta: prefix & suffix exceed base length")
+	}
+
+	data := make([]byte, 0, int(prefix)+len(delta)+int(suffix))
+	data = append(data, base[:prefix]...)
+	data = append(data, delta...)
+	return append(data, base[uint64(len(base))-suffix:]...), nil
+}
+
+// ReceiveBlockDelta accepts a block encoded as a delta against a base block
+// in the local blockstore. The reconstructed block is placed with
+// ReceiveBlock, which verifies it's hash
+func (s *session) ReceiveBlockDelta(hash, baseHash string, delta []byte) ReceiveResponse {
+	baseData, err := s.readBlock(baseHash)
+	if err != nil {
+		return ReceiveResponse{
+			Hash:   hash,
+			Status: StatusErrored,
+			Err:    fmt.Errorf("reading delta base %s: %w", baseHash, err),
+		}
+	}
+
+	data, err := applyDelta(baseData, delta)
+	if err != nil {
+		return ReceiveResponse{
+			Hash:   hash,
+			Status: StatusErrored,
+			Err:    err,
+		}
+	}
+	return s.ReceiveBlock(hash, bytes.NewReader(data))
+}
+
+// deltaEncoder finds base blocks to delta-encode pushed blocks against.
+// Candidate bases must be local, and already present on the remote
+type deltaEncoder struct {
+	lng        ipld.NodeGetter
+	candidates []string
+
+	once  sync.Once
+	bases []deltaBase // sorted by size
+}
+
+type deltaBase struct {
+	hash string
+	data []byte
+}
+
+// newDeltaEncoder creates an encoder for a push, using blocks in the pushed
+// manifest that aren't in the diff, plus any additional bases as candidates
+func newDeltaEncoder(lng ipld.NodeGetter, mfst, diff *dag.Manifest, additional *dag.Manifest) *deltaEncoder {
+	missing := map[string]bool{}
+	for _, id := range diff.Nodes {
+		missing[id] = true
+	}
+
+	seen := map[string]bool{}
+	var candidates []string
+	add := func(m *dag.Manifest) {
+		if m == nil {
+			return
+		}
+		for _, id := range m.Nodes {
+			if !missing[id] && !seen[id] {
+				seen[id] = true
+				candidates = append(candidates, id)
+			}
+		}
+	}
+	add(mfst)
+	add(additional)
+
+	return &deltaEncoder{lng: lng, candidates: candidates}
+}
+
+// encode returns a delta for data against the candidate that produces the
+// smallest delta. ok is false when no candidate produces a delta less than
+// half the size of data
+func (e *deltaEncoder) encode(ctx context.Context, data []byte) (baseHash string, delta []byte, ok bool) {
+	e.once.Do(func() { e.loadBases(ctx) })
+	if len(e.bases) == 0 {
+		return "", nil, false
+	}
+
+	// try the candidates closest in size
+	i := sort.Search(len(e.bases), func(i int) bool { return len(e.bases[i].data) >= len(data) })
+	lo, hi := i, i
+	for hi-lo < maxDeltaCandidates && (lo > 0 || hi < len(e.bases)) {
+		if lo > 0 && (hi == len(e.bases) || len(data)-len(e.bases[lo-1].data) < len(e.bases[hi].data)-len(data)) {
+			lo--
+		} else {
+			hi++
+		}
+	}
+
+	for _, base := range e.bases[lo:hi] {
+		d := encodeDelta(base.data, data)
+		if delta == nil || len(d) < len(delta) {
+			baseHash, delta = base.hash, d
+		}
+	}
+	if len(delta) >= len(data)/2 {
+		return "", nil, false
+	}
+	return baseHash, delta, true
+}
+
+func (e *deltaEncoder) loadBases(ctx context.Context) {
+	for _, hash := range e.candidates {
+		id, err := cid.Parse(hash)
+		if err != nil {
+			continue
+		}
+		nd, err := e.lng.Get(ctx, id)
+		if err != nil {
+			log.Debugf("skipping delta base %s: %s", hash, err)
+			continue
+		}
+		e.bases = append(e.bases, deltaBase{hash: hash, data: nd.RawData()})
+	}
+	sort.SliceStable(e.bases, func(i, j int) bool {
+		return len(e.bases[i].data) < len(e.bases[j].data)
+	})
+}
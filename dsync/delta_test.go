@@ -0,0 +1,123 @@
+package dsync
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/go-merkledag"
+	"github.com/qri-io/dag"
+)
+
+func TestDeltaRoundTrip(t *testing.T) {
+	cases := []struct {
+		base, target string
+	}{
+		{"", ""},
+		{"", "new"},
+		{"old", ""},
+		{"hello world", "hello world"},
+		{"hello world", "hello there world"},
+		{"hello world", "jello world"},
+		{"hello world", "hello worlds"},
+		{"aaaa", "aa"},
+		{"aa", "aaaa"},
+	}
+
+	for _, c := range cases {
+		delta := encodeDelta([]byte(c.base), []byte(c.target))
+		got, err := applyDelta([]byte(c.base), delta)
+		if err != nil {
+			t.Errorf("applying delta of %q against %q: %s", c.target, c.base, err)
+			continue
+		}
+		if string(got) != c.target {
+			t.Errorf("round trip mismatch. expected: %q, got: %q", c.target, got)
+		}
+	}
+
+	if _, err := applyDelta([]byte("short"), encodeDelta([]byte("a longer base"), []byte("a longer target"))); err == nil {
+		t.Error("expected applying a delta to the wrong base to error")
+	}
+}
+
+func TestPushDeltaEncodeBlocks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	data := bytes.Repeat([]byte("a large leaf that changes a little between versions. "), 100)
+	leaf1 := merkledag.NodeWithData(data)
+	edited := append([]byte{}, data...)
+	copy(edited[len(edited)/2:], "EDITED")
+	leaf2 := merkledag.NodeWithData(edited)
+
+	// v1 has only leaf1, v2 keeps leaf1 & adds the near-duplicate leaf2
+	v1 := merkledag.NodeWithData([]byte("v1"))
+	if err := v1.AddNodeLink("0", leaf1); err != nil {
+		t.Fatal(err)
+	}
+	v2 := merkledag.NodeWithData([]byte("v2"))
+	if err := v2.AddNodeLink("0", leaf1); err != nil {
+		t.Fatal(err)
+	}
+	if err := v2.AddNodeLink("1", leaf2); err != nil {
+		t.Fatal(err)
+	}
+
+	local := newMemStore()
+	for _, nd := range []*merkledag.ProtoNode{leaf1, leaf2, v1, v2} {
+		local.putNode(nd)
+	}
+	remote := newMemStore()
+	remote.putNode(leaf1)
+	remote.putNode(v1)
+
+	remDs, err := New(remote.nodeGetter(), remote, acceptPushes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rem := &deltaRecordingRemote{Dsync: remDs, deltas: map[string]string{}}
+
+	info, err := dag.NewInfo(ctx, local.nodeGetter(), v2.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	push, err := NewPush(local.nodeGetter(), info, rem, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	push.deltas = true
+	if err := push.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if base, ok := rem.deltas[leaf2.Cid().String()]; !ok {
+		t.Errorf("expected near-duplicate leaf to be sent as a delta")
+	} else if base != leaf1.Cid().String() {
+		t.Errorf("delta base mismatch. expected: %s, got: %s", leaf1.Cid(), base)
+	}
+	if _, ok := rem.deltas[v2.Cid().String()]; ok {
+		t.Errorf("expected root without a suitable base to be sent in full")
+	}
+	if _, err := dag.NewManifest(ctx, remote.nodeGetter(), v2.Cid()); err != nil {
+		t.Errorf("expected v2 to be complete on the remote. error: %s", err)
+	}
+}
+
+// deltaRecordingRemote records the hash & base of accepted block deltas
+type deltaRecordingRemote struct {
+	*Dsync
+	lock   sync.Mutex
+	deltas map[string]string
+}
+
+func (r *deltaRecordingRemote) ReceiveBlockDelta(sid, hash, baseHash string, delta []byte) ReceiveResponse {
+	res := r.Dsync.ReceiveBlockDelta(sid, hash, baseHash, delta)
+	if res.Status == StatusOk {
+		r.lock.Lock()
+		r.deltas[hash] = baseHash
+		r.lock.Unlock()
+	}
+	return res
+}
@@ -16,10 +16,12 @@ package dsync
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -49,10 +51,27 @@ const (
 	// default to parallelism of 3
 	// TODO (b5): tune this figure
 	defaultPullParallelism = 1
-	// total number of retries to attempt before send is considered faulty
-	// TODO (b5): this number should be retries *per object*, and a much lower
-	// number, like 5.
-	maxRetries = 80
+	// default number of times a push resends a block the remote asks to retry
+	defaultPushBlockRetries = 5
+	// default delay before the first resend of a block, doubled for each
+	// following resend of the same block
+	defaultPushRetryBackoff = time.Millisecond * 100
+	// upper bound on the delay between resends of a block
+	maxPushRetryBackoff = time.Second * 10
+
+	// DefaultSessionTTL is the default duration a receive session can go
+	// without receiving blocks before it expires
+	DefaultSessionTTL = time.Hour * 5
+
+	// DefaultMaxManifestNodes is the default limit on the number of nodes in
+	// the manifest of a push a remote will accept
+	DefaultMaxManifestNodes = 1000000
+	// DefaultCheckpointInterval is the default number of blocks a receive
+	// session places between checkpoints
+	DefaultCheckpointInterval = 100
+	// upper bound on the encoded size of each manifest node a remote will
+	// decode, bounding request bodies to MaxManifestNodes times this size
+	maxManifestBytesPerNode = 256
 )
 
 var (
@@ -63,6 +82,82 @@ var (
 	// protocol is unknown, usually because the handshake with the the remote
 	// hasn't happened yet
 	ErrUnknownProtocolVersion = fmt.Errorf("unknown protocol version")
+	// ErrManifestHashMismatch is the error for when a manifest doesn't hash to
+	// the value it's expected to
+	ErrManifestHashMismatch = fmt.Errorf("manifest hash mismatch")
+	// ErrBlockSizeMismatch is the error for when a received block isn't the
+	// size the pushed dag.Info declares for it
+	ErrBlockSizeMismatch = fmt.Errorf("block size mismatch")
+	// ErrHashMismatch is the error for when a received block doesn't match the
+	// CID it was sent as
+	ErrHashMismatch = fmt.Errorf("hash mismatch")
+	// ErrRetriesExhausted is the error for when a push gives up on blocks the
+	// remote kept asking to retry
+	ErrRetriesExhausted = fmt.Errorf("block retries exhausted")
+	// ErrManifestTooLarge is the error for when a remote refuses a push with
+	// a manifest over it's configured MaxManifestNodes
+	ErrManifestTooLarge = fmt.Errorf("manifest too large")
+	// ErrDAGMismatch is the error for when a pulled DAG assembled in the local
+	// store doesn't match the manifest it was pulled with
+	ErrDAGMismatch = fmt.Errorf("local DAG doesn't match manifest")
+	// ErrSizesUnknown is the error for when a receive session can't report
+	// bytes because the pushed info doesn't declare block sizes
+	ErrSizesUnknown = fmt.Errorf("block sizes unknown")
+)
+
+// HashMismatchError describes a block that doesn't match the CID it was sent
+// or requested as. Hash mismatches mean the block is wrong, retrying the same
+// data won't help. HashMismatchErrors match ErrHashMismatch with errors.Is
+type HashMismatchError struct {
+	Expected string // CID the block was sent or requested as
+	Got      string // CID the block's data hashes to, if known
+	Reason   string // optional description of how the CIDs differ
+}
+
+// Error implements the error interface
+func (e *HashMismatchError) Error() string {
+	msg := fmt.Sprintf("%s. expected: '%s', got: '%s'", ErrHashMismatch, e.Expected, e.Got)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// Is makes HashMismatchErrors match ErrHashMismatch
+func (e *HashMismatchError) Is(target error) bool {
+	return target == ErrHashMismatch
+}
+
+// BlockPutError is the error for when a block store fails to place a block.
+// Put errors are often transient, use DefaultIsTransientErr on the wrapped
+// error to decide if a put is worth retrying
+type BlockPutError struct {
+	Cid string // CID of the block being put
+	Err error  // error the store returned
+}
+
+// Error implements the error interface
+func (e *BlockPutError) Error() string {
+	return fmt.Sprintf("putting block %s: %s", e.Cid, e.Err)
+}
+
+// Unwrap returns the error the store returned
+func (e *BlockPutError) Unwrap() error {
+	return e.Err
+}
+
+const (
+	// ManifestHashMetaKey is the push metadata key a sender uses to attach the
+	// hash of the manifest it's pushing
+	ManifestHashMetaKey = "dsync-manifest-hash"
+	// ManifestRootMetaKey is the push metadata key a sender uses to attach the
+	// root id of the DAG it's pushing alongside the manifest hash, letting
+	// remotes identify DAGs they already have without decoding the manifest
+	ManifestRootMetaKey = "dsync-manifest-root"
+	// PinNameMetaKey is the push metadata key a sender uses to give the DAG it's
+	// pushing a human-readable name. Remotes that pin the DAG on completion
+	// record the name alongside the pin, reported by Dsync.ManagedPins
+	PinNameMetaKey = "dsync-pin-name"
 )
 
 // DagSyncable is a source that can be synced to & from. dsync requests automate
@@ -94,10 +189,82 @@ type DagSyncable interface {
 	RemoveCID(ctx context.Context, cidStr string, meta map[string]string) (err error)
 }
 
+// BlockPartReceiver is an optional interface for remotes that can accept a
+// block split into sequential parts, giving large blocks progress that's
+// finer grained than the whole block
+type BlockPartReceiver interface {
+	// ReceiveBlockPart places part of a block on the remote. offset is the
+	// position of data within the block, size is the length of the full block
+	ReceiveBlockPart(sid, hash string, offset, size int, data []byte) ReceiveResponse
+}
+
+// ContextBlockReceiver is an optional interface for remotes that can abort
+// receiving a block when a context is cancelled. Pushes send blocks with
+// ReceiveBlockContext when the remote supports it, so cancelling a push stops
+// blocks that are in flight
+type ContextBlockReceiver interface {
+	// ReceiveBlockContext places a block on the remote, returning early with
+	// a non-ok status if ctx is cancelled
+	ReceiveBlockContext(ctx context.Context, sid, hash string, data []byte) ReceiveResponse
+}
+
+// ContextBlockPartReceiver is the ContextBlockReceiver counterpart of
+// BlockPartReceiver. Pushes that send blocks in parts use it when the remote
+// supports it, so cancelling a push stops parts that are in flight
+type ContextBlockPartReceiver interface {
+	// ReceiveBlockPartContext places part of a block on the remote, returning
+	// early with a non-ok status if ctx is cancelled
+	ReceiveBlockPartContext(ctx context.Context, sid, hash string, offset, size int, data []byte) ReceiveResponse
+}
+
+// ContextSessionOpener is an optional interface for remotes that can abort
+// opening a receive session when a context is cancelled. Pushes open sessions
+// with NewReceiveSessionContext when the remote supports it
+type ContextSessionOpener interface {
+	// NewReceiveSessionContext is NewReceiveSession, returning early with an
+	// error if ctx is cancelled
+	NewReceiveSessionContext(ctx context.Context, info *dag.Info, pinOnComplete bool, meta map[string]string) (sid string, diff *dag.Manifest, err error)
+}
+
+// BlockProber is an optional interface for remotes that can report which
+// blocks requested by a receive session they already have
+type BlockProber interface {
+	// ProbeBlocks returns the subset of ids the remote's block store already
+	// has, marking them complete in the receive session sid. Remotes may
+	// accept an empty sid to probe blocks outside of a session
+	ProbeBlocks(ctx context.Context, sid string, ids []string) (have []string, err error)
+}
+
+// CIDsRemover is an optional interface for remotes that can remove several
+// CIDs in one request
+type CIDsRemover interface {
+	// RemoveCIDs asks the remote to remove each of cids, reporting which were
+	// removed & which failed. Remotes that don't allow removes must return
+	// ErrRemoveNotSupported
+	RemoveCIDs(ctx context.Context, cids []string, meta map[string]string) (*RemoveResults, error)
+}
+
+// RemoveResults reports the outcome of removing several CIDs
+type RemoveResults struct {
+	Removed []string          `json:"removed"`          // cids that were removed
+	Failed  map[string]string `json:"failed,omitempty"` // error messages of cids that weren't, keyed by cid
+}
+
+// SessionProgresser is an optional interface for remotes that can report the
+// completion of a receive session, so an interrupted push can be resumed
+type SessionProgresser interface {
+	// SessionProgress returns the completion of receive session sid. Blocks
+	// the remote already had when the session opened are complete
+	SessionProgress(ctx context.Context, sid string) (dag.Completion, error)
+}
+
 // Hook is a function that a dsync instance will call at specified points in the
 // sync lifecycle
 type Hook func(ctx context.Context, info dag.Info, meta map[string]string) error
 
+// BlockHook is a function a dsync instance calls for individual blocks
+type BlockHook func(ctx context.Context, hash string, size int, meta map[string]string)
+
 // DefaultDagPrecheck rejects all requests
 // Dsync users are required to override this hook to make dsync work,
 // and are expected to supply a trust model in this hook. An example trust model
@@ -124,12 +291,17 @@ var DefaultDagFinalCheck = func(context.Context, dag.Info, map[string]string) er
 type Dsync struct {
 	// cache of dagInfo/manifests
 	infoStore dag.InfoStore
+	// store of manifest hashes sent with received DAGs
+	manifestHashStore dag.ManifestHashStore
+	manifestStore     dag.ManifestStore
 	// local node getter
 	lng ipld.NodeGetter
 	// local block API for placing blocks
 	bapi coreiface.BlockAPI
 	// api for pinning blocks
 	pin coreiface.PinAPI
+	// optional persistent record of managed pins
+	pinRegistry PinRegistry
 
 	// http server accepting dsync requests
 	httpServer *http.Server
@@ -141,6 +313,50 @@ type Dsync struct {
 	requireAllBlocks bool
 	// should dsync honor remove requests?
 	allowRemoves bool
+	// priority orders the send queue of pushes created by this instance
+	priority func(cid string) int
+	// blockPutTimeout limits the duration of a single block put when receiving
+	blockPutTimeout time.Duration
+	// blockChunkSize splits larger blocks into parts when pushing
+	blockChunkSize int
+	// perBlockCompression compresses streamed blocks larger than
+	// perBlockCompressionThreshold when pushing
+	perBlockCompression          bool
+	perBlockCompressionThreshold int
+	// pushBlockRetries & pushRetryBackoff configure resending blocks the
+	// remote asks to retry when pushing
+	pushBlockRetries int
+	pushRetryBackoff time.Duration
+	// pushParallelism is the number of blocks pushes send at once
+	pushParallelism int
+	// maxManifestNodes caps the size of manifests received pushes may have,
+	// zero means unlimited
+	maxManifestNodes int
+	// attachManifestHash sends manifest hashes with pushes
+	attachManifestHash bool
+	// deltaEncodeBlocks sends near-duplicate blocks as deltas when pushing
+	deltaEncodeBlocks bool
+	// probeRemoteBlocks asks remotes for blocks they have before pushing
+	probeRemoteBlocks bool
+	// progressInterval limits the frequency of completion updates
+	progressInterval time.Duration
+	// pushCodecs limits pushes to blocks with these CID codecs
+	pushCodecs []uint64
+	// pullBatchSize is the number of streamed blocks pulls write at once
+	pullBatchSize int
+	// verifyNodeOrder rejects pushes with manifests that aren't in the order
+	// their graph dictates
+	verifyNodeOrder bool
+	// store optionally places received blocks in a sink through a transform,
+	// and reads served blocks back through the inverse
+	store *transformingStore
+	// isTransientErr classifies errors putting received blocks
+	isTransientErr func(error) bool
+	// checkpointDir is where receive sessions save checkpoints, empty means
+	// sessions aren't checkpointed
+	checkpointDir string
+	// checkpointInterval is the number of blocks between session checkpoints
+	checkpointInterval int
 
 	// preCheck is called before creating a receive session
 	preCheck Hook
@@ -148,20 +364,39 @@ type Dsync struct {
 	finalCheck Hook
 	// onCompleteHook is optionally called once dag sync is complete
 	onCompleteHook Hook
+	// onBlockReceived is optionally called for each block a push places
+	onBlockReceived BlockHook
 	// getDagInfoCheck is an optional hook to call when a client asks for a dag
 	// info
 	getDagInfoCheck Hook
 	// openBlockStreamCheck is an optional hook to call when a client asks to pull
 	// a stream of one or more blocks
 	openBlockStreamCheck Hook
+	// getBlockCheck is an optional hook to call when a client asks for a single
+	// block
+	getBlockCheck Hook
 	// removeCheck is an optional hook to call before allowing a delete
 	removeCheck Hook
+	// authenticate is an optional gate HTTPRemoteHandler calls before handling
+	// any request
+	authenticate func(r *http.Request) error
+
+	// roots of DAGs this instance has pinned, keyed by CID string
+	managedLock  sync.Mutex
+	managedRoots map[string]cid.Cid
+	// names of pinned roots, keyed by CID string
+	pinNames map[string]string
+	// roots of partial DAGs this instance has received, keyed by CID string
+	partialRoots map[string]cid.Cid
 
 	// inbound transfers in progress, will be nil if not acting as a remote
 	sessionLock    sync.Mutex
 	sessionPool    map[string]*session
 	sessionCancels map[string]context.CancelFunc
-	sessionTTLDur  time.Duration
+	// sessions idle for longer than sessionTTLDur expire, zero means never
+	sessionTTLDur time.Duration
+	// now is the clock session activity is measured with
+	now func() time.Time
 }
 
 var (
@@ -169,12 +404,32 @@ var (
 	_ DagSyncable = (*Dsync)(nil)
 	// compile-time assertion that Dsync satisfies streaming interfaces
 	_ DagStreamable = (*Dsync)(nil)
+	// compile-time assertion that Dsync can receive blocks in parts
+	_ BlockPartReceiver = (*Dsync)(nil)
+	// compile-time assertion that Dsync can receive delta-encoded blocks
+	_ BlockDeltaReceiver = (*Dsync)(nil)
+	// compile-time assertion that Dsync can report blocks it has
+	_ BlockProber = (*Dsync)(nil)
+	// compile-time assertion that Dsync can report receive session progress
+	_ SessionProgresser = (*Dsync)(nil)
+	// compile-time assertion that Dsync can remove several cids at once
+	_ CIDsRemover = (*Dsync)(nil)
 )
 
 // Config encapsulates optional Dsync configuration
 type Config struct {
 	// InfoStore is an optional caching layer for dag.Info objects
 	InfoStore dag.InfoStore
+	// ManifestHashStore is an optional store for the manifest hashes pushers
+	// attach to DAGs. Stored hashes are compared against manifests re-derived
+	// from local blocks by VerifyManifestHash
+	ManifestHashStore dag.ManifestHashStore
+	// ManifestStore is an optional cache of manifests. Dsync checks the store
+	// before deriving a manifest from local blocks, storing manifests it
+	// derives, and the manifests of DAGs it receives. Infos GetDagInfo builds
+	// from stored manifests don't have sizes, cache infos with InfoStore to
+	// keep them. A nil store derives manifests every time
+	ManifestStore dag.ManifestStore
 	// provide a listening addres to have Dsync spin up an HTTP server when
 	// StartRemote(ctx) is called
 	HTTPRemoteAddress string
@@ -182,6 +437,10 @@ type Config struct {
 	Libp2pHost host.Host
 	// PinAPI is required for remotes to accept pinning requests
 	PinAPI coreiface.PinAPI
+	// PinRegistry optionally records the roots dsync pins & their names, so
+	// ManagedRoots & ManagedPins survive restarts. Without a registry roots
+	// are only tracked in memory
+	PinRegistry PinRegistry
 
 	// RequireAllBlocks will skip checking for blocks already present on the
 	// remote, requiring push requests to send all blocks each time
@@ -191,22 +450,168 @@ type Config struct {
 	// AllowRemoves let's dsync opt into remove requests. removes are
 	// disabled by default
 	AllowRemoves bool
+	// Priority is an optional function for ordering the blocks a push sends.
+	// Blocks with a higher priority are sent first, blocks with equal priority
+	// are sent in manifest order. Use this to get blocks the receiver needs
+	// early (index nodes for progressive rendering, for example) to the
+	// front of the queue
+	Priority func(cid string) int
+	// BlockPutTimeout is the maximum duration a remote will wait on the local
+	// block store to put a single received block. Puts that time out are
+	// reported back to the sender as retryable. Zero means no timeout
+	BlockPutTimeout time.Duration
+	// BlockChunkSize splits blocks larger than this many bytes into parts when
+	// pushing block-by-block to a remote that supports BlockPartReceiver,
+	// so progress on large blocks is reported as parts land. Pushes that
+	// stream blocks are unaffected. Zero means blocks are never split
+	BlockChunkSize int
+	// PerBlockCompression makes pushes that stream blocks compress each block
+	// larger than PerBlockCompressionThreshold bytes on it's own, flagging
+	// every block in the stream as compressed or not. Remotes decompress
+	// blocks before verifying them. Unlike compressing the whole stream,
+	// compressed blocks survive proxies that re-encode streams. Remotes must
+	// understand BlockCompressionMetaKey
+	PerBlockCompression bool
+	// PerBlockCompressionThreshold is the size in bytes a block must exceed
+	// to be compressed. Defaults to DefaultPerBlockCompressionThreshold
+	PerBlockCompressionThreshold int
+	// PushBlockRetries is the number of times a push resends a block the
+	// remote asks to retry, like a block the remote failed to store because
+	// of blockstore contention. Pushes keep sending other blocks while a block
+	// waits to be resent, and fail once all blocks are sent or out of retries,
+	// listing blocks that are out of retries in an error wrapping
+	// ErrRetriesExhausted. Defaults to 5
+	PushBlockRetries int
+	// PushRetryBackoff is the delay before a push resends a block for the
+	// first time. The delay doubles for each following resend of the same
+	// block, up to 10 seconds. Defaults to 100 milliseconds
+	PushRetryBackoff time.Duration
+	// PushParallelism is the number of blocks a push sends at once, which
+	// keeps more bandwidth busy on high-latency connections. Pushes sending
+	// blocks concurrently only send a block once the remote has all of it's
+	// children, so a block is never stored before the blocks it links to.
+	// Pushes that stream blocks are unaffected. Defaults to 1
+	PushParallelism int
+	// DeltaEncodeBlocks makes pushes send blocks that are near-duplicates of
+	// blocks the remote already has as deltas, if the remote supports
+	// BlockDeltaReceiver. Base blocks are drawn from the blocks of the pushed
+	// DAG the remote reports having, plus any set with Push.SetDeltaBase.
+	// Blocks without a suitable base are sent in full. Delta-encoding pushes
+	// send blocks one-by-one instead of streaming
+	DeltaEncodeBlocks bool
+	// ProbeRemoteBlocks makes pushes ask remotes that support BlockProber
+	// which of the requested blocks they already have before sending any,
+	// skipping blocks the remote's block store has. This avoids re-sending
+	// blocks shared with earlier pushes to remotes that can't diff manifests
+	// themselves, like remotes configured with RequireAllBlocks
+	ProbeRemoteBlocks bool
+	// ProgressInterval limits completion updates of pushes, pulls & receive
+	// sessions to at most one per interval. Changes between updates are
+	// coalesced into the next update, and completing a transfer always sends
+	// an update. Zero means an update is sent for every change
+	ProgressInterval time.Duration
+	// PushCodecs limits pushes to blocks whose CID codec is in the list,
+	// (cid.DagProtobuf & cid.DagCBOR for a "metadata-only" push that skips raw
+	// leaves, for example). Pushes with a codec list are partial transfers,
+	// see CodecsMetaKey for how remotes treat the DAGs they produce. Empty
+	// means blocks of all codecs are pushed
+	PushCodecs []uint64
+	// PullBatchSize makes pulls buffer this many streamed blocks before
+	// placing them in the local block store with a single call, if the block
+	// API supports BlockPutManyer. Batching amortizes the cost of writing DAGs
+	// of many small blocks. Buffered blocks are written when the batch fills
+	// & when the stream ends, and only count toward progress once written.
+	// Pulls that fetch blocks one-by-one are unaffected. Zero or one means
+	// each block is written as it arrives
+	PullBatchSize int
+	// AttachManifestHash makes pushes send the hash of the manifest being
+	// pushed, which remotes check on receipt and can store as an integrity
+	// anchor. Remotes with a ManifestHashStore that already hold every block
+	// of a DAG with the same root & manifest hash report the push as complete
+	// when the session is opened, without requesting any blocks. Remotes
+	// check the hash against their own manifest of the DAG before skipping
+	AttachManifestHash bool
+	// VerifyNodeOrder makes a remote recompute the node order of each manifest
+	// it's asked to receive, rejecting pushes where the sent order doesn't
+	// match. This guards against senders that tamper with ordering, which
+	// would corrupt progress tracking
+	VerifyNodeOrder bool
+	// TransformReceivedBlock is optionally called with the data of each block
+	// a push sends, returning the data to store in it's place, to encrypt
+	// blocks at rest, for example. The order is important: received data is
+	// first checked to hash to the block's CID, then transformed, then stored.
+	// Transformed data won't hash to the CID, so it's stored with the block
+	// API's BlockSink methods, keyed by the CID of the untransformed block.
+	// Stores that don't implement BlockSink can't be used with transforms.
+	// Requires TransformServedBlock
+	TransformReceivedBlock BlockTransform
+	// TransformServedBlock reverses TransformReceivedBlock. Blocks are read
+	// from the block API's BlockSink methods and transformed before being
+	// served by GetBlock & OpenBlockStream, or used as delta bases. The local
+	// NodeGetter dsync is created with must also read blocks through this
+	// transform, as it's used to diff & describe stored DAGs
+	TransformServedBlock BlockTransform
+	// IsTransientErr classifies errors putting a received block in the block
+	// store. Blocks that fail with transient errors are reported to the
+	// sender as retryable, all other put errors fail the block, so senders
+	// don't retry blocks that can never be stored. Defaults to
+	// DefaultIsTransientErr
+	IsTransientErr func(error) bool
+	// MaxManifestNodes is the largest number of nodes a remote will accept in
+	// the manifest of a push, refusing larger manifests with an error wrapping
+	// ErrManifestTooLarge before a session is created. HTTP remotes also cap
+	// the size of manifest request bodies to match. This stops clients from
+	// making remotes track state for manifests of millions of phantom nodes.
+	// Defaults to DefaultMaxManifestNodes, zero means unlimited
+	MaxManifestNodes int
+	// SessionTTL is the duration a receive session can go without receiving
+	// a block before the remote expires it. Expired sessions are removed,
+	// refuse further blocks & never run completion hooks, freeing the state
+	// of pushes from clients that went away. Each received block resets the
+	// session's timer. Defaults to DefaultSessionTTL, zero means sessions
+	// never expire
+	SessionTTL time.Duration
+	// CheckpointDir makes receive sessions periodically save their progress,
+	// manifest & id to a file in this directory, so a push interrupted by a
+	// remote restart can be picked up again with ResumeSession. Checkpoints
+	// are removed when a session completes. Empty means sessions aren't
+	// checkpointed
+	CheckpointDir string
+	// CheckpointInterval is the number of blocks a receive session places
+	// between checkpoints. Defaults to DefaultCheckpointInterval
+	CheckpointInterval int
 
 	// required check function for a remote accepting DAGs, this hook will be
-	// called before a push is allowed to begin
+	// called before a push is allowed to begin. pushes of DAGs the remote
+	// already has may be checked with an info that only contains the root id
 	PushPreCheck Hook
 	// optional check function for screening a receive before potentially pinning
 	PushFinalCheck Hook
 	// optional check function called after successful transfer
 	PushComplete Hook
+	// optional function called after each block of a push is received &
+	// placed in the block store, with the block's hash, size in bytes & push
+	// metadata. OnBlockReceived is called on the receive path, receiving the
+	// next block waits for it to return, so slow work like writing to external
+	// logs should be handed off
+	OnBlockReceived BlockHook
 	// optional check to run on dagInfo requests before sending an info back
 	GetDagInfoCheck Hook
 	// optional hook to run before allowing a stream of blocks
 	OpenBlockStreamCheck Hook
+	// optional hook to run before sending a single block. the dag.Info given
+	// to this check will only contain the requested block id
+	GetBlockCheck Hook
 	// optional check to run before executing a remove operation
 	// the dag.Info given to this check will only contain the root CID being
 	// removed
 	RemoveCheck Hook
+	// optional function HTTPRemoteHandler calls at the start of every request,
+	// before any session is created or DAG work is done. Use it to validate
+	// bearer tokens or request signatures. Requests that fail are rejected
+	// with a 401 status & the error message. Authenticate is transport-level,
+	// libp2p remotes rely on the host's peer authentication instead
+	Authenticate func(r *http.Request) error
 }
 
 // Validate confirms the configuration is valid
@@ -217,6 +622,24 @@ func (cfg *Config) Validate() error {
 	if cfg.PushFinalCheck == nil {
 		return fmt.Errorf("FinalCheck is required")
 	}
+	if (cfg.TransformReceivedBlock == nil) != (cfg.TransformServedBlock == nil) {
+		return fmt.Errorf("TransformReceivedBlock & TransformServedBlock must be set together")
+	}
+	if cfg.PushBlockRetries < 0 {
+		return fmt.Errorf("PushBlockRetries can't be negative")
+	}
+	if cfg.PushParallelism < 1 {
+		return fmt.Errorf("PushParallelism must be at least 1")
+	}
+	if cfg.MaxManifestNodes < 0 {
+		return fmt.Errorf("MaxManifestNodes can't be negative")
+	}
+	if cfg.SessionTTL < 0 {
+		return fmt.Errorf("SessionTTL can't be negative")
+	}
+	if cfg.CheckpointDir != "" && cfg.CheckpointInterval < 1 {
+		return fmt.Errorf("CheckpointInterval must be at least 1")
+	}
 	return nil
 }
 
@@ -236,6 +659,14 @@ func New(localNodes ipld.NodeGetter, blockStore coreiface.BlockAPI, opts ...func
 	cfg := &Config{
 		PushPreCheck:   DefaultDagPrecheck,
 		PushFinalCheck: DefaultDagFinalCheck,
+
+		PerBlockCompressionThreshold: DefaultPerBlockCompressionThreshold,
+		PushBlockRetries:             defaultPushBlockRetries,
+		PushRetryBackoff:             defaultPushRetryBackoff,
+		PushParallelism:              defaultPushParallelism,
+		MaxManifestNodes:             DefaultMaxManifestNodes,
+		SessionTTL:                   DefaultSessionTTL,
+		CheckpointInterval:           DefaultCheckpointInterval,
 	}
 
 	for _, opt := range opts {
@@ -250,19 +681,47 @@ func New(localNodes ipld.NodeGetter, blockStore coreiface.BlockAPI, opts ...func
 		lng:  localNodes,
 		bapi: blockStore,
 
-		requireAllBlocks: cfg.RequireAllBlocks,
-		allowRemoves:     cfg.AllowRemoves,
+		requireAllBlocks:   cfg.RequireAllBlocks,
+		allowRemoves:       cfg.AllowRemoves,
+		priority:           cfg.Priority,
+		blockPutTimeout:    cfg.BlockPutTimeout,
+		blockChunkSize:     cfg.BlockChunkSize,
+		attachManifestHash: cfg.AttachManifestHash,
+		deltaEncodeBlocks:  cfg.DeltaEncodeBlocks,
+		probeRemoteBlocks:  cfg.ProbeRemoteBlocks,
+		progressInterval:   cfg.ProgressInterval,
+		pushCodecs:         cfg.PushCodecs,
+		pullBatchSize:      cfg.PullBatchSize,
+		verifyNodeOrder:    cfg.VerifyNodeOrder,
+		isTransientErr:     cfg.IsTransientErr,
+		checkpointDir:      cfg.CheckpointDir,
+		checkpointInterval: cfg.CheckpointInterval,
+
+		perBlockCompression:          cfg.PerBlockCompression,
+		perBlockCompressionThreshold: cfg.PerBlockCompressionThreshold,
+		pushBlockRetries:             cfg.PushBlockRetries,
+		pushRetryBackoff:             cfg.PushRetryBackoff,
+		pushParallelism:              cfg.PushParallelism,
+		maxManifestNodes:             cfg.MaxManifestNodes,
 
 		preCheck:             cfg.PushPreCheck,
 		finalCheck:           cfg.PushFinalCheck,
 		onCompleteHook:       cfg.PushComplete,
+		onBlockReceived:      cfg.OnBlockReceived,
 		getDagInfoCheck:      cfg.GetDagInfoCheck,
 		openBlockStreamCheck: cfg.OpenBlockStreamCheck,
+		getBlockCheck:        cfg.GetBlockCheck,
 		removeCheck:          cfg.RemoveCheck,
+		authenticate:         cfg.Authenticate,
+
+		managedRoots: map[string]cid.Cid{},
+		pinNames:     map[string]string{},
+		partialRoots: map[string]cid.Cid{},
 
 		sessionPool:    map[string]*session{},
 		sessionCancels: map[string]context.CancelFunc{},
-		sessionTTLDur:  time.Hour * 5,
+		sessionTTLDur:  cfg.SessionTTL,
+		now:            time.Now,
 	}
 
 	if cfg.PinAPI != nil {
@@ -271,6 +730,36 @@ func New(localNodes ipld.NodeGetter, blockStore coreiface.BlockAPI, opts ...func
 	if cfg.InfoStore != nil {
 		ds.infoStore = cfg.InfoStore
 	}
+	if cfg.ManifestHashStore != nil {
+		ds.manifestHashStore = cfg.ManifestHashStore
+	}
+	if cfg.ManifestStore != nil {
+		ds.manifestStore = cfg.ManifestStore
+	}
+	if cfg.PinRegistry != nil {
+		pins, err := cfg.PinRegistry.Pins(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("reading pin registry: %w", err)
+		}
+		for _, pin := range pins {
+			ds.managedRoots[pin.Root.String()] = pin.Root
+			if pin.Name != "" {
+				ds.pinNames[pin.Root.String()] = pin.Name
+			}
+		}
+		ds.pinRegistry = cfg.PinRegistry
+	}
+	if cfg.TransformReceivedBlock != nil {
+		sink, ok := blockStore.(BlockSink)
+		if !ok {
+			return nil, fmt.Errorf("block transforms require a block store that implements BlockSink")
+		}
+		ds.store = &transformingStore{
+			sink:    sink,
+			receive: cfg.TransformReceivedBlock,
+			serve:   cfg.TransformServedBlock,
+		}
+	}
 
 	if cfg.HTTPRemoteAddress != "" {
 		m := http.NewServeMux()
@@ -362,7 +851,28 @@ func (ds *Dsync) NewPushInfo(info *dag.Info, remoteAddr string, pinOnComplete bo
 	if err != nil {
 		return nil, err
 	}
-	return NewPush(ds.lng, info, rem, pinOnComplete)
+	push, err := NewPush(ds.lng, info, rem, pinOnComplete)
+	if err != nil {
+		return nil, err
+	}
+	push.priority = ds.priority
+	push.chunkSize = ds.blockChunkSize
+	push.attachHash = ds.attachManifestHash
+	push.deltas = ds.deltaEncodeBlocks
+	push.probe = ds.probeRemoteBlocks
+	push.throttle = newUpdateThrottle(ds.progressInterval)
+	push.codecs = ds.pushCodecs
+	push.compress = ds.perBlockCompression
+	push.compressAbove = ds.perBlockCompressionThreshold
+	push.retries = ds.pushBlockRetries
+	push.retryBackoff = ds.pushRetryBackoff
+	if ds.pushParallelism > push.parallelism {
+		push.parallelism = ds.pushParallelism
+		if len(info.Manifest.Nodes) < push.parallelism {
+			push.parallelism = len(info.Manifest.Nodes)
+		}
+	}
+	return push, nil
 }
 
 // NewPull creates a pull. A pull fetches an entire DAG from a remote, placing
@@ -372,14 +882,65 @@ func (ds *Dsync) NewPull(cidStr, remoteAddr string, meta map[string]string) (*Pu
 	if err != nil {
 		return nil, err
 	}
-	return NewPull(cidStr, ds.lng, ds.bapi, rem, meta)
+	pull, err := NewPull(cidStr, ds.lng, ds.bapi, rem, meta)
+	if err != nil {
+		return nil, err
+	}
+	pull.throttle = newUpdateThrottle(ds.progressInterval)
+	pull.batchSize = ds.pullBatchSize
+	return pull, nil
+}
+
+// NewIncrementalPull creates a pull of the DAG at newRoot that only fetches
+// blocks not already present in the DAG at prevRoot, which must be local
+func (ds *Dsync) NewIncrementalPull(ctx context.Context, prevRoot, newRoot cid.Cid, remoteAddr string, meta map[string]string) (*Pull, error) {
+	rem, err := ds.syncableRemote(remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := ds.manifest(ctx, prevRoot)
+	if err != nil {
+		return nil, fmt.Errorf("building manifest for previous version: %w", err)
+	}
+	pull, err := newIncrementalPull(ctx, prev, newRoot, ds.lng, ds.bapi, rem, meta)
+	if err != nil {
+		return nil, err
+	}
+	pull.throttle = newUpdateThrottle(ds.progressInterval)
+	pull.batchSize = ds.pullBatchSize
+	return pull, nil
 }
 
 // NewReceiveSession takes a manifest sent by a remote and initiates a
 // transfer session. It returns a manifest/diff of the blocks the reciever needs
 // to have a complete DAG new sessions are created with a deadline for completion
 func (ds *Dsync) NewReceiveSession(info *dag.Info, pinOnComplete bool, meta map[string]string) (sid string, diff *dag.Manifest, err error) {
-	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(ds.sessionTTLDur))
+	if ds.maxManifestNodes > 0 && len(info.Manifest.Nodes) > ds.maxManifestNodes {
+		return "", nil, fmt.Errorf("%w: %d nodes exceeds the limit of %d", ErrManifestTooLarge, len(info.Manifest.Nodes), ds.maxManifestNodes)
+	}
+	if len(info.Manifest.Nodes) > 0 {
+		if complete, err := ds.receiveAlreadyComplete(context.Background(), info.Manifest.Nodes[0], info.Manifest, pinOnComplete, meta); err != nil {
+			return "", nil, err
+		} else if complete {
+			return "", &dag.Manifest{}, nil
+		}
+	}
+
+	if ds.verifyNodeOrder {
+		if err = info.Manifest.VerifyNodeOrder(); err != nil {
+			return
+		}
+	}
+
+	if hash, ok := meta[ManifestHashMetaKey]; ok {
+		if err = checkManifestHash(info.Manifest, hash); err != nil {
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	// hooks called for the session find it's id in the context
+	ctx = context.WithValue(ctx, sessionIDKey{}, randStringBytesMask(10))
 
 	if err = ds.preCheck(ctx, *info, meta); err != nil {
 		cancel()
@@ -398,13 +959,205 @@ func (ds *Dsync) NewReceiveSession(info *dag.Info, pinOnComplete bool, meta map[
 		cancel()
 		return
 	}
+	ds.addSession(ctx, cancel, sess)
+	return sess.id, sess.diff, nil
+}
+
+// ResumeSession rebuilds a receive session from a checkpoint saved to
+// CheckpointDir, keeping the session id the sender was given. Blocks the
+// checkpoint records as complete are re-checked against the local store in
+// case they've been removed since, and are otherwise left out of the returned
+// diff, so senders only need to send what's still missing. Resumed sessions
+// pass the push pre-check again
+func (ds *Dsync) ResumeSession(ctx context.Context, checkpointPath string) (sid string, diff *dag.Manifest, err error) {
+	cp, err := LoadSessionCheckpoint(checkpointPath)
+	if err != nil {
+		return "", nil, err
+	}
+	if ds.maxManifestNodes > 0 && len(cp.Info.Manifest.Nodes) > ds.maxManifestNodes {
+		return "", nil, fmt.Errorf("%w: %d nodes exceeds the limit of %d", ErrManifestTooLarge, len(cp.Info.Manifest.Nodes), ds.maxManifestNodes)
+	}
+	if cp.Pin && ds.pin == nil {
+		return "", nil, fmt.Errorf("remote doesn't support pinning")
+	}
+
+	ds.sessionLock.Lock()
+	_, open := ds.sessionPool[cp.SID]
+	ds.sessionLock.Unlock()
+	if open {
+		return "", nil, fmt.Errorf("sid %q is already open", cp.SID)
+	}
+
+	missing, err := reconcileCheckpoint(ctx, ds.lng, cp.Info.Manifest, cp.Completion)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sctx, cancel := context.WithCancel(context.Background())
+	sctx = context.WithValue(sctx, sessionIDKey{}, cp.SID)
+
+	if err = ds.preCheck(sctx, *cp.Info, cp.Meta); err != nil {
+		cancel()
+		return "", nil, err
+	}
+	log.Debugf("resuming receive session. sid=%q", cp.SID)
+
+	sess, err := newSession(sctx, ds.lng, ds.bapi, cp.Info, false, cp.Pin, cp.Meta)
+	if err != nil {
+		cancel()
+		return "", nil, err
+	}
+	sess.resume(missing)
+	ds.addSession(sctx, cancel, sess)
+	return sess.id, sess.diff, nil
+}
+
+// addSession configures sess with the instance's receive settings & opens it
+// for blocks. cancel ends the session
+func (ds *Dsync) addSession(ctx context.Context, cancel context.CancelFunc, sess *session) {
+	sess.putTimeout = ds.blockPutTimeout
+	sess.onBlock = ds.onBlockReceived
+	sess.store = ds.store
+	sess.isTransient = ds.isTransientErr
+	sess.throttle = newUpdateThrottle(ds.progressInterval)
+	sess.now = ds.now
+	sess.checkpointDir = ds.checkpointDir
+	sess.checkpointInterval = ds.checkpointInterval
+	sess.touch()
+	if sess.checkpointDir != "" {
+		if err := sess.saveCheckpoint(); err != nil {
+			log.Errorf("saving session checkpoint. sid=%q err=%q", sess.id, err)
+		}
+	}
 
 	ds.sessionLock.Lock()
 	defer ds.sessionLock.Unlock()
 	ds.sessionPool[sess.id] = sess
 	ds.sessionCancels[sess.id] = cancel
 
-	return sess.id, sess.diff, nil
+	// sessions that are cancelled or expire stop accepting blocks
+	go ds.expireSession(ctx, sess)
+}
+
+// expireSession removes sess once it's context is done, or it's been idle for
+// the session TTL
+func (ds *Dsync) expireSession(ctx context.Context, sess *session) {
+	if ds.sessionTTLDur == 0 {
+		<-ctx.Done()
+		ds.removeSession(sess.id)
+		return
+	}
+
+	t := time.NewTimer(ds.sessionTTLDur)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			ds.removeSession(sess.id)
+			return
+		case <-t.C:
+			idle := ds.now().Sub(sess.lastActive())
+			if idle >= ds.sessionTTLDur {
+				log.Debugf("receive session expired. sid=%q idle=%s", sess.id, idle)
+				ds.removeSession(sess.id)
+				return
+			}
+			t.Reset(ds.sessionTTLDur - idle)
+		}
+	}
+}
+
+// session returns the open receive session for sid, counting the lookup as
+// session activity. Sessions with a done context or that have been idle for
+// the session TTL are closed, and removed when looked up
+func (ds *Dsync) session(sid string) (*session, error) {
+	ds.sessionLock.Lock()
+	defer ds.sessionLock.Unlock()
+	sess, ok := ds.sessionPool[sid]
+	if !ok {
+		return nil, fmt.Errorf("sid %q not found", sid)
+	}
+	if err := sess.ctx.Err(); err != nil {
+		ds.removeSessionLocked(sid)
+		return nil, fmt.Errorf("sid %q closed: %w", sid, err)
+	}
+	if ds.sessionTTLDur > 0 && ds.now().Sub(sess.lastActive()) >= ds.sessionTTLDur {
+		ds.removeSessionLocked(sid)
+		return nil, fmt.Errorf("sid %q expired", sid)
+	}
+	sess.touch()
+	return sess, nil
+}
+
+// removeSession cancels, closes & forgets the receive session for sid
+func (ds *Dsync) removeSession(sid string) {
+	ds.sessionLock.Lock()
+	defer ds.sessionLock.Unlock()
+	ds.removeSessionLocked(sid)
+}
+
+func (ds *Dsync) removeSessionLocked(sid string) {
+	if cancel, ok := ds.sessionCancels[sid]; ok {
+		cancel()
+	}
+	if sess, ok := ds.sessionPool[sid]; ok {
+		sess.close()
+	}
+	delete(ds.sessionPool, sid)
+	delete(ds.sessionCancels, sid)
+}
+
+// receiveAlreadyComplete checks if a push is of a DAG this instance already
+// has, by comparing the root & manifest hash the sender attached against the
+// manifest of the local DAG. The claimed hash is never trusted on it's own:
+// mfst is checked against it when given, and DAGs that aren't pinned must
+// have every block stored locally. Pushes that ask to pin a DAG this instance
+// doesn't manage are never complete, so the normal session pins them. mfst
+// is nil when callers check before decoding the full manifest. Pushes of
+// complete DAGs still need to pass the push pre-check
+func (ds *Dsync) receiveAlreadyComplete(ctx context.Context, root string, mfst *dag.Manifest, pinOnComplete bool, meta map[string]string) (bool, error) {
+	hash, ok := meta[ManifestHashMetaKey]
+	if !ok || ds.manifestHashStore == nil || root == "" {
+		return false, nil
+	}
+	rootID, err := cid.Parse(root)
+	if err != nil {
+		return false, nil
+	}
+
+	ds.managedLock.Lock()
+	_, managed := ds.managedRoots[root]
+	ds.managedLock.Unlock()
+	if pinOnComplete && !managed {
+		return false, nil
+	}
+	if stored, err := ds.manifestHashStore.ManifestHash(ctx, root); err == nil && stored != hash {
+		return false, nil
+	}
+	if mfst != nil && checkManifestHash(mfst, hash) != nil {
+		return false, nil
+	}
+
+	local, err := ds.manifest(ctx, rootID)
+	if err != nil || checkManifestHash(local, hash) != nil {
+		return false, nil
+	}
+	if !managed {
+		missing, err := dag.Missing(ctx, ds.lng, local)
+		if err != nil || len(missing.Nodes) > 0 {
+			return false, nil
+		}
+	}
+
+	info := dag.Info{Manifest: mfst}
+	if mfst == nil {
+		info.Manifest = &dag.Manifest{Nodes: []string{root}}
+	}
+	if err := ds.preCheck(ctx, info, meta); err != nil {
+		return false, err
+	}
+	log.Debugf("push of %s is already complete", root)
+	return true, nil
 }
 
 // ReceiveBlock adds one block to the local node that was sent by the remote
@@ -412,12 +1165,12 @@ func (ds *Dsync) NewReceiveSession(info *dag.Info, pinOnComplete bool, meta map[
 // When the DAG is complete, it puts the manifest into a DAG info and the
 // DAG info into an infoStore
 func (ds *Dsync) ReceiveBlock(sid, hash string, data []byte) ReceiveResponse {
-	sess, ok := ds.sessionPool[sid]
-	if !ok {
+	sess, err := ds.session(sid)
+	if err != nil {
 		return ReceiveResponse{
 			Hash:   hash,
 			Status: StatusErrored,
-			Err:    fmt.Errorf("sid %q not found", sid),
+			Err:    err,
 		}
 	}
 
@@ -438,12 +1191,137 @@ func (ds *Dsync) ReceiveBlock(sid, hash string, data []byte) ReceiveResponse {
 	return res
 }
 
+// ReceiveBlockPart adds part of a block to the local node that was sent by
+// the remote. The block is written once the final part arrives
+func (ds *Dsync) ReceiveBlockPart(sid, hash string, offset, size int, data []byte) ReceiveResponse {
+	return ds.receiveInSession(sid, hash, func(sess *session) ReceiveResponse {
+		return sess.ReceiveBlockPart(hash, offset, size, data)
+	})
+}
+
+// ReceiveBlockDelta adds a block to the local node that was sent by the remote
+// as a delta against a base block the local node already has
+func (ds *Dsync) ReceiveBlockDelta(sid, hash, baseHash string, delta []byte) ReceiveResponse {
+	return ds.receiveInSession(sid, hash, func(sess *session) ReceiveResponse {
+		return sess.ReceiveBlockDelta(hash, baseHash, delta)
+	})
+}
+
+// ProbeBlocks reports which ids requested by a receive session are already in
+// the local block store, marking them complete in the session. Probing checks
+// the block store directly, so blocks are only reported if they're local.
+// Probes with an empty sid check blocks without a session, and are screened
+// by the GetBlockCheck hook if one is configured
+func (ds *Dsync) ProbeBlocks(ctx context.Context, sid string, ids []string) (have []string, err error) {
+	if sid == "" {
+		if ds.getBlockCheck != nil {
+			info := dag.Info{Manifest: &dag.Manifest{Nodes: ids}}
+			if err := ds.getBlockCheck(ctx, info, nil); err != nil {
+				return nil, err
+			}
+		}
+		return ds.localBlocks(ctx, ids), nil
+	}
+
+	sess, err := ds.session(sid)
+	if err != nil {
+		return nil, err
+	}
+
+	have = ds.localBlocks(ctx, ids)
+	sess.blocksPresent(have)
+
+	// probing may complete a session, finalize if so, but only once
+	if sess.IsFinalizedOnce() {
+		if err := ds.finalizeReceive(sess); err != nil {
+			return nil, err
+		}
+	}
+	return have, nil
+}
+
+// SessionProgress returns the completion of an open receive session. Sessions
+// are closed when they complete, or expire
+func (ds *Dsync) SessionProgress(ctx context.Context, sid string) (dag.Completion, error) {
+	sess, err := ds.session(sid)
+	if err != nil {
+		return nil, err
+	}
+	return append(dag.Completion{}, sess.prog...), nil
+}
+
+// SessionBytes returns the number of bytes an open receive session expects
+// to receive & the number it's still waiting on, using the block sizes the
+// pushed info declares. Partially received blocks count the part left to
+// receive. Sessions opened with infos that don't declare sizes, like those of
+// clients that push a bare manifest, error with ErrSizesUnknown
+func (ds *Dsync) SessionBytes(ctx context.Context, sid string) (expected, remaining uint64, err error) {
+	sess, err := ds.session(sid)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !sess.sized() {
+		return 0, 0, fmt.Errorf("%w: session %q", ErrSizesUnknown, sid)
+	}
+	prog := append(dag.Completion{}, sess.prog...)
+	for i, size := range sess.info.Sizes {
+		if prog[i] < 100 {
+			remaining += size * uint64(100-prog[i]) / 100
+		}
+	}
+	return sess.expectedBytes, remaining, nil
+}
+
+// localBlocks returns the subset of ids in the local block store
+func (ds *Dsync) localBlocks(ctx context.Context, ids []string) (have []string) {
+	for _, id := range ids {
+		if ds.store != nil {
+			if c, err := cid.Parse(id); err == nil {
+				if ok, err := ds.store.Has(ctx, c); err == nil && ok {
+					have = append(have, id)
+				}
+			}
+		} else if _, err := ds.bapi.Stat(ctx, path.New(id)); err == nil {
+			have = append(have, id)
+		}
+	}
+	return have
+}
+
+// receiveInSession calls receive with the session for sid, finalizing the
+// session if receive completes it
+func (ds *Dsync) receiveInSession(sid, hash string, receive func(sess *session) ReceiveResponse) ReceiveResponse {
+	sess, err := ds.session(sid)
+	if err != nil {
+		return ReceiveResponse{
+			Hash:   hash,
+			Status: StatusErrored,
+			Err:    err,
+		}
+	}
+
+	res := receive(sess)
+
+	// check if transfer has completed, if so finalize it, but only once
+	if res.Status == StatusOk && sess.IsFinalizedOnce() {
+		if err := ds.finalizeReceive(sess); err != nil {
+			return ReceiveResponse{
+				Hash:   sess.info.RootCID().String(),
+				Status: StatusErrored,
+				Err:    err,
+			}
+		}
+	}
+
+	return res
+}
+
 // ReceiveBlocks ingests blocks being pushed into the local store
 func (ds *Dsync) ReceiveBlocks(ctx context.Context, sid string, r io.Reader) error {
-	sess, ok := ds.sessionPool[sid]
-	if !ok {
+	sess, err := ds.session(sid)
+	if err != nil {
 		log.Debugf("couldn't find session. sid=%q", sid)
-		return fmt.Errorf("sid %q not found", sid)
+		return err
 	}
 
 	if err := sess.ReceiveBlocks(ctx, r); err != nil {
@@ -476,18 +1354,45 @@ func (ds *Dsync) finalizeReceive(sess *session) error {
 		}
 	}
 
-	if sess.pin {
+	// partial DAGs are missing blocks their manifest lists
+	if ds.manifestStore != nil && !sess.partial {
+		if err := ds.manifestStore.PutManifest(sess.ctx, sess.info.Manifest.Nodes[0], sess.info.Manifest); err != nil {
+			return err
+		}
+	}
+
+	if hash, ok := sess.meta[ManifestHashMetaKey]; ok && ds.manifestHashStore != nil {
+		if err := ds.manifestHashStore.PutManifestHash(sess.ctx, sess.info.Manifest.Nodes[0], hash); err != nil {
+			return err
+		}
+	}
+
+	if sess.partial {
+		// partial DAGs can't be pinned, pinning requires every block
+		ds.managedLock.Lock()
+		ds.partialRoots[sess.info.Manifest.Nodes[0]] = sess.info.RootCID()
+		ds.managedLock.Unlock()
+	} else if sess.pin {
 		if err := ds.pin.Add(sess.ctx, path.New(sess.info.Manifest.Nodes[0])); err != nil {
 			return err
 		}
+		if ds.pinRegistry != nil {
+			pin := ManagedPin{Root: sess.info.RootCID(), Name: sess.meta[PinNameMetaKey]}
+			if err := ds.pinRegistry.PutPin(sess.ctx, pin); err != nil {
+				return err
+			}
+		}
+		ds.managedLock.Lock()
+		ds.managedRoots[sess.info.Manifest.Nodes[0]] = sess.info.RootCID()
+		if name, ok := sess.meta[PinNameMetaKey]; ok {
+			ds.pinNames[sess.info.Manifest.Nodes[0]] = name
+		}
+		delete(ds.partialRoots, sess.info.Manifest.Nodes[0])
+		ds.managedLock.Unlock()
 	}
 
-	defer func() {
-		ds.sessionLock.Lock()
-		ds.sessionCancels[sess.id]()
-		delete(ds.sessionPool, sess.id)
-		ds.sessionLock.Unlock()
-	}()
+	sess.removeCheckpoint()
+	defer ds.removeSession(sess.id)
 
 	if ds.onCompleteHook != nil {
 		if err := ds.onCompleteHook(sess.ctx, *sess.info, sess.meta); err != nil {
@@ -499,6 +1404,42 @@ func (ds *Dsync) finalizeReceive(sess *session) error {
 	return nil
 }
 
+// VerifyManifestHash re-derives the manifest of a DAG from local blocks and
+// compares it's hash with the hash stored when the DAG was received, returning
+// an error wrapping ErrManifestHashMismatch if they differ. Blocks that have
+// been lost or corrupted since the push will fail to re-derive or produce a
+// different hash. VerifyManifestHash requires a ManifestHashStore
+func (ds *Dsync) VerifyManifestHash(ctx context.Context, rootID string) error {
+	if ds.manifestHashStore == nil {
+		return fmt.Errorf("remote doesn't store manifest hashes")
+	}
+	hash, err := ds.manifestHashStore.ManifestHash(ctx, rootID)
+	if err != nil {
+		return err
+	}
+
+	id, err := cid.Parse(rootID)
+	if err != nil {
+		return err
+	}
+	mfst, err := dag.NewManifest(ctx, ds.lng, id)
+	if err != nil {
+		return err
+	}
+	return checkManifestHash(mfst, hash)
+}
+
+func checkManifestHash(m *dag.Manifest, expect string) error {
+	got, err := m.Hash()
+	if err != nil {
+		return err
+	}
+	if got != expect {
+		return fmt.Errorf("%w. expected: %q, got: %q", ErrManifestHashMismatch, expect, got)
+	}
+	return nil
+}
+
 // GetDagInfo gets the manifest for a DAG rooted at id, checking any configured cache before falling back to generating a new manifest
 func (ds *Dsync) GetDagInfo(ctx context.Context, hash string, meta map[string]string) (info *dag.Info, err error) {
 	// check cache if one is specified
@@ -514,9 +1455,13 @@ func (ds *Dsync) GetDagInfo(ctx context.Context, hash string, meta map[string]st
 		return nil, err
 	}
 
-	info, err = dag.NewInfo(ctx, ds.lng, id)
-	if err != nil {
-		return nil, err
+	if mfst, ok := ds.storedManifest(ctx, hash); ok {
+		info = &dag.Info{Manifest: mfst}
+	} else {
+		if info, err = dag.NewInfo(ctx, ds.lng, id); err != nil {
+			return nil, err
+		}
+		ds.storeManifest(ctx, hash, info.Manifest)
 	}
 
 	if ds.getDagInfoCheck != nil {
@@ -528,8 +1473,70 @@ func (ds *Dsync) GetDagInfo(ctx context.Context, hash string, meta map[string]st
 	return info, nil
 }
 
+// manifest returns the manifest of the local DAG rooted at id, checking any
+// configured manifest store before deriving it from blocks
+func (ds *Dsync) manifest(ctx context.Context, id cid.Cid) (*dag.Manifest, error) {
+	if mfst, ok := ds.storedManifest(ctx, id.String()); ok {
+		return mfst, nil
+	}
+	mfst, err := dag.NewManifest(ctx, ds.lng, id)
+	if err != nil {
+		return nil, err
+	}
+	ds.storeManifest(ctx, id.String(), mfst)
+	return mfst, nil
+}
+
+// storedManifest gets the manifest for root from the manifest store if one is
+// configured. Store errors are treated as a miss
+func (ds *Dsync) storedManifest(ctx context.Context, root string) (*dag.Manifest, bool) {
+	if ds.manifestStore == nil {
+		return nil, false
+	}
+	mfst, err := ds.manifestStore.Manifest(ctx, root)
+	if err != nil {
+		if !errors.Is(err, dag.ErrManifestNotFound) {
+			log.Debugf("reading stored manifest. root=%q err=%q", root, err)
+		}
+		return nil, false
+	}
+	return mfst, true
+}
+
+// storeManifest caches a derived manifest in the manifest store if one is
+// configured. Failing to cache a manifest doesn't fail the caller
+func (ds *Dsync) storeManifest(ctx context.Context, root string, mfst *dag.Manifest) {
+	if ds.manifestStore == nil {
+		return
+	}
+	if err := ds.manifestStore.PutManifest(ctx, root, mfst); err != nil {
+		log.Debugf("storing manifest. root=%q err=%q", root, err)
+	}
+}
+
 // GetBlock returns a single block from the store
 func (ds *Dsync) GetBlock(ctx context.Context, hash string) ([]byte, error) {
+	return ds.getBlock(ctx, hash, nil)
+}
+
+// getBlock returns a single block from the store, running any configured
+// check first with the given request metadata
+func (ds *Dsync) getBlock(ctx context.Context, hash string, meta map[string]string) ([]byte, error) {
+	if ds.getBlockCheck != nil {
+		info := dag.Info{Manifest: &dag.Manifest{Nodes: []string{hash}}}
+		if err := ds.getBlockCheck(ctx, info, meta); err != nil {
+			return nil, err
+		}
+	}
+
+	if ds.store != nil {
+		id, err := cid.Parse(hash)
+		if err != nil {
+			return nil, err
+		}
+		return ds.store.get(ctx, id)
+	}
+
 	rdr, err := ds.bapi.Get(ctx, path.New(hash))
 	if err != nil {
 		return nil, err
@@ -546,6 +1553,10 @@ func (ds *Dsync) OpenBlockStream(ctx context.Context, info *dag.Info, meta map[s
 		}
 	}
 
+	if ds.store != nil {
+		return newGetterCARStream(ctx, info.Manifest, ds.store.get)
+	}
+
 	rdr, err := NewManifestCARReader(ctx, ds.lng, info.Manifest, nil)
 	if err != nil {
 		return nil, err
@@ -569,9 +1580,120 @@ func (ds *Dsync) RemoveCID(ctx context.Context, cidStr string, meta map[string]s
 		}
 	}
 
+	ds.managedLock.Lock()
+	_, partial := ds.partialRoots[cidStr]
+	delete(ds.partialRoots, cidStr)
+	ds.managedLock.Unlock()
+	if partial {
+		// partial DAGs are never pinned
+		return nil
+	}
+
 	if ds.pin != nil {
-		return ds.pin.Rm(ctx, path.New(cidStr))
+		if err := ds.pin.Rm(ctx, path.New(cidStr)); err != nil {
+			return err
+		}
+		if ds.pinRegistry != nil {
+			id, err := cid.Decode(cidStr)
+			if err != nil {
+				return err
+			}
+			if err := ds.pinRegistry.RemovePin(ctx, id); err != nil {
+				return err
+			}
+		}
+		ds.managedLock.Lock()
+		delete(ds.managedRoots, cidStr)
+		delete(ds.pinNames, cidStr)
+		ds.managedLock.Unlock()
 	}
 
 	return nil
 }
+
+// RemoveCIDs removes each of cids like RemoveCID, running the RemoveCheck hook
+// for every cid. Removes are best-effort: a cid that fails doesn't stop the
+// rest from being removed. Failures are reported in the results, the returned
+// error is only set when removes aren't allowed
+func (ds *Dsync) RemoveCIDs(ctx context.Context, cids []string, meta map[string]string) (*RemoveResults, error) {
+	if !ds.allowRemoves {
+		return nil, ErrRemoveNotSupported
+	}
+
+	res := &RemoveResults{Removed: []string{}}
+	for _, id := range cids {
+		if err := ds.RemoveCID(ctx, id, meta); err != nil {
+			if res.Failed == nil {
+				res.Failed = map[string]string{}
+			}
+			res.Failed[id] = err.Error()
+			continue
+		}
+		res.Removed = append(res.Removed, id)
+	}
+	return res, nil
+}
+
+// ManagedRoots lists the root CIDs of DAGs this dsync instance has pinned on
+// completion of a push, in CID string order. Roots are dropped when removed
+// with RemoveCID. This distinguishes dsync-managed content from other pins on
+// the node. Without a PinRegistry it only covers pins created over the
+// lifetime of this instance
+func (ds *Dsync) ManagedRoots() []cid.Cid {
+	ds.managedLock.Lock()
+	defer ds.managedLock.Unlock()
+
+	ids := make([]string, 0, len(ds.managedRoots))
+	for id := range ds.managedRoots {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	roots := make([]cid.Cid, len(ids))
+	for i, id := range ids {
+		roots[i] = ds.managedRoots[id]
+	}
+	return roots
+}
+
+// ManagedPin is a root pinned by dsync, with the name it was pushed with
+type ManagedPin struct {
+	Root cid.Cid `json:"root"`
+	// Name is the value of PinNameMetaKey sent with the push that pinned root,
+	// empty if the push didn't name it
+	Name string `json:"name,omitempty"`
+}
+
+// ManagedPins lists the roots ManagedRoots reports along with their names
+func (ds *Dsync) ManagedPins() []ManagedPin {
+	roots := ds.ManagedRoots()
+
+	ds.managedLock.Lock()
+	defer ds.managedLock.Unlock()
+	pins := make([]ManagedPin, len(roots))
+	for i, root := range roots {
+		pins[i] = ManagedPin{Root: root, Name: ds.pinNames[root.String()]}
+	}
+	return pins
+}
+
+// PartialRoots lists the root CIDs of partial DAGs this dsync instance has
+// received from pushes limited to some codecs, in CID string order. Partial
+// DAGs have dangling links to blocks that weren't sent. Roots are dropped
+// when the complete DAG is pushed & pinned, or when removed with RemoveCID
+func (ds *Dsync) PartialRoots() []cid.Cid {
+	ds.managedLock.Lock()
+	defer ds.managedLock.Unlock()
+
+	ids := make([]string, 0, len(ds.partialRoots))
+	for id := range ds.partialRoots {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	roots := make([]cid.Cid, len(ids))
+	for i, id := range ids {
+		roots[i] = ds.partialRoots[id]
+	}
+	return roots
+}
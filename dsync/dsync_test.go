@@ -2,10 +2,15 @@ package dsync
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http/httptest"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	files "github.com/ipfs/go-ipfs-files"
@@ -176,3 +181,376 @@ func addOneBlockDAG(node coreiface.CoreAPI, t *testing.T) cid.Cid {
 	}
 	return path.Cid()
 }
+
+func TestNewReceiveSessionVerifyNodeOrder(t *testing.T) {
+	_, _, info := newTestDAG(t, "order", 2, 2)
+
+	ds, _ := newTestRemote(t, func(cfg *Config) {
+		cfg.VerifyNodeOrder = true
+	})
+
+	if _, _, err := ds.NewReceiveSession(info, false, nil); err != nil {
+		t.Errorf("expected well-ordered manifest to be accepted. got: %s", err)
+	}
+
+	// move the last node to the front without touching links
+	nodes := info.Manifest.Nodes
+	misordered := &dag.Info{Manifest: &dag.Manifest{
+		Nodes: append([]string{nodes[len(nodes)-1]}, nodes[:len(nodes)-1]...),
+		Links: info.Manifest.Links,
+	}}
+	if _, _, err := ds.NewReceiveSession(misordered, false, nil); !errors.Is(err, dag.ErrInvalidNodeOrder) {
+		t.Errorf("expected misordered manifest to be rejected with ErrInvalidNodeOrder. got: %v", err)
+	}
+}
+
+func TestManagedRoots(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local := newMemStore()
+	a := addTestDAG(t, local, "managed_a", 2, 1)
+	b := addTestDAG(t, local, "managed_b", 2, 1)
+
+	pins := newMemPinAPI()
+	// a pin dsync doesn't manage
+	other := addTestDAG(t, newMemStore(), "other", 1, 0)
+	if err := pins.Add(ctx, path.IpfsPath(other.Cid())); err != nil {
+		t.Fatal(err)
+	}
+
+	rem, _ := newTestRemote(t, func(cfg *Config) {
+		cfg.PinAPI = pins
+		cfg.AllowRemoves = true
+	})
+	s := httptest.NewServer(HTTPRemoteHandler(rem))
+	defer s.Close()
+
+	ds, err := New(local.nodeGetter(), local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, root := range []cid.Cid{a.Cid(), b.Cid()} {
+		info, err := dag.NewInfo(ctx, local.nodeGetter(), root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		push, err := ds.NewPushInfo(info, s.URL, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := push.Do(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect := []string{a.Cid().String(), b.Cid().String()}
+	sort.Strings(expect)
+	got := []string{}
+	for _, id := range rem.ManagedRoots() {
+		got = append(got, id.String())
+	}
+	if fmt.Sprintf("%v", expect) != fmt.Sprintf("%v", got) {
+		t.Errorf("managed roots mismatch. expected: %v, got: %v", expect, got)
+	}
+
+	if err := rem.RemoveCID(ctx, a.Cid().String(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if roots := rem.ManagedRoots(); len(roots) != 1 || !roots[0].Equals(b.Cid()) {
+		t.Errorf("expected only %s to be managed after removing %s. got: %v", b.Cid(), a.Cid(), roots)
+	}
+}
+
+func TestManagedPinNames(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local := newMemStore()
+	named := addTestDAG(t, local, "named", 2, 1)
+	unnamed := addTestDAG(t, local, "unnamed", 2, 1)
+
+	rem, _ := newTestRemote(t, func(cfg *Config) {
+		cfg.PinAPI = newMemPinAPI()
+		cfg.AllowRemoves = true
+	})
+	s := httptest.NewServer(HTTPRemoteHandler(rem))
+	defer s.Close()
+
+	ds, err := New(local.nodeGetter(), local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]string{named.Cid().String(): "customer-data-2024"}
+	for _, root := range []cid.Cid{named.Cid(), unnamed.Cid()} {
+		info, err := dag.NewInfo(ctx, local.nodeGetter(), root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		push, err := ds.NewPushInfo(info, s.URL, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name, ok := names[root.String()]; ok {
+			push.SetMeta(map[string]string{PinNameMetaKey: name})
+		}
+		if err := push.Do(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pins := rem.ManagedPins()
+	if len(pins) != 2 {
+		t.Fatalf("expected 2 managed pins. got: %d", len(pins))
+	}
+	for _, pin := range pins {
+		if expect := names[pin.Root.String()]; pin.Name != expect {
+			t.Errorf("pin %s name mismatch. expected: %q, got: %q", pin.Root, expect, pin.Name)
+		}
+	}
+
+	if err := rem.RemoveCID(ctx, named.Cid().String(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if pins := rem.ManagedPins(); len(pins) != 1 || pins[0].Name != "" {
+		t.Errorf("expected only the unnamed pin after removing the named one. got: %v", pins)
+	}
+}
+
+func TestManifestStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local := newMemStore()
+	a := addTestDAG(t, local, "manifest store a", 2, 1)
+	b := addTestDAG(t, local, "manifest store b", 2, 1)
+	info, err := dag.NewInfo(ctx, local.nodeGetter(), a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := dag.NewMemManifestStore()
+	rem, _ := newTestRemote(t, func(cfg *Config) {
+		cfg.ManifestStore = store
+	})
+
+	// received DAGs are stored
+	push, err := NewPush(local.nodeGetter(), info, rem, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := push.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Manifest(ctx, a.Cid().String())
+	if err != nil {
+		t.Fatalf("expected received manifest to be stored: %s", err)
+	}
+	if !info.Manifest.Equal(stored) {
+		t.Errorf("stored manifest mismatch.\nexpected: %v\ngot:      %v", info.Manifest, stored)
+	}
+
+	// stored manifests are used in place of deriving them from blocks
+	fake := &dag.Manifest{Nodes: []string{b.Cid().String()}}
+	if err := store.PutManifest(ctx, b.Cid().String(), fake); err != nil {
+		t.Fatal(err)
+	}
+	got, err := rem.GetDagInfo(ctx, b.Cid().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fake.Equal(got.Manifest) {
+		t.Errorf("expected GetDagInfo to use the stored manifest. got: %v", got.Manifest)
+	}
+
+	// derived manifests are stored
+	ds, err := New(local.nodeGetter(), local, func(cfg *Config) {
+		cfg.ManifestStore = dag.NewMemManifestStore()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.GetDagInfo(ctx, a.Cid().String(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.manifestStore.Manifest(ctx, a.Cid().String()); err != nil {
+		t.Errorf("expected derived manifest to be stored: %s", err)
+	}
+}
+
+func TestSessionIDFromContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, _, info := newTestDAG(t, "sid", 2, 1)
+
+	var (
+		lock sync.Mutex
+		sids = map[string]string{}
+	)
+	record := func(hook string) Hook {
+		return func(ctx context.Context, _ dag.Info, _ map[string]string) error {
+			lock.Lock()
+			defer lock.Unlock()
+			sids[hook] = SessionIDFromContext(ctx)
+			return nil
+		}
+	}
+
+	remote := newMemStore()
+	rem, err := New(remote.nodeGetter(), remote, func(cfg *Config) {
+		cfg.PushPreCheck = record("pre")
+		cfg.PushFinalCheck = record("final")
+		cfg.PushComplete = record("complete")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	push, err := NewPush(local.nodeGetter(), info, rem, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := push.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if push.sid == "" {
+		t.Fatal("expected push to have a session id")
+	}
+	for _, hook := range []string{"pre", "final", "complete"} {
+		if sids[hook] != push.sid {
+			t.Errorf("%s hook: expected session id %q. got: %q", hook, push.sid, sids[hook])
+		}
+	}
+	if sid := SessionIDFromContext(ctx); sid != "" {
+		t.Errorf("expected no session id outside a session. got: %q", sid)
+	}
+}
+
+func TestCancelledSessionClosed(t *testing.T) {
+	_, root, info := newTestDAG(t, "cancelled session", 2, 1)
+
+	rem, remote := newTestRemote(t)
+	sid, _, err := rem.NewReceiveSession(info, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rem.sessionLock.Lock()
+	rem.sessionCancels[sid]()
+	rem.sessionLock.Unlock()
+
+	res := rem.ReceiveBlock(sid, root.Cid().String(), root.RawData())
+	if res.Status != StatusErrored {
+		t.Errorf("expected cancelled session to refuse blocks, got status: %s", res.Status)
+	}
+	if remote.has(root.Cid()) {
+		t.Errorf("expected block sent to a cancelled session not to be stored")
+	}
+	rem.sessionLock.Lock()
+	_, open := rem.sessionPool[sid]
+	_, cancelable := rem.sessionCancels[sid]
+	rem.sessionLock.Unlock()
+	if open || cancelable {
+		t.Errorf("expected cancelled session to be removed")
+	}
+}
+
+func TestSessionTTL(t *testing.T) {
+	ctx := context.Background()
+	local, root, info := newTestDAG(t, "ttl", 2, 1)
+
+	var (
+		lock      sync.Mutex
+		clock     = time.Now()
+		completed = 0
+	)
+	advance := func(d time.Duration) {
+		lock.Lock()
+		defer lock.Unlock()
+		clock = clock.Add(d)
+	}
+
+	rem, _ := newTestRemote(t, func(cfg *Config) {
+		cfg.PushComplete = func(context.Context, dag.Info, map[string]string) error {
+			completed++
+			return nil
+		}
+		cfg.SessionTTL = time.Hour
+	})
+	rem.now = func() time.Time {
+		lock.Lock()
+		defer lock.Unlock()
+		return clock
+	}
+
+	sid, _, err := rem.NewReceiveSession(info, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, err := rem.session(sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// receiving a block resets the session timer
+	advance(time.Minute * 50)
+	leaf, err := cid.Parse(info.Manifest.Nodes[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := local.rawData(leaf)
+	if res := rem.ReceiveBlock(sid, leaf.String(), data); res.Status != StatusOk {
+		t.Fatalf("expected block to be received, got: %s %v", res.Status, res.Err)
+	}
+	advance(time.Minute * 50)
+	if _, err := rem.SessionProgress(ctx, sid); err != nil {
+		t.Errorf("expected session that received a block within the TTL to be open. error: %s", err)
+	}
+
+	advance(time.Minute * 61)
+	if _, err := rem.SessionProgress(ctx, sid); err == nil {
+		t.Errorf("expected session idle past the TTL not to be resolvable by it's sid")
+	}
+	if res := rem.ReceiveBlock(sid, root.Cid().String(), root.RawData()); res.Status != StatusErrored {
+		t.Errorf("expected expired session to refuse blocks, got status: %s", res.Status)
+	}
+	rem.sessionLock.Lock()
+	_, open := rem.sessionPool[sid]
+	rem.sessionLock.Unlock()
+	if open {
+		t.Errorf("expected expired session to be removed")
+	}
+	for range sess.progCh {
+	}
+	if sess.ctx.Err() == nil {
+		t.Errorf("expected expired session's context to be cancelled")
+	}
+	if completed != 0 {
+		t.Errorf("expected expired session not to run completion hooks, ran %d", completed)
+	}
+}
+
+func TestSessionTTLCollectsIdleSessions(t *testing.T) {
+	_, _, info := newTestDAG(t, "idle", 2, 1)
+
+	rem, _ := newTestRemote(t, func(cfg *Config) {
+		cfg.SessionTTL = time.Millisecond * 20
+	})
+	if _, _, err := rem.NewReceiveSession(info, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// sessions are collected without being looked up
+	for i := 0; i < 50; i++ {
+		rem.sessionLock.Lock()
+		open := len(rem.sessionPool)
+		rem.sessionLock.Unlock()
+		if open == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Errorf("expected idle session to be collected")
+}
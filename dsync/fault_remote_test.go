@@ -0,0 +1,271 @@
+package dsync
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qri-io/dag"
+)
+
+// faultyRemote wraps a DagSyncable, injecting faults into block transfers to
+// simulate an unreliable network. Faults are drawn from a seeded source, so
+// runs are repeatable for a given sequence of calls. faultyRemote doesn't
+// implement DagStreamable, transfers through it always go block-by-block,
+// which makes a faultyRemote with no faults a plain block-by-block remote
+type faultyRemote struct {
+	DagSyncable
+	// fraction of blocks to drop, between 0 and 1. dropped pushes are
+	// reported as retryable, dropped pulls as errors
+	dropRate float64
+	// fraction of blocks to corrupt by flipping a byte, between 0 and 1
+	corruptRate float64
+	// blocks are delayed at least delay, plus a random duration up to
+	// maxDelay, which reorders concurrent transfers
+	delay    time.Duration
+	maxDelay time.Duration
+	// when above zero the remote accepts outageAfter pushed blocks, then
+	// errors on every block it's sent until restored
+	outageAfter int
+	// children of each block, if set blocks pushed before all of their
+	// children are received are recorded as early
+	children map[string][]string
+
+	lock        sync.Mutex
+	rand        *rand.Rand
+	dropped     int
+	corrupted   int
+	down        bool
+	received    []string
+	early       []string
+	inFlight    int
+	maxInFlight int
+}
+
+var _ DagSyncable = (*faultyRemote)(nil)
+
+func newFaultyRemote(rem DagSyncable, seed int64) *faultyRemote {
+	return &faultyRemote{DagSyncable: rem, rand: rand.New(rand.NewSource(seed))}
+}
+
+// checkOrder makes the remote record blocks of m pushed before their children
+func (f *faultyRemote) checkOrder(m *dag.Manifest) {
+	f.children = map[string][]string{}
+	for _, l := range m.Links {
+		f.children[m.Nodes[l[0]]] = append(f.children[m.Nodes[l[0]]], m.Nodes[l[1]])
+	}
+}
+
+// errDropped is the error for a block the faultyRemote dropped
+var errDropped = errors.New("block dropped")
+
+// ReceiveBlock forwards a block to the wrapped remote, possibly after delaying,
+// dropping or corrupting it
+func (f *faultyRemote) ReceiveBlock(sid, hash string, data []byte) ReceiveResponse {
+	if !f.arrive(hash) {
+		return ReceiveResponse{Hash: hash, Status: StatusErrored, Err: errors.New("remote unavailable")}
+	}
+	drop, corrupt, delay := f.fault()
+	time.Sleep(delay)
+	if drop {
+		f.depart(hash, false)
+		return ReceiveResponse{Hash: hash, Status: StatusRetry, Err: errDropped}
+	}
+	if corrupt {
+		data = corrupted(data)
+	}
+	res := f.DagSyncable.ReceiveBlock(sid, hash, data)
+	f.depart(hash, res.Status == StatusOk)
+	return res
+}
+
+// GetBlock fetches a block from the wrapped remote, possibly after delaying,
+// dropping or corrupting it
+func (f *faultyRemote) GetBlock(ctx context.Context, hash string) ([]byte, error) {
+	drop, corrupt, delay := f.fault()
+	time.Sleep(delay)
+	if drop {
+		return nil, errDropped
+	}
+	data, err := f.DagSyncable.GetBlock(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if corrupt {
+		data = corrupted(data)
+	}
+	return data, nil
+}
+
+// Faults returns the number of dropped & corrupted blocks
+func (f *faultyRemote) Faults() (dropped, corrupted int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.dropped, f.corrupted
+}
+
+// restore ends an outage, returning the blocks received since the last
+// restore
+func (f *faultyRemote) restore() (received []string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.down = false
+	f.outageAfter = 0
+	received, f.received = f.received, nil
+	return received
+}
+
+// arrive notes a pushed block is in flight, reporting false during an outage
+func (f *faultyRemote) arrive(hash string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.outageAfter > 0 && len(f.received)+f.inFlight >= f.outageAfter {
+		f.down = true
+	}
+	if f.down {
+		return false
+	}
+	for _, child := range f.children[hash] {
+		if !contains(f.received, child) {
+			f.early = append(f.early, hash)
+			break
+		}
+	}
+	f.inFlight++
+	if f.inFlight > f.maxInFlight {
+		f.maxInFlight = f.inFlight
+	}
+	return true
+}
+
+// depart notes a pushed block is no longer in flight
+func (f *faultyRemote) depart(hash string, ok bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.inFlight--
+	if ok {
+		f.received = append(f.received, hash)
+	}
+}
+
+func (f *faultyRemote) fault() (drop, corrupt bool, delay time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delay = f.delay
+	if f.maxDelay > 0 {
+		delay += time.Duration(f.rand.Int63n(int64(f.maxDelay)))
+	}
+	if f.rand.Float64() < f.dropRate {
+		f.dropped++
+		return true, false, delay
+	}
+	if f.rand.Float64() < f.corruptRate {
+		f.corrupted++
+		return false, true, delay
+	}
+	return false, false, delay
+}
+
+func contains(hashes []string, hash string) bool {
+	for _, h := range hashes {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// corrupted returns a copy of data with the last byte flipped
+func corrupted(data []byte) []byte {
+	c := append([]byte{}, data...)
+	if len(c) == 0 {
+		return []byte{0}
+	}
+	c[len(c)-1] ^= 0xff
+	return c
+}
+
+func TestPushRetriesDroppedBlocks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, root, info := newTestDAG(t, "retry", 3, 2)
+
+	remDs, remote := newTestRemote(t)
+	rem := newFaultyRemote(remDs, 1)
+	rem.dropRate = 0.3
+	rem.maxDelay = time.Millisecond * 5
+
+	push, err := NewPush(local.nodeGetter(), info, rem, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// drops are frequent, give blocks plenty of quick retries
+	push.retries = 20
+	push.retryBackoff = time.Millisecond
+	if err := push.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if dropped, _ := rem.Faults(); dropped == 0 {
+		t.Error("expected faulty remote to drop at least one block")
+	}
+	if _, err := dag.NewManifest(ctx, remote.nodeGetter(), root.Cid()); err != nil {
+		t.Errorf("expected DAG to be complete on the remote after retries. error: %s", err)
+	}
+}
+
+func TestPushCorruptBlock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, _, info := newTestDAG(t, "corrupt", 2, 1)
+
+	remDs, _ := newTestRemote(t)
+	rem := newFaultyRemote(remDs, 1)
+	rem.corruptRate = 1
+
+	push, err := NewPush(local.nodeGetter(), info, rem, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = push.Do(ctx)
+	if err == nil {
+		t.Fatal("expected push of corrupted blocks to fail hash verification")
+	}
+	if !strings.Contains(err.Error(), "hash mismatch") {
+		t.Errorf("expected hash mismatch error. got: %s", err)
+	}
+}
+
+func TestPullReorderedBlocks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	remote := newMemStore()
+	root := addTestDAG(t, remote, "reorder", 3, 2)
+	remDs, err := New(remote.nodeGetter(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rem := newFaultyRemote(remDs, 1)
+	rem.maxDelay = time.Millisecond * 10
+
+	local := newMemStore()
+	pull, err := NewPull(root.Cid().String(), local.nodeGetter(), local, rem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pull.parallelism = 4
+	if err := pull.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dag.NewManifest(ctx, local.nodeGetter(), root.Cid()); err != nil {
+		t.Errorf("expected DAG to be complete locally after pull. error: %s", err)
+	}
+}
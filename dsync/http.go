@@ -2,14 +2,20 @@ package dsync
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 
+	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	protocol "github.com/libp2p/go-libp2p-core/protocol"
@@ -34,18 +40,86 @@ type HTTPClient struct {
 	NodeGetter    format.NodeGetter
 	BlockAPI      coreiface.BlockAPI
 	remProtocolID protocol.ID
+
+	// Client is the HTTP client requests are made with, defaulting to
+	// http.DefaultClient. Use a client with an HTTP/2 transport to push block
+	// streams with HTTP/2 flow control
+	Client *http.Client
+	// StreamChunkSize caps the number of bytes of a pushed block stream
+	// handed to the transport at once, defaulting to DefaultStreamChunkSize.
+	// Pushed streams are only read as fast as the transport sends them, so
+	// a slow remote applies backpressure all the way back to reading blocks.
+	//
+	// Over HTTP/1.1 each chunk is written & flushed as one chunk of a chunked
+	// transfer encoding, and backpressure comes from the TCP connection
+	// filling up, which can buffer a lot of data in kernel socket buffers
+	// before a push stalls. Over HTTP/2 each chunk is sent as DATA frames
+	// only once the remote's flow-control window for the stream has room, so
+	// a push stays within a window of the remote's progress, without
+	// stalling other streams on the same connection
+	StreamChunkSize int
+	// DisableCompression stops the client from gzip-compressing pushed block
+	// streams, & from asking remotes to gzip-compress pulled block streams.
+	// Block streams are compressed by default when the remote supports it,
+	// which cuts the size of transfers of text-heavy DAGs like CSV & JSON
+	// datasets. Blocks are always verified against their CIDs once
+	// decompressed
+	DisableCompression bool
+
+	// remAcceptsGzip is true if the remote reported it accepts
+	// gzip-compressed requests during the handshake
+	remAcceptsGzip bool
+
+	// infos fetched from the remote, keyed by root id. manifests are immutable
+	// so a cached info is only refetched if the remote reports a new ETag
+	infoCacheLock sync.Mutex
+	infoCache     map[string]cachedInfo
+}
+
+// cachedInfo is a dag.Info fetched from a remote along with its ETag
+type cachedInfo struct {
+	etag string
+	info *dag.Info
 }
 
 var (
 	// HTTPClient exists to satisfy the DaySyncable interface on the client side
 	// of a transfer
-	_ DagSyncable   = (*HTTPClient)(nil)
-	_ DagStreamable = (*HTTPClient)(nil)
+	_ DagSyncable        = (*HTTPClient)(nil)
+	_ DagStreamable      = (*HTTPClient)(nil)
+	_ BlockPartReceiver  = (*HTTPClient)(nil)
+	_ BlockDeltaReceiver = (*HTTPClient)(nil)
+	_ BlockProber        = (*HTTPClient)(nil)
+	_ SessionProgresser  = (*HTTPClient)(nil)
+	_ CIDsRemover        = (*HTTPClient)(nil)
+
+	_ ContextSessionOpener      = (*HTTPClient)(nil)
+	_ ContextBlockReceiver      = (*HTTPClient)(nil)
+	_ ContextBlockPartReceiver  = (*HTTPClient)(nil)
+	_ ContextBlockDeltaReceiver = (*HTTPClient)(nil)
 )
 
+// DefaultStreamChunkSize is the default HTTPClient.StreamChunkSize, the
+// default maximum HTTP/2 frame size
+const DefaultStreamChunkSize = 16 * 1024
+
+// client returns the HTTP client to make requests with
+func (rem *HTTPClient) client() *http.Client {
+	if rem.Client != nil {
+		return rem.Client
+	}
+	return http.DefaultClient
+}
+
 // NewReceiveSession initiates a session for pushing blocks to a remote.
 // It sends a Manifest to a remote source over HTTP
 func (rem *HTTPClient) NewReceiveSession(info *dag.Info, pinOnComplete bool, meta map[string]string) (sid string, diff *dag.Manifest, err error) {
+	return rem.NewReceiveSessionContext(context.Background(), info, pinOnComplete, meta)
+}
+
+// NewReceiveSessionContext initiates a push over HTTP, aborting the request
+// if ctx is cancelled
+func (rem *HTTPClient) NewReceiveSessionContext(ctx context.Context, info *dag.Info, pinOnComplete bool, meta map[string]string) (sid string, diff *dag.Manifest, err error) {
 	buf := &bytes.Buffer{}
 	if err = json.NewEncoder(buf).Encode(info); err != nil {
 		return
@@ -62,7 +136,7 @@ func (rem *HTTPClient) NewReceiveSession(info *dag.Info, pinOnComplete bool, met
 	}
 	u.RawQuery = q.Encode()
 
-	req, err := http.NewRequest(http.MethodPost, u.String(), buf)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), buf)
 	if err != nil {
 		return
 	}
@@ -70,7 +144,7 @@ func (rem *HTTPClient) NewReceiveSession(info *dag.Info, pinOnComplete bool, met
 	req.Header.Set("Accept", jsonMIMEType)
 	req.Header.Set(httpDsyncProtocolIDHeader, string(DsyncProtocolID))
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := rem.client().Do(req)
 	if err != nil {
 		return
 	}
@@ -86,6 +160,7 @@ func (rem *HTTPClient) NewReceiveSession(info *dag.Info, pinOnComplete bool, met
 
 	sid = res.Header.Get("sid")
 	rem.remProtocolID = protocolIDFromHTTPData(req.URL, res.Header)
+	rem.remAcceptsGzip = acceptsGzip(res.Header)
 
 	diff = &dag.Manifest{}
 	err = json.NewDecoder(res.Body).Decode(diff)
@@ -102,21 +177,37 @@ func (rem *HTTPClient) ProtocolVersion() (protocol.ID, error) {
 	return rem.remProtocolID, nil
 }
 
-// ReceiveBlocks writes a block stream as an HTTP PUT request to the remote
+// ReceiveBlocks writes a block stream as an HTTP PUT request to the remote.
+// The stream is read in chunks of at most StreamChunkSize bytes, each read
+// only once the transport is ready to send it
 func (rem *HTTPClient) ReceiveBlocks(ctx context.Context, sid string, r io.Reader) error {
+	size := rem.StreamChunkSize
+	if size <= 0 {
+		size = DefaultStreamChunkSize
+	}
+
+	compress := rem.remAcceptsGzip && !rem.DisableCompression
+	if compress {
+		gz := gzipReader(r)
+		defer gz.Close()
+		r = gz
+	}
 
-	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s?sid=%s", rem.URL, sid), r)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, fmt.Sprintf("%s?sid=%s", rem.URL, sid), &chunkReader{r: r, size: size})
 	if err != nil {
 		log.Debugf("err creating %s HTTP request err=%q ", http.MethodPut, err)
 		return err
 	}
 	req.TransferEncoding = []string{"chunked"}
 	req.Header.Set("Content-Type", carMIMEType)
+	if compress {
+		req.Header.Set("Content-Encoding", gzipEncoding)
+	}
 	// response body is only used for error reporting
 	req.Header.Set("Accept", binaryMIMEType)
 	req.Header.Set(httpDsyncProtocolIDHeader, string(DsyncProtocolID))
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := rem.client().Do(req)
 	if err != nil {
 		log.Debugf("err doing HTTP request. err=%q", err)
 		return err
@@ -136,8 +227,43 @@ func (rem *HTTPClient) ReceiveBlocks(ctx context.Context, sid string, r io.Reade
 
 // ReceiveBlock asks a remote to receive a block over HTTP
 func (rem *HTTPClient) ReceiveBlock(sid, hash string, data []byte) ReceiveResponse {
+	return rem.ReceiveBlockContext(context.Background(), sid, hash, data)
+}
+
+// ReceiveBlockContext asks a remote to receive a block over HTTP, aborting
+// the request if ctx is cancelled
+func (rem *HTTPClient) ReceiveBlockContext(ctx context.Context, sid, hash string, data []byte) ReceiveResponse {
 	url := fmt.Sprintf("%s?sid=%s&hash=%s", rem.URL, sid, hash)
-	req, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(data))
+	return rem.putBlockData(ctx, url, hash, data)
+}
+
+// ReceiveBlockPart asks a remote to receive part of a block over HTTP
+func (rem *HTTPClient) ReceiveBlockPart(sid, hash string, offset, size int, data []byte) ReceiveResponse {
+	return rem.ReceiveBlockPartContext(context.Background(), sid, hash, offset, size, data)
+}
+
+// ReceiveBlockPartContext asks a remote to receive part of a block over
+// HTTP, aborting the request if ctx is cancelled
+func (rem *HTTPClient) ReceiveBlockPartContext(ctx context.Context, sid, hash string, offset, size int, data []byte) ReceiveResponse {
+	url := fmt.Sprintf("%s?sid=%s&hash=%s&offset=%d&size=%d", rem.URL, sid, hash, offset, size)
+	return rem.putBlockData(ctx, url, hash, data)
+}
+
+// ReceiveBlockDelta asks a remote to receive a block encoded as a delta
+// against a base block over HTTP
+func (rem *HTTPClient) ReceiveBlockDelta(sid, hash, baseHash string, delta []byte) ReceiveResponse {
+	return rem.ReceiveBlockDeltaContext(context.Background(), sid, hash, baseHash, delta)
+}
+
+// ReceiveBlockDeltaContext asks a remote to receive a block encoded as a
+// delta over HTTP, aborting the request if ctx is cancelled
+func (rem *HTTPClient) ReceiveBlockDeltaContext(ctx context.Context, sid, hash, baseHash string, delta []byte) ReceiveResponse {
+	url := fmt.Sprintf("%s?sid=%s&hash=%s&base=%s", rem.URL, sid, hash, baseHash)
+	return rem.putBlockData(ctx, url, hash, delta)
+}
+
+func (rem *HTTPClient) putBlockData(ctx context.Context, url, hash string, data []byte) ReceiveResponse {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewBuffer(data))
 	if err != nil {
 		log.Debugf("http client create request error=%s", err)
 		return ReceiveResponse{
@@ -150,7 +276,7 @@ func (rem *HTTPClient) ReceiveBlock(sid, hash string, data []byte) ReceiveRespon
 	// response body is only used for error reporting
 	req.Header.Set("Accept", binaryMIMEType)
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := rem.client().Do(req)
 	if err != nil {
 		log.Debugf("http client perform request error=%s", err)
 		return ReceiveResponse{
@@ -191,18 +317,30 @@ func (rem *HTTPClient) GetDagInfo(ctx context.Context, id string, meta map[strin
 	}
 	u.RawQuery = q.Encode()
 
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Accept", jsonMIMEType)
 
-	res, err := http.DefaultClient.Do(req)
+	rem.infoCacheLock.Lock()
+	cached, isCached := rem.infoCache[id]
+	rem.infoCacheLock.Unlock()
+	if isCached {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	res, err := rem.client().Do(req)
 	if err != nil {
 		return nil, err
 	}
 	rem.remProtocolID = protocolIDFromHTTPData(req.URL, res.Header)
 
+	if isCached && res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		return cached.info, nil
+	}
+
 	if res.StatusCode != http.StatusOK {
 		var msg string
 		if data, err := ioutil.ReadAll(res.Body); err == nil {
@@ -213,20 +351,146 @@ func (rem *HTTPClient) GetDagInfo(ctx context.Context, id string, meta map[strin
 	defer res.Body.Close()
 
 	info = &dag.Info{}
-	err = json.NewDecoder(res.Body).Decode(info)
-	return
+	if err = json.NewDecoder(res.Body).Decode(info); err != nil {
+		return nil, err
+	}
+
+	if etag := res.Header.Get("ETag"); etag != "" {
+		rem.infoCacheLock.Lock()
+		if rem.infoCache == nil {
+			rem.infoCache = map[string]cachedInfo{}
+		}
+		rem.infoCache[id] = cachedInfo{etag: etag, info: info}
+		rem.infoCacheLock.Unlock()
+	}
+	return info, nil
+}
+
+// ProbeBlocks asks the remote which blocks of a receive session it already
+// has over HTTP
+func (rem *HTTPClient) ProbeBlocks(ctx context.Context, sid string, ids []string) (have []string, err error) {
+	u, err := url.Parse(rem.URL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("sid", sid)
+	q.Set("have", strings.Join(ids, ","))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", jsonMIMEType)
+
+	res, err := rem.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var msg string
+		if data, err := ioutil.ReadAll(res.Body); err == nil {
+			msg = string(data)
+		}
+		return nil, fmt.Errorf("remote error: %d %s", res.StatusCode, msg)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&have)
+	return have, err
+}
+
+// SessionProgress asks the remote for the completion of a receive session
+func (rem *HTTPClient) SessionProgress(ctx context.Context, sid string) (dag.Completion, error) {
+	u, err := url.Parse(rem.URL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("progress", sid)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", jsonMIMEType)
+	req.Header.Set(httpDsyncProtocolIDHeader, string(DsyncProtocolID))
+
+	res, err := rem.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var msg string
+		if data, err := ioutil.ReadAll(res.Body); err == nil {
+			msg = string(data)
+		}
+		return nil, fmt.Errorf("remote error: %d %s", res.StatusCode, msg)
+	}
+	// resumed pushes skip the handshake, so the progress response establishes
+	// the remote protocol version
+	rem.remProtocolID = protocolIDFromHTTPData(req.URL, res.Header)
+	rem.remAcceptsGzip = acceptsGzip(res.Header)
+
+	prog := dag.Completion{}
+	err = json.NewDecoder(res.Body).Decode(&prog)
+	return prog, err
+}
+
+// VerifyManifestHash asks the remote to compare the manifest of a DAG it holds
+// against the manifest hash stored when the DAG was pushed
+func (rem *HTTPClient) VerifyManifestHash(ctx context.Context, id string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s?verify=%s", rem.URL, id), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	res, err := rem.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var msg string
+		if data, err := ioutil.ReadAll(res.Body); err == nil {
+			msg = string(data)
+		}
+		switch res.StatusCode {
+		case http.StatusConflict:
+			return fmt.Errorf("%w: remote error: %s", ErrManifestHashMismatch, msg)
+		case http.StatusNotFound:
+			return fmt.Errorf("%w: remote error: %s", dag.ErrManifestHashNotFound, msg)
+		}
+		return fmt.Errorf("remote error: %d %s", res.StatusCode, msg)
+	}
+	return nil
 }
 
-// GetBlock fetches a block from a remote source over HTTP
+// GetBlock fetches a block from a remote source over HTTP, checking the
+// returned data hashes to the requested id
 func (rem *HTTPClient) GetBlock(ctx context.Context, id string) (data []byte, err error) {
+	blockID, err := cid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+
 	url := fmt.Sprintf("%s?block=%s", rem.URL, id)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(ctx)
 	req.Header.Set("Accept", binaryMIMEType)
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := rem.client().Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -240,7 +504,18 @@ func (rem *HTTPClient) GetBlock(ctx context.Context, id string) (data []byte, er
 	}
 	defer res.Body.Close()
 
-	return ioutil.ReadAll(res.Body)
+	if data, err = ioutil.ReadAll(res.Body); err != nil {
+		return nil, err
+	}
+
+	got, err := blockID.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+	if !got.Equals(blockID) {
+		return nil, &HashMismatchError{Expected: blockID.String(), Got: got.String()}
+	}
+	return data, nil
 }
 
 // OpenBlockStream sends a dag.Info to the remote & asks that it returns a
@@ -261,15 +536,20 @@ func (rem *HTTPClient) OpenBlockStream(ctx context.Context, info *dag.Info, meta
 		return nil, err
 	}
 
-	req, err := http.NewRequest(http.MethodPatch, u.String(), bytes.NewBuffer(bodyData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, u.String(), bytes.NewBuffer(bodyData))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", cborMIMEType)
 	req.Header.Set("Accept", carMIMEType)
 	req.Header.Set(httpDsyncProtocolIDHeader, string(DsyncProtocolID))
+	if !rem.DisableCompression {
+		// setting Accept-Encoding turns off transparent decompression in the
+		// transport, responses are decompressed below
+		req.Header.Set("Accept-Encoding", gzipEncoding)
+	}
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := rem.client().Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -283,7 +563,20 @@ func (rem *HTTPClient) OpenBlockStream(ctx context.Context, info *dag.Info, meta
 		return nil, fmt.Errorf("unexpected media type: %s", res.Header.Get("Content-Type"))
 	}
 
-	return res.Body, nil
+	switch enc := res.Header.Get("Content-Encoding"); enc {
+	case "", identityEncoding:
+		return res.Body, nil
+	case gzipEncoding:
+		gz, err := gzip.NewReader(res.Body)
+		if err != nil {
+			res.Body.Close()
+			return nil, fmt.Errorf("reading gzip block stream: %w", err)
+		}
+		return gzipReadCloser{Reader: gz, body: res.Body}, nil
+	default:
+		res.Body.Close()
+		return nil, fmt.Errorf("unsupported content encoding: %s", enc)
+	}
 }
 
 // RemoveCID asks a remote to remove a CID
@@ -299,14 +592,14 @@ func (rem *HTTPClient) RemoveCID(ctx context.Context, id string, meta map[string
 	}
 	u.RawQuery = q.Encode()
 
-	req, err := http.NewRequest(http.MethodDelete, u.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u.String(), nil)
 	if err != nil {
 		return err
 	}
 	// response body is only used for error reporting
 	req.Header.Set("Accept", binaryMIMEType)
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := rem.client().Do(req)
 	if err != nil {
 		return err
 	}
@@ -325,18 +618,77 @@ func (rem *HTTPClient) RemoveCID(ctx context.Context, id string, meta map[string
 	return nil
 }
 
+// RemoveCIDs asks a remote to remove several CIDs in a single request
+func (rem *HTTPClient) RemoveCIDs(ctx context.Context, ids []string, meta map[string]string) (*RemoveResults, error) {
+	u, err := url.Parse(rem.URL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("cids", strings.Join(ids, ","))
+	for key, val := range meta {
+		q.Set(key, val)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", jsonMIMEType)
+
+	res, err := rem.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var msg string
+		if data, err := ioutil.ReadAll(res.Body); err == nil {
+			msg = string(data)
+		}
+		if msg == ErrRemoveNotSupported.Error() {
+			return nil, ErrRemoveNotSupported
+		}
+		return nil, fmt.Errorf("remote: %d %s", res.StatusCode, msg)
+	}
+
+	results := &RemoveResults{}
+	if err := json.NewDecoder(res.Body).Decode(results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
 // HTTPRemoteHandler exposes a Dsync remote over HTTP by exposing a HTTP handler
 // that interlocks with methods exposed by HTTPClient
 func HTTPRemoteHandler(ds *Dsync) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set(httpDsyncProtocolIDHeader, string(DsyncProtocolID))
+		// advertise the request encodings block streams can be pushed with
+		w.Header().Set("Accept-Encoding", gzipEncoding)
+
+		if ds.authenticate != nil {
+			if err := ds.authenticate(r); err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(err.Error()))
+				return
+			}
+		}
 
 		switch r.Method {
 		case http.MethodPost:
 			createDsyncSession(ds, w, r)
 		case http.MethodPut:
 			if r.Header.Get("Content-Type") == carMIMEType {
-				if err := ds.ReceiveBlocks(r.Context(), r.FormValue("sid"), r.Body); err != nil {
+				body, err := decodeRequestBody(r)
+				if err != nil {
+					w.WriteHeader(http.StatusUnsupportedMediaType)
+					w.Write([]byte(err.Error()))
+					return
+				}
+				if err := ds.ReceiveBlocks(r.Context(), r.FormValue("sid"), body); err != nil {
 					w.WriteHeader(http.StatusBadRequest)
 					w.Write([]byte(err.Error()))
 					return
@@ -347,6 +699,38 @@ func HTTPRemoteHandler(ds *Dsync) http.HandlerFunc {
 
 			receiveBlockHTTP(ds, w, r)
 		case http.MethodGet:
+			if ids := r.FormValue("have"); ids != "" {
+				have, err := ds.ProbeBlocks(r.Context(), r.FormValue("sid"), strings.Split(ids, ","))
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte(err.Error()))
+					return
+				}
+				if have == nil {
+					have = []string{}
+				}
+				w.Header().Set("Content-Type", jsonMIMEType)
+				json.NewEncoder(w).Encode(have)
+				return
+			}
+
+			if sid := r.FormValue("progress"); sid != "" {
+				prog, err := ds.SessionProgress(r.Context(), sid)
+				if err != nil {
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(err.Error()))
+					return
+				}
+				w.Header().Set("Content-Type", jsonMIMEType)
+				json.NewEncoder(w).Encode(prog)
+				return
+			}
+
+			if verifyID := r.FormValue("verify"); verifyID != "" {
+				verifyManifestHashHTTP(ds, w, r, verifyID)
+				return
+			}
+
 			mfstID := r.FormValue("manifest")
 			blockID := r.FormValue("block")
 			if mfstID == "" && blockID == "" {
@@ -368,6 +752,17 @@ func HTTPRemoteHandler(ds *Dsync) http.HandlerFunc {
 					return
 				}
 
+				// manifests are immutable, use the manifest hash as an ETag so clients
+				// can skip re-downloading manifests they already have
+				if hash, err := mfst.Manifest.Hash(); err == nil {
+					etag := fmt.Sprintf("%q", hash)
+					w.Header().Set("ETag", etag)
+					if r.Header.Get("If-None-Match") == etag {
+						w.WriteHeader(http.StatusNotModified)
+						return
+					}
+				}
+
 				data, err := json.Marshal(mfst)
 				if err != nil {
 					w.WriteHeader(http.StatusInternalServerError)
@@ -378,14 +773,28 @@ func HTTPRemoteHandler(ds *Dsync) http.HandlerFunc {
 				w.Header().Set("Content-Type", jsonMIMEType)
 				w.Write(data)
 			} else {
-				data, err := ds.GetBlock(r.Context(), blockID)
+				meta := map[string]string{}
+				for key := range r.URL.Query() {
+					if key != "block" {
+						meta[key] = r.URL.Query().Get(key)
+					}
+				}
+
+				data, err := ds.getBlock(r.Context(), blockID, meta)
 				if err != nil {
 					w.WriteHeader(http.StatusInternalServerError)
 					w.Write([]byte(err.Error()))
 					return
 				}
 				w.Header().Set("Content-Type", binaryMIMEType)
-				w.Write(data)
+				// compressing small blocks costs more than it saves
+				if len(data) <= DefaultPerBlockCompressionThreshold {
+					w.Write(data)
+					return
+				}
+				body, closeBody := gzipResponse(w, r)
+				body.Write(data)
+				closeBody()
 			}
 		case http.MethodPatch:
 			meta := map[string]string{}
@@ -399,7 +808,12 @@ func HTTPRemoteHandler(ds *Dsync) http.HandlerFunc {
 				w.Write([]byte(err.Error()))
 				return
 			}
-			r, err := ds.OpenBlockStream(r.Context(), info, meta)
+			if r.Header.Get("Range") != "" {
+				openBlockStreamRangeHTTP(ds, w, r, info, meta)
+				return
+			}
+
+			stream, err := ds.OpenBlockStream(r.Context(), info, meta)
 			if err != nil {
 				w.WriteHeader(http.StatusBadRequest)
 				w.Write([]byte(err.Error()))
@@ -407,12 +821,23 @@ func HTTPRemoteHandler(ds *Dsync) http.HandlerFunc {
 			}
 
 			w.Header().Set("Content-Type", carMIMEType)
+			w.Header().Set("Accept-Ranges", "bytes")
+			body, closeBody := gzipResponse(w, r)
 			w.WriteHeader(http.StatusOK)
-			defer r.Close()
-			io.Copy(w, r)
+			defer stream.Close()
+			// a failed copy leaves the gzip stream unterminated, so clients
+			// see a truncated stream rather than a short one
+			if _, err := io.Copy(body, stream); err == nil {
+				closeBody()
+			}
 			return
 
 		case http.MethodDelete:
+			if ids := r.FormValue("cids"); ids != "" {
+				removeCIDsHTTP(ds, w, r, strings.Split(ids, ","))
+				return
+			}
+
 			cid := r.FormValue("cid")
 			meta := map[string]string{}
 			for key := range r.URL.Query() {
@@ -432,6 +857,104 @@ func HTTPRemoteHandler(ds *Dsync) http.HandlerFunc {
 	}
 }
 
+// removeCIDsHTTP removes several cids, responding with the results
+func removeCIDsHTTP(ds *Dsync, w http.ResponseWriter, r *http.Request, ids []string) {
+	meta := map[string]string{}
+	for key := range r.URL.Query() {
+		if key != "cids" {
+			meta[key] = r.URL.Query().Get(key)
+		}
+	}
+
+	results, err := ds.RemoveCIDs(r.Context(), ids, meta)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", jsonMIMEType)
+	json.NewEncoder(w).Encode(results)
+}
+
+const (
+	gzipEncoding     = "gzip"
+	identityEncoding = "identity"
+)
+
+// acceptsGzip reports if an Accept-Encoding header lists gzip as acceptable
+func acceptsGzip(h http.Header) bool {
+	for _, value := range h["Accept-Encoding"] {
+		for _, enc := range strings.Split(value, ",") {
+			params := strings.Split(enc, ";")
+			if strings.TrimSpace(params[0]) != gzipEncoding {
+				continue
+			}
+			for _, param := range params[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					if q, err := strconv.ParseFloat(param[2:], 64); err != nil || q == 0 {
+						return false
+					}
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponse gzip-compresses the body of a response if the request accepts
+// gzip, returning the writer to write the body to. gzipResponse must be called
+// before writing the response header, & closeBody once the body is written
+func gzipResponse(w http.ResponseWriter, r *http.Request) (body io.Writer, closeBody func() error) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r.Header) {
+		return w, func() error { return nil }
+	}
+	w.Header().Set("Content-Encoding", gzipEncoding)
+	gz, _ := gzip.NewWriterLevel(w, gzip.BestSpeed)
+	return gz, gz.Close
+}
+
+// decodeRequestBody returns the body of a request, decompressed according to
+// it's Content-Encoding
+func decodeRequestBody(r *http.Request) (io.Reader, error) {
+	switch enc := r.Header.Get("Content-Encoding"); enc {
+	case "", identityEncoding:
+		return r.Body, nil
+	case gzipEncoding:
+		return gzip.NewReader(r.Body)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding: %s", enc)
+	}
+}
+
+// gzipReader gzip-compresses r as it's read. Closing the returned reader
+// stops compression
+func gzipReader(r io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		gz, _ := gzip.NewWriterLevel(pw, gzip.BestSpeed)
+		_, err := io.Copy(gz, r)
+		if err == nil {
+			err = gz.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// gzipReadCloser decompresses a response body, closing both on Close
+type gzipReadCloser struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (r gzipReadCloser) Close() error {
+	r.Reader.Close()
+	return r.body.Close()
+}
+
 func protocolIDFromHTTPData(url *url.URL, headers http.Header) protocol.ID {
 	protocolIDHeaderStr := headers.Get(httpDsyncProtocolIDHeader)
 	if protocolIDHeaderStr == "" {
@@ -445,33 +968,141 @@ func protocolIDFromHTTPData(url *url.URL, headers http.Header) protocol.ID {
 
 func decodeDAGInfoBody(r *http.Request) (*dag.Info, error) {
 	defer r.Body.Close()
-	info := &dag.Info{}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
 
+	info := &dag.Info{}
 	switch r.Header.Get("Content-Type") {
 	case cborMIMEType:
-		data, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			return nil, err
-		}
-		info, err = dag.UnmarshalCBORDagInfo(data)
-		if err != nil {
+		if info, err = dag.UnmarshalCBORDagInfo(data); err != nil {
 			return nil, err
 		}
 	default:
 		// default to JSON for legacy reads
-		err := json.NewDecoder(r.Body).Decode(info)
-		if err != nil {
+		if err := json.Unmarshal(data, info); err != nil {
 			return nil, err
 		}
 	}
 
+	// older clients send a bare manifest without sizes
 	if info.Manifest == nil {
-		return nil, fmt.Errorf("body must be a json dag info object")
+		mfst := &dag.Manifest{}
+		if r.Header.Get("Content-Type") == cborMIMEType {
+			mfst, err = dag.UnmarshalCBORManifest(data)
+		} else {
+			err = json.Unmarshal(data, mfst)
+		}
+		if err != nil || len(mfst.Nodes) == 0 {
+			return nil, fmt.Errorf("body must be a json dag info object")
+		}
+		info = &dag.Info{Manifest: mfst}
 	}
 
 	return info, nil
 }
 
+func verifyManifestHashHTTP(ds *Dsync, w http.ResponseWriter, r *http.Request, id string) {
+	if err := ds.VerifyManifestHash(r.Context(), id); err != nil {
+		if errors.Is(err, ErrManifestHashMismatch) {
+			w.WriteHeader(http.StatusConflict)
+		} else if errors.Is(err, dag.ErrManifestHashNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// openBlockStreamRangeHTTP responds to a block stream request with a Range
+// header by writing only the requested bytes of the stream. Block streams are
+// deterministically ordered, so any range of a stream is the same range of
+// every other stream of the same manifest. Only single byte ranges are
+// supported
+func openBlockStreamRangeHTTP(ds *Dsync, w http.ResponseWriter, r *http.Request, info *dag.Info, meta map[string]string) {
+	rdr, err := ds.OpenBlockStream(r.Context(), info, meta)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	defer rdr.Close()
+
+	length, err := manifestCARLength(r.Context(), ds.lng, info.Manifest)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	start, end, err := parseByteRange(r.Header.Get("Range"), length)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", length))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, rdr, start); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", carMIMEType)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, length))
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	io.CopyN(w, rdr, end-start+1)
+}
+
+// parseByteRange reads a single range from a Range header value, returning
+// the inclusive first & last byte positions, clamped to size
+func parseByteRange(header string, size int64) (start, end int64, err error) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header || strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("unsupported range: %q", header)
+	}
+	bounds := strings.SplitN(strings.TrimSpace(spec), "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("invalid range: %q", header)
+	}
+
+	if bounds[0] == "" {
+		// suffix range, the final n bytes
+		n, err := strconv.ParseInt(bounds[1], 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, fmt.Errorf("invalid range: %q", header)
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, nil
+	}
+
+	if start, err = strconv.ParseInt(bounds[0], 10, 64); err != nil || start < 0 {
+		return 0, 0, fmt.Errorf("invalid range: %q", header)
+	}
+	end = size - 1
+	if bounds[1] != "" {
+		if end, err = strconv.ParseInt(bounds[1], 10, 64); err != nil || end < start {
+			return 0, 0, fmt.Errorf("invalid range: %q", header)
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, fmt.Errorf("range start %d exceeds stream length %d", start, size)
+	}
+	return start, end, nil
+}
+
 func receiveBlockHTTP(ds *Dsync, w http.ResponseWriter, r *http.Request) {
 	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -480,7 +1111,26 @@ func receiveBlockHTTP(ds *Dsync, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	res := ds.ReceiveBlock(r.FormValue("sid"), r.FormValue("hash"), data)
+	var res ReceiveResponse
+	if base := r.FormValue("base"); base != "" {
+		res = ds.ReceiveBlockDelta(r.FormValue("sid"), r.FormValue("hash"), base, data)
+	} else if r.FormValue("offset") != "" {
+		offset, err := strconv.Atoi(r.FormValue("offset"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		size, err := strconv.Atoi(r.FormValue("size"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		res = ds.ReceiveBlockPart(r.FormValue("sid"), r.FormValue("hash"), offset, size, data)
+	} else {
+		res = ds.ReceiveBlock(r.FormValue("sid"), r.FormValue("hash"), data)
+	}
 
 	if res.Status == StatusErrored {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -494,13 +1144,6 @@ func receiveBlockHTTP(ds *Dsync, w http.ResponseWriter, r *http.Request) {
 }
 
 func createDsyncSession(ds *Dsync, w http.ResponseWriter, r *http.Request) {
-	info, err := decodeDAGInfoBody(r)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
-		return
-	}
-
 	pinOnComplete := r.FormValue("pin") == "true"
 	meta := map[string]string{}
 	for key := range r.URL.Query() {
@@ -509,9 +1152,35 @@ func createDsyncSession(ds *Dsync, w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// pushes of DAGs the remote already has are complete before the manifest
+	// is decoded
+	if root, ok := meta[ManifestRootMetaKey]; ok {
+		complete, err := ds.receiveAlreadyComplete(r.Context(), root, nil, pinOnComplete, meta)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if complete {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(&dag.Manifest{})
+			return
+		}
+	}
+
+	if ds.maxManifestNodes > 0 {
+		r.Body = &limitedBody{ReadCloser: r.Body, remaining: int64(ds.maxManifestNodes) * maxManifestBytesPerNode}
+	}
+	info, err := decodeDAGInfoBody(r)
+	if err != nil {
+		w.WriteHeader(manifestErrStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
 	sid, diff, err := ds.NewReceiveSession(info, pinOnComplete, meta)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(manifestErrStatus(err))
 		w.Write([]byte(err.Error()))
 		return
 	}
@@ -520,3 +1189,44 @@ func createDsyncSession(ds *Dsync, w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(diff)
 }
+
+// manifestErrStatus is the response status for an error decoding a manifest
+// or creating a session with it
+func manifestErrStatus(err error) int {
+	if errors.Is(err, ErrManifestTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// limitedBody fails reads past a number of bytes with ErrManifestTooLarge
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		return 0, fmt.Errorf("%w: request body exceeds the size limit", ErrManifestTooLarge)
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// chunkReader caps the size of each read from r, so the transport sends a
+// request body in chunks no larger than size
+type chunkReader struct {
+	r    io.Reader
+	size int
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	if len(p) > cr.size {
+		p = p[:cr.size]
+	}
+	return cr.r.Read(p)
+}
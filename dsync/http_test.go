@@ -1,18 +1,28 @@
 package dsync
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
 	"net/url"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/ipfs/go-cid"
 	files "github.com/ipfs/go-ipfs-files"
+	"github.com/ipfs/go-merkledag"
 	"github.com/qri-io/dag"
 )
 
@@ -56,9 +66,8 @@ func TestSyncHTTP(t *testing.T) {
 	}
 
 	bGetter := &dag.NodeGetter{Dag: b.Dag()}
-	bdsync, err := New(bGetter, b.Block(), func(cfg *Config) {
+	bdsync, err := New(bGetter, b.Block(), acceptPushes, func(cfg *Config) {
 		cfg.AllowRemoves = true
-		cfg.PushPreCheck = func(context.Context, dag.Info, map[string]string) error { return nil }
 		cfg.PushComplete = onCompleteHook
 		cfg.RemoveCheck = removeCheckHook
 	})
@@ -256,9 +265,8 @@ func TestBackwardCompatibleClient(t *testing.T) {
 	}
 
 	bGetter := &dag.NodeGetter{Dag: b.Dag()}
-	bdsync, err := New(bGetter, b.Block(), func(cfg *Config) {
+	bdsync, err := New(bGetter, b.Block(), acceptPushes, func(cfg *Config) {
 		cfg.AllowRemoves = true
-		cfg.PushPreCheck = func(context.Context, dag.Info, map[string]string) error { return nil }
 		cfg.PushComplete = onCompleteHook
 		cfg.RemoveCheck = removeCheckHook
 	})
@@ -324,3 +332,809 @@ func TestBackwardCompatibleClient(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestHTTPClientManifestETag(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	remote := newMemStore()
+	root := addTestDAG(t, remote, "etag", 2, 2)
+	rem, err := New(remote.nodeGetter(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var statuses []int
+	handler := HTTPRemoteHandler(rem)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		statuses = append(statuses, rec.Code)
+		for key, vals := range rec.Header() {
+			w.Header()[key] = vals
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	}))
+	defer s.Close()
+
+	cli := &HTTPClient{URL: s.URL}
+	a, err := cli.GetDagInfo(ctx, root.Cid().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := cli.GetDagInfo(ctx, root.Cid().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := fmt.Sprintf("%v", []int{http.StatusOK, http.StatusNotModified})
+	if got := fmt.Sprintf("%v", statuses); expect != got {
+		t.Errorf("response status mismatch. expected: %s, got: %s", expect, got)
+	}
+	if a != b {
+		t.Errorf("expected second fetch to return cached info")
+	}
+}
+
+func TestHTTPClientGetBlock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	remote := newMemStore()
+	root := addTestDAG(t, remote, "get_block", 2, 1)
+	blk := root.Links()[0].Cid
+	denied := root.Links()[1].Cid
+	rem, err := New(remote.nodeGetter(), remote, func(cfg *Config) {
+		cfg.GetBlockCheck = func(_ context.Context, info dag.Info, _ map[string]string) error {
+			if info.Manifest.Nodes[0] == denied.String() {
+				return fmt.Errorf("block denied")
+			}
+			return nil
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(HTTPRemoteHandler(rem))
+	defer s.Close()
+
+	cli := &HTTPClient{URL: s.URL}
+	data, err := cli.GetBlock(ctx, blk.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect, err := remote.GetNode(ctx, blk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(expect.RawData()) {
+		t.Errorf("block data mismatch")
+	}
+
+	if _, err := cli.GetBlock(ctx, denied.String()); err == nil {
+		t.Error("expected get of a block denied by the check hook to error")
+	}
+
+	// a remote that returns the wrong block
+	liar := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(root.RawData())
+	}))
+	defer liar.Close()
+	cli = &HTTPClient{URL: liar.URL}
+	if _, err := cli.GetBlock(ctx, blk.String()); err == nil || !strings.Contains(err.Error(), "hash mismatch") {
+		t.Errorf("expected hash mismatch error. got: %v", err)
+	}
+}
+
+func TestHTTPOpenBlockStreamRange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	remote := newMemStore()
+	root := addTestDAG(t, remote, "stream_range", 3, 2)
+	rem, err := New(remote.nodeGetter(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(HTTPRemoteHandler(rem))
+	defer s.Close()
+
+	info, err := dag.NewInfo(ctx, remote.nodeGetter(), root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := info.MarshalCBOR()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	streamRange := func(rng string) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodPatch, s.URL, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", cborMIMEType)
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, data
+	}
+
+	res, full := streamRange("")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected full stream status %d. got: %d", http.StatusOK, res.StatusCode)
+	}
+
+	mid := len(full) / 3
+	res, first := streamRange(fmt.Sprintf("bytes=0-%d", mid-1))
+	if res.StatusCode != http.StatusPartialContent {
+		t.Fatalf("expected range status %d. got: %d", http.StatusPartialContent, res.StatusCode)
+	}
+	expectRange := fmt.Sprintf("bytes 0-%d/%d", mid-1, len(full))
+	if got := res.Header.Get("Content-Range"); got != expectRange {
+		t.Errorf("content range mismatch. expected: %q, got: %q", expectRange, got)
+	}
+	_, rest := streamRange(fmt.Sprintf("bytes=%d-", mid))
+
+	if !bytes.Equal(full, append(first, rest...)) {
+		t.Errorf("reassembled stream doesn't match full stream")
+	}
+	added, err := AddAllFromCARReader(ctx, newMemStore(), bytes.NewReader(append(first, rest...)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != len(info.Manifest.Nodes) {
+		t.Errorf("expected reassembled stream to contain %d blocks. got: %d", len(info.Manifest.Nodes), added)
+	}
+
+	if res, _ := streamRange(fmt.Sprintf("bytes=%d-", len(full))); res.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("expected out of bounds range status %d. got: %d", http.StatusRequestedRangeNotSatisfiable, res.StatusCode)
+	}
+}
+
+// zeroStream produces size zero bytes, counting bytes read
+type zeroStream struct {
+	size int64
+	read int64
+}
+
+func (r *zeroStream) Read(p []byte) (int, error) {
+	remaining := r.size - atomic.LoadInt64(&r.read)
+	if remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	atomic.AddInt64(&r.read, int64(len(p)))
+	return len(p), nil
+}
+
+func TestHTTPClientReceiveBlocksBackpressure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const (
+		streamSize  = 64 << 20
+		readBefore  = 1 << 20
+		maxBuffered = 4 << 20
+	)
+
+	var received int64
+	paused := make(chan struct{})
+	resume := make(chan struct{})
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("expected HTTP/2 request. got: %s", r.Proto)))
+			return
+		}
+		// read a little, then stall like a slow receiver
+		n, _ := io.CopyN(ioutil.Discard, r.Body, readBefore)
+		atomic.AddInt64(&received, n)
+		close(paused)
+		<-resume
+		n, _ = io.Copy(ioutil.Discard, r.Body)
+		atomic.AddInt64(&received, n)
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	src := &zeroStream{size: streamSize}
+	rem := &HTTPClient{URL: srv.URL, Client: srv.Client()}
+	errCh := make(chan error)
+	go func() { errCh <- rem.ReceiveBlocks(ctx, "sid", src) }()
+
+	<-paused
+	// wait for the sender to stop reading once flow-control windows fill
+	read := atomic.LoadInt64(&src.read)
+	for i := 0; i < 50; i++ {
+		time.Sleep(20 * time.Millisecond)
+		next := atomic.LoadInt64(&src.read)
+		if next == read && i > 5 {
+			break
+		}
+		read = next
+	}
+	if buffered := read - atomic.LoadInt64(&received); buffered > maxBuffered {
+		t.Errorf("expected a stalled receiver to hold back the sender. sender is %d bytes ahead", buffered)
+	}
+
+	close(resume)
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt64(&received); got != streamSize {
+		t.Errorf("expected receiver to get %d bytes. got: %d", streamSize, got)
+	}
+}
+
+// encodingRecorder records the content encodings of requests & responses by
+// HTTP method
+type encodingRecorder struct {
+	lock      sync.Mutex
+	requests  map[string]string
+	responses map[string]string
+}
+
+func (rec *encodingRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	rec.lock.Lock()
+	rec.requests[req.Method] = req.Header.Get("Content-Encoding")
+	rec.responses[req.Method] = res.Header.Get("Content-Encoding")
+	rec.lock.Unlock()
+	return res, nil
+}
+
+// legacyEncodingHandler hides the Accept-Encoding headers of requests &
+// responses, like a remote from before dsync supported compression
+func legacyEncodingHandler(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("Accept-Encoding")
+		h.ServeHTTP(legacyEncodingWriter{w}, r)
+	}
+}
+
+type legacyEncodingWriter struct {
+	http.ResponseWriter
+}
+
+func (w legacyEncodingWriter) WriteHeader(code int) {
+	w.Header().Del("Accept-Encoding")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w legacyEncodingWriter) Write(p []byte) (int, error) {
+	w.Header().Del("Accept-Encoding")
+	return w.ResponseWriter.Write(p)
+}
+
+func TestHTTPCompression(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a text-heavy DAG, like a CSV dataset
+	src := newMemStore()
+	root := merkledag.NodeWithData([]byte("dataset"))
+	for i := 0; i < 4; i++ {
+		rows := bytes.Repeat([]byte(fmt.Sprintf("%d,name,description,value\n", i)), 200)
+		leaf := merkledag.NodeWithData(rows)
+		src.putNode(leaf)
+		if err := root.AddNodeLink(fmt.Sprintf("%d", i), leaf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src.putNode(root)
+	info, err := dag.NewInfo(ctx, src.nodeGetter(), root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		description string
+		legacy      bool
+		disable     bool
+		expect      string
+	}{
+		{"compressed", false, false, gzipEncoding},
+		{"client disables compression", false, true, ""},
+		{"remote without compression", true, false, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			rem, remote := newTestRemote(t)
+			var handler http.Handler = HTTPRemoteHandler(rem)
+			if c.legacy {
+				handler = legacyEncodingHandler(handler)
+			}
+			s := httptest.NewServer(handler)
+			defer s.Close()
+
+			rec := &encodingRecorder{requests: map[string]string{}, responses: map[string]string{}}
+			newClient := func() *HTTPClient {
+				return &HTTPClient{URL: s.URL, Client: &http.Client{Transport: rec}, DisableCompression: c.disable}
+			}
+
+			push, err := NewPush(src.nodeGetter(), info, newClient(), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := push.Do(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if got := rec.requests[http.MethodPut]; got != c.expect {
+				t.Errorf("pushed stream encoding mismatch. expected: %q, got: %q", c.expect, got)
+			}
+			if _, err := dag.NewManifest(ctx, remote.nodeGetter(), root.Cid()); err != nil {
+				t.Errorf("expected pushed DAG to be complete. error: %s", err)
+			}
+
+			dst := newMemStore()
+			pull, err := NewPull(root.Cid().String(), dst.nodeGetter(), dst, newClient(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := pull.Do(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if got := rec.responses[http.MethodPatch]; got != c.expect {
+				t.Errorf("pulled stream encoding mismatch. expected: %q, got: %q", c.expect, got)
+			}
+			if _, err := dag.NewManifest(ctx, dst.nodeGetter(), root.Cid()); err != nil {
+				t.Errorf("expected pulled DAG to be complete. error: %s", err)
+			}
+		})
+	}
+
+	// remotes reject encodings they don't support
+	remote := newMemStore()
+	rem, err := New(remote.nodeGetter(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPut, "/?sid=none", strings.NewReader("data"))
+	req.Header.Set("Content-Type", carMIMEType)
+	req.Header.Set("Content-Encoding", "br")
+	w := httptest.NewRecorder()
+	HTTPRemoteHandler(rem)(w, req)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected unsupported encoding to respond with %d. got: %d", http.StatusUnsupportedMediaType, w.Code)
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	cases := []struct {
+		header string
+		expect bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"br", false},
+		{"x-gzip", false},
+	}
+	for _, c := range cases {
+		h := http.Header{}
+		if c.header != "" {
+			h.Set("Accept-Encoding", c.header)
+		}
+		if got := acceptsGzip(h); got != c.expect {
+			t.Errorf("%q: expected %t. got: %t", c.header, c.expect, got)
+		}
+	}
+}
+
+// bearerTransport adds a bearer token to every request it sends
+type bearerTransport string
+
+func (t bearerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+string(t))
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestHTTPRemoteHandlerAuthenticate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src, root, info := newTestDAG(t, "authenticate", 2, 2)
+
+	rem, remote := newTestRemote(t, func(cfg *Config) {
+		cfg.Authenticate = func(r *http.Request) error {
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				return fmt.Errorf("invalid token")
+			}
+			return nil
+		}
+	})
+	s := httptest.NewServer(HTTPRemoteHandler(rem))
+	defer s.Close()
+
+	push, err := NewPush(src.nodeGetter(), info, &HTTPClient{URL: s.URL}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = push.Do(ctx)
+	if err == nil {
+		t.Fatal("expected unauthenticated push to fail")
+	}
+	if !strings.Contains(err.Error(), "401 invalid token") {
+		t.Errorf("expected a 401 error, got: %s", err)
+	}
+	rem.sessionLock.Lock()
+	sessions := len(rem.sessionPool)
+	rem.sessionLock.Unlock()
+	if sessions != 0 {
+		t.Errorf("expected no sessions to be created, got: %d", sessions)
+	}
+
+	cli := &HTTPClient{URL: s.URL, Client: &http.Client{Transport: bearerTransport("secret")}}
+	push, err = NewPush(src.nodeGetter(), info, cli, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := push.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !remote.has(root.Cid()) {
+		t.Errorf("expected authenticated push to land on the remote")
+	}
+}
+
+func TestHTTPMaxManifestNodes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src, root, info := newTestDAG(t, "max manifest nodes", 2, 2)
+
+	rem, _ := newTestRemote(t, func(cfg *Config) {
+		cfg.MaxManifestNodes = 3
+	})
+	if _, _, err := rem.NewReceiveSession(info, false, nil); !errors.Is(err, ErrManifestTooLarge) {
+		t.Errorf("expected session error to wrap ErrManifestTooLarge, got: %v", err)
+	}
+
+	s := httptest.NewServer(HTTPRemoteHandler(rem))
+	defer s.Close()
+
+	push, err := NewPush(src.nodeGetter(), info, &HTTPClient{URL: s.URL}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := push.Do(ctx); err == nil || !strings.Contains(err.Error(), "413") {
+		t.Errorf("expected push of an oversized manifest to fail with a 413, got: %v", err)
+	}
+
+	// a manifest within the node limit padded past the body size limit
+	small := &dag.Info{Manifest: &dag.Manifest{Nodes: []string{root.Cid().String()}}}
+	data, err := json.Marshal(small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := append(bytes.Repeat([]byte(" "), 3*maxManifestBytesPerNode), data...)
+	res, err := http.Post(s.URL, jsonMIMEType, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected oversized body to fail with a 413, got: %d", res.StatusCode)
+	}
+
+	rem.sessionLock.Lock()
+	sessions := len(rem.sessionPool)
+	rem.sessionLock.Unlock()
+	if sessions != 0 {
+		t.Errorf("expected no sessions to be created, got: %d", sessions)
+	}
+}
+
+func TestHTTPSessionBytes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src, _, info := newTestDAG(t, "session bytes", 2, 1)
+
+	rem, _ := newTestRemote(t)
+	s := httptest.NewServer(HTTPRemoteHandler(rem))
+	defer s.Close()
+
+	cli := &HTTPClient{URL: s.URL}
+	sid, _, err := cli.NewReceiveSession(info, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total uint64
+	for _, size := range info.Sizes {
+		total += size
+	}
+	expected, remaining, err := rem.SessionBytes(ctx, sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected != total || remaining != total {
+		t.Errorf("expected session to await all %d bytes. got expected: %d remaining: %d", total, expected, remaining)
+	}
+
+	leaf, err := cid.Parse(info.Manifest.Nodes[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := src.rawData(leaf)
+	if res := cli.ReceiveBlock(sid, leaf.String(), data); res.Status != StatusOk {
+		t.Fatalf("receiving block: %s", res.Err)
+	}
+	if _, remaining, err = rem.SessionBytes(ctx, sid); err != nil {
+		t.Fatal(err)
+	}
+	if remaining != total-info.Sizes[1] {
+		t.Errorf("expected %d bytes remaining. got: %d", total-info.Sizes[1], remaining)
+	}
+
+	// clients that send a bare manifest open sessions without sizes
+	body, err := json.Marshal(info.Manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Post(s.URL, jsonMIMEType, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected bare manifest to open a session. got: %d", res.StatusCode)
+	}
+	if _, _, err := rem.SessionBytes(ctx, res.Header.Get(sidHeader)); !errors.Is(err, ErrSizesUnknown) {
+		t.Errorf("expected bare manifest session to error with ErrSizesUnknown. got: %v", err)
+	}
+}
+
+func TestHTTPRemoveCIDs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	remote := newMemStore()
+	a := addTestDAG(t, remote, "remove a", 1, 0)
+	b := addTestDAG(t, remote, "remove b", 1, 0)
+	c := addTestDAG(t, remote, "remove c", 1, 0)
+
+	var (
+		lock     sync.Mutex
+		checked  = map[string]bool{}
+		rejected = b.Cid().String()
+	)
+	rem, err := New(remote.nodeGetter(), remote, func(cfg *Config) {
+		cfg.AllowRemoves = true
+		cfg.RemoveCheck = func(_ context.Context, info dag.Info, meta map[string]string) error {
+			id := info.Manifest.Nodes[0]
+			lock.Lock()
+			checked[id] = true
+			lock.Unlock()
+			if meta["reason"] != "deprovision" {
+				return fmt.Errorf("expected meta to be passed to the check")
+			}
+			if id == rejected {
+				return fmt.Errorf("can't remove %s", id)
+			}
+			return nil
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(HTTPRemoteHandler(rem))
+	defer s.Close()
+
+	cli := &HTTPClient{URL: s.URL}
+	ids := []string{a.Cid().String(), b.Cid().String(), c.Cid().String()}
+	res, err := cli.RemoveCIDs(ctx, ids, map[string]string{"reason": "deprovision"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	if len(checked) != len(ids) {
+		t.Errorf("expected remove check to run for all %d cids. ran for: %d", len(ids), len(checked))
+	}
+	lock.Unlock()
+	expect := &RemoveResults{
+		Removed: []string{a.Cid().String(), c.Cid().String()},
+		Failed:  map[string]string{rejected: fmt.Sprintf("can't remove %s", rejected)},
+	}
+	if !reflect.DeepEqual(expect, res) {
+		t.Errorf("results mismatch.\nexpected: %#v\ngot:      %#v", expect, res)
+	}
+
+	// remotes that don't allow removes refuse the whole request
+	noRemoves, err := New(remote.nodeGetter(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2 := httptest.NewServer(HTTPRemoteHandler(noRemoves))
+	defer s2.Close()
+	if _, err := (&HTTPClient{URL: s2.URL}).RemoveCIDs(ctx, ids, nil); !errors.Is(err, ErrRemoveNotSupported) {
+		t.Errorf("expected ErrRemoveNotSupported. got: %v", err)
+	}
+}
+
+// stallingHandler wraps a remote handler, holding requests that transfer
+// blocks open until the client gives up on them. Held requests signal
+// released once they end
+func stallingHandler(h http.Handler, started, released chan<- struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		transfersBlocks := r.Method == http.MethodPut || r.Method == http.MethodPatch || r.FormValue("block") != ""
+		if !transfersBlocks {
+			h.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		defer func() {
+			select {
+			case released <- struct{}{}:
+			default:
+			}
+		}()
+		// servers only notice clients going away once the request body is read,
+		// clients that go away mid-body fail the read
+		if _, err := io.Copy(ioutil.Discard, r.Body); err != nil {
+			return
+		}
+		<-r.Context().Done()
+	})
+}
+
+// contextBlockRemote transfers blocks one at a time, with requests that are
+// aborted when a push is cancelled
+type contextBlockRemote struct {
+	*faultyRemote
+	cli *HTTPClient
+}
+
+func (r contextBlockRemote) ReceiveBlockContext(ctx context.Context, sid, hash string, data []byte) ReceiveResponse {
+	return r.cli.ReceiveBlockContext(ctx, sid, hash, data)
+}
+
+// contextPartsRemote is a contextBlockRemote that also transfers blocks in
+// parts
+type contextPartsRemote struct {
+	contextBlockRemote
+}
+
+func (r contextPartsRemote) ReceiveBlockPart(sid, hash string, offset, size int, data []byte) ReceiveResponse {
+	return r.cli.ReceiveBlockPart(sid, hash, offset, size, data)
+}
+
+func (r contextPartsRemote) ReceiveBlockPartContext(ctx context.Context, sid, hash string, offset, size int, data []byte) ReceiveResponse {
+	return r.cli.ReceiveBlockPartContext(ctx, sid, hash, offset, size, data)
+}
+
+func TestHTTPCancelTransfer(t *testing.T) {
+	local := newMemStore()
+	root := addTestDAG(t, local, "cancel transfer", 2, 2)
+	info, err := dag.NewInfo(context.Background(), local.nodeGetter(), root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cancels do once a transfer starts, failing if do doesn't return
+	// promptly with context.Canceled, or the transfer's request isn't aborted
+	cancelMidTransfer := func(t *testing.T, started, released chan struct{}, do func(ctx context.Context) error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		errCh := make(chan error, 1)
+		go func() { errCh <- do(ctx) }()
+
+		select {
+		case <-started:
+		case <-time.After(time.Second * 5):
+			t.Fatal("timed out waiting for a transfer to start")
+		}
+		cancel()
+		select {
+		case err := <-errCh:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("expected context.Canceled, got: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected cancelled transfer to return within a second")
+		}
+		select {
+		case <-released:
+		case <-time.After(time.Second):
+			t.Fatal("expected cancelled transfer to abort it's request within a second")
+		}
+	}
+
+	// newRemote serves an HTTP remote holding store, signalling started when a
+	// block transfer begins & released when it ends
+	newRemote := func(t *testing.T, store *memStore, started, released chan struct{}, streaming bool) (DagSyncable, func()) {
+		rem, err := New(store.nodeGetter(), store, acceptPushes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := httptest.NewServer(stallingHandler(HTTPRemoteHandler(rem), started, released))
+		cli := &HTTPClient{URL: s.URL}
+		// requests that outlive a failed test would block closing the server
+		closeRemote := func() {
+			s.CloseClientConnections()
+			s.Close()
+		}
+		if streaming {
+			return cli, closeRemote
+		}
+		return contextBlockRemote{newFaultyRemote(cli, 1), cli}, closeRemote
+	}
+
+	for _, streaming := range []bool{true, false} {
+		t.Run(fmt.Sprintf("push, streaming: %t", streaming), func(t *testing.T) {
+			started, released := make(chan struct{}, 1), make(chan struct{}, 1)
+			remote, closeRemote := newRemote(t, newMemStore(), started, released, streaming)
+			defer closeRemote()
+			push, err := NewPush(local.nodeGetter(), info, remote, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cancelMidTransfer(t, started, released, push.Do)
+		})
+
+		t.Run(fmt.Sprintf("pull, streaming: %t", streaming), func(t *testing.T) {
+			started, released := make(chan struct{}, 1), make(chan struct{}, 1)
+			remote, closeRemote := newRemote(t, local, started, released, streaming)
+			defer closeRemote()
+			dst := newMemStore()
+			pull, err := NewPull(root.Cid().String(), dst.nodeGetter(), dst, remote, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cancelMidTransfer(t, started, released, pull.Do)
+		})
+	}
+
+	t.Run("push in parts", func(t *testing.T) {
+		started, released := make(chan struct{}, 1), make(chan struct{}, 1)
+		remote, closeRemote := newRemote(t, newMemStore(), started, released, false)
+		defer closeRemote()
+		push, err := NewPush(local.nodeGetter(), info, contextPartsRemote{remote.(contextBlockRemote)}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		push.chunkSize = 1
+		cancelMidTransfer(t, started, released, push.Do)
+	})
+
+	t.Run("open session", func(t *testing.T) {
+		remote, closeRemote := newRemote(t, newMemStore(), nil, nil, true)
+		defer closeRemote()
+		push, err := NewPush(local.nodeGetter(), info, remote, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := push.Do(ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected opening a session with a cancelled context to return context.Canceled. got: %v", err)
+		}
+	})
+}
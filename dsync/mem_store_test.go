@@ -0,0 +1,331 @@
+package dsync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	"github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/qri-io/dag"
+)
+
+// memStore is an in-memory coreiface.BlockAPI that can also act as an
+// ipld.NodeGetter via the nodeGetter method, letting tests exercise dsync
+// without IPFS nodes. Blocks are addressed the same way an IPFS BlockAPI
+// would, defaulting to CIDv0 for Put calls without options
+type memStore struct {
+	lock     sync.Mutex
+	blocks   map[string][]byte
+	putOrder []string
+	putManys int
+}
+
+var (
+	_ coreiface.BlockAPI = (*memStore)(nil)
+	_ BlockPutManyer     = (*memStore)(nil)
+	_ BlockHaser         = (*memStore)(nil)
+	_ KeyLister          = (*memStore)(nil)
+	_ ipld.NodeGetter    = (*memNodeGetter)(nil)
+)
+
+func newMemStore() *memStore {
+	return &memStore{blocks: map[string][]byte{}}
+}
+
+// Put adds a block to the store
+func (s *memStore) Put(_ context.Context, r io.Reader, opts ...options.BlockPutOption) (coreiface.BlockStat, error) {
+	_, pref, err := options.BlockPutOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	id, err := pref.Sum(data)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	s.blocks[id.KeyString()] = data
+	s.putOrder = append(s.putOrder, id.String())
+	s.lock.Unlock()
+	return memBlockStat{id: id, size: len(data)}, nil
+}
+
+// PutMany adds a batch of blocks to the store under the CIDs they carry
+func (s *memStore) PutMany(_ context.Context, blks []blocks.Block) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, blk := range blks {
+		s.blocks[blk.Cid().KeyString()] = blk.RawData()
+		s.putOrder = append(s.putOrder, blk.Cid().String())
+	}
+	s.putManys++
+	return nil
+}
+
+// Get returns a reader of raw block data
+func (s *memStore) Get(_ context.Context, p path.Path) (io.Reader, error) {
+	id, err := pathCid(p)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := s.rawData(id)
+	if !ok {
+		return nil, ipld.ErrNotFound
+	}
+	return bytes.NewReader(data), nil
+}
+
+// Rm drops a block from the store
+func (s *memStore) Rm(_ context.Context, p path.Path, _ ...options.BlockRmOption) error {
+	id, err := pathCid(p)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	delete(s.blocks, id.KeyString())
+	s.lock.Unlock()
+	return nil
+}
+
+// Stat returns block details, erroring if the block isn't in the store
+func (s *memStore) Stat(_ context.Context, p path.Path) (coreiface.BlockStat, error) {
+	id, err := pathCid(p)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := s.rawData(id)
+	if !ok {
+		return nil, ipld.ErrNotFound
+	}
+	return memBlockStat{id: id, size: len(data)}, nil
+}
+
+// GetNode decodes a stored block into an ipld.Node
+func (s *memStore) GetNode(_ context.Context, id cid.Cid) (ipld.Node, error) {
+	data, ok := s.rawData(id)
+	if !ok {
+		return nil, ipld.ErrNotFound
+	}
+	blk, err := blocks.NewBlockWithCid(data, id)
+	if err != nil {
+		return nil, err
+	}
+	return ipld.Decode(blk)
+}
+
+// GetMany fetches a list of nodes, closing the returned channel when finished
+func (s *memStore) GetMany(ctx context.Context, ids []cid.Cid) <-chan *ipld.NodeOption {
+	ch := make(chan *ipld.NodeOption, len(ids))
+	go func() {
+		defer close(ch)
+		for _, id := range ids {
+			nd, err := s.GetNode(ctx, id)
+			ch <- &ipld.NodeOption{Node: nd, Err: err}
+		}
+	}()
+	return ch
+}
+
+// Has reports if a block is in the store
+func (s *memStore) Has(_ context.Context, id cid.Cid) (bool, error) {
+	return s.has(id), nil
+}
+
+func (s *memStore) has(id cid.Cid) bool {
+	_, ok := s.rawData(id)
+	return ok
+}
+
+// AllKeysChan lists the CIDs of all blocks in the store
+func (s *memStore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	s.lock.Lock()
+	ids := make([]cid.Cid, 0, len(s.blocks))
+	for key := range s.blocks {
+		id, err := cid.Cast([]byte(key))
+		if err != nil {
+			s.lock.Unlock()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	s.lock.Unlock()
+
+	ch := make(chan cid.Cid)
+	go func() {
+		defer close(ch)
+		for _, id := range ids {
+			select {
+			case ch <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// Puts returns a copy of the CIDs of all Put calls, in call order
+func (s *memStore) Puts() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.putOrder...)
+}
+
+func (s *memStore) rawData(id cid.Cid) ([]byte, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	data, ok := s.blocks[id.KeyString()]
+	return data, ok
+}
+
+// putNode adds a node directly to the store, without recording a Put call
+func (s *memStore) putNode(nd ipld.Node) {
+	s.lock.Lock()
+	s.blocks[nd.Cid().KeyString()] = nd.RawData()
+	s.lock.Unlock()
+}
+
+// nodeGetter wraps the store in a type that only exposes the ipld.NodeGetter
+// method set
+func (s *memStore) nodeGetter() ipld.NodeGetter {
+	return memNodeGetter{s}
+}
+
+type memNodeGetter struct{ s *memStore }
+
+func (ng memNodeGetter) Get(ctx context.Context, id cid.Cid) (ipld.Node, error) {
+	return ng.s.GetNode(ctx, id)
+}
+
+func (ng memNodeGetter) GetMany(ctx context.Context, ids []cid.Cid) <-chan *ipld.NodeOption {
+	return ng.s.GetMany(ctx, ids)
+}
+
+func pathCid(p path.Path) (cid.Cid, error) {
+	if r, ok := p.(path.Resolved); ok {
+		return r.Cid(), nil
+	}
+	str := strings.TrimPrefix(p.String(), "/ipfs/")
+	return cid.Decode(strings.TrimPrefix(str, "/ipld/"))
+}
+
+type memBlockStat struct {
+	id   cid.Cid
+	size int
+}
+
+func (bs memBlockStat) Size() int           { return bs.size }
+func (bs memBlockStat) Path() path.Resolved { return path.IpldPath(bs.id) }
+
+// addTestDAG writes a tree of dag-pb nodes to store, each node having fanout
+// children down to the given depth. seed distinguishes the content of
+// otherwise-identically shaped trees. addTestDAG returns the root node
+func addTestDAG(t testing.TB, store *memStore, seed string, fanout, depth int) ipld.Node {
+	t.Helper()
+	var build func(prefix string, d int) ipld.Node
+	build = func(prefix string, d int) ipld.Node {
+		nd := merkledag.NodeWithData([]byte(prefix))
+		if d < depth {
+			for i := 0; i < fanout; i++ {
+				ch := build(fmt.Sprintf("%s.%d", prefix, i), d+1)
+				if err := nd.AddNodeLink(fmt.Sprintf("%d", i), ch); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		store.putNode(nd)
+		return nd
+	}
+	return build(seed, 0)
+}
+
+// newTestDAG builds a test DAG in a new memStore, returning the store, the
+// root node & the DAG's info
+func newTestDAG(t testing.TB, seed string, fanout, depth int) (*memStore, ipld.Node, *dag.Info) {
+	t.Helper()
+	store := newMemStore()
+	root := addTestDAG(t, store, seed, fanout, depth)
+	info, err := dag.NewInfo(context.Background(), store.nodeGetter(), root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, root, info
+}
+
+// acceptPushes configures a Dsync to accept all pushes
+func acceptPushes(cfg *Config) {
+	cfg.PushPreCheck = func(context.Context, dag.Info, map[string]string) error { return nil }
+}
+
+// newTestRemote creates a Dsync backed by a new memStore that accepts all
+// pushes. opts are applied after acceptPushes, so they can replace it
+func newTestRemote(t testing.TB, opts ...func(cfg *Config)) (*Dsync, *memStore) {
+	t.Helper()
+	store := newMemStore()
+	ds, err := New(store.nodeGetter(), store, append([]func(cfg *Config){acceptPushes}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ds, store
+}
+
+// memPinAPI records pins in memory. Only Add, Rm & IsPinned are implemented
+type memPinAPI struct {
+	coreiface.PinAPI
+	lock sync.Mutex
+	pins map[string]bool
+}
+
+func newMemPinAPI() *memPinAPI {
+	return &memPinAPI{pins: map[string]bool{}}
+}
+
+func (p *memPinAPI) Add(_ context.Context, pth path.Path, _ ...options.PinAddOption) error {
+	id, err := pathCid(pth)
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	p.pins[id.String()] = true
+	p.lock.Unlock()
+	return nil
+}
+
+func (p *memPinAPI) Rm(_ context.Context, pth path.Path, _ ...options.PinRmOption) error {
+	id, err := pathCid(pth)
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	delete(p.pins, id.String())
+	p.lock.Unlock()
+	return nil
+}
+
+func (p *memPinAPI) IsPinned(_ context.Context, pth path.Path, _ ...options.PinIsPinnedOption) (string, bool, error) {
+	id, err := pathCid(pth)
+	if err != nil {
+		return "", false, err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.pins[id.String()] {
+		return "recursive", true, nil
+	}
+	return "", false, nil
+}
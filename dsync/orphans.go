@@ -0,0 +1,66 @@
+package dsync
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// KeyLister is a store that can list the CIDs of all blocks it holds, like
+// go-ipfs-blockstore's Blockstore
+type KeyLister interface {
+	AllKeysChan(ctx context.Context) (<-chan cid.Cid, error)
+}
+
+// FindOrphans lists blocks in a store that aren't reachable from any of the
+// given pinned roots, in CID string order. Interrupted pushes & pulls can
+// leave orphaned blocks behind, FindOrphans identifies them for cleanup.
+// ng must read from the same store keys lists. All blocks reachable from a
+// root must be present, an incomplete DAG returns an error instead of
+// reporting blocks that may belong to it as orphans
+func FindOrphans(ctx context.Context, ng ipld.NodeGetter, keys KeyLister, pinnedRoots []cid.Cid) ([]cid.Cid, error) {
+	reachable := map[string]bool{}
+	queue := make([]cid.Cid, 0, len(pinnedRoots))
+	for _, id := range pinnedRoots {
+		if !reachable[id.KeyString()] {
+			reachable[id.KeyString()] = true
+			queue = append(queue, id)
+		}
+	}
+
+	for len(queue) > 0 {
+		nd, err := ng.Get(ctx, queue[0])
+		if err != nil {
+			return nil, err
+		}
+		queue = queue[1:]
+		for _, l := range nd.Links() {
+			if !reachable[l.Cid.KeyString()] {
+				reachable[l.Cid.KeyString()] = true
+				queue = append(queue, l.Cid)
+			}
+		}
+	}
+
+	ch, err := keys.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []cid.Cid
+	for id := range ch {
+		if !reachable[id.KeyString()] {
+			orphans = append(orphans, id)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		// key listing stops early on cancellation
+		return nil, err
+	}
+
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].String() < orphans[j].String()
+	})
+	return orphans, nil
+}
@@ -0,0 +1,53 @@
+package dsync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+)
+
+func TestFindOrphans(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+
+	a := addTestDAG(t, store, "pinned_a", 2, 2)
+	b := addTestDAG(t, store, "pinned_b", 3, 1)
+	// blocks of an interrupted transfer: a parent that never arrived leaves
+	// it's children unreachable
+	orphanRoot := addTestDAG(t, store, "orphaned", 2, 1)
+	store.lock.Lock()
+	delete(store.blocks, orphanRoot.Cid().KeyString())
+	store.lock.Unlock()
+	stray := merkledag.NodeWithData([]byte("stray"))
+	store.putNode(stray)
+
+	expect := []string{stray.Cid().String()}
+	for _, l := range orphanRoot.Links() {
+		expect = append(expect, l.Cid.String())
+	}
+	sort.Strings(expect)
+
+	orphans, err := FindOrphans(ctx, store.nodeGetter(), store, []cid.Cid{a.Cid(), b.Cid()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, id := range orphans {
+		got = append(got, id.String())
+	}
+	if fmt.Sprintf("%v", expect) != fmt.Sprintf("%v", got) {
+		t.Errorf("orphans mismatch. expected: %v, got: %v", expect, got)
+	}
+
+	// a root with missing blocks
+	store.lock.Lock()
+	delete(store.blocks, b.Links()[0].Cid.KeyString())
+	store.lock.Unlock()
+	if _, err := FindOrphans(ctx, store.nodeGetter(), store, []cid.Cid{a.Cid(), b.Cid()}); err == nil {
+		t.Error("expected an incomplete pinned DAG to error")
+	}
+}
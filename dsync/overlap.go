@@ -0,0 +1,53 @@
+package dsync
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/qri-io/dag"
+)
+
+// EstimateOverlap estimates the fraction of the nodes in m a remote already
+// has, between 0.0 and 1.0, by probing the remote for a random sample of
+// sampleSize nodes. No blocks are transferred, and the probe doesn't open a
+// session, so the remote must accept sessionless probes. Sample sizes at or
+// above the number of nodes in m probe every node & give an exact answer.
+// Use OverlapMarginOfError to gauge how far an estimate can be trusted
+func EstimateOverlap(ctx context.Context, remote BlockProber, m *dag.Manifest, sampleSize int) (float64, error) {
+	if len(m.Nodes) == 0 {
+		return 0, fmt.Errorf("manifest has no nodes")
+	}
+	if sampleSize < 1 {
+		return 0, fmt.Errorf("sample size must be at least one")
+	}
+
+	sample := m.Nodes
+	if sampleSize < len(m.Nodes) {
+		sample = make([]string, sampleSize)
+		for i, idx := range rand.Perm(len(m.Nodes))[:sampleSize] {
+			sample[i] = m.Nodes[idx]
+		}
+	}
+
+	have, err := remote.ProbeBlocks(ctx, "", sample)
+	if err != nil {
+		return 0, err
+	}
+	return float64(len(have)) / float64(len(sample)), nil
+}
+
+// OverlapMarginOfError returns the margin of error at 95% confidence of an
+// overlap estimate made from a sample of sampleSize out of total nodes. The
+// true overlap is within estimate plus or minus the margin 95% of the time.
+// Exhaustive samples have no margin of error
+func OverlapMarginOfError(estimate float64, sampleSize, total int) float64 {
+	if sampleSize <= 0 || total <= 1 || sampleSize >= total {
+		return 0
+	}
+	n, size := float64(sampleSize), float64(total)
+	// normal approximation, corrected for sampling without replacement
+	stdErr := math.Sqrt(estimate*(1-estimate)/n) * math.Sqrt((size-n)/(size-1))
+	return 1.96 * stdErr
+}
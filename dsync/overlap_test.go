@@ -0,0 +1,87 @@
+package dsync
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/qri-io/dag"
+)
+
+func TestEstimateOverlap(t *testing.T) {
+	ctx := context.Background()
+
+	local := newMemStore()
+	root := addTestDAG(t, local, "overlap", 20, 2)
+	m, err := dag.NewManifest(ctx, local.nodeGetter(), root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the remote has 30% of the DAG
+	remote := newMemStore()
+	shared := len(m.Nodes) * 3 / 10
+	for _, idStr := range m.Nodes[:shared] {
+		id, _ := cid.Parse(idStr)
+		data, _ := local.rawData(id)
+		remote.blocks[id.KeyString()] = data
+	}
+	expect := float64(shared) / float64(len(m.Nodes))
+
+	ds, err := New(remote.nodeGetter(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(HTTPRemoteHandler(ds))
+	defer s.Close()
+
+	remotes := []struct {
+		description string
+		prober      BlockProber
+	}{
+		{"direct", ds},
+		{"http", &HTTPClient{URL: s.URL}},
+	}
+	for _, r := range remotes {
+		t.Run(r.description, func(t *testing.T) {
+			exact, err := EstimateOverlap(ctx, r.prober, m, len(m.Nodes))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exact != expect {
+				t.Errorf("expected exhaustive sample to be exact. want: %f, got: %f", expect, exact)
+			}
+
+			sampleSize := 100
+			est, err := EstimateOverlap(ctx, r.prober, m, sampleSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// tolerate well beyond the 95% margin so the test doesn't flake
+			if math.Abs(est-expect) > 0.15 {
+				t.Errorf("estimate %f is too far from %f", est, expect)
+			}
+			if margin := OverlapMarginOfError(est, sampleSize, len(m.Nodes)); margin <= 0 || margin > 0.15 {
+				t.Errorf("unexpected margin of error for a sample of %d: %f", sampleSize, margin)
+			}
+		})
+	}
+
+	if margin := OverlapMarginOfError(expect, len(m.Nodes), len(m.Nodes)); margin != 0 {
+		t.Errorf("expected exhaustive samples to have no margin of error. got: %f", margin)
+	}
+
+	errDenied := errors.New("denied")
+	private, err := New(remote.nodeGetter(), remote, func(cfg *Config) {
+		cfg.GetBlockCheck = func(context.Context, dag.Info, map[string]string) error { return errDenied }
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EstimateOverlap(ctx, private, m, 10); !errors.Is(err, errDenied) {
+		t.Errorf("expected sessionless probes to be screened by GetBlockCheck. got: %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"sync"
 
 	host "github.com/libp2p/go-libp2p-core/host"
 	net "github.com/libp2p/go-libp2p-core/network"
@@ -126,6 +127,9 @@ func (c *p2pClient) GetDagInfo(ctx context.Context, cidStr string, meta map[stri
 	if err != nil {
 		return nil, err
 	}
+	if e := res.Header("error"); e != "" {
+		return nil, fmt.Errorf("%s", e)
+	}
 
 	info = &dag.Info{}
 	err = codec.NewDecoder(bytes.NewReader(res.Body), &codec.CborHandle{}).Decode(info)
@@ -135,12 +139,16 @@ func (c *p2pClient) GetDagInfo(ctx context.Context, cidStr string, meta map[stri
 // GetBlock gets a block of data from the remote
 func (c *p2pClient) GetBlock(ctx context.Context, cidStr string) (rawdata []byte, err error) {
 	msg := p2putil.NewMessage(c.host.ID(), mtGetBlock, nil).WithHeaders(
+		"phase", "request",
 		"cid", cidStr,
 	)
 	res, err := c.sendMessage(ctx, msg, c.remotePeerID)
 	if err != nil {
 		return nil, err
 	}
+	if e := res.Header("error"); e != "" {
+		return nil, fmt.Errorf("%s", e)
+	}
 	return res.Body, nil
 }
 
@@ -165,6 +173,71 @@ func (c *p2pClient) RemoveCID(ctx context.Context, cidStr string, meta map[strin
 	return nil
 }
 
+// Libp2pClient is the request side of doing dsync over libp2p streams, the
+// libp2p counterpart of HTTPClient. Pushes & pulls work the same on either
+// transport. Libp2pClient only opens streams to the remote, the local host
+// needn't run a dsync protocol handler
+type Libp2pClient struct {
+	// Host is the local libp2p host streams are opened from
+	Host host.Host
+	// PeerID identifies the remote, which must be reachable from Host
+	PeerID peer.ID
+
+	once   sync.Once
+	client *p2pClient
+}
+
+// assert at compile time that Libp2pClient implements DagSyncable
+var _ DagSyncable = (*Libp2pClient)(nil)
+
+func (c *Libp2pClient) remote() *p2pClient {
+	c.once.Do(func() {
+		c.client = &p2pClient{remotePeerID: c.PeerID, p2pHandler: &p2pHandler{host: c.Host}}
+	})
+	return c.client
+}
+
+// NewReceiveSession initiates a session for pushing blocks to the remote
+func (c *Libp2pClient) NewReceiveSession(info *dag.Info, pinOnComplete bool, meta map[string]string) (sid string, diff *dag.Manifest, err error) {
+	return c.remote().NewReceiveSession(info, pinOnComplete, meta)
+}
+
+// ProtocolVersion indicates the version of dsync the remote speaks, only
+// available after a stream to the remote has been opened
+func (c *Libp2pClient) ProtocolVersion() (protocol.ID, error) {
+	return c.remote().ProtocolVersion()
+}
+
+// ReceiveBlock places a block on the remote
+func (c *Libp2pClient) ReceiveBlock(sid, hash string, data []byte) ReceiveResponse {
+	return c.remote().ReceiveBlock(sid, hash, data)
+}
+
+// GetDagInfo asks the remote for info specified by a the root identifier
+// string of a DAG
+func (c *Libp2pClient) GetDagInfo(ctx context.Context, cidStr string, meta map[string]string) (*dag.Info, error) {
+	return c.remote().GetDagInfo(ctx, cidStr, meta)
+}
+
+// GetBlock gets a block of data from the remote
+func (c *Libp2pClient) GetBlock(ctx context.Context, hash string) ([]byte, error) {
+	return c.remote().GetBlock(ctx, hash)
+}
+
+// RemoveCID asks the remote to remove a CID
+func (c *Libp2pClient) RemoveCID(ctx context.Context, cidStr string, meta map[string]string) error {
+	return c.remote().RemoveCID(ctx, cidStr, meta)
+}
+
+// Libp2pRemoteHandler exposes a Dsync remote over libp2p by registering a
+// stream handler for the dsync protocol on host, the libp2p counterpart of
+// HTTPRemoteHandler. Dsync instances configured with a Libp2pHost register
+// their handler when the remote is started
+func Libp2pRemoteHandler(h host.Host, ds *Dsync) {
+	handler := newp2pHandler(ds, h)
+	h.SetStreamHandler(DsyncProtocolID, handler.LibP2PStreamHandler)
+}
+
 // p2pHandler implements dsync as a libp2p protocol handler
 type p2pHandler struct {
 	dsync            *Dsync
@@ -205,21 +278,34 @@ func (c *p2pHandler) sendMessage(ctx context.Context, msg p2putil.Message, pid p
 	// rem.host.ConnManager().TagPeer(pid, dsyncSupportKey, dsyncSupportValue)
 
 	ws := p2putil.WrapStream(s)
-	replies := make(chan p2putil.Message)
+	replies := make(chan p2putil.Message, 1)
 	go c.handleStream(ws, replies)
 	if err := ws.SendMessage(msg); err != nil {
 		return p2putil.Message{}, err
 	}
 
-	reply := <-replies
-	return reply, nil
+	select {
+	case reply, ok := <-replies:
+		if !ok {
+			return p2putil.Message{}, fmt.Errorf("stream closed before %s response", msg.Type)
+		}
+		return reply, nil
+	case <-ctx.Done():
+		return p2putil.Message{}, ctx.Err()
+	}
 }
 
 // handleStream is a loop which receives and handles messages
 // When Message.HangUp is true, it exits. This will close the stream
 // on one of the sides. The other side's receiveMessage() will error
 // with EOF, thus also breaking out from the loop.
+// Requests are dispatched to handlers by message type. Responses answer the
+// request sent on a stream, they're delivered on replies & end the stream.
+// replies is closed when the loop exits
 func (c *p2pHandler) handleStream(ws *p2putil.WrappedStream, replies chan p2putil.Message) {
+	if replies != nil {
+		defer close(replies)
+	}
 	for {
 		// Loop forever, receiving messages until the other end hangs up
 		// or something goes wrong
@@ -233,8 +319,11 @@ func (c *p2pHandler) handleStream(ws *p2putil.WrappedStream, replies chan p2puti
 			break
 		}
 
-		if replies != nil {
-			go func() { replies <- msg }()
+		if msg.Header("phase") == "response" {
+			if replies != nil {
+				replies <- msg
+			}
+			break
 		}
 
 		handler, ok := c.handlers[msg.Type]
@@ -339,6 +428,9 @@ func (c *p2pHandler) HandleReceiveBlock(ws *p2putil.WrappedStream, msg p2putil.M
 
 // HandleReqManifest asks the remote for a manifest specified by the root ID of a DAG
 func (c *p2pHandler) HandleReqManifest(ws *p2putil.WrappedStream, msg p2putil.Message) (hangup bool) {
+	if msg.Header("phase") != "request" {
+		return false
+	}
 	cidStr := msg.Header("cid")
 	res := msg.WithHeaders("phase", "response")
 
@@ -351,13 +443,14 @@ func (c *p2pHandler) HandleReqManifest(ws *p2putil.WrappedStream, msg p2putil.Me
 
 	// TODO (b5): pass a context into here
 	if di, err := c.dsync.GetDagInfo(context.Background(), cidStr, meta); err != nil {
-		res = res.WithHeaders("error", err.Error())
+		res = msg.WithHeaders("phase", "response", "error", err.Error())
 	} else {
 		data, err := di.MarshalCBOR()
 		if err != nil {
-			return
+			res = msg.WithHeaders("phase", "response", "error", err.Error())
+		} else {
+			res = res.Update(data)
 		}
-		res = res.Update(data)
 	}
 
 	if err := ws.SendMessage(res); err != nil {
@@ -368,13 +461,16 @@ func (c *p2pHandler) HandleReqManifest(ws *p2putil.WrappedStream, msg p2putil.Me
 
 // HandleGetBlock gets a block from the remote
 func (c *p2pHandler) HandleGetBlock(ws *p2putil.WrappedStream, msg p2putil.Message) (hangup bool) {
+	if msg.Header("phase") != "request" {
+		return false
+	}
 	cidStr := msg.Header("cid")
 	res := msg.WithHeaders("phase", "response")
 
 	// TODO (b5) - plumb a context in here
 	data, err := c.dsync.GetBlock(context.Background(), cidStr)
 	if err != nil {
-		res = res.WithHeaders("error", err.Error())
+		res = msg.WithHeaders("phase", "response", "error", err.Error())
 	} else {
 		res = res.Update(data)
 	}
@@ -403,7 +499,7 @@ func (c *p2pHandler) HandleRemoveCID(ws *p2putil.WrappedStream, msg p2putil.Mess
 		)
 
 		if err := c.dsync.RemoveCID(context.Background(), cid, meta); err != nil {
-			res = msg.WithHeaders("error", err.Error())
+			res = msg.WithHeaders("phase", "response", "cid", cid, "error", err.Error())
 		}
 
 		if err := ws.SendMessage(res); err != nil {
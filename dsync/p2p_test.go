@@ -3,8 +3,11 @@ package dsync
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"strings"
 	"testing"
 
+	files "github.com/ipfs/go-ipfs-files"
 	core "github.com/ipfs/go-ipfs/core"
 	coreapi "github.com/ipfs/interface-go-ipfs-core"
 	"github.com/ipfs/interface-go-ipfs-core/path"
@@ -128,3 +131,61 @@ func mustNewLocalRemoteIPFSNode(ctx context.Context) (ipfsA, ipfsB *core.IpfsNod
 
 	return nd[0], nd[1], ca[0], ca[1]
 }
+
+func TestLibp2pClient(t *testing.T) {
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+
+	nodeA, nodeB, capiA, capiB := mustNewLocalRemoteIPFSNode(ctx)
+	aLocalDS, err := NewLocalNodeGetter(capiA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bLocalDS, err := NewLocalNodeGetter(capiB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nodeB serves dsync over libp2p without being configured with a host
+	bDsync, err := New(bLocalDS, capiB.Block(), acceptPushes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Libp2pRemoteHandler(nodeB.PeerHost, bDsync)
+	client := &Libp2pClient{Host: nodeA.PeerHost, PeerID: nodeB.Identity}
+
+	// push a DAG from nodeA to nodeB
+	pushed := mustAddOneBlockDAG(capiA)
+	info, err := dag.NewInfo(ctx, aLocalDS, pushed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	push, err := NewPush(aLocalDS, info, client, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := push.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := capiB.Block().Get(ctx, path.New(pushed.String())); err != nil {
+		t.Errorf("expected pushed block to be on nodeB. error: %s", err)
+	}
+
+	// pull a DAG from nodeB to nodeA
+	f := files.NewReaderFile(ioutil.NopCloser(strings.NewReader("only on nodeB")))
+	added, err := capiB.Unixfs().Add(ctx, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pulled := added.Cid()
+	pull, err := NewPull(pulled.String(), aLocalDS, capiA.Block(), client, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pull.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := capiA.Block().Get(ctx, path.New(pulled.String())); err != nil {
+		t.Errorf("expected pulled block to be on nodeA. error: %s", err)
+	}
+}
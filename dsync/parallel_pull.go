@@ -0,0 +1,157 @@
+package dsync
+
+import (
+	"context"
+	"sort"
+
+	"github.com/qri-io/dag"
+)
+
+// ParallelPull executes a pull over several concurrent block streams, each
+// scoped to a disjoint set of sub-DAGs. The missing blocks of a pull are
+// partitioned by the subtree of the DAG root they belong to, and partitions
+// are balanced across streams by block count. Pulling a wide DAG this way
+// spreads the work of reading, hashing & writing blocks over many
+// connections.
+//
+// Streams request a manifest of only the blocks in their partition, so
+// remotes must be able to stream arbitrary subsets of a DAG, as Dsync and
+// HTTPClient do. Remotes that don't support block streaming are pulled
+// block-by-block
+type ParallelPull struct {
+	*Pull
+	streams int
+}
+
+// NewParallelPull wraps a pull to fetch blocks over a number of concurrent
+// streams. streams less than one is treated as one
+func NewParallelPull(pull *Pull, streams int) *ParallelPull {
+	if streams < 1 {
+		streams = 1
+	}
+	return &ParallelPull{Pull: pull, streams: streams}
+}
+
+// Do executes the pull, blocking until all streams are complete. Progress
+// from all streams is combined into a single completion reported on Updates
+func (p *ParallelPull) Do(ctx context.Context) error {
+	f := p.Pull
+	defer func() { f.progress.finish(f.completion()) }()
+	if err := f.ready(ctx); err != nil {
+		return err
+	}
+	if f.completion().Complete() {
+		return nil
+	}
+
+	protoID, err := f.remote.ProtocolVersion()
+	if err != nil {
+		return err
+	}
+	streamable, ok := f.remote.(DagStreamable)
+	if !protocolSupportsDagStreaming(protoID) || !ok {
+		return f.do(ctx)
+	}
+
+	parts := partitionManifest(f.info.Manifest, f.diff, p.streams)
+	infos := make([]*dag.Info, len(parts))
+	for i, part := range parts {
+		infos[i] = &dag.Info{Manifest: part}
+	}
+	log.Debugf("pulling %d blocks over %d streams", len(f.diff.Nodes), len(infos))
+	return f.pullStreams(ctx, streamable, infos)
+}
+
+// partitionManifest splits the nodes of diff into at most n disjoint
+// manifests, using the structure of the full DAG manifest m. Each child of
+// the root & all nodes below it form a sub-DAG, with nodes reachable from
+// more than one child assigned to the first child in link order. Sub-DAGs
+// are placed whole, largest first, into the partition with the fewest nodes.
+// Nodes keep their manifest order within a partition, and empty partitions
+// are dropped
+func partitionManifest(m, diff *dag.Manifest, n int) []*dag.Manifest {
+	missing := make(map[string]bool, len(diff.Nodes))
+	for _, id := range diff.Nodes {
+		missing[id] = true
+	}
+
+	children := make([][]int, len(m.Nodes))
+	for _, l := range m.Links {
+		children[l[0]] = append(children[l[0]], l[1])
+	}
+
+	// subtree[i] is the index of the sub-DAG node i belongs to. the root & any
+	// node unreachable from it belong to sub-DAG zero
+	subtree := make([]int, len(m.Nodes))
+	for i := range subtree {
+		subtree[i] = -1
+	}
+	var sizes []int
+	if len(m.Nodes) > 0 {
+		for _, child := range children[0] {
+			if subtree[child] != -1 {
+				continue
+			}
+			st := len(sizes)
+			sizes = append(sizes, 0)
+			queue := []int{child}
+			subtree[child] = st
+			for len(queue) > 0 {
+				idx := queue[0]
+				queue = queue[1:]
+				if missing[m.Nodes[idx]] {
+					sizes[st]++
+				}
+				for _, ch := range children[idx] {
+					if subtree[ch] == -1 {
+						subtree[ch] = st
+						queue = append(queue, ch)
+					}
+				}
+			}
+		}
+	}
+
+	// assign sub-DAGs to partitions, largest first
+	order := make([]int, len(sizes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return sizes[order[i]] > sizes[order[j]] })
+
+	partOf := make([]int, len(sizes))
+	loads := make([]int, n)
+	for _, st := range order {
+		lightest := 0
+		for i, load := range loads {
+			if load < loads[lightest] {
+				lightest = i
+			}
+		}
+		partOf[st] = lightest
+		loads[lightest] += sizes[st]
+	}
+
+	parts := make([]*dag.Manifest, n)
+	for i := range parts {
+		parts[i] = &dag.Manifest{}
+	}
+	for i, id := range m.Nodes {
+		if !missing[id] {
+			continue
+		}
+		part := 0
+		if subtree[i] != -1 {
+			part = partOf[subtree[i]]
+		}
+		parts[part].Nodes = append(parts[part].Nodes, id)
+	}
+
+	nonEmpty := parts[:0]
+	for _, part := range parts {
+		if len(part.Nodes) > 0 {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+	return nonEmpty
+}
@@ -0,0 +1,95 @@
+package dsync
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/qri-io/dag"
+)
+
+// scopedStreamRemote records the manifests of block streams it's asked for
+type scopedStreamRemote struct {
+	*Dsync
+	lock    sync.Mutex
+	streams []*dag.Manifest
+}
+
+func (rem *scopedStreamRemote) OpenBlockStream(ctx context.Context, info *dag.Info, meta map[string]string) (io.ReadCloser, error) {
+	rem.lock.Lock()
+	rem.streams = append(rem.streams, info.Manifest)
+	rem.lock.Unlock()
+	return rem.Dsync.OpenBlockStream(ctx, info, meta)
+}
+
+func TestParallelPull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	remote := newMemStore()
+	root := addTestDAG(t, remote, "wide", 12, 2)
+	ds, err := New(remote.nodeGetter(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rem := &scopedStreamRemote{Dsync: ds}
+	info, err := dag.NewInfo(ctx, remote.nodeGetter(), root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local := newMemStore()
+	pull, err := NewPull(root.Cid().String(), local.nodeGetter(), local, rem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pp := NewParallelPull(pull, 4)
+	if err := pp.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := dag.NewManifest(ctx, local.nodeGetter(), root.Cid())
+	if err != nil {
+		t.Fatalf("expected DAG to be complete locally after pull. error: %s", err)
+	}
+	if len(got.Nodes) != len(info.Manifest.Nodes) {
+		t.Errorf("expected %d nodes locally. got: %d", len(info.Manifest.Nodes), len(got.Nodes))
+	}
+	if !pp.completion().Complete() {
+		t.Errorf("expected pull progress to be complete. got: %v", pp.completion())
+	}
+
+	if len(rem.streams) != 4 {
+		t.Fatalf("expected 4 streams. got: %d", len(rem.streams))
+	}
+	seen := map[string]bool{}
+	for _, m := range rem.streams {
+		// 12 sub-DAGs of 13 blocks, plus the root, over 4 streams
+		if len(m.Nodes) < 39 || len(m.Nodes) > 40 {
+			t.Errorf("expected streams to be balanced. got stream of %d blocks", len(m.Nodes))
+		}
+		for _, id := range m.Nodes {
+			if seen[id] {
+				t.Errorf("block %s requested in more than one stream", id)
+			}
+			seen[id] = true
+		}
+	}
+	if len(seen) != len(info.Manifest.Nodes) {
+		t.Errorf("expected streams to cover %d blocks. got: %d", len(info.Manifest.Nodes), len(seen))
+	}
+
+	// pulling again requests nothing
+	rem.streams = nil
+	pull, err = NewPull(root.Cid().String(), local.nodeGetter(), local, rem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewParallelPull(pull, 4).Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(rem.streams) != 0 {
+		t.Errorf("expected a complete DAG to open no streams. got: %d", len(rem.streams))
+	}
+}
@@ -0,0 +1,102 @@
+package dsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+)
+
+// PinRegistry is the interface for a persistent record of the roots a dsync
+// instance pins on completion of a push. Dsync reads the registry when it's
+// created, so ManagedRoots & ManagedPins survive restarts
+type PinRegistry interface {
+	// PutPin records root as pinned, overwriting any previous entry
+	PutPin(ctx context.Context, pin ManagedPin) error
+	// RemovePin forgets root. Removing a root that isn't recorded isn't an
+	// error
+	RemovePin(ctx context.Context, root cid.Cid) error
+	// Pins lists all recorded pins
+	Pins(ctx context.Context) ([]ManagedPin, error)
+}
+
+// FilePinRegistry is a PinRegistry that keeps pins in a JSON file
+type FilePinRegistry struct {
+	path string
+	lock sync.Mutex
+	pins map[string]ManagedPin
+}
+
+var _ PinRegistry = (*FilePinRegistry)(nil)
+
+// NewFilePinRegistry creates a PinRegistry backed by the file at path,
+// reading any pins the file already holds. The file is created on the first
+// write
+func NewFilePinRegistry(path string) (*FilePinRegistry, error) {
+	r := &FilePinRegistry{path: path, pins: map[string]ManagedPin{}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var pins []ManagedPin
+	if err := json.Unmarshal(data, &pins); err != nil {
+		return nil, fmt.Errorf("decoding pin registry: %w", err)
+	}
+	for _, pin := range pins {
+		r.pins[pin.Root.String()] = pin
+	}
+	return r, nil
+}
+
+// PutPin records a pin, writing the registry file
+func (r *FilePinRegistry) PutPin(_ context.Context, pin ManagedPin) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.pins[pin.Root.String()] = pin
+	return r.write()
+}
+
+// RemovePin forgets root, writing the registry file
+func (r *FilePinRegistry) RemovePin(_ context.Context, root cid.Cid) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.pins[root.String()]; !ok {
+		return nil
+	}
+	delete(r.pins, root.String())
+	return r.write()
+}
+
+// Pins lists recorded pins in root CID string order
+func (r *FilePinRegistry) Pins(_ context.Context) ([]ManagedPin, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.sorted(), nil
+}
+
+func (r *FilePinRegistry) sorted() []ManagedPin {
+	pins := make([]ManagedPin, 0, len(r.pins))
+	for _, pin := range r.pins {
+		pins = append(pins, pin)
+	}
+	sort.Slice(pins, func(i, j int) bool { return pins[i].Root.String() < pins[j].Root.String() })
+	return pins
+}
+
+// write replaces the registry file with the current pins
+func (r *FilePinRegistry) write() error {
+	data, err := json.Marshal(r.sorted())
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Dir(r.path), r.path, data)
+}
@@ -0,0 +1,113 @@
+package dsync
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFilePinRegistry(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "dsync_pin_registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pins.json")
+
+	store := newMemStore()
+	a := addTestDAG(t, store, "registry_a", 1, 0).Cid()
+	b := addTestDAG(t, store, "registry_b", 1, 0).Cid()
+
+	reg, err := NewFilePinRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pins, err := reg.Pins(ctx); err != nil || len(pins) != 0 {
+		t.Fatalf("expected new registry to be empty. got: %v, %v", pins, err)
+	}
+	for _, pin := range []ManagedPin{{Root: a, Name: "a"}, {Root: b}} {
+		if err := reg.PutPin(ctx, pin); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := reg.RemovePin(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.RemovePin(ctx, b); err != nil {
+		t.Errorf("expected removing an unrecorded root not to error. got: %v", err)
+	}
+
+	reopened, err := NewFilePinRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pins, err := reopened.Pins(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []ManagedPin{{Root: a, Name: "a"}}; !reflect.DeepEqual(expect, pins) {
+		t.Errorf("reopened pins mismatch.\nexpected: %v\ngot:      %v", expect, pins)
+	}
+}
+
+func TestManagedPinsSurviveRestart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "dsync_pin_registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pins.json")
+
+	local, root, info := newTestDAG(t, "restart", 2, 1)
+
+	remote := newMemStore()
+	pinAPI := newMemPinAPI()
+	newRemote := func() *Dsync {
+		reg, err := NewFilePinRegistry(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rem, err := New(remote.nodeGetter(), remote, acceptPushes, func(cfg *Config) {
+			cfg.PinAPI = pinAPI
+			cfg.PinRegistry = reg
+			cfg.AllowRemoves = true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rem
+	}
+
+	rem := newRemote()
+	s := httptest.NewServer(HTTPRemoteHandler(rem))
+	defer s.Close()
+	push, err := NewPush(local.nodeGetter(), info, &HTTPClient{URL: s.URL}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	push.SetMeta(map[string]string{PinNameMetaKey: "restart"})
+	if err := push.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newRemote()
+	expect := []ManagedPin{{Root: root.Cid(), Name: "restart"}}
+	if pins := restarted.ManagedPins(); !reflect.DeepEqual(expect, pins) {
+		t.Errorf("expected pins to survive a restart.\nexpected: %v\ngot:      %v", expect, pins)
+	}
+
+	if err := restarted.RemoveCID(ctx, root.Cid().String(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if roots := newRemote().ManagedRoots(); len(roots) != 0 {
+		t.Errorf("expected removed root to stay removed after a restart. got: %v", roots)
+	}
+}
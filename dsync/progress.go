@@ -0,0 +1,194 @@
+package dsync
+
+import (
+	"sync"
+	"time"
+
+	"github.com/qri-io/dag"
+)
+
+// updateThrottle limits completion updates to at most one per interval. Nil
+// throttles & throttles with an interval of zero don't limit updates
+type updateThrottle struct {
+	interval time.Duration
+
+	lock     sync.Mutex
+	last     time.Time
+	trailing bool // a trailing send is scheduled
+	final    bool // the final update has been sent
+}
+
+func newUpdateThrottle(interval time.Duration) *updateThrottle {
+	if interval <= 0 {
+		return nil
+	}
+	return &updateThrottle{interval: interval}
+}
+
+// throttle calls send immediately if the interval has passed since the last
+// send, or if the update is final. Otherwise throttle schedules a single
+// trailing send for when the interval elapses, coalescing all updates in
+// between. send reads the completion when called, so a trailing send carries
+// the latest state.
+// Once complete a completion doesn't change, so only one final update is sent
+func (t *updateThrottle) throttle(final bool, send func()) {
+	if t == nil {
+		send()
+		return
+	}
+
+	t.lock.Lock()
+	if t.final {
+		t.lock.Unlock()
+		return
+	}
+	now := time.Now()
+	wait := t.interval - now.Sub(t.last)
+	if final || wait <= 0 {
+		t.final = final
+		t.last = now
+		t.lock.Unlock()
+		send()
+		return
+	}
+	if t.trailing {
+		t.lock.Unlock()
+		return
+	}
+	t.trailing = true
+	t.lock.Unlock()
+
+	time.AfterFunc(wait, func() {
+		t.lock.Lock()
+		t.trailing = false
+		if t.final {
+			t.lock.Unlock()
+			return
+		}
+		t.last = time.Now()
+		t.lock.Unlock()
+		send()
+	})
+}
+
+// progressFeed fans completion snapshots out to subscribers. Each subscriber
+// channel buffers a single snapshot, replacing an unread snapshot with the
+// latest, so publishing never blocks on subscribers that don't read
+type progressFeed struct {
+	lock     sync.Mutex
+	subs     []chan dag.Completion
+	finished bool
+}
+
+// subscribe adds a subscriber channel, closed when the feed finishes.
+// Subscribing to a finished feed returns a closed channel
+func (f *progressFeed) subscribe() <-chan dag.Completion {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	ch := make(chan dag.Completion, 1)
+	if f.finished {
+		close(ch)
+		return ch
+	}
+	f.subs = append(f.subs, ch)
+	return ch
+}
+
+// reopen accepts subscribers to a finished feed again, for transfers that are
+// started again
+func (f *progressFeed) reopen() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.finished = false
+}
+
+// publish sends a copy of a completion to all subscribers
+func (f *progressFeed) publish(c dag.Completion) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.send(c)
+}
+
+func (f *progressFeed) send(c dag.Completion) {
+	if c == nil || len(f.subs) == 0 {
+		return
+	}
+	snapshot := make(dag.Completion, len(c))
+	copy(snapshot, c)
+	for _, ch := range f.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- snapshot
+	}
+}
+
+// finish publishes a final completion & closes all subscriber channels
+func (f *progressFeed) finish(c dag.Completion) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.send(c)
+	for _, ch := range f.subs {
+		close(ch)
+	}
+	f.subs = nil
+	f.finished = true
+}
+
+// updateCh delivers completion updates on a channel that buffers a single
+// update. An unread update is replaced by the latest, so sending never blocks
+// on readers that don't read. The channel closes when the transfer finishes
+type updateCh struct {
+	lock   sync.Mutex
+	ch     chan dag.Completion
+	closed bool
+}
+
+func newUpdateCh() *updateCh {
+	return &updateCh{ch: make(chan dag.Completion, 1)}
+}
+
+// updates returns the current update channel
+func (u *updateCh) updates() <-chan dag.Completion {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.ch
+}
+
+// send notifies the channel of a completion change without blocking
+func (u *updateCh) send(c dag.Completion) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.closed {
+		return
+	}
+	select {
+	case <-u.ch:
+	default:
+	}
+	select {
+	case u.ch <- c:
+	default:
+	}
+}
+
+// close ends updates, closing the channel
+func (u *updateCh) close() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if !u.closed {
+		u.closed = true
+		close(u.ch)
+	}
+}
+
+// reopen replaces a closed channel, for transfers that are started again
+func (u *updateCh) reopen() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.closed {
+		u.closed = false
+		u.ch = make(chan dag.Completion, 1)
+	}
+}
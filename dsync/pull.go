@@ -3,13 +3,18 @@ package dsync
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
 
 	"github.com/qri-io/dag"
 
 	"github.com/ipfs/go-cid"
 	ipld "github.com/ipfs/go-ipld-format"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/ipfs/interface-go-ipfs-core/path"
 )
 
 // NewPull sets up fetching a DAG at an id from a remote
@@ -21,7 +26,7 @@ func NewPull(cidStr string, lng ipld.NodeGetter, bapi coreiface.BlockAPI, rem Da
 		bapi:        bapi,
 		remote:      rem,
 		parallelism: defaultPullParallelism,
-		progCh:      make(chan dag.Completion),
+		progCh:      newUpdateCh(),
 		reqCh:       make(chan string),
 		resCh:       make(chan blockResponse),
 	}
@@ -39,18 +44,55 @@ func NewPullWithInfo(info *dag.Info, lng ipld.NodeGetter, bapi coreiface.BlockAP
 	return f, nil
 }
 
+// NewIncrementalPull sets up fetching a new version of a DAG that only
+// transfers blocks not already present in a previous version. The DAG rooted
+// at prevRoot must be complete in the local store. NewIncrementalPull fetches
+// the manifest for newRoot from the remote, and the resulting pull requests
+// only blocks that aren't part of the previous version, skipping a
+// block-by-block probe of the local store
+func NewIncrementalPull(ctx context.Context, prevRoot, newRoot cid.Cid, lng ipld.NodeGetter, bapi coreiface.BlockAPI, rem DagSyncable, meta map[string]string) (*Pull, error) {
+	prev, err := dag.NewManifest(ctx, lng, prevRoot)
+	if err != nil {
+		return nil, fmt.Errorf("building manifest for previous version: %w", err)
+	}
+	return newIncrementalPull(ctx, prev, newRoot, lng, bapi, rem, meta)
+}
+
+// newIncrementalPull creates a pull of newRoot that skips blocks in prev
+func newIncrementalPull(ctx context.Context, prev *dag.Manifest, newRoot cid.Cid, lng ipld.NodeGetter, bapi coreiface.BlockAPI, rem DagSyncable, meta map[string]string) (*Pull, error) {
+	info, err := rem.GetDagInfo(ctx, newRoot.String(), meta)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := NewPullWithInfo(info, lng, bapi, rem, meta)
+	if err != nil {
+		return nil, err
+	}
+	f.prev = prev
+	return f, nil
+}
+
 // Pull coordinates the transfer of missing blocks in a DAG from a remote to a block store
 type Pull struct {
 	path        string
 	meta        map[string]string
 	info        *dag.Info
+	prev        *dag.Manifest  // previous version, set for incremental pulls
+	resume      dag.Completion // checkpointed progress, set for resumed pulls
 	diff        *dag.Manifest
 	remote      DagSyncable
 	lng         ipld.NodeGetter
 	bapi        coreiface.BlockAPI
 	parallelism int
-	prog        dag.Completion
-	progCh      chan dag.Completion
+	progLock    sync.Mutex
+	prog        dag.Completion // guarded by progLock
+	initial     dag.Completion // completion before pulling, set by prepare
+	prepared    bool           // true if LocalCompletion prepared the next Do
+	progCh      *updateCh
+	throttle    *updateThrottle // optional limit on completion update frequency
+	progress    progressFeed    // optional completion snapshots for Progress
+	batchSize   int             // number of streamed blocks to write at once
 	reqCh       chan string
 	resCh       chan blockResponse
 }
@@ -77,26 +119,145 @@ func (f *Pull) Do(ctx context.Context) (err error) {
 	//      - valid hash response: put the incoming block into our local store
 	//      - error: send the error over the error channel & bail
 	//    - every time we receive a block, check if we're done
+	defer func() {
+		f.progress.finish(f.completion())
+		f.progCh.close()
+	}()
+	if err = f.ready(ctx); err != nil {
+		return err
+	}
+	if f.completion().Complete() {
+		return nil
+	}
+
+	return f.do(ctx)
+}
+
+// prepare fetches the dag.Info if the pull doesn't have one, and works out
+// which blocks are missing locally
+func (f *Pull) prepare(ctx context.Context) (err error) {
 	if f.info == nil {
 		// request a manifest from the remote if we don't have one
 		if f.info, err = f.remote.GetDagInfo(ctx, f.path, f.meta); err != nil {
-			return
+			return err
 		}
 	}
 
-	f.diff, err = dag.Missing(ctx, f.lng, f.info.Manifest)
-	if err != nil {
-		return
+	if f.prev != nil {
+		f.diff = f.info.Manifest.Diff(f.prev)
+	} else if f.resume != nil {
+		if f.diff, err = reconcileCheckpoint(ctx, f.lng, f.info.Manifest, f.resume); err != nil {
+			return err
+		}
+	} else if f.diff, err = dag.Missing(ctx, f.lng, f.info.Manifest); err != nil {
+		return err
 	}
 
+	f.progLock.Lock()
 	f.prog = dag.NewCompletion(f.info.Manifest, f.diff)
-	go f.completionChanged()
+	f.initial = append(dag.Completion{}, f.prog...)
+	f.progLock.Unlock()
+	f.completionChanged()
+	return nil
+}
 
-	if f.prog.Complete() {
+// ready prepares the pull, unless LocalCompletion already has
+func (f *Pull) ready(ctx context.Context) error {
+	if f.prepared {
+		f.prepared = false
 		return nil
 	}
+	return f.prepare(ctx)
+}
 
-	return f.do(ctx)
+// LocalCompletion returns the completion of the DAG in the local block store
+// before pulling, fetching the dag.Info from the remote if the pull doesn't
+// have one. Blocks the local store already has are complete, which lets
+// callers show how much of a DAG is cached before any blocks are pulled. The
+// completion is worked out once, and the next call to Do pulls the blocks it
+// reports missing
+func (f *Pull) LocalCompletion(ctx context.Context) (dag.Completion, error) {
+	if f.initial == nil {
+		if err := f.prepare(ctx); err != nil {
+			return nil, err
+		}
+		f.prepared = true
+	}
+	return append(dag.Completion{}, f.initial...), nil
+}
+
+// Verify checks the DAG a pull assembled in the local store matches the
+// manifest it was pulled with. Every block must be present & hash to it's
+// CID, and a manifest re-derived from the local blocks must equal the pulled
+// one. Errors wrap ErrDAGMismatch, listing missing, corrupt & extra nodes.
+// Verify must be called after Do
+func (f *Pull) Verify(ctx context.Context) error {
+	if f.info == nil {
+		return fmt.Errorf("pull has no manifest to verify, call Do first")
+	}
+	expect := f.info.Manifest
+
+	prog, problems, err := dag.Verify(ctx, f.lng, expect)
+	if err != nil {
+		return err
+	}
+	var missing, corrupt []string
+	for _, p := range problems {
+		if errors.Is(p.Err, ipld.ErrNotFound) {
+			missing = append(missing, p.ID)
+		} else {
+			corrupt = append(corrupt, p.ID)
+		}
+	}
+	// node getters decode blocks with the CID they're stored under, rehash
+	// block data to catch blocks stored under the wrong key
+	for i, hash := range expect.Nodes {
+		if prog[i] != 100 {
+			continue
+		}
+		r, err := f.bapi.Get(ctx, path.New(hash))
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if verifyBlock(hash, data) != nil {
+			corrupt = append(corrupt, hash)
+		}
+	}
+	if len(missing) > 0 || len(corrupt) > 0 {
+		return dagMismatchErr(missing, corrupt, nil)
+	}
+
+	got, err := dag.NewManifest(ctx, f.lng, f.info.RootCID())
+	if err != nil {
+		return fmt.Errorf("%w: deriving local manifest: %s", ErrDAGMismatch, err)
+	}
+	if !got.Equal(expect) {
+		missing, extra := expect.Diff(got).Nodes, got.Diff(expect).Nodes
+		if len(missing) == 0 && len(extra) == 0 {
+			return fmt.Errorf("%w: links differ", ErrDAGMismatch)
+		}
+		return dagMismatchErr(missing, nil, extra)
+	}
+	return nil
+}
+
+// dagMismatchErr describes the differences between a local DAG & a manifest
+func dagMismatchErr(missing, corrupt, extra []string) error {
+	var diffs []string
+	if len(missing) > 0 {
+		diffs = append(diffs, fmt.Sprintf("missing nodes: %s", strings.Join(missing, ", ")))
+	}
+	if len(corrupt) > 0 {
+		diffs = append(diffs, fmt.Sprintf("corrupt nodes: %s", strings.Join(corrupt, ", ")))
+	}
+	if len(extra) > 0 {
+		diffs = append(diffs, fmt.Sprintf("extra nodes: %s", strings.Join(extra, ", ")))
+	}
+	return fmt.Errorf("%w. %s", ErrDAGMismatch, strings.Join(diffs, "; "))
 }
 
 func (f *Pull) do(ctx context.Context) error {
@@ -107,33 +268,7 @@ func (f *Pull) do(ctx context.Context) error {
 
 	if protocolSupportsDagStreaming(protoID) {
 		if streamable, ok := f.remote.(DagStreamable); ok {
-			progCh := make(chan cid.Cid)
-			go func() {
-				for {
-					select {
-					case cid := <-progCh:
-						// this is the only place we should modify progress after creation
-						for i, hash := range f.info.Manifest.Nodes {
-							if cid.String() == hash {
-								f.prog[i] = 100
-								go f.completionChanged()
-								break
-							}
-						}
-					case <-ctx.Done():
-						return
-					}
-				}
-			}()
-
-			r, err := streamable.OpenBlockStream(ctx, f.info, f.meta)
-			if err != nil {
-				return err
-			}
-			defer r.Close()
-
-			_, err = AddAllFromCARReader(ctx, f.bapi, r, progCh)
-			return err
+			return f.pullStreams(ctx, streamable, []*dag.Info{f.streamInfo()})
 		}
 		log.Debugf("protocol supports streaming but doesn't have the streamable interface: %T %v", f.remote, f.remote)
 	}
@@ -144,6 +279,11 @@ func (f *Pull) do(ctx context.Context) error {
 		f.parallelism = len(f.diff.Nodes)
 	}
 
+	// cancelling the attempt's context when it returns aborts blocks still
+	// being fetched, & stops goroutines handling responses from blocking
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	// create pullers
 	pullers := make([]puller, f.parallelism)
 	for i := 0; i < f.parallelism; i++ {
@@ -164,39 +304,40 @@ func (f *Pull) do(ctx context.Context) error {
 	}()
 
 	errCh := make(chan error)
+	fail := func(err error) {
+		select {
+		case errCh <- err:
+		case <-ctx.Done():
+		}
+	}
 	go func() {
 		for {
 			select {
 			case res := <-f.resCh:
 				go func(res blockResponse) {
 					if res.Error != nil {
-						errCh <- res.Error
+						fail(res.Error)
 						return
 					}
 
 					bs, err := f.bapi.Put(ctx, bytes.NewReader(res.Raw))
 					if err != nil {
-						errCh <- res.Error
+						fail(&BlockPutError{Cid: res.Hash, Err: err})
+						return
 					}
 
-					if bs.Path().Cid().String() != res.Hash {
-						errCh <- fmt.Errorf("hash integrity mismatch. expected %s, got: %s", bs.Path().Cid().String(), res.Hash)
+					if err := compareCIDs(res.Hash, bs.Path().Cid()); err != nil {
+						fail(err)
+						return
 					}
 
-					// this is the only place we should modify progress after creation
-					for i, hash := range f.info.Manifest.Nodes {
-						if res.Hash == hash {
-							f.prog[i] = 100
-						}
-					}
-					go f.completionChanged()
-					if f.prog.Complete() {
-						errCh <- nil
+					if f.markPulled(res.Hash) {
+						fail(nil)
 						return
 					}
 				}(res)
 			case <-ctx.Done():
-				errCh <- nil
+				return
 			}
 		}
 	}()
@@ -204,20 +345,183 @@ func (f *Pull) do(ctx context.Context) error {
 	// fill requests channel with missing ids
 	go func() {
 		for _, hash := range f.diff.Nodes {
-			f.reqCh <- hash
+			select {
+			case f.reqCh <- hash:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 
-	return <-errCh
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		f.completionChanged()
+		return ctx.Err()
+	}
 }
 
-// Updates returns a read-only channel of pull completion changes
+// pullStreams opens a block stream for each info, reading the streams into
+// the local block store concurrently. The first stream to fail cancels the
+// rest
+func (f *Pull) pullStreams(ctx context.Context, streamable DagStreamable, infos []*dag.Info) error {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// record progress as blocks land. recorded is only accessed by the
+	// progress goroutine until progDone is closed
+	progCh := make(chan cid.Cid)
+	stopProg := make(chan struct{})
+	progDone := make(chan struct{})
+	recorded := 0
+	go func() {
+		defer close(progDone)
+		for {
+			select {
+			case id := <-progCh:
+				f.blockPulled(id)
+				recorded++
+			case <-stopProg:
+				return
+			}
+		}
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		lock     sync.Mutex
+		added    int
+		firstErr error
+	)
+	for _, info := range infos {
+		wg.Add(1)
+		go func(info *dag.Info) {
+			defer wg.Done()
+			n, err := f.readStream(streamCtx, streamable, info, progCh)
+			lock.Lock()
+			defer lock.Unlock()
+			added += n
+			if err != nil && firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+		}(info)
+	}
+	wg.Wait()
+
+	// finish recording progress for all added blocks, so progress is
+	// accurate when the pull returns
+	close(stopProg)
+	<-progDone
+	for ; recorded < added; recorded++ {
+		f.blockPulled(<-progCh)
+	}
+
+	if ctx.Err() != nil {
+		// report partial progress made before cancellation
+		f.completionChanged()
+		return ctx.Err()
+	}
+	return firstErr
+}
+
+// readStream opens a block stream of the blocks in info, adding them to the
+// local block store. The id of each added block is sent on progCh
+func (f *Pull) readStream(ctx context.Context, streamable DagStreamable, info *dag.Info, progCh chan cid.Cid) (int, error) {
+	r, err := streamable.OpenBlockStream(ctx, info, f.meta)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	// reads from the stream can block indefinitely, closing the stream when
+	// the context is cancelled unblocks any read in progress
+	streamDone := make(chan struct{})
+	defer close(streamDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.Close()
+		case <-streamDone:
+		}
+	}()
+
+	if putter, ok := f.bapi.(BlockPutManyer); ok && f.batchSize > 1 {
+		return addAllFromCARReaderBatched(ctx, putter, f.batchSize, r, progCh)
+	}
+	return AddAllFromCARReader(ctx, f.bapi, r, progCh)
+}
+
+// blockPulled marks a streamed block as complete
+func (f *Pull) blockPulled(id cid.Cid) {
+	f.markPulled(id.String())
+}
+
+// markPulled marks a block as complete, reporting if the pull is complete
+func (f *Pull) markPulled(hash string) (complete bool) {
+	f.progLock.Lock()
+	for i, h := range f.info.Manifest.Nodes {
+		if hash == h {
+			f.prog[i] = 100
+		}
+	}
+	complete = f.prog.Complete()
+	f.progLock.Unlock()
+	f.completionChanged()
+	return complete
+}
+
+// completion returns a copy of the pull's completion, nil before the pull
+// works out which blocks are missing
+func (f *Pull) completion() dag.Completion {
+	f.progLock.Lock()
+	defer f.progLock.Unlock()
+	if f.prog == nil {
+		return nil
+	}
+	return append(dag.Completion{}, f.prog...)
+}
+
+// streamInfo returns the info to request a block stream for. Incremental
+// pulls only request the blocks that differ from the previous version, and
+// resumed pulls only the blocks the checkpoint doesn't cover
+func (f *Pull) streamInfo() *dag.Info {
+	if f.prev != nil || f.resume != nil {
+		return &dag.Info{Manifest: f.diff}
+	}
+	return f.info
+}
+
+// Updates returns a read-only channel of pull completion changes. Updates are
+// copies of the pull's completion & never hold up the pull, an unread update
+// is replaced by the latest state. The channel closes once Do returns
 func (f *Pull) Updates() <-chan dag.Completion {
-	return f.progCh
+	return f.progCh.updates()
+}
+
+// Progress returns a channel of completion snapshots, updated as blocks are
+// pulled. Unlike Updates, Progress snapshots are copies of the completion.
+// The channel holds only the latest snapshot, & never holds up the pull. The
+// channel closes once Do returns, channels requested after Do returns are
+// closed
+func (f *Pull) Progress() <-chan dag.Completion {
+	return f.progress.subscribe()
 }
 
+// completionChanged publishes a copy of the completion, taken under the
+// progress lock so updates are published in order
 func (f *Pull) completionChanged() {
-	f.progCh <- f.prog
+	f.progLock.Lock()
+	final := f.prog.Complete()
+	f.progLock.Unlock()
+	f.throttle.throttle(final, func() {
+		f.progLock.Lock()
+		defer f.progLock.Unlock()
+		c := append(dag.Completion{}, f.prog...)
+		f.progress.publish(c)
+		f.progCh.send(c)
+	})
 }
 
 // puller is a parallelizable, stateless struct that pulls blocks
@@ -240,10 +544,13 @@ func (f puller) start() {
 		case hash := <-f.reqCh:
 			go func() {
 				data, err := f.remote.GetBlock(f.ctx, hash)
-				f.resCh <- blockResponse{
+				select {
+				case f.resCh <- blockResponse{
 					Hash:  hash,
 					Raw:   data,
 					Error: err,
+				}:
+				case <-f.ctx.Done():
 				}
 			}()
 		case <-f.stopCh:
@@ -1,9 +1,20 @@
 package dsync
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/interface-go-ipfs-core/path"
 	"github.com/qri-io/dag"
 )
 
@@ -32,3 +43,336 @@ func TestPull(t *testing.T) {
 		t.Errorf("expected dag to be available in local node after fetch. error: %s", err.Error())
 	}
 }
+
+func TestIncrementalPull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local := newMemStore()
+	remote := newMemStore()
+	v1 := addTestDAG(t, local, "v1", 2, 2)
+	addTestDAG(t, remote, "v1", 2, 2)
+
+	// v2 keeps the first subtree of v1 & replaces the second with a new leaf
+	leaf := merkledag.NodeWithData([]byte("v2 leaf"))
+	remote.putNode(leaf)
+	v2 := merkledag.NodeWithData([]byte("v2"))
+	if err := v2.AddRawLink("0", v1.Links()[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := v2.AddNodeLink("1", leaf); err != nil {
+		t.Fatal(err)
+	}
+	remote.putNode(v2)
+
+	rem, err := New(remote.nodeGetter(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewIncrementalPull(ctx, v1.Cid(), v2.Cid(), local.nodeGetter(), local, rem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]bool{}
+	for _, id := range local.Puts() {
+		got[id] = true
+	}
+	expect := []string{v2.Cid().String(), leaf.Cid().String()}
+	if len(got) != len(expect) {
+		t.Errorf("expected only %d new blocks to transfer. got: %d", len(expect), len(got))
+	}
+	for _, id := range expect {
+		if !got[id] {
+			t.Errorf("expected block %s to be pulled", id)
+		}
+	}
+
+	if _, err := dag.NewManifest(ctx, local.nodeGetter(), v2.Cid()); err != nil {
+		t.Errorf("expected v2 to be complete locally after pull. error: %s", err)
+	}
+}
+
+func TestPullCancelMidStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	remote := newMemStore()
+	root := addTestDAG(t, remote, "cancel", 3, 2)
+	remDs, err := New(remote.nodeGetter(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rem := &stallingStreamRemote{Dsync: remDs, stalled: make(chan struct{})}
+
+	local := newMemStore()
+	p, err := NewPull(root.Cid().String(), local.nodeGetter(), local, rem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updates := make(chan dag.Completion, 100)
+	go func() {
+		for c := range p.Updates() {
+			updates <- c
+		}
+	}()
+
+	go func() {
+		<-rem.stalled
+		cancel()
+	}()
+
+	errCh := make(chan error)
+	go func() { errCh <- p.Do(ctx) }()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected cancelled pull to return context.Canceled. got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pull didn't return promptly after cancellation")
+	}
+
+	puts := len(local.Puts())
+	if puts == 0 || puts == 13 {
+		t.Errorf("expected a partial pull. got %d of 13 blocks", puts)
+	}
+
+	// wait for an update reporting the partial completion
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case c := <-updates:
+			if c.CompletedBlocks() > 0 && !c.Complete() {
+				return
+			}
+		case <-timeout:
+			t.Fatal("expected pull to report partial completion")
+		}
+	}
+}
+
+// stallingStreamRemote serves the first half of each block stream, then blocks
+// reads until the stream is closed
+type stallingStreamRemote struct {
+	*Dsync
+	stalled chan struct{}
+}
+
+func (r *stallingStreamRemote) OpenBlockStream(ctx context.Context, info *dag.Info, meta map[string]string) (io.ReadCloser, error) {
+	rc, err := r.Dsync.OpenBlockStream(ctx, info, meta)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	return &stallingReader{
+		Reader:  bytes.NewReader(data[:len(data)/2]),
+		stalled: r.stalled,
+		closed:  make(chan struct{}),
+	}, nil
+}
+
+type stallingReader struct {
+	io.Reader
+	stalled   chan struct{}
+	stallOnce sync.Once
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (r *stallingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != io.EOF {
+		return n, err
+	}
+	r.stallOnce.Do(func() { close(r.stalled) })
+	<-r.closed
+	return 0, io.ErrClosedPipe
+}
+
+func (r *stallingReader) Close() error {
+	r.closeOnce.Do(func() { close(r.closed) })
+	return nil
+}
+
+func TestPullProgress(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local := newMemStore()
+	remote := newMemStore()
+	root := addTestDAG(t, remote, "progress", 3, 2)
+	rem, err := New(remote.nodeGetter(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewPull(root.Cid().String(), local.nodeGetter(), local, rem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress := p.Progress()
+	if err := p.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing read progress during the pull, leaving only the final snapshot
+	c, ok := <-progress
+	if !ok || !c.Complete() {
+		t.Errorf("expected final snapshot to be complete. got: %v", c)
+	}
+	if _, ok := <-progress; ok {
+		t.Error("expected progress channel to close when Do returns")
+	}
+	if _, ok := <-p.Progress(); ok {
+		t.Error("expected progress requested after Do returns to be closed")
+	}
+
+	// nothing read updates either, which hold the latest completion
+	updates := p.Updates()
+	if c, ok := <-updates; !ok || !c.Complete() {
+		t.Errorf("expected unread updates to hold the final completion. got: %v", c)
+	}
+	if _, ok := <-updates; ok {
+		t.Error("expected updates to close when Do returns")
+	}
+}
+
+func TestPullLocalCompletion(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	remote := newMemStore()
+	root := addTestDAG(t, remote, "local completion", 3, 2)
+	rem, err := New(remote.nodeGetter(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := rem.GetDagInfo(ctx, root.Cid().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the local store already has every other leaf
+	local := newMemStore()
+	for i, hash := range info.Manifest.Nodes[1:] {
+		id, err := cid.Parse(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := remote.rawData(id); i%2 == 0 {
+			if _, err := local.Put(ctx, bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	missing, err := dag.Missing(ctx, local.nodeGetter(), info.Manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := dag.NewCompletion(info.Manifest, missing)
+
+	p, err := NewPull(root.Cid().String(), local.nodeGetter(), local, rem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.LocalCompletion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("local completion mismatch. want: %v, got: %v", expect, got)
+	}
+	if got.Complete() || got.CompletedBlocks() == 0 {
+		t.Errorf("expected a partially cached DAG. got: %v", got)
+	}
+
+	if err := p.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range info.Manifest.Nodes {
+		id, _ := cid.Parse(hash)
+		if !local.has(id) {
+			t.Errorf("expected pull to fetch block %s", hash)
+		}
+	}
+
+	// the completion is worked out once, and doesn't change as blocks land
+	again, err := p.LocalCompletion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expect, again) {
+		t.Errorf("local completion changed after pulling. want: %v, got: %v", expect, again)
+	}
+}
+
+func TestPullVerify(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	remote := newMemStore()
+	root := addTestDAG(t, remote, "verify", 2, 2)
+	rem, err := New(remote.nodeGetter(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local := newMemStore()
+	p, err := NewPull(root.Cid().String(), local.nodeGetter(), local, rem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Verify(ctx); err == nil {
+		t.Error("expected verifying a pull that hasn't run to error")
+	}
+	if err := p.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Verify(ctx); err != nil {
+		t.Fatalf("expected complete pull to verify. got: %s", err)
+	}
+
+	nodes := p.info.Manifest.Nodes
+	leafA, leafB := nodes[len(nodes)-1], nodes[len(nodes)-2]
+
+	// a manifest missing a node the local DAG links to
+	full := p.info
+	p.info = &dag.Info{Manifest: full.Manifest.Diff(&dag.Manifest{Nodes: []string{leafA}})}
+	err = p.Verify(ctx)
+	if !errors.Is(err, ErrDAGMismatch) || !strings.Contains(err.Error(), "extra nodes: "+leafA) {
+		t.Errorf("expected verify to report an extra node. got: %v", err)
+	}
+	p.info = full
+
+	// a block stored under the wrong key
+	idA, _ := cid.Parse(leafA)
+	local.lock.Lock()
+	local.blocks[idA.KeyString()] = merkledag.NodeWithData([]byte("not leaf a")).RawData()
+	local.lock.Unlock()
+
+	// a block lost after the pull
+	idB, _ := cid.Parse(leafB)
+	if err := local.Rm(ctx, path.IpfsPath(idB)); err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.Verify(ctx)
+	if !errors.Is(err, ErrDAGMismatch) {
+		t.Fatalf("expected ErrDAGMismatch. got: %v", err)
+	}
+	for _, expect := range []string{"missing nodes: " + leafB, "corrupt nodes: " + leafA} {
+		if !strings.Contains(err.Error(), expect) {
+			t.Errorf("expected error to contain %q. got: %s", expect, err)
+		}
+	}
+}
@@ -2,7 +2,12 @@ package dsync
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	ipld "github.com/ipfs/go-ipld-format"
@@ -41,6 +46,9 @@ type ReceiveResponse struct {
 	Err    error
 }
 
+// probeBatchSize is the number of block ids sent in each probe request
+const probeBatchSize = 100
+
 // Push coordinates sending a manifest to a remote, tracking progress and state
 type Push struct {
 	pinOnComplete bool              // weather dag should be pinned on completion
@@ -51,11 +59,31 @@ type Push struct {
 	remote        DagSyncable       // place we're sending to
 	meta          map[string]string // metadata to associate with this push
 	parallelism   int               // number of "tracks" for sending along
-	prog          dag.Completion    // progress state
-	progCh        chan dag.Completion
-	blocksCh      chan string
-	responses     chan ReceiveResponse
-	retries       chan string
+	priority      func(string) int  // optional ordering of the send queue
+	chunkSize     int               // split blocks larger than this into parts, zero disables
+	attachHash    bool              // send the manifest hash with the push
+	deltas        bool              // send near-duplicate blocks as deltas
+	deltaBase     *dag.Manifest     // additional delta bases present on the remote
+	probe         bool              // ask the remote for blocks it has before sending
+	throttle      *updateThrottle   // optional limit on completion update frequency
+	codecs        []uint64          // optional limit on the codecs of blocks sent
+	compress      bool              // send streamed blocks in compression frames
+	compressAbove int               // size streamed blocks must exceed to be compressed
+	signature     []byte            // optional signature of info, from a third party
+	retries       int               // times to resend a block the remote asks to retry
+	retryBackoff  time.Duration     // delay before the first resend of a block
+	progLock      sync.Mutex
+	prog          dag.Completion // progress state, guarded by progLock
+	progCh        *updateCh
+	progress      progressFeed // optional completion snapshots for Progress
+	planned       bool         // sid & diff were set by Plan, Do continues them
+}
+
+// TransferPlan lists the blocks a push would send to a remote
+type TransferPlan struct {
+	CIDs      []string `json:"cids"`      // ids of blocks the remote is missing, in send order
+	Sizes     []uint64 `json:"sizes"`     // size of each block in CIDs, in bytes
+	TotalSize uint64   `json:"totalSize"` // sum of Sizes
 }
 
 // NewPush initiates a send for a DAG at an id from a local to a remote.
@@ -72,10 +100,9 @@ func NewPush(lng ipld.NodeGetter, info *dag.Info, remote DagSyncable, pinOnCompl
 		lng:           lng,
 		remote:        remote,
 		parallelism:   parallelism,
-		blocksCh:      make(chan string),
-		progCh:        make(chan dag.Completion),
-		responses:     make(chan ReceiveResponse),
-		retries:       make(chan string),
+		retries:       defaultPushBlockRetries,
+		retryBackoff:  defaultPushRetryBackoff,
+		progCh:        newUpdateCh(),
 	}
 	return ps, nil
 }
@@ -87,8 +114,19 @@ func (snd *Push) SetMeta(meta map[string]string) {
 	snd.meta = meta
 }
 
+// SetDeltaBase adds the blocks of a DAG the remote already has, like a
+// previous version of the DAG being pushed, as bases for delta-encoding. All
+// blocks in the base manifest must be accessible locally. Delta bases only
+// have an effect on pushes configured to delta-encode blocks, and must be set
+// before starting the push
+func (snd *Push) SetDeltaBase(m *dag.Manifest) {
+	snd.deltaBase = m
+}
+
 // Do executes the push, blocking until complete
 func (snd *Push) Do(ctx context.Context) (err error) {
+	snd.start()
+	defer snd.finish()
 	log.Debugf("initiating push")
 	// how this process works:
 	// * Do sends a dag.Info to the remote node
@@ -106,18 +144,166 @@ func (snd *Push) Do(ctx context.Context) (err error) {
 	// posible TODO (ramfox): it would be great if the fetch and send Do functions
 	// followed the same pattern. Specifically the go function that is used to listen for
 	// responses
-	snd.sid, snd.diff, err = snd.remote.NewReceiveSession(snd.info, snd.pinOnComplete, snd.meta)
+	if snd.planned {
+		snd.planned = false
+		log.Debugf("push continuing planned receive session: %s", snd.sid)
+		return snd.do(ctx)
+	}
+	if err := snd.openSession(ctx); err != nil {
+		return err
+	}
+	return snd.do(ctx)
+}
+
+// Plan reports the blocks Do would send without sending any. Plan opens a
+// receive session to learn which blocks the remote is missing, and asks the
+// remote which of them it already has if the push probes. A call to Do after
+// Plan continues the planned session, sending exactly the planned blocks.
+// Remotes expire planned sessions that aren't pushed to like any other idle
+// session
+func (snd *Push) Plan(ctx context.Context) (*TransferPlan, error) {
+	if len(snd.info.Sizes) != len(snd.info.Manifest.Nodes) {
+		return nil, fmt.Errorf("info has %d sizes for %d nodes", len(snd.info.Sizes), len(snd.info.Manifest.Nodes))
+	}
+	if err := snd.openSession(ctx); err != nil {
+		return nil, err
+	}
+	if snd.probe {
+		have, err := snd.probeHave(ctx)
+		if err != nil {
+			return nil, err
+		}
+		snd.removeFromDiff(have)
+	}
+	snd.planned = true
+
+	sizes := map[string]uint64{}
+	for i, id := range snd.info.Manifest.Nodes {
+		sizes[id] = snd.info.Sizes[i]
+	}
+	plan := &TransferPlan{}
+	for _, id := range snd.queue() {
+		plan.CIDs = append(plan.CIDs, id)
+		plan.Sizes = append(plan.Sizes, sizes[id])
+		plan.TotalSize += sizes[id]
+	}
+	return plan, nil
+}
+
+// openSession asks the remote to open a receive session for the push, setting
+// the session id & the diff of blocks the remote needs
+func (snd *Push) openSession(ctx context.Context) (err error) {
+	meta := snd.meta
+	if snd.attachHash || len(snd.codecs) > 0 || snd.compress || snd.signature != nil {
+		meta = map[string]string{}
+		for key, val := range snd.meta {
+			meta[key] = val
+		}
+	}
+	if snd.attachHash {
+		hash, err := snd.info.Manifest.Hash()
+		if err != nil {
+			return err
+		}
+		meta[ManifestHashMetaKey] = hash
+		meta[ManifestRootMetaKey] = snd.info.RootCID().String()
+	}
+	if len(snd.codecs) > 0 {
+		meta[CodecsMetaKey] = encodeCodecs(snd.codecs)
+	}
+	if snd.compress {
+		meta[BlockCompressionMetaKey] = blockCompressionDeflate
+	}
+	if snd.signature != nil {
+		meta[InfoSignatureMetaKey] = base64.StdEncoding.EncodeToString(snd.signature)
+	}
+
+	if so, ok := snd.remote.(ContextSessionOpener); ok {
+		snd.sid, snd.diff, err = so.NewReceiveSessionContext(ctx, snd.info, snd.pinOnComplete, meta)
+	} else {
+		snd.sid, snd.diff, err = snd.remote.NewReceiveSession(snd.info, snd.pinOnComplete, meta)
+	}
 	if err != nil {
 		log.Debugf("error creating receive session: %s", err)
 		return err
 	}
 	log.Debugf("push has receive session: %s", snd.sid)
+	return nil
+}
+
+// Retry resumes a push that returned an error, resending only the blocks
+// that aren't complete in the push's last known completion. Retries reuse the
+// receive session of the failed attempt, so the remote must keep sessions open
+// across errors. Pushes that failed before the remote opened a session start
+// over. Blocks still in flight when a push fails may be sent again, as are
+// all blocks of a failed stream, which the remote doesn't confirm one by one
+func (snd *Push) Retry(ctx context.Context) error {
+	snd.start()
+	defer snd.finish()
+	prog := snd.completion()
+	if snd.sid == "" || prog == nil {
+		return snd.Do(ctx)
+	}
+
+	diff := &dag.Manifest{}
+	for i, hash := range snd.info.Manifest.Nodes {
+		if prog[i] != 100 {
+			diff.Nodes = append(diff.Nodes, hash)
+		}
+	}
+	log.Debugf("retrying push. sid=%q remaining=%d", snd.sid, len(diff.Nodes))
+	snd.diff = diff
+	return snd.do(ctx)
+}
+
+// SessionID returns the id of the push's receive session on the remote, empty
+// until the remote opens a session. Persist session ids of large pushes to
+// resume them with Resume if they're interrupted
+func (snd *Push) SessionID() string {
+	return snd.sid
+}
+
+// Resume continues an interrupted push of the same info in a receive session
+// the remote still holds, like the session of a push from a process that
+// exited before finishing. Resume asks the remote for the session's
+// completion, and only sends blocks the session is missing. The remote must
+// implement SessionProgresser. Pushes that send compressed blocks must be
+// resumed by a push with the same block compression
+func (snd *Push) Resume(ctx context.Context, sid string) error {
+	snd.start()
+	defer snd.finish()
+	sp, ok := snd.remote.(SessionProgresser)
+	if !ok {
+		return fmt.Errorf("remote doesn't support resuming pushes")
+	}
+	prog, err := sp.SessionProgress(ctx, sid)
+	if err != nil {
+		return err
+	}
+	if len(prog) != len(snd.info.Manifest.Nodes) {
+		return fmt.Errorf("session %q has %d blocks, info has %d. sessions must be resumed with the same info", sid, len(prog), len(snd.info.Manifest.Nodes))
+	}
+
+	diff := &dag.Manifest{}
+	for i, hash := range snd.info.Manifest.Nodes {
+		if prog[i] != 100 {
+			diff.Nodes = append(diff.Nodes, hash)
+		}
+	}
+	log.Debugf("resuming push. sid=%q remaining=%d", sid, len(diff.Nodes))
+	snd.sid = sid
+	snd.diff = diff
 	return snd.do(ctx)
 }
 
 func (snd *Push) do(ctx context.Context) (err error) {
-	snd.prog = dag.NewCompletion(snd.info.Manifest, snd.diff)
-	go snd.completionChanged()
+	snd.resetCompletion()
+
+	if snd.probe {
+		if err := snd.probeRemote(ctx); err != nil {
+			return err
+		}
+	}
 
 	// response said we have nothing to send. all done
 	if len(snd.diff.Nodes) == 0 {
@@ -129,120 +315,507 @@ func (snd *Push) do(ctx context.Context) (err error) {
 		return err
 	}
 
-	if protocolSupportsDagStreaming(protoID) {
+	var deltas *deltaEncoder
+	if _, ok := snd.remote.(BlockDeltaReceiver); ok && snd.deltas {
+		deltas = newDeltaEncoder(snd.lng, snd.info.Manifest, snd.diff, snd.deltaBase)
+	}
+
+	if protocolSupportsDagStreaming(protoID) && deltas == nil {
 		if str, ok := snd.remote.(DagStreamable); ok {
+			// cancelling the stream's context when it returns stops reader
+			// progress sends no one is left to receive
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
 			progCh := make(chan cid.Cid)
-
+			done, stopped := make(chan struct{}), make(chan struct{})
 			go func() {
-				for id := range progCh {
-					// this is the only place we should modify progress after creation
-					idStr := id.String()
-					log.Debugf("sent block %s", idStr)
-					for i, hash := range snd.info.Manifest.Nodes {
-						if idStr == hash {
-							snd.prog[i] = 100
-						}
+				defer close(stopped)
+				for {
+					select {
+					case id := <-progCh:
+						log.Debugf("sent block %s", id)
+						snd.setCompletion(id.String(), 100)
+					case <-done:
+						return
 					}
-					go snd.completionChanged()
 				}
 			}()
+			// stop ends the progress goroutine, returning once it has so
+			// completion can be changed without it
+			stop := func() {
+				close(done)
+				<-stopped
+			}
 
-			r, err := NewManifestCARReader(ctx, snd.lng, snd.diff, progCh)
+			queue := snd.queue()
+			r, err := newManifestCARReader(ctx, snd.lng, &dag.Manifest{Nodes: queue}, progCh)
 			if err != nil {
+				stop()
 				log.Debugf("err creating CARReader err=%q ", err)
 				return err
 			}
+			r.framed = snd.compress
+			r.compressAbove = snd.compressAbove
 
-			return str.ReceiveBlocks(ctx, snd.sid, r)
+			err = str.ReceiveBlocks(ctx, snd.sid, r)
+			stop()
+			if err != nil {
+				// streamed blocks are marked complete as they're sent, which doesn't
+				// mean the remote got them. forget progress made by a failed stream
+				// so a retry resends all of it
+				snd.resetCompletion()
+				return err
+			}
+			// the remote has every block of a finished stream, including blocks
+			// the progress goroutine didn't get to
+			sent := make(map[string]bool, len(queue))
+			for _, hash := range queue {
+				sent[hash] = true
+			}
+			snd.progLock.Lock()
+			for i, hash := range snd.info.Manifest.Nodes {
+				if sent[hash] {
+					snd.prog[i] = 100
+				}
+			}
+			snd.progLock.Unlock()
+			snd.completionChanged()
+			return nil
 		}
 	}
 
 	log.Debugf("protocol doesn't support block streaming. falling back to pushing per-block strategy")
 
+	// each attempt has it's own channels, so goroutines left over from a failed
+	// attempt never handle the blocks of a retry
+	blocksCh := make(chan string)
+	responses := make(chan ReceiveResponse)
+	retries := make(chan string)
+	// cancelling the attempt's context when it returns stops it's senders &
+	// the goroutines feeding them
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	send := func(hash string) bool {
+		select {
+		case blocksCh <- hash:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
 	// create senders
 	sends := make([]sender, snd.parallelism)
 	for i := 0; i < snd.parallelism; i++ {
 		sends[i] = sender{
 			id:        i,
 			sid:       snd.sid,
-			blocksCh:  snd.blocksCh,
-			responses: snd.responses,
+			blocksCh:  blocksCh,
+			responses: responses,
 			lng:       snd.lng,
 			remote:    snd.remote,
+			chunkSize: snd.chunkSize,
+			deltas:    deltas,
+			onPart:    snd.partSent,
 			stopCh:    make(chan bool),
 		}
 		go sends[i].start(ctx)
 	}
 
+	// fail reports the outcome of the attempt. goroutines still running when
+	// the attempt returns give up instead of blocking forever
 	errCh := make(chan error)
+	fail := func(err error) {
+		select {
+		case errCh <- err:
+		case <-ctx.Done():
+		}
+	}
+	queue := snd.queue()
+	tracker := newRetryTracker(queue, snd.retries, snd.retryBackoff)
+
+	// concurrent senders hold blocks back until the remote has their children,
+	// so the remote never holds a block without the blocks it links to
+	var deps *dependencyGate
+	if snd.parallelism > 1 {
+		deps = newDependencyGate(snd.info.Manifest, queue)
+		queue = deps.ready()
+	}
+	release := func(hash string) {
+		if deps == nil {
+			return
+		}
+		if next := deps.done(hash); len(next) > 0 {
+			go func() {
+				for _, hash := range next {
+					if !send(hash) {
+						return
+					}
+				}
+			}()
+		}
+	}
 
 	// receive block responses
-	go func(sends []sender, errCh chan error) {
+	go func(sends []sender) {
 		// handle *all* responses from senders. it's very important that this loop
 		// never block, so all responses are handled in their own goroutine
-		for res := range snd.responses {
+		for res := range responses {
 			go func(r ReceiveResponse) {
 				switch r.Status {
 				case StatusOk:
-					// this is the only place we should modify progress after creation
-					for i, hash := range snd.info.Manifest.Nodes {
-						if r.Hash == hash {
-							snd.prog[i] = 100
-						}
-					}
-					go snd.completionChanged()
-					if snd.prog.Complete() {
-						errCh <- nil
+					snd.setCompletion(r.Hash, 100)
+					if done, err := tracker.resolve(r.Hash, false); done {
+						fail(err)
 						return
 					}
+					release(r.Hash)
 				case StatusErrored:
 					log.Debugf("error pushing block. hash=%q error=%q", r.Hash, r.Err)
-					errCh <- r.Err
+					fail(r.Err)
 					for _, s := range sends {
 						s.stop()
 					}
 				case StatusRetry:
 					log.Debugf("retrying push block. hash=%q error=%q", r.Hash, r.Err)
-					snd.retries <- r.Hash
+					select {
+					case retries <- r.Hash:
+					case <-ctx.Done():
+					}
 				}
 			}(res)
 		}
-	}(sends, errCh)
+	}(sends)
 
-	go func(errCh chan error) {
-		retries := 0
-		for hash := range snd.retries {
-			retries++
-			if retries == maxRetries {
-				for _, s := range sends {
-					s.stop()
+	go func() {
+		for hash := range retries {
+			delay, ok := tracker.retry(hash)
+			if !ok {
+				log.Debugf("block out of retries. hash=%q", hash)
+				if done, err := tracker.resolve(hash, true); done {
+					fail(err)
+					return
 				}
-				errCh <- fmt.Errorf("max %d retries reached", retries)
-				return
+				// the push fails once all other blocks are sent, send the
+				// blocks waiting on this one anyway
+				release(hash)
+				continue
 			}
-			snd.blocksCh <- hash
+			// wait out the backoff without holding up retries of other blocks
+			go func(hash string) {
+				select {
+				case <-time.After(delay):
+					send(hash)
+				case <-ctx.Done():
+				}
+			}(hash)
 		}
-	}(errCh)
+	}()
 
 	// fill queue with missing blocks to kick off the send
 	go func() {
-		for _, hash := range snd.diff.Nodes {
-			snd.blocksCh <- hash
+		for _, hash := range queue {
+			if !send(hash) {
+				return
+			}
 		}
 	}()
 
-	// block until send on errCh
-	return <-errCh
+	// block until send on errCh, or the push is cancelled
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryTracker counts resends of the blocks in a push attempt, and tracks
+// which blocks are still outstanding so the attempt can finish once every
+// block is sent or out of retries
+type retryTracker struct {
+	lock      sync.Mutex
+	retries   int
+	backoff   time.Duration
+	attempts  map[string]int
+	pending   map[string]bool
+	exhausted []string
+}
+
+func newRetryTracker(hashes []string, retries int, backoff time.Duration) *retryTracker {
+	pending := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		pending[hash] = true
+	}
+	return &retryTracker{
+		retries:  retries,
+		backoff:  backoff,
+		attempts: map[string]int{},
+		pending:  pending,
+	}
+}
+
+// retry counts a resend of a block, returning the delay to wait before
+// resending it. ok is false when the block is out of retries
+func (t *retryTracker) retry(hash string) (delay time.Duration, ok bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	n := t.attempts[hash]
+	if n >= t.retries {
+		return 0, false
+	}
+	t.attempts[hash] = n + 1
+
+	delay = t.backoff
+	for i := 0; i < n && delay < maxPushRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxPushRetryBackoff {
+		delay = maxPushRetryBackoff
+	}
+	return delay, true
+}
+
+// resolve marks a block as sent, or as out of retries. done is true once no
+// blocks are outstanding, with an error listing blocks that ran out of
+// retries, if any
+func (t *retryTracker) resolve(hash string, exhausted bool) (done bool, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !t.pending[hash] {
+		return false, nil
+	}
+	delete(t.pending, hash)
+	if exhausted {
+		t.exhausted = append(t.exhausted, hash)
+	}
+	if len(t.pending) > 0 {
+		return false, nil
+	}
+	if len(t.exhausted) > 0 {
+		return true, fmt.Errorf("%w after %d retries: %s", ErrRetriesExhausted, t.retries, strings.Join(t.exhausted, ", "))
+	}
+	return true, nil
+}
+
+// dependencyGate orders the blocks of a push attempt so a block is only sent
+// once the remote has all of it's children that are part of the attempt
+type dependencyGate struct {
+	lock    sync.Mutex
+	order   []string            // blocks in send queue order
+	waiting map[string]int      // number of unsent children of each block
+	parents map[string][]string // blocks that link to each block
+}
+
+func newDependencyGate(m *dag.Manifest, queue []string) *dependencyGate {
+	sending := make(map[string]bool, len(queue))
+	for _, hash := range queue {
+		sending[hash] = true
+	}
+	g := &dependencyGate{
+		order:   queue,
+		waiting: map[string]int{},
+		parents: map[string][]string{},
+	}
+	for _, l := range m.Links {
+		if l[0] >= len(m.Nodes) || l[1] >= len(m.Nodes) {
+			continue
+		}
+		parent, child := m.Nodes[l[0]], m.Nodes[l[1]]
+		if sending[parent] && sending[child] {
+			g.waiting[parent]++
+			g.parents[child] = append(g.parents[child], parent)
+		}
+	}
+	return g
+}
+
+// ready returns the blocks that can be sent right away, in queue order
+func (g *dependencyGate) ready() []string {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	var ready []string
+	for _, hash := range g.order {
+		if g.waiting[hash] == 0 {
+			ready = append(ready, hash)
+		}
+	}
+	return ready
+}
+
+// done marks a block as finished, returning blocks that are now ready to send
+func (g *dependencyGate) done(hash string) (ready []string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, parent := range g.parents[hash] {
+		g.waiting[parent]--
+		if g.waiting[parent] == 0 {
+			ready = append(ready, parent)
+		}
+	}
+	delete(g.parents, hash)
+	return ready
+}
+
+// probeRemote asks remotes that support probing which blocks in the diff
+// they already have, removing them from the diff. Probes are sent in batches
+func (snd *Push) probeRemote(ctx context.Context) error {
+	have, err := snd.probeHave(ctx)
+	if err != nil || len(have) == 0 {
+		return err
+	}
+	snd.removeFromDiff(have)
+
+	snd.progLock.Lock()
+	for i, hash := range snd.info.Manifest.Nodes {
+		if have[hash] {
+			snd.prog[i] = 100
+		}
+	}
+	snd.progLock.Unlock()
+	snd.completionChanged()
+	return nil
+}
+
+// probeHave returns the blocks in the diff the remote already has. Remotes
+// that don't support probing have none
+func (snd *Push) probeHave(ctx context.Context) (map[string]bool, error) {
+	prober, ok := snd.remote.(BlockProber)
+	if !ok {
+		return nil, nil
+	}
+
+	have := map[string]bool{}
+	nodes := snd.diff.Nodes
+	for start := 0; start < len(nodes); start += probeBatchSize {
+		end := start + probeBatchSize
+		if end > len(nodes) {
+			end = len(nodes)
+		}
+		ids, err := prober.ProbeBlocks(ctx, snd.sid, nodes[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			have[id] = true
+		}
+	}
+	if len(have) > 0 {
+		log.Debugf("remote has %d of %d requested blocks", len(have), len(nodes))
+	}
+	return have, nil
+}
+
+// removeFromDiff drops blocks in have from the diff
+func (snd *Push) removeFromDiff(have map[string]bool) {
+	if len(have) == 0 {
+		return
+	}
+	diff := &dag.Manifest{}
+	for _, id := range snd.diff.Nodes {
+		if !have[id] {
+			diff.Nodes = append(diff.Nodes, id)
+		}
+	}
+	snd.diff = diff
+}
+
+// queue returns the list of hashes to send in the order they should be sent.
+// When a priority function is set hashes are ordered highest-priority first,
+// hashes of equal priority retain their order in the diff manifest
+func (snd *Push) queue() []string {
+	if snd.priority == nil {
+		return snd.diff.Nodes
+	}
+
+	q := make([]string, len(snd.diff.Nodes))
+	copy(q, snd.diff.Nodes)
+	sort.SliceStable(q, func(i, j int) bool {
+		return snd.priority(q[i]) > snd.priority(q[j])
+	})
+	return q
+}
+
+// partSent records partial progress of a block being sent in parts
+func (snd *Push) partSent(hash string, pct uint16) {
+	snd.setCompletion(hash, pct)
+}
+
+// setCompletion sets the completion of a block
+func (snd *Push) setCompletion(hash string, pct uint16) {
+	snd.progLock.Lock()
+	for i, h := range snd.info.Manifest.Nodes {
+		if hash == h {
+			snd.prog[i] = pct
+		}
+	}
+	snd.progLock.Unlock()
+	snd.completionChanged()
+}
+
+// resetCompletion starts completion over from the diff
+func (snd *Push) resetCompletion() {
+	snd.progLock.Lock()
+	snd.prog = dag.NewCompletion(snd.info.Manifest, snd.diff)
+	snd.progLock.Unlock()
+	snd.completionChanged()
+}
+
+// completion returns a copy of the push's completion, nil before the push
+// starts
+func (snd *Push) completion() dag.Completion {
+	snd.progLock.Lock()
+	defer snd.progLock.Unlock()
+	if snd.prog == nil {
+		return nil
+	}
+	return append(dag.Completion{}, snd.prog...)
 }
 
 // Updates returns a read-only channel of Completion objects that depict
-// transfer state
+// transfer state. Updates are copies of the push's completion & never hold up
+// the push, an unread update is replaced by the latest state. The channel
+// closes once the next call to Do, Retry or Resume returns, so updates of a
+// retry need a fresh call to Updates
 func (snd *Push) Updates() <-chan dag.Completion {
-	return snd.progCh
+	return snd.progCh.updates()
+}
+
+// Progress returns a channel of completion snapshots, updated as blocks are
+// sent. Unlike Updates, Progress snapshots are copies of the completion. The
+// channel holds only the latest snapshot, & never holds up the push. The
+// channel closes once the next call to Do, Retry or Resume returns, so
+// progress of a retry needs a fresh call to Progress. Channels requested
+// after a push returns are closed
+func (snd *Push) Progress() <-chan dag.Completion {
+	return snd.progress.subscribe()
 }
 
+// start opens completion updates for a call to Do, Retry or Resume
+func (snd *Push) start() {
+	snd.progCh.reopen()
+	snd.progress.reopen()
+}
+
+// finish sends final completion updates & closes update channels
+func (snd *Push) finish() {
+	snd.progress.finish(snd.completion())
+	snd.progCh.close()
+}
+
+// completionChanged publishes a copy of the completion, taken under the
+// progress lock so updates are published in order
 func (snd *Push) completionChanged() {
-	snd.progCh <- snd.prog
+	snd.progLock.Lock()
+	final := snd.prog.Complete()
+	snd.progLock.Unlock()
+	snd.throttle.throttle(final, func() {
+		snd.progLock.Lock()
+		defer snd.progLock.Unlock()
+		c := append(dag.Completion{}, snd.prog...)
+		snd.progress.publish(c)
+		snd.progCh.send(c)
+	})
 }
 
 // sender is a parallelizable, stateless struct that sends blocks
@@ -251,6 +824,9 @@ type sender struct {
 	sid       string
 	lng       ipld.NodeGetter
 	remote    DagSyncable
+	chunkSize int
+	deltas    *deltaEncoder
+	onPart    func(hash string, pct uint16)
 	blocksCh  chan string
 	responses chan ReceiveResponse
 	stopCh    chan bool
@@ -264,8 +840,12 @@ func (s sender) start(ctx context.Context) {
 			// we're (probably) firing off a blocking call to s.remote.PutBlock that's
 			// waiting on a network response. This can prevent reading on stopCh & ctx.Done
 			// which is very bad, so we fire a goroutine to prevent the select loop from
-			// ever blocking. Concurrency is fun!
+			// ever blocking. Concurrency is fun! Each sender waits for it's block to
+			// be sent before taking the next, so senders bound the number of blocks
+			// in flight
+			sent := make(chan struct{})
 			go func() {
+				defer close(sent)
 				id, err := cid.Parse(hash)
 				if err != nil {
 					log.Debugf("error parsing sent block: %s", err)
@@ -274,6 +854,7 @@ func (s sender) start(ctx context.Context) {
 						Status: StatusErrored,
 						Err:    err,
 					}
+					return
 				}
 				node, err := s.lng.Get(ctx, id)
 				if err != nil {
@@ -284,9 +865,17 @@ func (s sender) start(ctx context.Context) {
 					}
 					return
 				}
-				s.responses <- s.remote.ReceiveBlock(s.sid, hash, node.RawData())
+				s.responses <- s.receive(ctx, hash, node.RawData())
 			}()
 
+			select {
+			case <-sent:
+			case <-s.stopCh:
+				return
+			case <-ctx.Done():
+				return
+			}
+
 		case <-s.stopCh:
 			return
 		case <-ctx.Done():
@@ -296,6 +885,55 @@ func (s sender) start(ctx context.Context) {
 	}
 }
 
+// receive sends block data to the remote. Blocks are sent as deltas if the
+// sender has a delta encoder & a suitable base, falling back to sending the
+// full block if the remote doesn't accept the delta. Blocks larger than the
+// chunk size are sent in parts if the remote supports it, stopping at the
+// first part that isn't accepted
+func (s sender) receive(ctx context.Context, hash string, data []byte) ReceiveResponse {
+	if s.deltas != nil {
+		if base, delta, ok := s.deltas.encode(ctx, data); ok {
+			var res ReceiveResponse
+			if cr, ok := s.remote.(ContextBlockDeltaReceiver); ok {
+				res = cr.ReceiveBlockDeltaContext(ctx, s.sid, hash, base, delta)
+			} else {
+				res = s.remote.(BlockDeltaReceiver).ReceiveBlockDelta(s.sid, hash, base, delta)
+			}
+			if res.Status == StatusOk {
+				return res
+			}
+			log.Debugf("delta send failed, sending full block. hash=%q base=%q err=%q", hash, base, res.Err)
+		}
+	}
+
+	pr, ok := s.remote.(BlockPartReceiver)
+	if !ok || s.chunkSize <= 0 || len(data) <= s.chunkSize {
+		if cr, ok := s.remote.(ContextBlockReceiver); ok {
+			return cr.ReceiveBlockContext(ctx, s.sid, hash, data)
+		}
+		return s.remote.ReceiveBlock(s.sid, hash, data)
+	}
+
+	for offset, end := 0, 0; ; offset = end {
+		end = offset + s.chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		var res ReceiveResponse
+		if cr, ok := s.remote.(ContextBlockPartReceiver); ok {
+			res = cr.ReceiveBlockPartContext(ctx, s.sid, hash, offset, len(data), data[offset:end])
+		} else {
+			res = pr.ReceiveBlockPart(s.sid, hash, offset, len(data), data[offset:end])
+		}
+		if res.Status != StatusOk || end == len(data) {
+			return res
+		}
+		if s.onPart != nil {
+			s.onPart(hash, uint16(end*100/len(data)))
+		}
+	}
+}
+
 func (s sender) stop() {
 	go func() {
 		s.stopCh <- true
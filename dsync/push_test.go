@@ -1,9 +1,25 @@
 package dsync
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	"github.com/ipfs/interface-go-ipfs-core/path"
 	"github.com/qri-io/dag"
 )
 
@@ -43,3 +59,1028 @@ func TestPush(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestPushPriority(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, _, info := newTestDAG(t, "priority", 3, 2)
+
+	// prioritize the last two nodes in the manifest, which are leaves
+	nodes := info.Manifest.Nodes
+	urgent := map[string]bool{nodes[len(nodes)-1]: true, nodes[len(nodes)-2]: true}
+
+	rem, remote := newTestRemote(t)
+	s := httptest.NewServer(HTTPRemoteHandler(rem))
+	defer s.Close()
+
+	ds, err := New(local.nodeGetter(), local, func(cfg *Config) {
+		cfg.Priority = func(id string) int {
+			if urgent[id] {
+				return 1
+			}
+			return 0
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	push, err := ds.NewPushInfo(info, s.URL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := push.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	puts := remote.Puts()
+	if len(puts) != len(nodes) {
+		t.Fatalf("expected %d blocks to be received, got %d", len(nodes), len(puts))
+	}
+	for i, id := range puts[:len(urgent)] {
+		if !urgent[id] {
+			t.Errorf("expected block %d to be high priority. got: %s", i, id)
+		}
+	}
+	if puts[len(urgent)] != nodes[0] {
+		t.Errorf("expected normal priority blocks to keep manifest order, starting with root. got: %s", puts[len(urgent)])
+	}
+}
+
+func TestPushManifestHash(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, root, info := newTestDAG(t, "integrity", 2, 2)
+
+	rem, remote := newTestRemote(t, func(cfg *Config) {
+		cfg.ManifestHashStore = dag.NewMemManifestHashStore()
+	})
+	s := httptest.NewServer(HTTPRemoteHandler(rem))
+	defer s.Close()
+
+	if _, _, err := rem.NewReceiveSession(info, false, map[string]string{ManifestHashMetaKey: "bad_hash"}); !errors.Is(err, ErrManifestHashMismatch) {
+		t.Errorf("expected session with a mismatched manifest hash to be rejected. got: %v", err)
+	}
+
+	ds, err := New(local.nodeGetter(), local, func(cfg *Config) {
+		cfg.AttachManifestHash = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	push, err := ds.NewPushInfo(info, s.URL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := push.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	cli := &HTTPClient{URL: s.URL}
+	if err := cli.VerifyManifestHash(ctx, root.Cid().String()); err != nil {
+		t.Errorf("expected freshly pushed DAG to verify. got: %s", err)
+	}
+
+	// corrupt an interior block on the remote, replacing it's data with that
+	// of a leaf
+	interior := root.Links()[0].Cid
+	sibling, err := local.GetNode(ctx, root.Links()[1].Cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := local.GetNode(ctx, sibling.Links()[0].Cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote.lock.Lock()
+	remote.blocks[interior.KeyString()] = leaf.RawData()
+	remote.lock.Unlock()
+
+	if err := cli.VerifyManifestHash(ctx, root.Cid().String()); !errors.Is(err, ErrManifestHashMismatch) {
+		t.Errorf("expected corrupted DAG to fail verification with a hash mismatch. got: %v", err)
+	}
+}
+
+func TestPushAlreadyComplete(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, root, info := newTestDAG(t, "idempotent", 3, 2)
+
+	rem, remote := newTestRemote(t, func(cfg *Config) {
+		cfg.ManifestHashStore = dag.NewMemManifestHashStore()
+		cfg.PinAPI = newMemPinAPI()
+	})
+
+	// count bytes of session-opening request bodies the remote reads
+	var (
+		lock      sync.Mutex
+		bodyBytes int
+	)
+	handler := HTTPRemoteHandler(rem)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			data, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			r.Body = ioutil.NopCloser(&countingReader{r: bytes.NewReader(data), count: func(n int) {
+				lock.Lock()
+				bodyBytes += n
+				lock.Unlock()
+			}})
+		}
+		handler(w, r)
+	}))
+	defer s.Close()
+
+	ds, err := New(local.nodeGetter(), local, func(cfg *Config) {
+		cfg.AttachManifestHash = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		push, err := ds.NewPushInfo(info, s.URL, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := push.Do(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if !push.completion().Complete() {
+			t.Errorf("push %d: expected push to be complete", i)
+		}
+
+		lock.Lock()
+		puts, read := len(remote.Puts()), bodyBytes
+		bodyBytes = 0
+		lock.Unlock()
+		switch i {
+		case 0:
+			if puts != len(info.Manifest.Nodes) || read == 0 {
+				t.Errorf("expected first push to send the manifest & all %d blocks. got %d blocks, %d manifest bytes", len(info.Manifest.Nodes), puts, read)
+			}
+		case 1:
+			if puts != len(info.Manifest.Nodes) {
+				t.Errorf("expected second push to transfer zero blocks. got: %d", puts-len(info.Manifest.Nodes))
+			}
+			if read != 0 {
+				t.Errorf("expected second push manifest not to be decoded. remote read %d bytes", read)
+			}
+		}
+	}
+
+	// a restarted remote doesn't manage the DAG, but still has all of it's
+	// blocks & can skip unpinned pushes
+	hashes := rem.manifestHashStore
+	restarted, err := New(remote.nodeGetter(), remote, acceptPushes, func(cfg *Config) {
+		cfg.ManifestHashStore = hashes
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootStr := root.Cid().String()
+	bad := map[string]string{ManifestHashMetaKey: "not-the-hash"}
+	if complete, _ := restarted.receiveAlreadyComplete(ctx, rootStr, nil, false, bad); complete {
+		t.Error("expected a claimed hash that doesn't match the local manifest not to skip")
+	}
+
+	hash, err := info.Manifest.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := map[string]string{ManifestHashMetaKey: hash}
+	if sid, _, err := restarted.NewReceiveSession(info, false, good); err != nil {
+		t.Fatal(err)
+	} else if sid != "" {
+		t.Error("expected unpinned push to a restarted remote to skip opening a session")
+	}
+	other := &dag.Manifest{Nodes: []string{rootStr}}
+	if complete, _ := restarted.receiveAlreadyComplete(ctx, rootStr, other, false, good); complete {
+		t.Error("expected a manifest that doesn't match the claimed hash not to skip")
+	}
+	if complete, _ := restarted.receiveAlreadyComplete(ctx, rootStr, nil, true, good); complete {
+		t.Error("expected a pinned push of an unmanaged DAG not to skip")
+	}
+
+	id, err := info.Manifest.NodeCID(len(info.Manifest.Nodes) - 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Rm(ctx, path.IpldPath(id)); err != nil {
+		t.Fatal(err)
+	}
+	if complete, _ := restarted.receiveAlreadyComplete(ctx, rootStr, nil, false, good); complete {
+		t.Error("expected an unmanaged DAG missing blocks not to skip")
+	}
+}
+
+// countingReader reports the number of bytes read from r
+type countingReader struct {
+	r     io.Reader
+	count func(n int)
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.count(n)
+	return n, err
+}
+
+func TestPushProbeRemoteBlocks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local := newMemStore()
+	a := addTestDAG(t, local, "probe", 2, 2)
+
+	// b shares the first subtree of a, adding a new leaf
+	leaf := merkledag.NodeWithData([]byte("probe leaf"))
+	local.putNode(leaf)
+	b := merkledag.NodeWithData([]byte("probe b"))
+	if err := b.AddRawLink("0", a.Links()[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddNodeLink("1", leaf); err != nil {
+		t.Fatal(err)
+	}
+	local.putNode(b)
+
+	rem, remote := newTestRemote(t, func(cfg *Config) {
+		cfg.RequireAllBlocks = true
+	})
+	s := httptest.NewServer(HTTPRemoteHandler(rem))
+	defer s.Close()
+
+	ds, err := New(local.nodeGetter(), local, func(cfg *Config) {
+		cfg.ProbeRemoteBlocks = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, root := range []cid.Cid{a.Cid(), b.Cid()} {
+		info, err := dag.NewInfo(ctx, local.nodeGetter(), root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		push, err := ds.NewPushInfo(info, s.URL, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := push.Do(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	puts := remote.Puts()
+	if len(puts) != 9 {
+		t.Fatalf("expected 7 blocks for the first push & 2 for the second. got: %d", len(puts))
+	}
+	expect := map[string]bool{b.Cid().String(): true, leaf.Cid().String(): true}
+	for _, id := range puts[7:] {
+		if !expect[id] {
+			t.Errorf("expected second push to skip shared block %s", id)
+		}
+	}
+}
+
+func TestPushPlan(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local := newMemStore()
+	a := addTestDAG(t, local, "plan", 2, 2)
+
+	// b shares the first subtree of a, adding a new leaf
+	leaf := merkledag.NodeWithData([]byte("plan leaf"))
+	local.putNode(leaf)
+	b := merkledag.NodeWithData([]byte("plan b"))
+	if err := b.AddRawLink("0", a.Links()[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddNodeLink("1", leaf); err != nil {
+		t.Fatal(err)
+	}
+	local.putNode(b)
+
+	rem, remote := newTestRemote(t, func(cfg *Config) {
+		cfg.RequireAllBlocks = true
+	})
+	s := httptest.NewServer(HTTPRemoteHandler(rem))
+	defer s.Close()
+
+	ds, err := New(local.nodeGetter(), local, func(cfg *Config) {
+		cfg.ProbeRemoteBlocks = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := 0
+	for _, root := range []cid.Cid{a.Cid(), b.Cid()} {
+		info, err := dag.NewInfo(ctx, local.nodeGetter(), root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		push, err := ds.NewPushInfo(info, s.URL, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := push.Plan(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if puts := len(remote.Puts()); puts != sent {
+			t.Fatalf("expected plan not to transfer blocks. remote got %d", puts-sent)
+		}
+
+		var total uint64
+		for i, id := range plan.CIDs {
+			idx := info.Manifest.IDIndex(id)
+			if idx < 0 {
+				t.Fatalf("planned block %s isn't in the manifest", id)
+			}
+			if plan.Sizes[i] != info.Sizes[idx] {
+				t.Errorf("expected block %s size %d. got: %d", id, info.Sizes[idx], plan.Sizes[i])
+			}
+			total += plan.Sizes[i]
+		}
+		if plan.TotalSize != total {
+			t.Errorf("expected total size %d. got: %d", total, plan.TotalSize)
+		}
+
+		if err := push.Do(ctx); err != nil {
+			t.Fatal(err)
+		}
+		puts := remote.Puts()[sent:]
+		sent += len(puts)
+		// blocks are sent in parallel, & may arrive out of plan order
+		planned := append([]string{}, plan.CIDs...)
+		sort.Strings(planned)
+		sort.Strings(puts)
+		if strings.Join(planned, ",") != strings.Join(puts, ",") {
+			t.Errorf("expected push to send planned blocks.\nplanned: %v\nsent:    %v", plan.CIDs, puts)
+		}
+	}
+
+	// the second plan probes for blocks shared with the first DAG
+	if sent != 9 {
+		t.Errorf("expected 7 blocks for the first push & 2 for the second. got: %d", sent)
+	}
+}
+
+func TestPushProgressInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, _, info := newTestDAG(t, "interval", 10, 2)
+
+	rem, _ := newTestRemote(t)
+	s := httptest.NewServer(HTTPRemoteHandler(rem))
+	defer s.Close()
+
+	interval := time.Millisecond * 20
+	ds, err := New(local.nodeGetter(), local, func(cfg *Config) {
+		cfg.ProgressInterval = interval
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	push, err := ds.NewPushInfo(info, s.URL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		lock     sync.Mutex
+		updates  int
+		complete bool
+	)
+	go func() {
+		for c := range push.Updates() {
+			lock.Lock()
+			updates++
+			complete = complete || c.Complete()
+			lock.Unlock()
+		}
+	}()
+
+	start := time.Now()
+	if err := push.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// wait out any trailing update
+	time.Sleep(interval * 3)
+	elapsed := time.Since(start)
+
+	lock.Lock()
+	defer lock.Unlock()
+	// one update per interval, plus the initial & final updates
+	max := int(elapsed/interval) + 2
+	if updates > max {
+		t.Errorf("expected at most %d updates over %s. got: %d", max, elapsed, updates)
+	}
+	if !complete {
+		t.Error("expected a final update reporting completion")
+	}
+}
+
+func TestPushCodecs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// protobuf nodes with raw leaves
+	local := newMemStore()
+	root := merkledag.NodeWithData([]byte("root"))
+	for i := 0; i < 2; i++ {
+		dir := merkledag.NodeWithData([]byte(fmt.Sprintf("dir.%d", i)))
+		for j := 0; j < 3; j++ {
+			leaf := merkledag.NewRawNode([]byte(fmt.Sprintf("leaf.%d.%d", i, j)))
+			local.putNode(leaf)
+			if err := dir.AddNodeLink(fmt.Sprintf("%d", j), leaf); err != nil {
+				t.Fatal(err)
+			}
+		}
+		local.putNode(dir)
+		if err := root.AddNodeLink(fmt.Sprintf("%d", i), dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	local.putNode(root)
+
+	pins := newMemPinAPI()
+	rem, remote := newTestRemote(t, func(cfg *Config) {
+		cfg.PinAPI = pins
+	})
+	s := httptest.NewServer(HTTPRemoteHandler(rem))
+	defer s.Close()
+
+	info, err := dag.NewInfo(ctx, local.nodeGetter(), root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	structure, err := New(local.nodeGetter(), local, func(cfg *Config) {
+		cfg.PushCodecs = []uint64{cid.DagProtobuf}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	push, err := structure.NewPushInfo(info, s.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := push.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, idStr := range info.Manifest.Nodes {
+		id, _ := cid.Parse(idStr)
+		if has := remote.has(id); has != (id.Type() == cid.DagProtobuf) {
+			t.Errorf("block %s with codec %s: expected remote to have block: %t, got: %t", id, cid.CodecToStr[id.Type()], !has, has)
+		}
+	}
+	if roots := rem.PartialRoots(); len(roots) != 1 || !roots[0].Equals(root.Cid()) {
+		t.Errorf("expected %s to be the only partial root. got: %v", root.Cid(), roots)
+	}
+	if _, pinned, _ := pins.IsPinned(ctx, path.IpfsPath(root.Cid())); pinned {
+		t.Errorf("expected partial DAG not to be pinned")
+	}
+}
+
+func TestPushOnBlockReceived(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, _, info := newTestDAG(t, "block_hook", 3, 2)
+	sizes := map[string]int{}
+	for _, idStr := range info.Manifest.Nodes {
+		id, _ := cid.Parse(idStr)
+		data, _ := local.rawData(id)
+		sizes[idStr] = len(data)
+	}
+
+	var (
+		lock     sync.Mutex
+		received map[string]int
+	)
+	hook := func(_ context.Context, hash string, size int, meta map[string]string) {
+		lock.Lock()
+		defer lock.Unlock()
+		received[hash]++
+		if size != sizes[hash] {
+			t.Errorf("block %s: expected size %d. got: %d", hash, sizes[hash], size)
+		}
+		if meta["user"] != "test" {
+			t.Errorf("block %s: expected push meta to be passed to hook. got: %v", hash, meta)
+		}
+	}
+
+	cases := []struct {
+		description string
+		remote      func(rem *Dsync) DagSyncable
+	}{
+		{"streamed", func(rem *Dsync) DagSyncable { return rem }},
+		// faultyRemote doesn't stream, with no faults it sends block-by-block
+		{"per-block", func(rem *Dsync) DagSyncable { return newFaultyRemote(rem, 1) }},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			received = map[string]int{}
+			rem, _ := newTestRemote(t, func(cfg *Config) {
+				cfg.OnBlockReceived = hook
+			})
+
+			push, err := NewPush(local.nodeGetter(), info, c.remote(rem), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			push.SetMeta(map[string]string{"user": "test"})
+			if err := push.Do(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			if len(received) != len(info.Manifest.Nodes) {
+				t.Errorf("expected hook to fire for %d blocks. got: %d", len(info.Manifest.Nodes), len(received))
+			}
+			for hash, count := range received {
+				if count != 1 {
+					t.Errorf("block %s: expected hook to fire once. got: %d", hash, count)
+				}
+			}
+		})
+	}
+}
+
+func TestPushRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, root, info := newTestDAG(t, "retry", 3, 2)
+
+	remDs, remote := newTestRemote(t)
+	rem := newFaultyRemote(remDs, 1)
+	rem.outageAfter = 5
+
+	push, err := NewPush(local.nodeGetter(), info, rem, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := push.Do(ctx); err == nil {
+		t.Fatal("expected push to fail during outage")
+	}
+
+	// wait for responses to blocks accepted before the outage to land
+	deadline := time.Now().Add(time.Second)
+	for push.completion().CompletedBlocks() < 5 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 5)
+	}
+	if done := push.completion().CompletedBlocks(); done != 5 {
+		t.Fatalf("expected 5 blocks complete before retrying. got: %d", done)
+	}
+
+	completed := map[string]bool{}
+	for i, pct := range push.completion() {
+		if pct == 100 {
+			completed[info.Manifest.Nodes[i]] = true
+		}
+	}
+	first := rem.restore()
+	if err := push.Retry(ctx); err != nil {
+		t.Fatal(err)
+	}
+	resent := rem.restore()
+
+	// blocks in flight at the outage may be sent again, blocks completed
+	// before it must not be
+	delivered := map[string]bool{}
+	for _, hash := range first {
+		delivered[hash] = true
+	}
+	for _, hash := range resent {
+		if completed[hash] {
+			t.Errorf("block %s completed before the outage sent again on retry", hash)
+		}
+		delivered[hash] = true
+	}
+	if len(delivered) != len(info.Manifest.Nodes) {
+		t.Errorf("expected all %d blocks to be delivered. got: %d", len(info.Manifest.Nodes), len(delivered))
+	}
+	if _, err := dag.NewManifest(ctx, remote.nodeGetter(), root.Cid()); err != nil {
+		t.Errorf("expected DAG to be complete on remote after retry. error: %s", err)
+	}
+}
+
+func TestPushResume(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, root, info := newTestDAG(t, "resume", 3, 2)
+
+	remDs, remote := newTestRemote(t)
+	s := httptest.NewServer(HTTPRemoteHandler(remDs))
+	defer s.Close()
+
+	// the first push is interrupted after the remote gets 5 blocks
+	outage := newFaultyRemote(&HTTPClient{URL: s.URL}, 1)
+	outage.outageAfter = 5
+	interrupted, err := NewPush(local.nodeGetter(), info, outage, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := interrupted.Do(ctx); err == nil {
+		t.Fatal("expected push to fail during outage")
+	}
+	sid := interrupted.SessionID()
+	if sid == "" {
+		t.Fatal("expected interrupted push to have a session id")
+	}
+
+	// a new push, as if from a restarted process, picks up the session
+	resumed, err := NewPush(local.nodeGetter(), info, &HTTPClient{URL: s.URL}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resumed.Resume(ctx, sid); err != nil {
+		t.Fatal(err)
+	}
+	if expect := len(info.Manifest.Nodes) - 5; len(resumed.diff.Nodes) != expect {
+		t.Errorf("expected resumed push to send the remaining %d blocks. got: %d", expect, len(resumed.diff.Nodes))
+	}
+	if _, err := dag.NewManifest(ctx, remote.nodeGetter(), root.Cid()); err != nil {
+		t.Errorf("expected DAG to be complete on remote after resuming. error: %s", err)
+	}
+
+	// completed sessions are closed
+	if err := resumed.Resume(ctx, sid); err == nil {
+		t.Error("expected resuming a closed session to error")
+	}
+	unsupported, err := NewPush(local.nodeGetter(), info, newFaultyRemote(remDs, 1), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unsupported.Resume(ctx, sid); err == nil {
+		t.Error("expected resuming with a remote that doesn't report progress to error")
+	}
+}
+
+// contendedBlockAPI fails the first failures puts of each block with a
+// temporary error, like a blockstore under contention
+type contendedBlockAPI struct {
+	coreiface.BlockAPI
+	failures int
+
+	lock  sync.Mutex
+	puts  map[string]int
+	stuck map[string]bool // blocks that never stop failing
+}
+
+func (b *contendedBlockAPI) Put(ctx context.Context, r io.Reader, opts ...options.BlockPutOption) (coreiface.BlockStat, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	b.lock.Lock()
+	key := string(data)
+	b.puts[key]++
+	fail := b.puts[key] <= b.failures || b.stuck[key]
+	b.lock.Unlock()
+	if fail {
+		return nil, temporaryErr{}
+	}
+	return b.BlockAPI.Put(ctx, bytes.NewReader(data), opts...)
+}
+
+func TestPushBlockRetries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, root, info := newTestDAG(t, "contended", 3, 1)
+	stuckHash := info.Manifest.Nodes[2]
+	stuckID, err := cid.Parse(stuckHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stuckData, _ := local.rawData(stuckID)
+
+	push := func(t *testing.T, bapi *contendedBlockAPI, retries int) (*memStore, error) {
+		remote := newMemStore()
+		bapi.BlockAPI = remote
+		bapi.puts = map[string]int{}
+		remDs, err := New(remote.nodeGetter(), bapi, acceptPushes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := NewPush(local.nodeGetter(), info, newFaultyRemote(remDs, 1), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.retries = retries
+		p.retryBackoff = time.Millisecond
+		return remote, p.Do(ctx)
+	}
+
+	t.Run("recovers", func(t *testing.T) {
+		bapi := &contendedBlockAPI{failures: 2}
+		remote, err := push(t, bapi, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dag.NewManifest(ctx, remote.nodeGetter(), root.Cid()); err != nil {
+			t.Errorf("expected DAG to be complete on remote after retries. error: %s", err)
+		}
+		for _, n := range bapi.puts {
+			if n != 3 {
+				t.Errorf("expected each block to be put 3 times. got: %d", n)
+			}
+		}
+	})
+
+	t.Run("exhausted", func(t *testing.T) {
+		bapi := &contendedBlockAPI{failures: 1, stuck: map[string]bool{string(stuckData): true}}
+		remote, err := push(t, bapi, 2)
+		if !errors.Is(err, ErrRetriesExhausted) {
+			t.Fatalf("expected ErrRetriesExhausted. got: %v", err)
+		}
+		if !strings.Contains(err.Error(), stuckHash) {
+			t.Errorf("expected error to list the exhausted block. got: %s", err)
+		}
+		if n := bapi.puts[string(stuckData)]; n != 3 {
+			t.Errorf("expected exhausted block to be put 3 times. got: %d", n)
+		}
+		// every other block gets through before the push fails
+		for _, hash := range info.Manifest.Nodes {
+			id, _ := cid.Parse(hash)
+			if expect := hash != stuckHash; remote.has(id) != expect {
+				t.Errorf("block %s stored mismatch. expected: %t", hash, expect)
+			}
+		}
+	})
+
+	if _, err := New(newMemStore().nodeGetter(), newMemStore(), func(cfg *Config) { cfg.PushBlockRetries = -1 }); err == nil {
+		t.Error("expected negative PushBlockRetries to error")
+	}
+}
+
+func TestRetryTrackerBackoff(t *testing.T) {
+	tracker := newRetryTracker([]string{"a"}, 12, time.Millisecond*100)
+	expect := []time.Duration{
+		time.Millisecond * 100,
+		time.Millisecond * 200,
+		time.Millisecond * 400,
+		time.Millisecond * 800,
+		time.Millisecond * 1600,
+		time.Millisecond * 3200,
+		time.Millisecond * 6400,
+		maxPushRetryBackoff,
+		maxPushRetryBackoff,
+	}
+	for i, e := range expect {
+		got, ok := tracker.retry("a")
+		if !ok {
+			t.Fatalf("retry %d: expected block to have retries left", i)
+		}
+		if got != e {
+			t.Errorf("retry %d: delay mismatch. expected: %s, got: %s", i, e, got)
+		}
+	}
+}
+
+func TestPushParallelism(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, root, info := newTestDAG(t, "parallel", 3, 2)
+
+	completed := 0
+	remDs, remote := newTestRemote(t, func(cfg *Config) {
+		cfg.PushComplete = func(context.Context, dag.Info, map[string]string) error {
+			completed++
+			return nil
+		}
+	})
+	rem := newFaultyRemote(remDs, 1)
+	rem.delay = time.Millisecond * 5
+	rem.checkOrder(info.Manifest)
+
+	push, err := NewPush(local.nodeGetter(), info, rem, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	push.parallelism = 4
+	if err := push.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if !push.completion().Complete() {
+		t.Errorf("expected push completion to be complete. got: %v", push.completion())
+	}
+	if completed != 1 {
+		t.Errorf("expected PushComplete hook to be called once. got: %d", completed)
+	}
+	if len(rem.early) > 0 {
+		t.Errorf("expected blocks to be received after their children. received early: %v", rem.early)
+	}
+	if rem.maxInFlight < 2 || rem.maxInFlight > 4 {
+		t.Errorf("expected between 2 & 4 blocks in flight at once. got: %d", rem.maxInFlight)
+	}
+	if _, err := dag.NewManifest(ctx, remote.nodeGetter(), root.Cid()); err != nil {
+		t.Errorf("expected DAG to be complete on remote. error: %s", err)
+	}
+
+	// parallelism is configured on Dsync, & capped to the number of blocks
+	ds, err := New(local.nodeGetter(), local, func(cfg *Config) { cfg.PushParallelism = 4 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(HTTPRemoteHandler(remDs))
+	defer s.Close()
+	configured, err := ds.NewPushInfo(info, s.URL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configured.parallelism != 4 {
+		t.Errorf("expected push parallelism to be 4. got: %d", configured.parallelism)
+	}
+	single := &dag.Info{Manifest: &dag.Manifest{Nodes: info.Manifest.Nodes[:1]}, Sizes: info.Sizes[:1]}
+	if configured, err = ds.NewPushInfo(single, s.URL, false); err != nil {
+		t.Fatal(err)
+	}
+	if configured.parallelism != 1 {
+		t.Errorf("expected push parallelism to be capped at 1 block. got: %d", configured.parallelism)
+	}
+	if _, err := New(local.nodeGetter(), local, func(cfg *Config) { cfg.PushParallelism = 0 }); err == nil {
+		t.Error("expected PushParallelism of 0 to error")
+	}
+}
+
+func BenchmarkPushParallelism(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, _, info := newTestDAG(b, "benchmark parallel", 4, 2)
+
+	for _, parallelism := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("parallelism_%d", parallelism), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				remDs, _ := newTestRemote(b)
+				// every request to the remote takes at least 2ms
+				handler := HTTPRemoteHandler(remDs)
+				s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					time.Sleep(time.Millisecond * 2)
+					handler(w, r)
+				}))
+
+				push, err := NewPush(local.nodeGetter(), info, newFaultyRemote(&HTTPClient{URL: s.URL}, 1), false)
+				if err != nil {
+					b.Fatal(err)
+				}
+				push.parallelism = parallelism
+				b.StartTimer()
+
+				if err := push.Do(ctx); err != nil {
+					b.Fatal(err)
+				}
+
+				b.StopTimer()
+				s.Close()
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func TestPushProgress(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, _, info := newTestDAG(t, "progress", 3, 2)
+
+	remDs, _ := newTestRemote(t)
+
+	push, err := NewPush(local.nodeGetter(), info, newFaultyRemote(remDs, 1), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one subscriber reads as the push runs, the other never reads until the
+	// push is done. Nothing reads Updates
+	read, unread := push.Progress(), push.Progress()
+	snapshots := make(chan []dag.Completion)
+	go func() {
+		var got []dag.Completion
+		for c := range read {
+			got = append(got, c)
+		}
+		snapshots <- got
+	}()
+
+	if err := push.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-snapshots
+	if len(got) == 0 {
+		t.Fatal("expected progress snapshots")
+	}
+	if last := got[len(got)-1]; !last.Complete() {
+		t.Errorf("expected last snapshot to be complete. got: %v", last)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i].CompletedBlocks() < got[i-1].CompletedBlocks() {
+			t.Errorf("snapshot %d went backwards: %v -> %v", i, got[i-1], got[i])
+		}
+	}
+
+	// unread subscribers hold only the latest snapshot
+	c, ok := <-unread
+	if !ok || !c.Complete() {
+		t.Errorf("expected unread subscriber to hold the final snapshot. got: %v", c)
+	}
+	if _, ok := <-unread; ok {
+		t.Error("expected progress channel to close when Do returns")
+	}
+	if _, ok := <-push.Progress(); ok {
+		t.Error("expected progress requested after Do returns to be closed")
+	}
+
+	// unread updates hold the latest completion, & close when Do returns
+	updates := push.Updates()
+	if c, ok := <-updates; !ok || !c.Complete() {
+		t.Errorf("expected unread updates to hold the final completion. got: %v", c)
+	}
+	if _, ok := <-updates; ok {
+		t.Error("expected updates to close when Do returns")
+	}
+}
+
+func TestPushSendsMissingBlocks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, root, info := newTestDAG(t, "half present", 3, 2)
+
+	// the remote has every other block of the DAG
+	seed := func() *memStore {
+		remote := newMemStore()
+		for i, hash := range info.Manifest.Nodes {
+			if i%2 == 1 {
+				id, _ := cid.Parse(hash)
+				data, _ := local.rawData(id)
+				if _, err := remote.Put(ctx, bytes.NewReader(data)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		return remote
+	}
+
+	cases := []struct {
+		description      string
+		requireAllBlocks bool
+		expect           int
+	}{
+		{"diff", false, (len(info.Manifest.Nodes) + 1) / 2},
+		{"require all blocks", true, len(info.Manifest.Nodes)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			remote := seed()
+			before := len(remote.Puts())
+			rem, err := New(remote.nodeGetter(), remote, acceptPushes, func(cfg *Config) {
+				cfg.RequireAllBlocks = c.requireAllBlocks
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			push, err := NewPush(local.nodeGetter(), info, rem, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := push.Do(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if len(push.diff.Nodes) != c.expect {
+				t.Errorf("expected remote to request %d blocks. got: %d", c.expect, len(push.diff.Nodes))
+			}
+			if got := len(remote.Puts()) - before; got != c.expect {
+				t.Errorf("expected %d blocks to be sent. got: %d", c.expect, got)
+			}
+			if _, err := dag.NewManifest(ctx, remote.nodeGetter(), root.Cid()); err != nil {
+				t.Errorf("expected DAG to be complete on remote. error: %s", err)
+			}
+		})
+	}
+}
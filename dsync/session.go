@@ -1,18 +1,54 @@
 package dsync
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	ipld "github.com/ipfs/go-ipld-format"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	"github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/multiformats/go-multihash"
 	"github.com/qri-io/dag"
 )
 
+// BlockHaser is an optional interface for block APIs that can report if they
+// have a block without reading it. Receive sessions check stores that
+// implement BlockHaser before writing a block, skipping writes of blocks the
+// store already has
+type BlockHaser interface {
+	Has(ctx context.Context, id cid.Cid) (bool, error)
+}
+
+// maxBlockPartsSize is the largest block a session will assemble from parts.
+// Sizes of blocks sent in parts come from the sender, so they're checked
+// before any part is buffered
+const maxBlockPartsSize = 1 << 24
+
+// sessionIDKey is the context key for the id of a receive session
+type sessionIDKey struct{}
+
+// SessionIDFromContext returns the id of the receive session a hook is called
+// for. Every hook dsync calls for a push, from PushPreCheck to PushComplete,
+// sees the same id. Hooks called outside a session get an empty string
+func SessionIDFromContext(ctx context.Context) string {
+	sid, _ := ctx.Value(sessionIDKey{}).(string)
+	return sid
+}
+
 // session tracks the state of a transfer
 type session struct {
 	ctx  context.Context
@@ -28,6 +64,64 @@ type session struct {
 	progCh chan dag.Completion
 	lock   sync.Mutex
 	fin    bool
+	// index maps the hash of each manifest node to it's position in prog
+	index map[string]int
+	// closed is true once progCh is closed, guarded by lock
+	closed bool
+	// now is the clock activity is measured with, defaulting to time.Now
+	now func() time.Time
+	// unix time in nanoseconds the session last received a block
+	active int64
+	// partial is true when the sender limited the transfer to some codecs
+	partial bool
+	// framed is true when the sender's block stream is made of compression
+	// frames
+	framed bool
+
+	// putTimeout limits the duration of each block put, zero means no limit
+	putTimeout time.Duration
+	// throttle optionally limits the frequency of completion updates
+	throttle *updateThrottle
+	// onBlock is optionally called for each block placed
+	onBlock BlockHook
+	// store optionally places blocks in a sink through a transform, in place
+	// of bapi
+	store *transformingStore
+	// isTransient classifies put errors, defaulting to DefaultIsTransientErr
+	isTransient func(error) bool
+
+	// expectedBytes is the sum of the declared sizes of blocks in diff, zero
+	// when the info doesn't declare sizes
+	expectedBytes uint64
+
+	// checkpointDir is where the session saves checkpoints every
+	// checkpointInterval placed blocks, empty means no checkpoints
+	checkpointDir      string
+	checkpointInterval int
+	checkpointLock     sync.Mutex
+	// placed counts blocks marked complete, for timing checkpoints
+	placed int64
+
+	// sizes the info declares for blocks, keyed by hash. built on first use
+	sizesOnce sync.Once
+	sizes     map[string]declaredSize
+
+	// blocks being received in parts, keyed by hash
+	partsLock sync.Mutex
+	parts     map[string]*partialBlock
+}
+
+// declaredSize is the size an info declares for a block. exact sizes are
+// block sizes, others are an upper bound
+type declaredSize struct {
+	size  uint64
+	exact bool
+}
+
+// partialBlock is a block that has been partially received
+type partialBlock struct {
+	size int
+	data []byte
 }
 
 // newSession creates a receive state machine
@@ -42,8 +136,30 @@ func newSession(ctx context.Context, lng ipld.NodeGetter, bapi coreiface.BlockAP
 		}
 	}
 
+	partial := false
+	if str, ok := meta[CodecsMetaKey]; ok {
+		codecs, err := parseCodecs(str)
+		if err != nil {
+			return nil, err
+		}
+		if diff, err = filterCodecs(diff, codecs); err != nil {
+			return nil, err
+		}
+		partial = true
+	}
+
+	framed, err := parseBlockCompression(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	id := SessionIDFromContext(ctx)
+	if id == "" {
+		id = randStringBytesMask(10)
+	}
+
 	s = &session{
-		id:     randStringBytesMask(10),
+		id:     id,
 		ctx:    ctx,
 		lng:    lng,
 		bapi:   bapi,
@@ -52,19 +168,45 @@ func newSession(ctx context.Context, lng ipld.NodeGetter, bapi coreiface.BlockAP
 		pin:    pinOnComplete,
 		meta:   meta,
 		prog:   dag.NewCompletion(info.Manifest, diff),
-		progCh: make(chan dag.Completion),
+		index:  make(map[string]int, len(info.Manifest.Nodes)),
+		progCh: make(chan dag.Completion, 1),
+
+		partial: partial,
+		framed:  framed,
 	}
+	for i, h := range info.Manifest.Nodes {
+		s.index[h] = i
+	}
+	s.expectedBytes = s.incompleteBytes()
 
-	go s.completionChanged()
+	s.completionChanged()
 
 	log.Debugf("created session: %s", s.id)
 	return s, nil
 }
 
+// sized reports if the session's info declares the size of every block
+func (s *session) sized() bool {
+	return len(s.info.Sizes) == len(s.info.Manifest.Nodes)
+}
+
+// incompleteBytes sums the declared sizes of blocks that aren't complete,
+// zero when the info doesn't declare sizes
+func (s *session) incompleteBytes() (total uint64) {
+	if !s.sized() {
+		return 0
+	}
+	for i, done := range s.prog {
+		if done < 100 {
+			total += s.info.Sizes[i]
+		}
+	}
+	return total
+}
+
 // ReceiveBlock accepts a block from the sender, placing it in the local blockstore
 func (s *session) ReceiveBlock(hash string, data io.Reader) ReceiveResponse {
-	bstat, err := s.bapi.Put(s.ctx, data)
-
+	raw, err := ioutil.ReadAll(data)
 	if err != nil {
 		return ReceiveResponse{
 			Hash:   hash,
@@ -72,56 +214,500 @@ func (s *session) ReceiveBlock(hash string, data io.Reader) ReceiveResponse {
 			Err:    err,
 		}
 	}
+	if err := s.checkSize(hash, len(raw)); err != nil {
+		return ReceiveResponse{
+			Hash:   hash,
+			Status: StatusErrored,
+			Err:    err,
+		}
+	}
+
+	haser, ok := s.bapi.(BlockHaser)
+	if s.store != nil {
+		haser, ok = s.store, true
+	}
+	if ok {
+		present, err := s.blockPresent(haser, hash, raw)
+		if err != nil {
+			return ReceiveResponse{
+				Hash:   hash,
+				Status: StatusErrored,
+				Err:    err,
+			}
+		}
+		if present {
+			log.Debugf("block already present, skipping put. hash=%q", hash)
+			s.blockComplete(hash)
+			return ReceiveResponse{
+				Hash:   hash,
+				Status: StatusOk,
+			}
+		}
+	}
+
+	if s.store != nil {
+		return s.putTransformed(hash, raw)
+	}
+
+	bstat, err := s.putBlock(bytes.NewReader(raw))
+
+	if err != nil {
+		return ReceiveResponse{
+			Hash:   hash,
+			Status: s.putErrStatus(err),
+			Err:    &BlockPutError{Cid: hash, Err: err},
+		}
+	}
 
-	id := bstat.Path().Cid()
-	if id.String() != hash {
+	if err := compareCIDs(hash, bstat.Path().Cid()); err != nil {
 		return ReceiveResponse{
 			Hash:   hash,
 			Status: StatusErrored,
-			Err:    fmt.Errorf("hash mismatch. expected: '%s', got: '%s'", hash, id.String()),
+			Err:    err,
+		}
+	}
+	if s.onBlock != nil {
+		s.onBlock(s.ctx, hash, bstat.Size(), s.meta)
+	}
+
+	s.blockComplete(hash)
+	return ReceiveResponse{
+		Hash:   hash,
+		Status: StatusOk,
+	}
+}
+
+// compareCIDs checks the CID a block was stored under matches the CID it was
+// sent as, field by field. CID versions aren't compared: a CIDv0 & a CIDv1
+// with the same codec & multihash address the same block. Errors wrap
+// ErrHashMismatch, listing each field that differs
+func compareCIDs(expected string, got cid.Cid) error {
+	mismatch := func(reason string) error {
+		return &HashMismatchError{Expected: expected, Got: got.String(), Reason: reason}
+	}
+	exp, err := cid.Parse(expected)
+	if err != nil {
+		return mismatch(fmt.Sprintf("parsing expected cid: %s", err))
+	}
+
+	var diffs []string
+	if exp.Type() != got.Type() {
+		diffs = append(diffs, fmt.Sprintf("codec %s, expected %s", codecName(got.Type()), codecName(exp.Type())))
+	}
+	expHash, err := multihash.Decode(exp.Hash())
+	if err != nil {
+		return mismatch(fmt.Sprintf("decoding expected multihash: %s", err))
+	}
+	gotHash, err := multihash.Decode(got.Hash())
+	if err != nil {
+		return mismatch(fmt.Sprintf("decoding multihash: %s", err))
+	}
+	switch {
+	case expHash.Code != gotHash.Code:
+		diffs = append(diffs, fmt.Sprintf("hash function %s, expected %s", gotHash.Name, expHash.Name))
+	case expHash.Length != gotHash.Length:
+		diffs = append(diffs, fmt.Sprintf("digest length %d, expected %d", gotHash.Length, expHash.Length))
+	case !bytes.Equal(expHash.Digest, gotHash.Digest):
+		diffs = append(diffs, "digest differs")
+	}
+
+	if len(diffs) > 0 {
+		return mismatch(strings.Join(diffs, ", "))
+	}
+	return nil
+}
+
+// codecName returns the name of a CID codec, or it's number if it's unknown
+func codecName(codec uint64) string {
+	if name, ok := cid.CodecToStr[codec]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%x", codec)
+}
+
+// readBlock returns the data of a block in the local store
+func (s *session) readBlock(hash string) ([]byte, error) {
+	if s.store != nil {
+		id, err := cid.Parse(hash)
+		if err != nil {
+			return nil, err
 		}
+		return s.store.get(s.ctx, id)
+	}
+	r, err := s.bapi.Get(s.ctx, path.New(hash))
+	if err != nil {
+		return nil, err
 	}
+	return ioutil.ReadAll(r)
+}
 
-	// this should be the only place that modifies progress
-	for i, h := range s.info.Manifest.Nodes {
-		if hash == h {
-			s.prog[i] = 100
+// DefaultIsTransientErr reports if a block put error is likely to clear up
+// if the put is retried. Timeouts and errors that report themselves as
+// temporary or as timeouts, like many network & syscall errors, are
+// transient. All other errors are treated as permanent
+func DefaultIsTransientErr(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var temp interface{ Temporary() bool }
+	if errors.As(err, &temp) && temp.Temporary() {
+		return true
+	}
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
+
+// putErrStatus is the response status for a failed block put. Senders retry
+// blocks that failed with transient errors, & give up on the rest
+func (s *session) putErrStatus(err error) ReceiveResponseStatus {
+	isTransient := s.isTransient
+	if isTransient == nil {
+		isTransient = DefaultIsTransientErr
+	}
+	if isTransient(err) {
+		return StatusRetry
+	}
+	return StatusErrored
+}
+
+// putTransformed places a received block in the session's transforming
+// store. Blocks are verified against their CID before being transformed, as
+// the stored data won't hash to it
+func (s *session) putTransformed(hash string, data []byte) ReceiveResponse {
+	if err := verifyBlock(hash, data); err != nil {
+		return ReceiveResponse{
+			Hash:   hash,
+			Status: StatusErrored,
+			Err:    err,
 		}
 	}
-	go s.completionChanged()
+	if err := s.store.put(s.ctx, hash, data); err != nil {
+		return ReceiveResponse{
+			Hash:   hash,
+			Status: s.putErrStatus(err),
+			Err:    &BlockPutError{Cid: hash, Err: err},
+		}
+	}
+	if s.onBlock != nil {
+		s.onBlock(s.ctx, hash, len(data), s.meta)
+	}
 
+	s.blockComplete(hash)
 	return ReceiveResponse{
 		Hash:   hash,
 		Status: StatusOk,
 	}
 }
 
+// checkSize returns an error wrapping ErrBlockSizeMismatch if size
+// contradicts the size the session's info declares for hash. Depending on the
+// node getter that built it, an info can declare the size of a node's block
+// or the cumulative size of the node & it's descendants, so blocks of nodes
+// with links may be smaller than declared, but never larger. Blocks of nodes
+// without links must match exactly. Infos without sizes pass
+func (s *session) checkSize(hash string, size int) error {
+	s.sizesOnce.Do(func() {
+		if len(s.info.Sizes) != len(s.info.Manifest.Nodes) {
+			return
+		}
+		parents := map[int]bool{}
+		for _, l := range s.info.Manifest.Links {
+			parents[l[0]] = true
+		}
+		s.sizes = make(map[string]declaredSize, len(s.info.Manifest.Nodes))
+		for i, h := range s.info.Manifest.Nodes {
+			s.sizes[h] = declaredSize{size: s.info.Sizes[i], exact: !parents[i]}
+		}
+	})
+
+	declared, ok := s.sizes[hash]
+	if !ok {
+		return nil
+	}
+	if got := uint64(size); got > declared.size || (declared.exact && got != declared.size) {
+		return fmt.Errorf("%w: block %s is %d bytes, info declares %d", ErrBlockSizeMismatch, hash, size, declared.size)
+	}
+	return nil
+}
+
+// blockComplete marks a received block as complete
+func (s *session) blockComplete(hash string) {
+	s.touch()
+	s.setProgress(hash, 100)
+	s.completionChanged()
+	if s.checkpointDir != "" && atomic.AddInt64(&s.placed, 1)%int64(s.checkpointInterval) == 0 {
+		if err := s.saveCheckpoint(); err != nil {
+			log.Errorf("saving session checkpoint. sid=%q err=%q", s.id, err)
+		}
+	}
+}
+
+// setProgress sets the completion of a block in the manifest, looking up it's
+// index instead of scanning manifest nodes, which is quadratic over a whole
+// transfer. Hashes that aren't in the manifest are ignored
+func (s *session) setProgress(hash string, pct uint16) {
+	if i, ok := s.index[hash]; ok {
+		s.prog[i] = pct
+	}
+}
+
+// blockPresent reports if the store already has the block data claims to be.
+// Blocks are verified to match hash before relying on the stored copy, a
+// block that doesn't match is an error even if the store has hash
+func (s *session) blockPresent(haser BlockHaser, hash string, data []byte) (bool, error) {
+	id, err := cid.Parse(hash)
+	if err != nil {
+		return false, err
+	}
+	has, err := haser.Has(s.ctx, id)
+	if err != nil || !has {
+		// fall back to writing the block if the store can't say
+		return false, nil
+	}
+	if err := verifyBlock(hash, data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// blocksPresent marks blocks that are already in the local store as complete
+func (s *session) blocksPresent(ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	for _, id := range ids {
+		s.setProgress(id, 100)
+	}
+	s.completionChanged()
+}
+
+// ReceiveBlockPart accepts a sequential part of a block that's been split for
+// transfer. Parts must arrive in order, starting at offset zero. While parts
+// are arriving the block's completion reports the percentage of bytes
+// received, capped at 99. The final part writes the assembled block with
+// ReceiveBlock, which verifies the block hash before marking it complete.
+// Sending a part at offset zero restarts the block
+func (s *session) ReceiveBlockPart(hash string, offset, size int, data []byte) ReceiveResponse {
+	if size <= 0 || offset < 0 || offset+len(data) > size {
+		return ReceiveResponse{
+			Hash:   hash,
+			Status: StatusErrored,
+			Err:    fmt.Errorf("invalid block part. offset: %d, length: %d, block size: %d", offset, len(data), size),
+		}
+	}
+	if _, ok := s.index[hash]; !ok {
+		return ReceiveResponse{
+			Hash:   hash,
+			Status: StatusErrored,
+			Err:    fmt.Errorf("block %s isn't in the session manifest", hash),
+		}
+	}
+	if size > maxBlockPartsSize {
+		return ReceiveResponse{
+			Hash:   hash,
+			Status: StatusErrored,
+			Err:    fmt.Errorf("block %s of %d bytes exceeds the %d byte limit for blocks sent in parts", hash, size, maxBlockPartsSize),
+		}
+	}
+	if err := s.checkSize(hash, size); err != nil {
+		return ReceiveResponse{
+			Hash:   hash,
+			Status: StatusErrored,
+			Err:    err,
+		}
+	}
+
+	s.partsLock.Lock()
+	if s.parts == nil {
+		s.parts = map[string]*partialBlock{}
+	}
+	pb, ok := s.parts[hash]
+	if offset == 0 {
+		// the buffer grows as parts arrive, senders can't claim memory with
+		// the size alone
+		pb = &partialBlock{size: size}
+		s.parts[hash] = pb
+	} else if !ok {
+		// a block's first part must start it's buffer
+		pb = &partialBlock{size: size}
+	}
+	if pb.size != size || len(pb.data) != offset {
+		s.partsLock.Unlock()
+		return ReceiveResponse{
+			Hash:   hash,
+			Status: StatusErrored,
+			Err:    fmt.Errorf("block part out of order. expected offset: %d, got: %d", len(pb.data), offset),
+		}
+	}
+	pb.data = append(pb.data, data...)
+	received := len(pb.data)
+	if received == size {
+		delete(s.parts, hash)
+	}
+	s.partsLock.Unlock()
+
+	if received == size {
+		return s.ReceiveBlock(hash, bytes.NewReader(pb.data))
+	}
+
+	pct := uint16(received * 100 / size)
+	if pct > 99 {
+		pct = 99
+	}
+	s.setProgress(hash, pct)
+	s.completionChanged()
+
+	return ReceiveResponse{
+		Hash:   hash,
+		Status: StatusOk,
+	}
+}
+
+// putBlock writes block data to the blockstore. If the session has a put
+// timeout, putBlock gives up waiting on the store once the timeout elapses
+// and returns an error wrapping context.DeadlineExceeded. A store that ignores
+// context cancellation may still complete the put in the background
+func (s *session) putBlock(data io.Reader) (coreiface.BlockStat, error) {
+	if s.putTimeout <= 0 {
+		return s.bapi.Put(s.ctx, data)
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.putTimeout)
+	defer cancel()
+
+	type putResult struct {
+		stat coreiface.BlockStat
+		err  error
+	}
+	resCh := make(chan putResult, 1)
+	go func() {
+		stat, err := s.bapi.Put(ctx, data)
+		resCh <- putResult{stat, err}
+	}()
+
+	select {
+	case res := <-resCh:
+		return res.stat, res.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("putting block: %w", ctx.Err())
+	}
+}
+
 func (s *session) ReceiveBlocks(ctx context.Context, r io.Reader) error {
+	if s.store != nil || s.framed {
+		return s.receiveEachBlock(ctx, r)
+	}
+
 	progCh := make(chan cid.Cid)
 
 	go func() {
 		for id := range progCh {
-			idStr := id.String()
-			for i, h := range s.info.Manifest.Nodes {
-				if idStr == h {
-					s.prog[i] = 100
-				}
-			}
-			go s.completionChanged()
+			s.setProgress(id.String(), 100)
+			s.completionChanged()
 		}
 	}()
 
-	_, err := AddAllFromCARReader(ctx, s.bapi, r, progCh)
+	_, err := AddAllFromCARReader(ctx, sessionBlockAPI{BlockAPI: s.bapi, sess: s}, r, progCh)
 	return err
 }
 
+// receiveEachBlock places the blocks of a CAR stream with ReceiveBlock one at
+// a time, decoding compression frames if the stream is framed. ReceiveBlock
+// checks each block hashes to it's CID
+func (s *session) receiveEachBlock(ctx context.Context, r io.Reader) error {
+	br := bufio.NewReader(r)
+	if _, err := car.ReadHeader(br); err != nil {
+		return err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		id, data, err := carutil.ReadNode(br)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if s.framed {
+			if data, err = decodeFrame(data); err != nil {
+				return fmt.Errorf("block %s: %w", id, err)
+			}
+		}
+		if res := s.ReceiveBlock(id.String(), bytes.NewReader(data)); res.Status != StatusOk {
+			return res.Err
+		}
+	}
+}
+
+// sessionBlockAPI checks the size of each streamed block a session puts
+// against the session info, and calls the session's block hook for each
+// successful put
+type sessionBlockAPI struct {
+	coreiface.BlockAPI
+	sess *session
+}
+
+func (b sessionBlockAPI) Put(ctx context.Context, r io.Reader, opts ...options.BlockPutOption) (coreiface.BlockStat, error) {
+	stat, err := b.BlockAPI.Put(ctx, r, opts...)
+	if err != nil {
+		return nil, err
+	}
+	hash := stat.Path().Cid().String()
+	if err := b.sess.checkSize(hash, stat.Size()); err != nil {
+		return nil, err
+	}
+	if b.sess.onBlock != nil {
+		b.sess.onBlock(b.sess.ctx, hash, stat.Size(), b.sess.meta)
+	}
+	return stat, nil
+}
+
 // Complete returns if this receive session is finished or not
 func (s *session) Complete() bool {
 	return s.prog.Complete()
 }
 
+// completionChanged notifies progCh of a completion change without blocking.
+// progCh buffers a single update, & updates share the session completion, so
+// an unread update already carries the latest state and later updates are
+// dropped
 func (s *session) completionChanged() {
-	s.progCh <- s.prog
+	s.throttle.throttle(s.prog.Complete(), func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.closed {
+			return
+		}
+		select {
+		case s.progCh <- s.prog:
+		default:
+		}
+	})
+}
+
+// close ends completion updates, closing progCh
+func (s *session) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.progCh)
+	}
+}
+
+// touch records session activity
+func (s *session) touch() {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	atomic.StoreInt64(&s.active, now().UnixNano())
+}
+
+// lastActive is the time of the latest session activity
+func (s *session) lastActive() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&s.active))
 }
 
 // IsFinalizedOnce will return true if the session is complete, but only the first time it is
@@ -0,0 +1,495 @@
+package dsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	"github.com/multiformats/go-multihash"
+	"github.com/qri-io/dag"
+)
+
+func TestSessionReceiveBlockPutTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, root, info := newTestDAG(t, "timeout", 1, 0)
+
+	bapi := &blockingBlockAPI{BlockAPI: newMemStore(), release: make(chan struct{})}
+	defer close(bapi.release)
+
+	sess, err := newSession(ctx, local.nodeGetter(), bapi, info, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.putTimeout = time.Millisecond * 20
+
+	resCh := make(chan ReceiveResponse)
+	go func() {
+		resCh <- sess.ReceiveBlock(root.Cid().String(), bytes.NewReader(root.RawData()))
+	}()
+
+	select {
+	case res := <-resCh:
+		if res.Status != StatusRetry {
+			t.Errorf("expected timed out put to return status retry. got: %s", res.Status)
+		}
+		if !errors.Is(res.Err, context.DeadlineExceeded) {
+			t.Errorf("expected deadline exceeded error. got: %v", res.Err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ReceiveBlock didn't return after put timeout")
+	}
+}
+
+func TestSessionReceiveBlockPart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local := newMemStore()
+	root := merkledag.NodeWithData(bytes.Repeat([]byte("large block "), 1000))
+	local.putNode(root)
+	info, err := dag.NewInfo(ctx, local.nodeGetter(), root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote := newMemStore()
+	sess, err := newSession(ctx, remote.nodeGetter(), remote, info, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range sess.progCh {
+		}
+	}()
+
+	hash := root.Cid().String()
+	data := root.RawData()
+	chunk := (len(data) + 9) / 10
+	prev := uint16(0)
+	for offset := 0; offset < len(data); offset += chunk {
+		end := offset + chunk
+		if end > len(data) {
+			end = len(data)
+		}
+		res := sess.ReceiveBlockPart(hash, offset, len(data), data[offset:end])
+		if res.Status != StatusOk {
+			t.Fatalf("part at offset %d: unexpected status %s: %v", offset, res.Status, res.Err)
+		}
+
+		if end < len(data) {
+			if sess.prog[0] <= prev || sess.prog[0] >= 100 {
+				t.Errorf("expected partial progress between %d and 100 after receiving %d bytes. got: %d", prev, end, sess.prog[0])
+			}
+			prev = sess.prog[0]
+			if remote.has(root.Cid()) {
+				t.Fatal("block written before final part was received")
+			}
+		}
+	}
+
+	if !sess.Complete() {
+		t.Errorf("expected session to be complete after receiving final part. got: %v", sess.prog)
+	}
+	if !remote.has(root.Cid()) {
+		t.Error("expected assembled block to be written to the store")
+	}
+
+	// a tampered block fails hash verification on the final part
+	sess.prog[0] = 0
+	tampered := append([]byte{}, data...)
+	tampered[0]++
+	sess.ReceiveBlockPart(hash, 0, len(data), tampered[:chunk])
+	res := sess.ReceiveBlockPart(hash, chunk, len(data), tampered[chunk:])
+	if res.Status != StatusErrored {
+		t.Errorf("expected tampered block to error. got: %s", res.Status)
+	}
+
+	// parts must arrive in order
+	res = sess.ReceiveBlockPart(hash, chunk, len(data), data[chunk:2*chunk])
+	if res.Status != StatusErrored {
+		t.Errorf("expected out of order part to error. got: %s", res.Status)
+	}
+
+	// parts of blocks outside the manifest are refused
+	other := merkledag.NodeWithData([]byte("not in the manifest")).Cid().String()
+	if res := sess.ReceiveBlockPart(other, 0, 100, data[:10]); res.Status != StatusErrored {
+		t.Errorf("expected part of a block outside the manifest to error. got: %s", res.Status)
+	}
+
+	// sizes are checked before parts are buffered
+	if res := sess.ReceiveBlockPart(hash, 0, len(data)+1, data[:chunk]); !errors.Is(res.Err, ErrBlockSizeMismatch) {
+		t.Errorf("expected size that doesn't match the info to wrap ErrBlockSizeMismatch. got: %v", res.Err)
+	}
+	if res := sess.ReceiveBlockPart(hash, 0, maxBlockPartsSize+1, data[:chunk]); res.Status != StatusErrored {
+		t.Errorf("expected oversized block to error. got: %s", res.Status)
+	}
+	sess.partsLock.Lock()
+	buffered := len(sess.parts)
+	sess.partsLock.Unlock()
+	if buffered != 0 {
+		t.Errorf("expected refused parts not to be buffered. got %d buffered blocks", buffered)
+	}
+}
+
+// blockingBlockAPI blocks all calls to Put until release is closed
+type blockingBlockAPI struct {
+	coreiface.BlockAPI
+	release chan struct{}
+}
+
+func (b *blockingBlockAPI) Put(ctx context.Context, r io.Reader, opts ...options.BlockPutOption) (coreiface.BlockStat, error) {
+	<-b.release
+	return b.BlockAPI.Put(ctx, r, opts...)
+}
+
+// failingBlockAPI fails all calls to Put with err
+type failingBlockAPI struct {
+	coreiface.BlockAPI
+	err error
+}
+
+func (b *failingBlockAPI) Put(ctx context.Context, r io.Reader, opts ...options.BlockPutOption) (coreiface.BlockStat, error) {
+	return nil, b.err
+}
+
+// temporaryErr is an error that reports itself as temporary, like many
+// network errors
+type temporaryErr struct{}
+
+func (temporaryErr) Error() string   { return "temporary failure" }
+func (temporaryErr) Temporary() bool { return true }
+
+func TestSessionReceiveBlockPutErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, root, info := newTestDAG(t, "put errors", 1, 0)
+
+	errDiskFull := errors.New("disk full")
+	cases := []struct {
+		description string
+		err         error
+		isTransient func(error) bool
+		expect      ReceiveResponseStatus
+	}{
+		{"permanent", errDiskFull, nil, StatusErrored},
+		{"temporary", temporaryErr{}, nil, StatusRetry},
+		{"wrapped temporary", fmt.Errorf("putting block: %w", temporaryErr{}), nil, StatusRetry},
+		{"timeout", context.DeadlineExceeded, nil, StatusRetry},
+		{"override transient", errDiskFull, func(err error) bool { return errors.Is(err, errDiskFull) }, StatusRetry},
+		{"override permanent", temporaryErr{}, func(error) bool { return false }, StatusErrored},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			bapi := &failingBlockAPI{BlockAPI: newMemStore(), err: c.err}
+			sess, err := newSession(ctx, local.nodeGetter(), bapi, info, false, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sess.isTransient = c.isTransient
+
+			res := sess.ReceiveBlock(root.Cid().String(), bytes.NewReader(root.RawData()))
+			if res.Status != c.expect {
+				t.Errorf("status mismatch. expected: %s, got: %s", c.expect, res.Status)
+			}
+			if !errors.Is(res.Err, c.err) {
+				t.Errorf("expected put error to be returned. got: %v", res.Err)
+			}
+			var putErr *BlockPutError
+			if !errors.As(res.Err, &putErr) || putErr.Cid != root.Cid().String() {
+				t.Errorf("expected a BlockPutError for %s. got: %#v", root.Cid(), res.Err)
+			}
+		})
+	}
+}
+
+func TestSessionReceivePresentBlock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	remote, root, info := newTestDAG(t, "present", 1, 0)
+	hash := root.Cid().String()
+
+	// stores with Has skip writing blocks they have
+	sess, err := newSession(ctx, remote.nodeGetter(), remote, info, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func(progCh chan dag.Completion) {
+		for range progCh {
+		}
+	}(sess.progCh)
+	puts := len(remote.Puts())
+	if res := sess.ReceiveBlock(hash, bytes.NewReader(root.RawData())); res.Status != StatusOk {
+		t.Fatalf("unexpected status %s: %v", res.Status, res.Err)
+	}
+	if len(remote.Puts()) != puts {
+		t.Errorf("expected present block not to be written again. got %d puts", len(remote.Puts())-puts)
+	}
+	if !sess.Complete() {
+		t.Errorf("expected session to be complete. got: %v", sess.prog)
+	}
+
+	// present blocks are still checked against their hash
+	sess.prog[0] = 0
+	if res := sess.ReceiveBlock(hash, bytes.NewReader([]byte("not the block"))); res.Status != StatusErrored {
+		t.Errorf("expected tampered block to error. got: %s", res.Status)
+	}
+
+	// stores without Has always write
+	noHas := struct{ coreiface.BlockAPI }{remote}
+	sess, err = newSession(ctx, remote.nodeGetter(), noHas, info, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func(progCh chan dag.Completion) {
+		for range progCh {
+		}
+	}(sess.progCh)
+	if res := sess.ReceiveBlock(hash, bytes.NewReader(root.RawData())); res.Status != StatusOk {
+		t.Fatalf("unexpected status %s: %v", res.Status, res.Err)
+	}
+	if len(remote.Puts()) != puts+1 {
+		t.Errorf("expected block to be written to a store without Has. got %d puts", len(remote.Puts())-puts)
+	}
+}
+
+func TestSessionReceiveBlockSizeMismatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, root, info := newTestDAG(t, "sizes", 1, 0)
+	info.Sizes[0]++
+
+	remote := newMemStore()
+	sess, err := newSession(ctx, remote.nodeGetter(), remote, info, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range sess.progCh {
+		}
+	}()
+
+	res := sess.ReceiveBlock(root.Cid().String(), bytes.NewReader(root.RawData()))
+	if res.Status != StatusErrored || !errors.Is(res.Err, ErrBlockSizeMismatch) {
+		t.Errorf("expected errored status with ErrBlockSizeMismatch. got: %s %v", res.Status, res.Err)
+	}
+	if remote.has(root.Cid()) {
+		t.Error("expected block with a mismatched size not to be written")
+	}
+
+	// streamed blocks are checked too
+	r, err := NewManifestCARReader(ctx, local.nodeGetter(), info.Manifest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sess.ReceiveBlocks(ctx, r); !errors.Is(err, ErrBlockSizeMismatch) {
+		t.Errorf("expected streamed block to error with ErrBlockSizeMismatch. got: %v", err)
+	}
+	if sess.Complete() {
+		t.Error("expected session with a mismatched block not to complete")
+	}
+}
+
+func TestSessionCompletionUpdatesDontAccumulate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, _, info := newTestDAG(t, "many blocks", 10, 3)
+	if len(info.Manifest.Nodes) < 1000 {
+		t.Fatalf("expected at least 1000 blocks. got: %d", len(info.Manifest.Nodes))
+	}
+
+	remote := newMemStore()
+	sess, err := newSession(ctx, remote.nodeGetter(), remote, info, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing reads progCh while blocks are received
+	before := runtime.NumGoroutine()
+	for _, hash := range info.Manifest.Nodes {
+		id, err := cid.Parse(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := local.rawData(id)
+		if res := sess.ReceiveBlock(hash, bytes.NewReader(data)); res.Status != StatusOk {
+			t.Fatalf("receiving block %s: %s", hash, res.Err)
+		}
+	}
+	if after := runtime.NumGoroutine(); after-before > 10 {
+		t.Errorf("expected goroutine count not to grow with blocks received. before: %d, after: %d", before, after)
+	}
+
+	// the buffered update carries the latest completion
+	if prog := <-sess.progCh; !prog.Complete() {
+		t.Errorf("expected buffered update to be complete. got: %v", prog)
+	}
+}
+
+func BenchmarkSessionReceiveBlocks(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, _, info := newTestDAG(b, "receive blocks", 10, 4)
+	blocks := make([][]byte, len(info.Manifest.Nodes))
+	for i := range info.Manifest.Nodes {
+		blocks[i], _ = local.rawData(info.Manifest.MustNodeCID(i))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		remote := newMemStore()
+		sess, err := newSession(ctx, remote.nodeGetter(), remote, info, false, false, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for j, hash := range info.Manifest.Nodes {
+			if res := sess.ReceiveBlock(hash, bytes.NewReader(blocks[j])); res.Status != StatusOk {
+				b.Fatalf("receiving block %s: %s", hash, res.Err)
+			}
+		}
+	}
+}
+
+func TestCompareCIDs(t *testing.T) {
+	data := []byte("compare me")
+	sum := func(code uint64, length int, data []byte) multihash.Multihash {
+		hash, err := multihash.Sum(data, code, length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	hash := sum(multihash.SHA2_256, -1, data)
+	expect := cid.NewCidV1(cid.Raw, hash)
+
+	cases := []struct {
+		description string
+		got         cid.Cid
+		reason      string
+	}{
+		{"match", cid.NewCidV1(cid.Raw, hash), ""},
+		{"version & codec", cid.NewCidV0(hash), "codec protobuf, expected raw"},
+		{"codec", cid.NewCidV1(cid.DagCBOR, hash), "codec cbor, expected raw"},
+		{"hash function", cid.NewCidV1(cid.Raw, sum(multihash.SHA2_512, -1, data)), "hash function sha2-512, expected sha2-256"},
+		{"digest length", cid.NewCidV1(cid.Raw, sum(multihash.SHA2_256, 20, data)), "digest length 20, expected 32"},
+		{"digest", cid.NewCidV1(cid.Raw, sum(multihash.SHA2_256, -1, []byte("other"))), "digest differs"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			err := compareCIDs(expect.String(), c.got)
+			if c.reason == "" {
+				if err != nil {
+					t.Errorf("expected no error. got: %s", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrHashMismatch) {
+				t.Fatalf("expected ErrHashMismatch. got: %v", err)
+			}
+			var mismatch *HashMismatchError
+			if !errors.As(err, &mismatch) {
+				t.Fatalf("expected a HashMismatchError. got: %T", err)
+			}
+			if mismatch.Expected != expect.String() || mismatch.Got != c.got.String() {
+				t.Errorf("expected mismatch of %s & %s. got: %s & %s", expect, c.got, mismatch.Expected, mismatch.Got)
+			}
+			if !strings.HasSuffix(err.Error(), c.reason) {
+				t.Errorf("error reason mismatch. expected: %q, got: %q", c.reason, err)
+			}
+		})
+	}
+
+	// CIDv0 & CIDv1 of the same protobuf block are equal in both directions
+	v0, v1 := cid.NewCidV0(hash), cid.NewCidV1(cid.DagProtobuf, hash)
+	if err := compareCIDs(v0.String(), v1); err != nil {
+		t.Errorf("expected CIDv1 to match equivalent CIDv0. got: %s", err)
+	}
+	if err := compareCIDs(v1.String(), v0); err != nil {
+		t.Errorf("expected CIDv0 to match equivalent CIDv1. got: %s", err)
+	}
+
+	if err := compareCIDs("not a cid", expect); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("expected unparsable expected cid to error with ErrHashMismatch. got: %v", err)
+	}
+}
+
+func TestSessionReceiveBlockWrongCodec(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, root, info := newTestDAG(t, "wrong codec", 1, 0)
+
+	// a sender claims the block has a different codec, with the same digest
+	wrong := cid.NewCidV1(cid.DagCBOR, root.Cid().Hash()).String()
+	lying := &dag.Info{Manifest: &dag.Manifest{Nodes: []string{wrong}}, Sizes: info.Sizes}
+
+	remote := newMemStore()
+	sess, err := newSession(ctx, remote.nodeGetter(), remote, lying, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range sess.progCh {
+		}
+	}()
+
+	res := sess.ReceiveBlock(wrong, bytes.NewReader(root.RawData()))
+	if res.Status != StatusErrored {
+		t.Errorf("expected block to error. got: %s", res.Status)
+	}
+	if !errors.Is(res.Err, ErrHashMismatch) {
+		t.Fatalf("expected ErrHashMismatch. got: %v", res.Err)
+	}
+	if !strings.Contains(res.Err.Error(), "codec protobuf, expected cbor") {
+		t.Errorf("expected error to name the codec mismatch. got: %s", res.Err)
+	}
+	if sess.prog.Complete() {
+		t.Error("expected mismatched block not to complete the session")
+	}
+}
+
+func TestSessionReceiveBlockCIDVersion(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, root, info := newTestDAG(t, "cid version", 1, 0)
+
+	// a manifest lists the CIDv1 of a block the store puts as a CIDv0
+	v1 := cid.NewCidV1(cid.DagProtobuf, root.Cid().Hash()).String()
+	upgraded := &dag.Info{Manifest: &dag.Manifest{Nodes: []string{v1}}, Sizes: info.Sizes}
+
+	remote := newMemStore()
+	sess, err := newSession(ctx, remote.nodeGetter(), remote, upgraded, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range sess.progCh {
+		}
+	}()
+
+	if res := sess.ReceiveBlock(v1, bytes.NewReader(root.RawData())); res.Status != StatusOk {
+		t.Fatalf("expected block addressed by an equivalent CID to be received. got: %s %v", res.Status, res.Err)
+	}
+	if !sess.prog.Complete() {
+		t.Error("expected session to complete")
+	}
+}
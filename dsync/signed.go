@@ -0,0 +1,62 @@
+package dsync
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/qri-io/dag"
+)
+
+// InfoSignatureMetaKey is the push metadata key a sender uses to attach the
+// base64-encoded signature of a dag.SignedInfo to a push of the signed info.
+// The signer of an info needn't be the party that pushes it's blocks
+const InfoSignatureMetaKey = "dsync-info-signature"
+
+// NewSignedPush creates a push of an info signed by a third party, attaching
+// the signature so remotes can check who produced the info before accepting
+// the push. All blocks in the info manifest must be accessible from lng
+func NewSignedPush(lng ipld.NodeGetter, si *dag.SignedInfo, remote DagSyncable, pinOnComplete bool) (*Push, error) {
+	if si == nil || si.Info == nil {
+		return nil, fmt.Errorf("signed info is required")
+	}
+	push, err := NewPush(lng, si.Info, remote, pinOnComplete)
+	if err != nil {
+		return nil, err
+	}
+	push.signature = si.Signature
+	return push, nil
+}
+
+// RequireInfoSignature creates a push pre-check that only accepts pushes of
+// infos signed by one of the trusted ed25519 public keys, as attached by
+// pushes created with NewSignedPush. Pushes without a signature, or with a
+// signature that doesn't verify against any trusted key, fail with an error
+// wrapping dag.ErrInvalidSignature. Applications with other pre-check
+// requirements can call the returned function from their own pre-check
+func RequireInfoSignature(trusted ...ed25519.PublicKey) func(ctx context.Context, info dag.Info, meta map[string]string) error {
+	return func(ctx context.Context, info dag.Info, meta map[string]string) error {
+		str, ok := meta[InfoSignatureMetaKey]
+		if !ok {
+			return fmt.Errorf("%w: push is unsigned", dag.ErrInvalidSignature)
+		}
+		sig, err := base64.StdEncoding.DecodeString(str)
+		if err != nil {
+			return fmt.Errorf("%w: decoding signature: %s", dag.ErrInvalidSignature, err)
+		}
+
+		si := &dag.SignedInfo{Info: &info, Signature: sig}
+		for _, pub := range trusted {
+			err := dag.VerifyInfo(pub, si)
+			if err == nil {
+				return nil
+			} else if !errors.Is(err, dag.ErrInvalidSignature) {
+				return err
+			}
+		}
+		return fmt.Errorf("%w: not signed by a trusted key", dag.ErrInvalidSignature)
+	}
+}
@@ -0,0 +1,93 @@
+package dsync
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/qri-io/dag"
+)
+
+func TestSignedPush(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// party A signs the info, party B pushes it's blocks
+	pubA, privA, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, privOther, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local, root, info := newTestDAG(t, "signed", 2, 2)
+	signedByA, err := dag.SignInfo(privA, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedByOther, err := dag.SignInfo(privOther, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := &dag.SignedInfo{
+		Info:      &dag.Info{Manifest: info.Manifest, Sizes: make([]uint64, len(info.Sizes))},
+		Signature: signedByA.Signature,
+	}
+
+	cases := []struct {
+		description string
+		si          *dag.SignedInfo
+		valid       bool
+	}{
+		{"trusted signer", signedByA, true},
+		{"untrusted signer", signedByOther, false},
+		{"tampered info", tampered, false},
+		{"unsigned", &dag.SignedInfo{Info: info}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			remote := newMemStore()
+			rem, err := New(remote.nodeGetter(), remote, func(cfg *Config) {
+				cfg.PushPreCheck = RequireInfoSignature(pubA)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := httptest.NewServer(HTTPRemoteHandler(rem))
+			defer s.Close()
+
+			push, err := NewSignedPush(local.nodeGetter(), c.si, &HTTPClient{URL: s.URL}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = push.Do(ctx)
+			if c.valid {
+				if err != nil {
+					t.Fatalf("expected push to succeed. got: %s", err)
+				}
+				if _, err := dag.NewManifest(ctx, remote.nodeGetter(), root.Cid()); err != nil {
+					t.Errorf("expected pushed DAG to be complete. error: %s", err)
+				}
+				return
+			}
+			// errors lose their type over HTTP
+			if err == nil || !strings.Contains(err.Error(), dag.ErrInvalidSignature.Error()) {
+				t.Errorf("expected invalid signature error. got: %v", err)
+			}
+		})
+	}
+
+	check := RequireInfoSignature(pubA)
+	if err := check(ctx, *info, map[string]string{InfoSignatureMetaKey: "not base64!"}); !errors.Is(err, dag.ErrInvalidSignature) {
+		t.Errorf("expected malformed signature to error with ErrInvalidSignature. got: %v", err)
+	}
+	if _, err := NewSignedPush(local.nodeGetter(), nil, nil, false); err == nil {
+		t.Error("expected missing signed info to error")
+	}
+}
@@ -3,6 +3,7 @@ package dsync
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"strconv"
 	"strings"
@@ -59,8 +60,14 @@ func protocolSupportsDagStreaming(pid protocol.ID) bool {
 // for more on CAR files, see: https://github.com/ipld/specs/blob/master/block-layer/content-addressable-archives.md
 // If supplied a non-nil channel progress channel, the stream will send as
 // each CID is buffered to the read stream
+// Archives are written in manifest order, so the same manifest & blocks
+// always produce the same bytes. Byte ranges of the stream are stable across
+// requests, which is what makes resuming a stream by byte offset possible
 func NewManifestCARReader(ctx context.Context, ng ipld.NodeGetter, mfst *dag.Manifest, progCh chan cid.Cid) (io.Reader, error) {
+	return newManifestCARReader(ctx, ng, mfst, progCh)
+}
 
+func newManifestCARReader(ctx context.Context, ng ipld.NodeGetter, mfst *dag.Manifest, progCh chan cid.Cid) (*mfstCarReader, error) {
 	cids := make([]cid.Cid, 0, len(mfst.Nodes))
 	for _, cidStr := range mfst.Nodes {
 		id, err := cid.Decode(cidStr)
@@ -85,22 +92,63 @@ func NewManifestCARReader(ctx context.Context, ng ipld.NodeGetter, mfst *dag.Man
 	}
 
 	str := &mfstCarReader{
-		ctx:      ctx,
-		cids:     cids,
-		buf:      buf,
-		progCh:   progCh,
-		blocksCh: ng.GetMany(ctx, cids),
+		ctx:    ctx,
+		ng:     ng,
+		cids:   cids,
+		buf:    buf,
+		progCh: progCh,
 	}
 	return str, nil
 }
 
+// carReadAhead is the number of blocks a manifest CAR reader requests from
+// it's node getter at once. Blocks are only requested as the stream is read,
+// so a slow reader holds back fetching instead of the stream buffering the
+// DAG ahead of it
+const carReadAhead = 16
+
+// manifestCARLength calculates the length in bytes of the archive
+// NewManifestCARReader creates for a manifest, fetching each block to get
+// it's size
+func manifestCARLength(ctx context.Context, ng ipld.NodeGetter, mfst *dag.Manifest) (int64, error) {
+	buf := &bytes.Buffer{}
+	header := &car.CarHeader{
+		Roots:   []cid.Cid{mfst.RootCID()},
+		Version: 1,
+	}
+	if err := car.WriteHeader(header, buf); err != nil {
+		return 0, err
+	}
+	length := int64(buf.Len())
+
+	for _, cidStr := range mfst.Nodes {
+		id, err := cid.Decode(cidStr)
+		if err != nil {
+			return 0, err
+		}
+		nd, err := ng.Get(ctx, id)
+		if err != nil {
+			return 0, err
+		}
+		length += int64(carutil.LdSize(nd.Cid().Bytes(), nd.RawData()))
+	}
+	return length, nil
+}
+
 type mfstCarReader struct {
 	i        int
 	ctx      context.Context
+	ng       ipld.NodeGetter
 	cids     []cid.Cid
 	buf      *bytes.Buffer
 	progCh   chan cid.Cid
 	blocksCh <-chan *ipld.NodeOption
+	// index of the first block not yet requested from ng
+	requested int
+	// framed wraps each block in a compression frame, compressing blocks
+	// larger than compressAbove bytes
+	framed        bool
+	compressAbove int
 }
 
 func (str *mfstCarReader) Read(p []byte) (int, error) {
@@ -129,19 +177,46 @@ func (str *mfstCarReader) readBlock() error {
 		return io.EOF
 	}
 
-	no := <-str.blocksCh
+	if str.i == str.requested {
+		end := str.i + carReadAhead
+		if end > len(str.cids) {
+			end = len(str.cids)
+		}
+		str.blocksCh = str.ng.GetMany(str.ctx, str.cids[str.i:end])
+		str.requested = end
+	}
+
+	no, ok := <-str.blocksCh
+	if !ok {
+		if err := str.ctx.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("node getter closed stream before block %s", str.cids[str.i])
+	}
 	if no.Err != nil {
 		log.Debugf("error getting block: err=%q", no.Err)
 		return no.Err
 	}
 
 	str.i++
-	if err := carutil.LdWrite(str.buf, no.Node.Cid().Bytes(), no.Node.RawData()); err != nil {
+	data := no.Node.RawData()
+	if str.framed {
+		var err error
+		if data, err = encodeFrame(data, str.compressAbove); err != nil {
+			return err
+		}
+	}
+	if err := carutil.LdWrite(str.buf, no.Node.Cid().Bytes(), data); err != nil {
 		return err
 	}
 
 	if str.progCh != nil {
-		go func() { str.progCh <- no.Node.Cid() }()
+		go func(id cid.Cid) {
+			select {
+			case str.progCh <- id:
+			case <-str.ctx.Done():
+			}
+		}(no.Node.Cid())
 	}
 
 	return nil
@@ -158,6 +233,10 @@ func AddAllFromCARReader(ctx context.Context, bapi coreiface.BlockAPI, r io.Read
 	added := 0
 	buf := &bytes.Buffer{}
 	for {
+		if err := ctx.Err(); err != nil {
+			return added, err
+		}
+
 		blk, err := rdr.Next()
 		if err == io.EOF {
 			break
@@ -8,6 +8,7 @@ import (
 
 	"github.com/ipfs/go-cid"
 	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
 	protocol "github.com/libp2p/go-libp2p-core/protocol"
 	"github.com/qri-io/dag"
 )
@@ -97,3 +98,46 @@ func TestProtocolSupportsDagStreaming(t *testing.T) {
 		})
 	}
 }
+
+// requestCountingGetter counts the nodes requested with GetMany
+type requestCountingGetter struct {
+	ipld.NodeGetter
+	requested int
+}
+
+func (ng *requestCountingGetter) GetMany(ctx context.Context, ids []cid.Cid) <-chan *ipld.NodeOption {
+	ng.requested += len(ids)
+	return ng.NodeGetter.GetMany(ctx, ids)
+}
+
+func TestManifestCARReaderReadAhead(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	root := addTestDAG(t, store, "read_ahead", 3, 3)
+	mfst, err := dag.NewManifest(ctx, store.nodeGetter(), root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mfst.Nodes) <= carReadAhead {
+		t.Fatalf("expected more than %d nodes. got: %d", carReadAhead, len(mfst.Nodes))
+	}
+
+	ng := &requestCountingGetter{NodeGetter: store.nodeGetter()}
+	r, err := NewManifestCARReader(ctx, ng, mfst, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if ng.requested > carReadAhead {
+		t.Errorf("expected reader to request at most %d blocks ahead of reads. got: %d", carReadAhead, ng.requested)
+	}
+
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	if ng.requested != len(mfst.Nodes) {
+		t.Errorf("expected each block to be requested once. got %d requests for %d blocks", ng.requested, len(mfst.Nodes))
+	}
+}
@@ -19,7 +19,8 @@ func NewTestDsync() *Dsync {
 		bapi:           newTestBlockAPI(),
 		sessionPool:    make(map[string]*session),
 		sessionCancels: make(map[string]context.CancelFunc),
-		sessionTTLDur:  time.Hour * 5,
+		sessionTTLDur:  DefaultSessionTTL,
+		now:            time.Now,
 	}
 }
 
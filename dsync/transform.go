@@ -0,0 +1,108 @@
+package dsync
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/qri-io/dag"
+)
+
+// BlockTransform rewrites the data of the block with the given hash
+type BlockTransform func(ctx context.Context, hash string, data []byte) ([]byte, error)
+
+// transformingStore places & reads blocks in a BlockSink, transforming block
+// data on the way in & back out. The sink keys blocks by the CID of their
+// untransformed data, so transforms don't need to preserve CIDs
+type transformingStore struct {
+	sink    BlockSink
+	receive BlockTransform
+	serve   BlockTransform
+}
+
+// put transforms data with the receive transform and stores it under hash.
+// data must already be verified to hash to the CID
+func (t *transformingStore) put(ctx context.Context, hash string, data []byte) error {
+	id, err := cid.Parse(hash)
+	if err != nil {
+		return err
+	}
+	stored, err := t.receive(ctx, hash, data)
+	if err != nil {
+		return fmt.Errorf("transforming block %s: %w", hash, err)
+	}
+	return t.sink.PutBlock(ctx, id, stored)
+}
+
+// get reads the data stored under id, reversing the receive transform with
+// the serve transform
+func (t *transformingStore) get(ctx context.Context, id cid.Cid) ([]byte, error) {
+	stored, err := t.sink.GetBlock(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := t.serve(ctx, id.String(), stored)
+	if err != nil {
+		return nil, fmt.Errorf("transforming block %s: %w", id, err)
+	}
+	return data, nil
+}
+
+// Has reports if the sink has a block, satisfying BlockHaser
+func (t *transformingStore) Has(ctx context.Context, id cid.Cid) (bool, error) {
+	return t.sink.HasBlock(ctx, id)
+}
+
+// verifyBlock checks data hashes to the CID hash
+func verifyBlock(hash string, data []byte) error {
+	id, err := cid.Parse(hash)
+	if err != nil {
+		return err
+	}
+	sum, err := id.Prefix().Sum(data)
+	if err != nil {
+		return err
+	}
+	if !sum.Equals(id) {
+		return &HashMismatchError{Expected: hash, Got: sum.String()}
+	}
+	return nil
+}
+
+// newGetterCARStream writes the blocks of a manifest to a CAR stream in
+// manifest order, reading the data of each block with get
+func newGetterCARStream(ctx context.Context, m *dag.Manifest, get func(context.Context, cid.Cid) ([]byte, error)) (io.ReadCloser, error) {
+	ids := make([]cid.Cid, len(m.Nodes))
+	for i, idStr := range m.Nodes {
+		id, err := cid.Parse(idStr)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		header := &car.CarHeader{Roots: []cid.Cid{m.RootCID()}, Version: 1}
+		if err := car.WriteHeader(header, w); err != nil {
+			w.CloseWithError(err)
+			return
+		}
+		for _, id := range ids {
+			data, err := get(ctx, id)
+			if err != nil {
+				w.CloseWithError(err)
+				return
+			}
+			if err := carutil.LdWrite(w, id.Bytes(), data); err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
+		w.Close()
+	}()
+	return r, nil
+}
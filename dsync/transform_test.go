@@ -0,0 +1,159 @@
+package dsync
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/qri-io/dag"
+)
+
+// xorBlocks "encrypts" block data by flipping every bit. xorBlocks is it's
+// own inverse
+func xorBlocks(_ context.Context, _ string, data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = b ^ 0xff
+	}
+	return out, nil
+}
+
+// sinkStore is a memStore that can also act as a BlockSink, storing data
+// under any CID
+type sinkStore struct{ *memStore }
+
+var _ BlockSink = sinkStore{}
+
+func (s sinkStore) PutBlock(_ context.Context, id cid.Cid, data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.blocks[id.KeyString()] = data
+	return nil
+}
+
+func (s sinkStore) GetBlock(_ context.Context, id cid.Cid) ([]byte, error) {
+	data, ok := s.rawData(id)
+	if !ok {
+		return nil, ipld.ErrNotFound
+	}
+	return data, nil
+}
+
+func (s sinkStore) HasBlock(_ context.Context, id cid.Cid) (bool, error) {
+	return s.has(id), nil
+}
+
+// xorNodeGetter decodes blocks of a store that have been transformed by
+// xorBlocks
+type xorNodeGetter struct{ s *memStore }
+
+func (ng xorNodeGetter) Get(ctx context.Context, id cid.Cid) (ipld.Node, error) {
+	data, ok := ng.s.rawData(id)
+	if !ok {
+		return nil, ipld.ErrNotFound
+	}
+	data, _ = xorBlocks(ctx, id.String(), data)
+	s := newMemStore()
+	s.blocks[id.KeyString()] = data
+	return s.GetNode(ctx, id)
+}
+
+func (ng xorNodeGetter) GetMany(ctx context.Context, ids []cid.Cid) <-chan *ipld.NodeOption {
+	ch := make(chan *ipld.NodeOption, len(ids))
+	go func() {
+		defer close(ch)
+		for _, id := range ids {
+			nd, err := ng.Get(ctx, id)
+			ch <- &ipld.NodeOption{Node: nd, Err: err}
+		}
+	}()
+	return ch
+}
+
+func TestTransformReceivedBlock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, root, info := newTestDAG(t, "transform", 3, 2)
+
+	cases := []struct {
+		description string
+		remote      func(rem *Dsync) DagSyncable
+	}{
+		{"streamed", func(rem *Dsync) DagSyncable { return rem }},
+		// faultyRemote doesn't stream, with no faults it sends block-by-block
+		{"per-block", func(rem *Dsync) DagSyncable { return newFaultyRemote(rem, 1) }},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			remote := newMemStore()
+			rem, err := New(xorNodeGetter{remote}, sinkStore{remote}, acceptPushes, func(cfg *Config) {
+				cfg.TransformReceivedBlock = xorBlocks
+				cfg.TransformServedBlock = xorBlocks
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			push, err := NewPush(local.nodeGetter(), info, c.remote(rem), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := push.Do(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, idStr := range info.Manifest.Nodes {
+				id, _ := cid.Parse(idStr)
+				data, _ := local.rawData(id)
+				stored, ok := remote.rawData(id)
+				if !ok {
+					t.Fatalf("expected block %s to be stored under it's original CID", id)
+				}
+				if bytes.Equal(data, stored) {
+					t.Errorf("expected block %s to be stored transformed", id)
+				}
+				if restored, _ := xorBlocks(ctx, idStr, stored); !bytes.Equal(data, restored) {
+					t.Errorf("block %s doesn't reverse to it's original data", id)
+				}
+			}
+
+			// blocks are served untransformed
+			got, err := rem.GetBlock(ctx, root.Cid().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, root.RawData()) {
+				t.Errorf("expected GetBlock to serve original block data")
+			}
+
+			dest := newMemStore()
+			pull, err := NewPull(root.Cid().String(), dest.nodeGetter(), dest, rem, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := pull.Do(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := dag.NewManifest(ctx, dest.nodeGetter(), root.Cid()); err != nil {
+				t.Errorf("expected pulled DAG to be complete. error: %s", err)
+			}
+		})
+	}
+
+	// transforms must be reversible, & need a sink to store transformed data
+	if _, err := New(newMemStore().nodeGetter(), newMemStore(), func(cfg *Config) {
+		cfg.TransformReceivedBlock = xorBlocks
+	}); err == nil {
+		t.Error("expected a receive transform without a serve transform to error")
+	}
+	if _, err := New(newMemStore().nodeGetter(), newMemStore(), func(cfg *Config) {
+		cfg.TransformReceivedBlock = xorBlocks
+		cfg.TransformServedBlock = xorBlocks
+	}); err == nil {
+		t.Error("expected transforms with a block store that isn't a BlockSink to error")
+	}
+}
@@ -0,0 +1,145 @@
+package dsync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	unixfs "github.com/ipfs/go-unixfs"
+)
+
+// ErrNotUnixFS is the error for when a DAG written to the filesystem contains
+// nodes that aren't UnixFS
+var ErrNotUnixFS = fmt.Errorf("not a UnixFS DAG")
+
+// ToDirectory executes the pull, then writes the pulled UnixFS DAG to the
+// filesystem at path, naming files & directories with link names. When the
+// DAG root is a directory its entries are written into path, creating path if
+// it doesn't exist. A root file or symlink is written to path itself.
+// Symlinks are created with the target stored in the DAG, and aren't followed.
+// DAGs that contain non-UnixFS nodes or sharded directories return an error
+// wrapping ErrNotUnixFS
+func (f *Pull) ToDirectory(ctx context.Context, path string) error {
+	if err := f.Do(ctx); err != nil {
+		return err
+	}
+	return writeUnixFS(ctx, f.lng, f.info.RootCID(), path)
+}
+
+// writeUnixFS writes the UnixFS node at id & it's descendants to path
+func writeUnixFS(ctx context.Context, ng ipld.NodeGetter, id cid.Cid, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	nd, err := ng.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if id.Type() == cid.Raw {
+		return writeUnixFSFile(ctx, ng, nd, path)
+	}
+	pn, fsn, err := decodeUnixFS(nd)
+	if err != nil {
+		return err
+	}
+
+	switch fsn.Type() {
+	case unixfs.TDirectory:
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+		names := map[string]bool{}
+		for _, l := range pn.Links() {
+			if l.Name == "" || l.Name == "." || l.Name == ".." || strings.ContainsAny(l.Name, `/\`) {
+				return fmt.Errorf("invalid directory entry name %q in %s", l.Name, id)
+			}
+			if names[l.Name] {
+				return fmt.Errorf("duplicate directory entry name %q in %s", l.Name, id)
+			}
+			names[l.Name] = true
+			if err := writeUnixFS(ctx, ng, l.Cid, filepath.Join(path, l.Name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case unixfs.TFile, unixfs.TRaw:
+		return writeUnixFSFile(ctx, ng, nd, path)
+	case unixfs.TSymlink:
+		return os.Symlink(string(fsn.Data()), path)
+	default:
+		return fmt.Errorf("%w: unsupported UnixFS node type %s at %s", ErrNotUnixFS, fsn.Type(), id)
+	}
+}
+
+// writeUnixFSFile writes the contents of the UnixFS file nd to a file at path
+func writeUnixFSFile(ctx context.Context, ng ipld.NodeGetter, nd ipld.Node, path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err := copyUnixFSFile(ctx, ng, nd, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// copyUnixFSFile writes file data held in nd & it's children to w. File data
+// in a node comes before the data of it's children
+func copyUnixFSFile(ctx context.Context, ng ipld.NodeGetter, nd ipld.Node, w io.Writer) error {
+	if nd.Cid().Type() == cid.Raw {
+		_, err := w.Write(nd.RawData())
+		return err
+	}
+
+	pn, fsn, err := decodeUnixFS(nd)
+	if err != nil {
+		return err
+	}
+	if fsn.Type() != unixfs.TFile && fsn.Type() != unixfs.TRaw {
+		return fmt.Errorf("%w: expected file data at %s, got: %s", ErrNotUnixFS, nd.Cid(), fsn.Type())
+	}
+	if _, err := w.Write(fsn.Data()); err != nil {
+		return err
+	}
+
+	for _, l := range pn.Links() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		child, err := ng.Get(ctx, l.Cid)
+		if err != nil {
+			return err
+		}
+		if err := copyUnixFSFile(ctx, ng, child, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeUnixFS reads the UnixFS metadata of a protobuf node
+func decodeUnixFS(nd ipld.Node) (*merkledag.ProtoNode, *unixfs.FSNode, error) {
+	if nd.Cid().Type() != cid.DagProtobuf {
+		return nil, nil, fmt.Errorf("%w: node %s has codec %s", ErrNotUnixFS, nd.Cid(), cid.CodecToStr[nd.Cid().Type()])
+	}
+	pn, ok := nd.(*merkledag.ProtoNode)
+	if !ok {
+		var err error
+		if pn, err = merkledag.DecodeProtobuf(nd.RawData()); err != nil {
+			return nil, nil, err
+		}
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: node %s: %s", ErrNotUnixFS, nd.Cid(), err)
+	}
+	return pn, fsn, nil
+}
@@ -0,0 +1,112 @@
+package dsync
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-merkledag"
+	unixfs "github.com/ipfs/go-unixfs"
+)
+
+func TestPullToDirectory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// root/
+	//   a.txt
+	//   link -> a.txt
+	//   sub/
+	//     b.txt (two chunks)
+	remote := newMemStore()
+	file := func(data string) *merkledag.ProtoNode {
+		nd := merkledag.NodeWithData(unixfs.FilePBData([]byte(data), uint64(len(data))))
+		remote.putNode(nd)
+		return nd
+	}
+	dir := func(links map[string]*merkledag.ProtoNode) *merkledag.ProtoNode {
+		nd := merkledag.NodeWithData(unixfs.FolderPBData())
+		for name, ch := range links {
+			if err := nd.AddNodeLink(name, ch); err != nil {
+				t.Fatal(err)
+			}
+		}
+		remote.putNode(nd)
+		return nd
+	}
+
+	symData, err := unixfs.SymlinkData("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sym := merkledag.NodeWithData(symData)
+	remote.putNode(sym)
+
+	chunked := merkledag.NodeWithData(unixfs.FilePBData(nil, 10))
+	for _, chunk := range []string{"hello ", "dsync"} {
+		if err := chunked.AddNodeLink("", file(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	remote.putNode(chunked)
+
+	root := dir(map[string]*merkledag.ProtoNode{
+		"a.txt": file("apples"),
+		"link":  sym,
+		"sub":   dir(map[string]*merkledag.ProtoNode{"b.txt": chunked}),
+	})
+
+	rem, err := New(remote.nodeGetter(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmp, err := ioutil.TempDir("", "dsync_to_directory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	out := filepath.Join(tmp, "out")
+
+	local := newMemStore()
+	pull, err := NewPull(root.Cid().String(), local.nodeGetter(), local, rem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pull.ToDirectory(ctx, out); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expect := range map[string]string{
+		"a.txt":     "apples",
+		"sub/b.txt": "hello dsync",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(out, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expect {
+			t.Errorf("file %s content mismatch. expected: %q, got: %q", path, expect, string(data))
+		}
+	}
+	target, err := os.Readlink(filepath.Join(out, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "a.txt" {
+		t.Errorf("symlink target mismatch. expected: %q, got: %q", "a.txt", target)
+	}
+
+	// a DAG that isn't UnixFS
+	notUnixFS := addTestDAG(t, remote, "not_unixfs", 1, 1)
+	pull, err = NewPull(notUnixFS.Cid().String(), local.nodeGetter(), local, rem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pull.ToDirectory(ctx, filepath.Join(tmp, "bad")); !errors.Is(err, ErrNotUnixFS) {
+		t.Errorf("expected pulling a non-UnixFS DAG to error with ErrNotUnixFS. got: %v", err)
+	}
+}
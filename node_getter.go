@@ -2,6 +2,10 @@ package dag
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	ipld "github.com/ipfs/go-ipld-format"
@@ -35,3 +39,247 @@ func (ng *NodeGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.
 	}()
 	return ch
 }
+
+// TimeoutNodeGetter wraps a NodeGetter, limiting the duration of each call to
+// Get. Wrapped getters that ignore context cancellation are abandoned once the
+// timeout elapses, so a single slow node can't stall a DAG walk
+type TimeoutNodeGetter struct {
+	NodeGetter ipld.NodeGetter
+	Timeout    time.Duration
+}
+
+// assert at compile time that TimeoutNodeGetter is a NodeGetter
+var _ ipld.NodeGetter = (*TimeoutNodeGetter)(nil)
+
+// NewTimeoutNodeGetter wraps ng, applying timeout to each node fetch
+func NewTimeoutNodeGetter(ng ipld.NodeGetter, timeout time.Duration) *TimeoutNodeGetter {
+	return &TimeoutNodeGetter{NodeGetter: ng, Timeout: timeout}
+}
+
+// Get fetches a node, returning an error wrapping context.DeadlineExceeded if
+// the fetch takes longer than the timeout
+func (ng *TimeoutNodeGetter) Get(ctx context.Context, id cid.Cid) (ipld.Node, error) {
+	ctx, cancel := context.WithTimeout(ctx, ng.Timeout)
+	defer cancel()
+
+	resCh := make(chan *ipld.NodeOption, 1)
+	go func() {
+		n, err := ng.NodeGetter.Get(ctx, id)
+		resCh <- &ipld.NodeOption{Node: n, Err: err}
+	}()
+
+	select {
+	case res := <-resCh:
+		// getters that respect cancellation return an error when the deadline
+		// passes, report these as timeouts as well
+		if res.Err == nil || ctx.Err() == nil {
+			return res.Node, res.Err
+		}
+	case <-ctx.Done():
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("getting node %s: timed out after %s: %w", id, ng.Timeout, ctx.Err())
+	}
+	return nil, ctx.Err()
+}
+
+// GetMany returns a channel of NodeOptions given a set of CIDs, applying the
+// timeout to each node individually
+func (ng *TimeoutNodeGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	ch := make(chan *ipld.NodeOption, len(cids))
+	go func() {
+		defer close(ch)
+		for _, id := range cids {
+			n, err := ng.Get(ctx, id)
+			ch <- &ipld.NodeOption{Err: err, Node: n}
+		}
+	}()
+	return ch
+}
+
+// CachingNodeGetter wraps a NodeGetter, caching fetched nodes by CID across
+// calls, so manifesting the same DAG again or DAGs sharing nodes only fetches
+// each node once. Once the cache holds it's limit of nodes the least recently
+// used nodes are evicted first. CachingNodeGetter is safe for concurrent use
+type CachingNodeGetter struct {
+	NodeGetter ipld.NodeGetter
+	cache      *nodeCache
+}
+
+// assert at compile time that CachingNodeGetter is a NodeGetter
+var _ ipld.NodeGetter = (*CachingNodeGetter)(nil)
+
+// NewCachingNodeGetter wraps ng, caching up to size nodes. A size of zero or
+// less caches nodes without limit
+func NewCachingNodeGetter(ng ipld.NodeGetter, size int) *CachingNodeGetter {
+	return &CachingNodeGetter{NodeGetter: ng, cache: newNodeCache(size, 0)}
+}
+
+// Get returns a cached node if one exists, fetching & caching the node from the
+// wrapped getter otherwise
+func (ng *CachingNodeGetter) Get(ctx context.Context, id cid.Cid) (ipld.Node, error) {
+	if n, ok := ng.cache.get(id); ok {
+		return n, nil
+	}
+	n, err := ng.NodeGetter.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	ng.cache.add(n)
+	return n, nil
+}
+
+// GetMany returns a channel of NodeOptions given a set of CIDs. Cached nodes
+// are sent first, uncached nodes are fetched with a single GetMany call to the
+// wrapped getter & cached as they arrive
+func (ng *CachingNodeGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	ch := make(chan *ipld.NodeOption, len(cids))
+	var misses []cid.Cid
+	for _, id := range cids {
+		if n, ok := ng.cache.get(id); ok {
+			ch <- &ipld.NodeOption{Node: n}
+		} else {
+			misses = append(misses, id)
+		}
+	}
+	if len(misses) == 0 {
+		close(ch)
+		return ch
+	}
+
+	go func() {
+		defer close(ch)
+		// wrapped getters needn't close the channel they return, read only as
+		// many results as were requested
+		res := ng.NodeGetter.GetMany(ctx, misses)
+		for range misses {
+			select {
+			case opt, ok := <-res:
+				if !ok {
+					return
+				}
+				if opt.Err == nil {
+					ng.cache.add(opt.Node)
+				}
+				ch <- opt
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// Len returns the number of nodes currently held in the cache
+func (ng *CachingNodeGetter) Len() int {
+	return ng.cache.len()
+}
+
+// ErrTraceMismatch is the error for when a replayed walk requests nodes in a
+// different order than the recorded trace
+var ErrTraceMismatch = errors.New("node request doesn't match trace")
+
+// TracingNodeGetter wraps a NodeGetter, recording the id of each node
+// requested in order, and keeping fetched nodes. Comparing the traces of two
+// manifest builds of the same DAG shows where walk order diverges
+type TracingNodeGetter struct {
+	NodeGetter ipld.NodeGetter
+
+	lock  sync.Mutex
+	trace []cid.Cid
+	nodes map[string]ipld.Node
+}
+
+// assert at compile time that TracingNodeGetter is a NodeGetter
+var _ ipld.NodeGetter = (*TracingNodeGetter)(nil)
+
+// NewTracingNodeGetter wraps ng, recording a trace of node requests
+func NewTracingNodeGetter(ng ipld.NodeGetter) *TracingNodeGetter {
+	return &TracingNodeGetter{NodeGetter: ng, nodes: map[string]ipld.Node{}}
+}
+
+// Get fetches a node from the wrapped getter, adding the request to the trace
+func (ng *TracingNodeGetter) Get(ctx context.Context, id cid.Cid) (ipld.Node, error) {
+	n, err := ng.NodeGetter.Get(ctx, id)
+
+	ng.lock.Lock()
+	defer ng.lock.Unlock()
+	ng.trace = append(ng.trace, id)
+	if err == nil {
+		ng.nodes[id.KeyString()] = n
+	}
+	return n, err
+}
+
+// GetMany returns a channel of NodeOptions given a set of CIDs, tracing
+// requests in the order of cids
+func (ng *TracingNodeGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	ch := make(chan *ipld.NodeOption, len(cids))
+	go func() {
+		defer close(ch)
+		for _, id := range cids {
+			n, err := ng.Get(ctx, id)
+			ch <- &ipld.NodeOption{Err: err, Node: n}
+		}
+	}()
+	return ch
+}
+
+// Trace returns the ids of requested nodes in request order
+func (ng *TracingNodeGetter) Trace() []cid.Cid {
+	ng.lock.Lock()
+	defer ng.lock.Unlock()
+	return append([]cid.Cid(nil), ng.trace...)
+}
+
+// Replay returns a NodeGetter that serves the nodes recorded so far, without
+// the wrapped getter. Requests must follow the recorded trace, a request for
+// any other node returns an error wrapping ErrTraceMismatch
+func (ng *TracingNodeGetter) Replay() ipld.NodeGetter {
+	ng.lock.Lock()
+	defer ng.lock.Unlock()
+	nodes := make(map[string]ipld.Node, len(ng.nodes))
+	for k, n := range ng.nodes {
+		nodes[k] = n
+	}
+	return &replayNodeGetter{trace: append([]cid.Cid(nil), ng.trace...), nodes: nodes}
+}
+
+// replayNodeGetter serves cached nodes in the order of a trace
+type replayNodeGetter struct {
+	lock  sync.Mutex
+	trace []cid.Cid
+	nodes map[string]ipld.Node
+	pos   int
+}
+
+func (ng *replayNodeGetter) Get(ctx context.Context, id cid.Cid) (ipld.Node, error) {
+	ng.lock.Lock()
+	defer ng.lock.Unlock()
+	if ng.pos >= len(ng.trace) {
+		return nil, fmt.Errorf("%w: request %d for %s is past the end of the trace", ErrTraceMismatch, ng.pos, id)
+	}
+	if expect := ng.trace[ng.pos]; !expect.Equals(id) {
+		return nil, fmt.Errorf("%w: request %d expected %s, got: %s", ErrTraceMismatch, ng.pos, expect, id)
+	}
+	ng.pos++
+
+	n, ok := ng.nodes[id.KeyString()]
+	if !ok {
+		return nil, fmt.Errorf("node %s wasn't fetched when traced", id)
+	}
+	return n, nil
+}
+
+func (ng *replayNodeGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	ch := make(chan *ipld.NodeOption, len(cids))
+	go func() {
+		defer close(ch)
+		for _, id := range cids {
+			n, err := ng.Get(ctx, id)
+			ch <- &ipld.NodeOption{Err: err, Node: n}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,179 @@
+package dag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+func TestTimeoutNodeGetter(t *testing.T) {
+	content = 0
+
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	a.links = []*node{b, c}
+
+	slow := &sleepingGetter{
+		ng:    TestingNodeGetter{[]ipld.Node{a, b, c}},
+		delay: map[string]time.Duration{c.Cid().String(): time.Second},
+	}
+	ng := NewTimeoutNodeGetter(slow, time.Millisecond*20)
+
+	ctx := context.Background()
+	if _, err := ng.Get(ctx, b.Cid()); err != nil {
+		t.Errorf("expected fast get to succeed. got: %s", err)
+	}
+
+	start := time.Now()
+	_, err := ng.Get(ctx, c.Cid())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected slow get to return deadline exceeded. got: %v", err)
+	}
+	if time.Since(start) > time.Millisecond*500 {
+		t.Errorf("expected slow get to return once timeout elapsed. took: %s", time.Since(start))
+	}
+
+	// timeouts apply per-node, a slow node fails the whole manifest walk
+	if _, err := NewManifest(ctx, ng, a.Cid()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected manifest walk to fail with deadline exceeded. got: %v", err)
+	}
+}
+
+// sleepingGetter delays Get calls for ids in the delay map, ignoring context
+// cancellation
+type sleepingGetter struct {
+	ng    ipld.NodeGetter
+	delay map[string]time.Duration
+}
+
+func (sg *sleepingGetter) Get(ctx context.Context, id cid.Cid) (ipld.Node, error) {
+	time.Sleep(sg.delay[id.String()])
+	return sg.ng.Get(ctx, id)
+}
+
+func (sg *sleepingGetter) GetMany(ctx context.Context, ids []cid.Cid) <-chan *ipld.NodeOption {
+	return sg.ng.GetMany(ctx, ids)
+}
+
+func TestCachingNodeGetter(t *testing.T) {
+	content = 0
+
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	d := newNode(40)
+	a.links = []*node{b, c}
+	b.links = []*node{d}
+	c.links = []*node{d}
+	counter := &idCountingGetter{ng: TestingNodeGetter{[]ipld.Node{a, b, c, d}}, gets: map[string]int{}}
+	ng := NewCachingNodeGetter(counter, 0)
+
+	ctx := context.Background()
+	m1, err := NewManifest(ctx, ng, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched := len(counter.gets)
+	if ng.Len() != fetched {
+		t.Errorf("expected cache to hold %d nodes. got: %d", fetched, ng.Len())
+	}
+
+	m2, err := NewManifest(ctx, ng, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%v", m1) != fmt.Sprintf("%v", m2) {
+		t.Errorf("expected cached manifest to match.\nexpected: %v\ngot:      %v", m1, m2)
+	}
+	for id, n := range counter.gets {
+		if n != 1 {
+			t.Errorf("expected node %s to be fetched once. got: %d", id, n)
+		}
+	}
+
+	for opt := range ng.GetMany(ctx, []cid.Cid{b.Cid(), d.Cid()}) {
+		if opt.Err != nil {
+			t.Errorf("unexpected GetMany error: %s", opt.Err)
+		}
+	}
+	if counter.gets[d.Cid().String()] != 1 {
+		t.Errorf("expected GetMany to be served from the cache")
+	}
+
+	// least recently used nodes are evicted once the cache is full
+	small := NewCachingNodeGetter(counter, 2)
+	for _, n := range []ipld.Node{a, b, a, c} {
+		if _, err := small.Get(ctx, n.Cid()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if small.Len() != 2 {
+		t.Errorf("expected cache to hold 2 nodes. got: %d", small.Len())
+	}
+	before := counter.gets[b.Cid().String()]
+	if _, err := small.Get(ctx, b.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if counter.gets[b.Cid().String()] != before+1 {
+		t.Errorf("expected evicted node to be fetched again")
+	}
+	before = counter.gets[c.Cid().String()]
+	if _, err := small.Get(ctx, c.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if counter.gets[c.Cid().String()] != before {
+		t.Errorf("expected recently used node to be served from the cache")
+	}
+}
+
+func TestTracingNodeGetter(t *testing.T) {
+	content = 0
+
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	d := newNode(40)
+	a.links = []*node{c, b}
+	b.links = []*node{d}
+	c.links = []*node{d}
+	ng := TestingNodeGetter{[]ipld.Node{a, b, c, d}}
+
+	ctx := context.Background()
+	build := func() (*Manifest, *TracingNodeGetter) {
+		tng := NewTracingNodeGetter(ng)
+		m, err := NewManifest(ctx, tng, a.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m, tng
+	}
+
+	m1, t1 := build()
+	_, t2 := build()
+	trace1, trace2 := t1.Trace(), t2.Trace()
+	if len(trace1) == 0 {
+		t.Fatal("expected a non-empty trace")
+	}
+	if fmt.Sprintf("%v", trace1) != fmt.Sprintf("%v", trace2) {
+		t.Errorf("expected builds of the same DAG to have identical traces.\nfirst:  %v\nsecond: %v", trace1, trace2)
+	}
+
+	replayed, err := NewManifest(ctx, t1.Replay(), a.Cid())
+	if err != nil {
+		t.Fatalf("replaying trace: %s", err)
+	}
+	if fmt.Sprintf("%v", m1) != fmt.Sprintf("%v", replayed) {
+		t.Errorf("expected replayed manifest to match.\nexpected: %v\ngot:      %v", m1, replayed)
+	}
+
+	// walks that diverge from the trace error
+	if _, err := NewManifest(ctx, t1.Replay(), b.Cid()); !errors.Is(err, ErrTraceMismatch) {
+		t.Errorf("expected diverging replay to error with ErrTraceMismatch. got: %v", err)
+	}
+}
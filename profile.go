@@ -0,0 +1,102 @@
+package dag
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// BuildProfile describes where the time building an Info went. Profiles help
+// diagnose slow builds, like builds against a remote store with high latency.
+// Durations of concurrent fetches are summed, so FetchTime can exceed Total
+// for builds configured with WithFetchConcurrency
+type BuildProfile struct {
+	// Total is the wall-clock duration of the build
+	Total time.Duration
+	// Fetches is the number of nodes requested from the NodeGetter
+	Fetches int
+	// FetchTime is the summed duration of all node fetches
+	FetchTime time.Duration
+	// SizeTime is the summed duration of computing node sizes
+	SizeTime time.Duration
+	// SortTime is the summed duration of sorting links & nodes into manifest
+	// order
+	SortTime time.Duration
+	// Slowest lists the slowest fetches, slowest first
+	Slowest []FetchTiming
+}
+
+// FetchTiming is the duration of fetching a single node
+type FetchTiming struct {
+	ID       string
+	Duration time.Duration
+}
+
+// ProfileInfo creates an Info like NewInfo, recording a profile of the build
+// that includes the slowest n node fetches
+func ProfileInfo(ctx context.Context, ng ipld.NodeGetter, id cid.Cid, n int, opts ...func(cfg *ManifestConfig)) (*Info, *BuildProfile, error) {
+	ms := newMState(ctx, ng, opts)
+	ms.profiler = &buildProfiler{slowest: n}
+
+	start := time.Now()
+	info, err := ms.info(id)
+	ms.profiler.profile.Total = time.Since(start)
+	return info, &ms.profiler.profile, err
+}
+
+// buildProfiler accumulates a BuildProfile. Fetches may be recorded
+// concurrently. Methods are no-ops on a nil profiler
+type buildProfiler struct {
+	slowest int
+
+	lock    sync.Mutex
+	profile BuildProfile
+}
+
+func (p *buildProfiler) addFetch(id cid.Cid, d time.Duration) {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.profile.Fetches++
+	p.profile.FetchTime += d
+
+	if p.slowest <= 0 {
+		return
+	}
+	slow := p.profile.Slowest
+	if len(slow) == p.slowest && d <= slow[len(slow)-1].Duration {
+		return
+	}
+	i := sort.Search(len(slow), func(i int) bool { return slow[i].Duration < d })
+	slow = append(slow, FetchTiming{})
+	copy(slow[i+1:], slow[i:])
+	slow[i] = FetchTiming{ID: id.String(), Duration: d}
+	if len(slow) > p.slowest {
+		slow = slow[:p.slowest]
+	}
+	p.profile.Slowest = slow
+}
+
+func (p *buildProfiler) addSize(d time.Duration) {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.profile.SizeTime += d
+}
+
+func (p *buildProfiler) addSort(d time.Duration) {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.profile.SortTime += d
+}
@@ -0,0 +1,77 @@
+package dag
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// slowNodeGetter delays fetches of a single node
+type slowNodeGetter struct {
+	ipld.NodeGetter
+	slow  cid.Cid
+	delay time.Duration
+}
+
+func (ng slowNodeGetter) Get(ctx context.Context, id cid.Cid) (ipld.Node, error) {
+	if id.Equals(ng.slow) {
+		time.Sleep(ng.delay)
+	}
+	return ng.NodeGetter.Get(ctx, id)
+}
+
+func TestProfileInfo(t *testing.T) {
+	content = 0
+	g := newGraph([]layer{
+		{4, kb},
+		{3, kb},
+	})
+	slow := g[5].Cid()
+	delay := time.Millisecond * 20
+	ng := slowNodeGetter{NodeGetter: newMapNodeGetter(g), slow: slow, delay: delay}
+	ctx := context.Background()
+
+	info, profile, err := ProfileInfo(ctx, ng, g[0].Cid(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect, err := NewInfo(ctx, ng, g[0].Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expect, info) {
+		t.Error("expected profiling not to change the info")
+	}
+
+	if profile.Fetches != len(g) {
+		t.Errorf("expected %d fetches. got: %d", len(g), profile.Fetches)
+	}
+	if profile.FetchTime < delay {
+		t.Errorf("expected fetch time to be at least %s. got: %s", delay, profile.FetchTime)
+	}
+	if profile.Total < profile.FetchTime {
+		t.Errorf("expected total %s to cover serial fetch time %s", profile.Total, profile.FetchTime)
+	}
+	if len(profile.Slowest) != 3 {
+		t.Fatalf("expected 3 slowest fetches. got: %d", len(profile.Slowest))
+	}
+	if profile.Slowest[0].ID != slow.String() {
+		t.Errorf("expected slowest fetch to be %s. got: %s", slow, profile.Slowest[0].ID)
+	}
+	for i := 1; i < len(profile.Slowest); i++ {
+		if profile.Slowest[i].Duration > profile.Slowest[i-1].Duration {
+			t.Errorf("expected slowest fetches in descending order. got: %v", profile.Slowest)
+		}
+	}
+
+	if _, profile, err = ProfileInfo(ctx, ng, g[0].Cid(), 3, WithFetchConcurrency(4)); err != nil {
+		t.Fatal(err)
+	}
+	if profile.Fetches != len(g) {
+		t.Errorf("expected %d concurrent fetches. got: %d", len(g), profile.Fetches)
+	}
+}
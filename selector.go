@@ -0,0 +1,124 @@
+package dag
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	ipldprime "github.com/ipld/go-ipld-prime"
+	dagpb "github.com/ipld/go-ipld-prime-proto"
+	// register the dag-cbor decoder for selector traversals
+	_ "github.com/ipld/go-ipld-prime/encoding/dagcbor"
+	ipldfree "github.com/ipld/go-ipld-prime/impl/free"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+)
+
+// NewManifestWithSelector generates a manifest of the portion of the DAG at id
+// an IPLD selector walks, instead of the full DAG. sel is a selector spec
+// node, as built by go-ipld-prime's selector/builder package. Every block the
+// selector traversal loads is included, which is the path from the root to
+// each matched node, plus matched subtrees. Links to blocks outside the
+// selection are left out of the manifest, so the result describes a DAG
+// rooted at id that is a subgraph of the full DAG. dag-pb, raw & dag-cbor
+// blocks can be traversed
+func NewManifestWithSelector(ctx context.Context, ng ipld.NodeGetter, id cid.Cid, sel ipldprime.Node) (*Manifest, error) {
+	s, err := selector.ParseSelector(sel)
+	if err != nil {
+		return nil, fmt.Errorf("parsing selector: %w", err)
+	}
+
+	var (
+		lock   sync.Mutex
+		loaded = map[string]bool{}
+	)
+	loader := func(lnk ipldprime.Link, _ ipldprime.LinkContext) (io.Reader, error) {
+		cl, ok := lnk.(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("unsupported link type: %T", lnk)
+		}
+		nd, err := ng.Get(ctx, cl.Cid)
+		if err != nil {
+			return nil, err
+		}
+		lock.Lock()
+		loaded[cl.Cid.KeyString()] = true
+		lock.Unlock()
+		return bytes.NewReader(nd.RawData()), nil
+	}
+	chooser := dagpb.AddDagPBSupportToChooser(func(ipldprime.Link, ipldprime.LinkContext) ipldprime.NodeBuilder {
+		return ipldfree.NodeBuilder()
+	})
+
+	rootLink := cidlink.Link{Cid: id}
+	rootNode, err := rootLink.Load(ctx, ipldprime.LinkContext{}, chooser(rootLink, ipldprime.LinkContext{}), loader)
+	if err != nil {
+		return nil, err
+	}
+
+	prog := traversal.Progress{
+		Cfg: &traversal.Config{
+			Ctx:                    ctx,
+			LinkLoader:             loader,
+			LinkNodeBuilderChooser: chooser,
+		},
+	}
+	visit := func(traversal.Progress, ipldprime.Node, traversal.VisitReason) error { return nil }
+	if err := prog.WalkAdv(rootNode, s, visit); err != nil {
+		return nil, err
+	}
+
+	return NewManifest(ctx, &selectedNodeGetter{ng: ng, selected: loaded}, id)
+}
+
+// selectedNodeGetter returns nodes with links to nodes outside a selection
+// removed
+type selectedNodeGetter struct {
+	ng       ipld.NodeGetter
+	selected map[string]bool
+}
+
+func (sg *selectedNodeGetter) Get(ctx context.Context, id cid.Cid) (ipld.Node, error) {
+	nd, err := sg.ng.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return selectedNode{Node: nd, selected: sg.selected}, nil
+}
+
+func (sg *selectedNodeGetter) GetMany(ctx context.Context, ids []cid.Cid) <-chan *ipld.NodeOption {
+	ch := make(chan *ipld.NodeOption, len(ids))
+	go func() {
+		defer close(ch)
+		for _, id := range ids {
+			nd, err := sg.Get(ctx, id)
+			select {
+			case ch <- &ipld.NodeOption{Node: nd, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// selectedNode is a node with links limited to a selection
+type selectedNode struct {
+	ipld.Node
+	selected map[string]bool
+}
+
+func (nd selectedNode) Links() []*ipld.Link {
+	var links []*ipld.Link
+	for _, l := range nd.Node.Links() {
+		if nd.selected[l.Cid.KeyString()] {
+			links = append(links, l)
+		}
+	}
+	return links
+}
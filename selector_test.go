@@ -0,0 +1,84 @@
+package dag
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	ipldfree "github.com/ipld/go-ipld-prime/impl/free"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+)
+
+func TestNewManifestWithSelector(t *testing.T) {
+	ctx := context.Background()
+
+	// root
+	//  ├── a
+	//  │   ├── a.0
+	//  │   └── a.1
+	//  └── b
+	//      └── b.0
+	//          └── b.0.0
+	var nodes []ipld.Node
+	node := func(data string, links ...*merkledag.ProtoNode) *merkledag.ProtoNode {
+		nd := merkledag.NodeWithData([]byte(data))
+		for i, l := range links {
+			if err := nd.AddNodeLink(fmt.Sprintf("%d", i), l); err != nil {
+				t.Fatal(err)
+			}
+		}
+		nodes = append(nodes, nd)
+		return nd
+	}
+	a := node("a", node("a.0"), node("a.1"))
+	b0 := node("b.0", node("b.0.0"))
+	b := node("b", b0)
+	root := merkledag.NodeWithData([]byte("root"))
+	if err := root.AddNodeLink("a", a); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.AddNodeLink("b", b); err != nil {
+		t.Fatal(err)
+	}
+	nodes = append(nodes, root)
+	ng := TestingNodeGetter{nodes}
+
+	// select everything under the root's second link, "b"
+	ssb := builder.NewSelectorSpecBuilder(ipldfree.NodeBuilder())
+	all := ssb.ExploreRecursive(selector.RecursionLimitDepth(100), ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+		efsb.Insert("Links", ssb.ExploreAll(ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+			efsb.Insert("Hash", ssb.ExploreRecursiveEdge())
+		})))
+	}))
+	sel := ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+		efsb.Insert("Links", ssb.ExploreIndex(1, ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+			efsb.Insert("Hash", all)
+		})))
+	}).Node()
+
+	mf, err := NewManifestWithSelector(ctx, ng, root.Cid(), sel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{root.Cid().String(), b.Cid().String(), b0.Cid().String(), b0.Links()[0].Cid.String()}
+	got := append([]string{}, mf.Nodes...)
+	sort.Strings(expect)
+	sort.Strings(got)
+	if fmt.Sprintf("%v", expect) != fmt.Sprintf("%v", got) {
+		t.Errorf("selected nodes mismatch. expected: %v, got: %v", expect, got)
+	}
+	if len(mf.Links) != 3 {
+		t.Errorf("expected 3 links in the selected subgraph. got: %d", len(mf.Links))
+	}
+	if mf.Nodes[0] != root.Cid().String() {
+		t.Errorf("expected root to be the first node. got: %s", mf.Nodes[0])
+	}
+	if err := mf.VerifyNodeOrder(); err != nil {
+		t.Errorf("expected selected manifest node order to verify. got: %s", err)
+	}
+}
@@ -0,0 +1,93 @@
+package dag
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"sort"
+
+	"github.com/ugorji/go/codec"
+)
+
+// ErrInvalidSignature is the error for a SignedInfo that doesn't verify
+var ErrInvalidSignature = fmt.Errorf("invalid info signature")
+
+// SignedInfo pairs an Info with a signature from it's producer. Info details
+// like sizes & labels are gossip, a signature lets a peer check who produced a
+// given Info before trusting it
+type SignedInfo struct {
+	Info      *Info  `json:"info"`
+	Signature []byte `json:"signature"`
+}
+
+// SignInfo signs an Info with an ed25519 private key. The signature covers the
+// manifest hash, sizes & labels of the info
+func SignInfo(priv ed25519.PrivateKey, i *Info) (*SignedInfo, error) {
+	if len(priv) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid ed25519 private key length: %d", len(priv))
+	}
+	data, err := infoSigningPayload(i)
+	if err != nil {
+		return nil, err
+	}
+	return &SignedInfo{
+		Info:      i,
+		Signature: ed25519.Sign(priv, data),
+	}, nil
+}
+
+// VerifyInfo checks the signature of a SignedInfo against an ed25519 public
+// key, returning ErrInvalidSignature if the signature doesn't match
+func VerifyInfo(pub ed25519.PublicKey, si *SignedInfo) error {
+	if len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid ed25519 public key length: %d", len(pub))
+	}
+	data, err := infoSigningPayload(si.Info)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, data, si.Signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// infoSigningPayload is a deterministic encoding of the signed parts of an
+// Info. Labels are sorted by name to remove map ordering
+func infoSigningPayload(i *Info) ([]byte, error) {
+	if i == nil || i.Manifest == nil {
+		return nil, fmt.Errorf("info has no manifest")
+	}
+	hash, err := i.Manifest.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	labels := make([]string, 0, len(i.Labels))
+	for label := range i.Labels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	indexes := make([]int, len(labels))
+	for j, label := range labels {
+		indexes[j] = i.Labels[label]
+	}
+
+	payload := struct {
+		Domain       string
+		ManifestHash string
+		Sizes        []uint64
+		Labels       []string
+		LabelIndexes []int
+	}{
+		Domain:       "dag.Info signature v1",
+		ManifestHash: hash,
+		Sizes:        i.Sizes,
+		Labels:       labels,
+		LabelIndexes: indexes,
+	}
+
+	buf := &bytes.Buffer{}
+	err = codec.NewEncoder(buf, &codec.CborHandle{}).Encode(payload)
+	return buf.Bytes(), err
+}
@@ -0,0 +1,77 @@
+package dag
+
+import (
+	"context"
+	"crypto/ed25519"
+	"testing"
+)
+
+func TestSignInfo(t *testing.T) {
+	ctx := context.Background()
+	g := newGraph([]layer{{3, 2 * kb}, {2, 256}})
+	info, err := NewInfo(ctx, TestingNodeGetter{g}, g[0].Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := info.AddLabel("data", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := info.AddLabel("meta", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	si, err := SignInfo(priv, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyInfo(pub, si); err != nil {
+		t.Errorf("expected signed info to verify. got: %s", err)
+	}
+
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyInfo(otherPub, si); err != ErrInvalidSignature {
+		t.Errorf("expected verifying with the wrong key to return ErrInvalidSignature. got: %v", err)
+	}
+
+	tamper := []struct {
+		description string
+		change      func(i *Info)
+	}{
+		{"size", func(i *Info) { i.Sizes[1]++ }},
+		{"label index", func(i *Info) { i.Labels["data"] = 3 }},
+		{"added label", func(i *Info) { i.Labels["extra"] = 0 }},
+		{"manifest node", func(i *Info) { i.Manifest.Nodes[1], i.Manifest.Nodes[2] = i.Manifest.Nodes[2], i.Manifest.Nodes[1] }},
+	}
+
+	for _, c := range tamper {
+		tampered, err := SignInfo(priv, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tampered.Info = copyInfo(t, info)
+		c.change(tampered.Info)
+		if err := VerifyInfo(pub, tampered); err != ErrInvalidSignature {
+			t.Errorf("tampered %s: expected ErrInvalidSignature. got: %v", c.description, err)
+		}
+	}
+}
+
+func copyInfo(t *testing.T, i *Info) *Info {
+	data, err := i.MarshalCBOR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp, err := UnmarshalCBORDagInfo(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cp
+}
@@ -68,3 +68,102 @@ func (s *MemInfoStore) DeleteDAGInfo(_ context.Context, id string) (deleted bool
 
 	return
 }
+
+// ManifestHashStore is the interface for a key-value store of manifest hashes,
+// keyed by the root id of the DAG the manifest describes. Stored hashes act as
+// an integrity anchor, a manifest re-derived from local blocks should always
+// produce the stored hash
+type ManifestHashStore interface {
+	// store a manifest hash at the key, overwriting any previous entry
+	PutManifestHash(ctx context.Context, key, hash string) error
+	// get the manifest hash stored at key, return ErrManifestHashNotFound when
+	// a key isn't present in the store
+	ManifestHash(ctx context.Context, key string) (hash string, err error)
+}
+
+// ErrManifestHashNotFound should be returned by all implementations of
+// ManifestHashStore when a hash isn't found
+var ErrManifestHashNotFound = fmt.Errorf("manifest hash: not found")
+
+// MemManifestHashStore is an implementation of ManifestHashStore that uses an
+// in-memory map
+type MemManifestHashStore struct {
+	lock   sync.Mutex
+	hashes map[string]string
+}
+
+// NewMemManifestHashStore creates an in-memory ManifestHashStore
+func NewMemManifestHashStore() ManifestHashStore {
+	return &MemManifestHashStore{
+		hashes: map[string]string{},
+	}
+}
+
+// PutManifestHash stores a hash at key, overwriting any previous entry
+func (s *MemManifestHashStore) PutManifestHash(_ context.Context, key, hash string) error {
+	s.lock.Lock()
+	s.hashes[key] = hash
+	s.lock.Unlock()
+	return nil
+}
+
+// ManifestHash gets the hash stored at key, returning ErrManifestHashNotFound
+// when a key isn't present in the store
+func (s *MemManifestHashStore) ManifestHash(_ context.Context, key string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	hash, ok := s.hashes[key]
+	if !ok {
+		return "", ErrManifestHashNotFound
+	}
+	return hash, nil
+}
+
+// ManifestStore is the interface for a key-value store of manifests, keyed by
+// the root id of the DAG the manifest describes. Manifests are deterministic,
+// so a stored manifest can stand in for re-deriving it from blocks
+type ManifestStore interface {
+	// store a manifest at the key, overwriting any previous entry
+	PutManifest(ctx context.Context, key string, m *Manifest) error
+	// get the manifest stored at key, return ErrManifestNotFound when a key
+	// isn't present in the store
+	Manifest(ctx context.Context, key string) (*Manifest, error)
+}
+
+// ErrManifestNotFound should be returned by all implementations of
+// ManifestStore when a manifest isn't found
+var ErrManifestNotFound = fmt.Errorf("manifest: not found")
+
+// MemManifestStore is an implementation of ManifestStore that uses an
+// in-memory map
+type MemManifestStore struct {
+	lock      sync.Mutex
+	manifests map[string]*Manifest
+}
+
+// NewMemManifestStore creates an in-memory ManifestStore
+func NewMemManifestStore() ManifestStore {
+	return &MemManifestStore{
+		manifests: map[string]*Manifest{},
+	}
+}
+
+// PutManifest stores a manifest at key, overwriting any previous entry
+func (s *MemManifestStore) PutManifest(_ context.Context, key string, m *Manifest) error {
+	s.lock.Lock()
+	s.manifests[key] = m
+	s.lock.Unlock()
+	return nil
+}
+
+// Manifest gets the manifest stored at key, returning ErrManifestNotFound
+// when a key isn't present in the store
+func (s *MemManifestStore) Manifest(_ context.Context, key string) (*Manifest, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	m, ok := s.manifests[key]
+	if !ok {
+		return nil, ErrManifestNotFound
+	}
+	return m, nil
+}
@@ -57,3 +57,42 @@ func testInfoStoreInterface(is InfoStore) func(t *testing.T) {
 		}
 	}
 }
+
+func TestMemManifestHashStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemManifestHashStore()
+
+	if _, err := s.ManifestHash(ctx, "foo"); err != ErrManifestHashNotFound {
+		t.Errorf("expected ErrManifestHashNotFound for a get to a non-existent key. got: %v", err)
+	}
+	if err := s.PutManifestHash(ctx, "foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := s.ManifestHash(ctx, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != "bar" {
+		t.Errorf("hash mismatch. expected: %q, got: %q", "bar", hash)
+	}
+}
+
+func TestMemManifestStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemManifestStore()
+
+	if _, err := s.Manifest(ctx, "foo"); err != ErrManifestNotFound {
+		t.Errorf("expected ErrManifestNotFound for a get to a non-existent key. got: %v", err)
+	}
+	m := &Manifest{Nodes: []string{"a", "b"}, Links: [][2]int{{0, 1}}}
+	if err := s.PutManifest(ctx, "foo", m); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Manifest(ctx, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Equal(got) {
+		t.Errorf("manifest mismatch. expected: %v, got: %v", m, got)
+	}
+}
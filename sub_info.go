@@ -165,3 +165,64 @@ func (i *Info) InfoAtLabel(label string) (*Info, error) {
 	}
 	return i.InfoAtIndex(idx)
 }
+
+// SubDAGSize returns the size in bytes of the sub-DAG rooted at the given id:
+// the node itself plus all of its descendants. Descendants reachable by more
+// than one path are only counted once
+func (i *Info) SubDAGSize(id string) (uint64, error) {
+	if i.Manifest == nil {
+		return 0, fmt.Errorf("no manifest provided")
+	}
+	idx := i.Manifest.IDIndex(id)
+	if idx == -1 {
+		return 0, ErrIDNotFound
+	}
+	if len(i.Sizes) != len(i.Manifest.Nodes) {
+		return 0, fmt.Errorf("expected %d sizes, info has %d", len(i.Manifest.Nodes), len(i.Sizes))
+	}
+
+	var size uint64
+	for idx := range i.Manifest.ReachableFrom([]int{idx}) {
+		size += i.Sizes[idx]
+	}
+	return size, nil
+}
+
+// CumulativeSizes returns the size in bytes of the sub-DAG rooted at each
+// node, in manifest node order. Like SubDAGSize, descendants reachable by more
+// than one path are counted once in each sub-DAG they're part of
+func (i *Info) CumulativeSizes() ([]uint64, error) {
+	if i.Manifest == nil {
+		return nil, fmt.Errorf("no manifest provided")
+	}
+	if len(i.Sizes) != len(i.Manifest.Nodes) {
+		return nil, fmt.Errorf("expected %d sizes, info has %d", len(i.Manifest.Nodes), len(i.Sizes))
+	}
+
+	children := map[int][]int{}
+	for _, l := range i.Manifest.Links {
+		children[l[0]] = append(children[l[0]], l[1])
+	}
+
+	// visited holds the root each node was last visited from, so one slice
+	// serves every walk
+	visited := make([]int, len(i.Manifest.Nodes))
+	for idx := range visited {
+		visited[idx] = -1
+	}
+	sizes := make([]uint64, len(i.Manifest.Nodes))
+	for root := range i.Manifest.Nodes {
+		stack := []int{root}
+		for len(stack) > 0 {
+			idx := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if idx < 0 || idx >= len(visited) || visited[idx] == root {
+				continue
+			}
+			visited[idx] = root
+			sizes[root] += i.Sizes[idx]
+			stack = append(stack, children[idx]...)
+		}
+	}
+	return sizes, nil
+}
@@ -100,3 +100,91 @@ func TestInfoAtIndex(t *testing.T) {
 	}
 
 }
+
+// TestSubDAGSize uses a diamond-shaped DAG, where D is reachable from A by two paths:
+//
+//	  A
+//	 / \
+//	B   C
+//	 \ /
+//	  D
+//	  |
+//	  E
+func TestSubDAGSize(t *testing.T) {
+	content = 0
+
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	d := newNode(40)
+	e := newNode(50)
+	a.links = []*node{b, c}
+	b.links = []*node{d}
+	c.links = []*node{d}
+	d.links = []*node{e}
+
+	ctx := context.Background()
+	ng := TestingNodeGetter{[]ipld.Node{a, b, c, d, e}}
+	di, err := NewInfo(ctx, ng, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		id   string
+		size uint64
+		err  error
+	}{
+		{a.Cid().String(), 150, nil},
+		{b.Cid().String(), 110, nil},
+		{c.Cid().String(), 120, nil},
+		{e.Cid().String(), 50, nil},
+		{"bad id", 0, ErrIDNotFound},
+	}
+
+	for i, c := range cases {
+		got, err := di.SubDAGSize(c.id)
+		if err != c.err {
+			t.Errorf("case %d error mismatch. expected: %v, got: %v", i, c.err, err)
+			continue
+		}
+		if got != c.size {
+			t.Errorf("case %d size mismatch. expected: %d, got: %d", i, c.size, got)
+		}
+	}
+
+	if _, err := (&Info{Manifest: di.Manifest}).SubDAGSize(a.Cid().String()); err == nil {
+		t.Error("expected info without sizes to error")
+	}
+
+	// D & E are only counted once in the total
+	if got := di.TotalSize(); got != 150 {
+		t.Errorf("total size mismatch. expected: 150, got: %d", got)
+	}
+
+	sizes, err := di.CumulativeSizes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range di.Manifest.Nodes {
+		expect, err := di.SubDAGSize(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sizes[i] != expect {
+			t.Errorf("node %d cumulative size mismatch. expected: %d, got: %d", i, expect, sizes[i])
+		}
+	}
+	if _, err := (&Info{Manifest: di.Manifest}).CumulativeSizes(); err == nil {
+		t.Error("expected info without sizes to error")
+	}
+
+	// out of range links aren't followed
+	bad := &Info{
+		Manifest: &Manifest{Nodes: []string{"a", "b"}, Links: [][2]int{{0, 1}, {1, 2}}},
+		Sizes:    []uint64{10, 20},
+	}
+	if got, err := bad.SubDAGSize("a"); err != nil || got != 30 {
+		t.Errorf("expected size of 30 ignoring out of range links. got: %d, %v", got, err)
+	}
+}
@@ -0,0 +1,91 @@
+package dag
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// BlockProblem describes a block described by a manifest that failed
+// verification, either because it's missing or because it's corrupt
+type BlockProblem struct {
+	ID  string
+	Err error
+}
+
+// Error implements the error interface
+func (p BlockProblem) Error() string {
+	return fmt.Sprintf("block %s: %s", p.ID, p.Err)
+}
+
+// VerifyConfig encapsulates options for Verify
+type VerifyConfig struct {
+	// FailFast stops verification at the first missing or corrupt block
+	FailFast bool
+}
+
+// OptVerifyFailFast configures Verify to return at the first problem
+func OptVerifyFailFast(cfg *VerifyConfig) {
+	cfg.FailFast = true
+}
+
+// Verify checks that every block in a manifest can be fetched from a node
+// getter, and that fetched blocks match the requested id. Verify returns a
+// completion that marks verified blocks, and a list of problems in manifest
+// order. An error is only returned when verification itself fails, like a
+// malformed id in the manifest or context cancellation.
+//
+// By default Verify probes every block. When configured to fail fast Verify
+// stops at the first problem, the returned completion will only mark blocks
+// checked before the first problem as complete
+func Verify(ctx context.Context, ng ipld.NodeGetter, m *Manifest, opts ...func(cfg *VerifyConfig)) (Completion, []BlockProblem, error) {
+	cfg := &VerifyConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	var problems []BlockProblem
+	prog := make(Completion, len(m.Nodes))
+	for i, idstr := range m.Nodes {
+		id, err := m.NodeCID(i)
+		if err != nil {
+			return prog, problems, err
+		}
+
+		if prob := verifyBlock(ctx, ng, id); prob != nil {
+			if ctx.Err() != nil {
+				return prog, problems, ctx.Err()
+			}
+			problems = append(problems, BlockProblem{ID: idstr, Err: prob})
+			if cfg.FailFast {
+				return prog, problems, nil
+			}
+			continue
+		}
+		prog[i] = 100
+	}
+
+	return prog, problems, nil
+}
+
+func verifyBlock(ctx context.Context, ng ipld.NodeGetter, id cid.Cid) error {
+	node, err := ng.Get(ctx, id)
+	if err != nil {
+		if isNotFound(err) {
+			return ipld.ErrNotFound
+		}
+		return err
+	}
+	// getters decode blocks under the requested id, so only rehashing the
+	// data catches blocks that are corrupt
+	got, err := id.Prefix().Sum(node.RawData())
+	if err != nil {
+		return err
+	}
+	if !got.Equals(id) {
+		return fmt.Errorf("hash mismatch. data hashes to: %s", got)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package dag
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+func TestVerify(t *testing.T) {
+	content = 0
+
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	d := newNode(40)
+	a.links = []*node{b, c}
+	c.links = []*node{d}
+
+	ctx := context.Background()
+	ng := TestingNodeGetter{[]ipld.Node{a, b, c, d}}
+	mf, err := NewManifest(ctx, ng, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prog, problems, err := Verify(ctx, ng, mf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("expected complete DAG to have no problems. got: %v", problems)
+	}
+	if !prog.Complete() {
+		t.Errorf("expected complete DAG to verify as complete. got: %v", prog)
+	}
+
+	// drop c & d from the getter
+	missing := TestingNodeGetter{[]ipld.Node{a, b}}
+	prog, problems, err = Verify(ctx, missing, mf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("expected 2 problems, got: %d", len(problems))
+	}
+	if prog.CompletedBlocks() != 2 {
+		t.Errorf("expected 2 completed blocks. got: %d", prog.CompletedBlocks())
+	}
+
+	// getters decode corrupt data under the requested id
+	corrupt := *c
+	corrupt.data = []byte("corrupt")
+	prog, problems, err = Verify(ctx, TestingNodeGetter{[]ipld.Node{a, b, &corrupt, d}}, mf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0].ID != c.Cid().String() {
+		t.Fatalf("expected corrupt block %s to be the only problem. got: %v", c.Cid(), problems)
+	}
+	if prog.CompletedBlocks() != 3 {
+		t.Errorf("expected 3 completed blocks. got: %d", prog.CompletedBlocks())
+	}
+}
+
+func TestVerifyFailFast(t *testing.T) {
+	content = 0
+
+	a := newNode(10)
+	b := newNode(20)
+	c := newNode(30)
+	d := newNode(40)
+	a.links = []*node{b, c}
+	c.links = []*node{d}
+
+	ctx := context.Background()
+	mf, err := NewManifest(ctx, TestingNodeGetter{[]ipld.Node{a, b, c, d}}, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// c & d are both missing, c comes first in manifest order
+	ng := &countingGetter{ng: TestingNodeGetter{[]ipld.Node{a, b}}}
+	_, problems, err := Verify(ctx, ng, mf, OptVerifyFailFast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 {
+		t.Fatalf("expected fail-fast verify to report exactly one problem. got: %d", len(problems))
+	}
+	if problems[0].ID != c.Cid().String() {
+		t.Errorf("expected first offending block to be %s. got: %s", c.Cid(), problems[0].ID)
+	}
+	if ng.gets != mf.IDIndex(c.Cid().String())+1 {
+		t.Errorf("expected verify to stop requesting blocks after the first missing block. got %d gets", ng.gets)
+	}
+}
+
+type countingGetter struct {
+	ng   ipld.NodeGetter
+	gets int
+}
+
+func (cg *countingGetter) Get(ctx context.Context, id cid.Cid) (ipld.Node, error) {
+	cg.gets++
+	return cg.ng.Get(ctx, id)
+}
+
+func (cg *countingGetter) GetMany(ctx context.Context, ids []cid.Cid) <-chan *ipld.NodeOption {
+	return cg.ng.GetMany(ctx, ids)
+}